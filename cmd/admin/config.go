@@ -26,11 +26,75 @@ type Config struct {
 
 	HTTP struct {
 		Addr               string
+		PublicURL          string
 		ShutdownTimeoutSec time.Duration
+		ShutdownDelay      time.Duration
 
 		CorsAllowedOrigins []string
 		CorsAllowedHeaders []string
 		CorsExposedHeaders []string
+
+		TrustedProxies []string
+
+		RateLimit      int
+		LoginRateLimit int
+
+		CookieSecure  bool
+		CookieKeys    []secret.String
+		CookieEncrypt bool
+	}
+
+	Auth struct {
+		LoginMaxFailures int
+		LoginLockout     time.Duration
+
+		SessionMaxAge        time.Duration
+		SessionIdleTimeout   time.Duration
+		SessionRenewInterval time.Duration
+
+		SessionGCInterval  time.Duration
+		SessionGCBatchSize int
+
+		LoginAttemptsRetention time.Duration
+
+		PasswordMinLength    int
+		PasswordRejectCommon bool
+		PasswordHasher       string
+		PasswordResetTTL     time.Duration
+
+		TOTPIssuer string
+	}
+
+	OIDC struct {
+		IssuerURL        string
+		ClientID         string
+		ClientSecret     secret.String
+		Name             string
+		Scopes           []string
+		GroupsClaim      string
+		CreateUsers      bool
+		TrustProviderMFA bool
+	}
+
+	Mail struct {
+		Transport string
+		From      string
+		SMTPAddr  string
+		SMTPUser  string
+		SMTPPass  secret.String
+		FileDir   string
+	}
+
+	Metrics struct {
+		Enabled bool
+		Addr    string
+	}
+
+	Tracing struct {
+		Exporter     string
+		OTLPEndpoint string
+		File         string
+		SampleRatio  float64
 	}
 
 	Postgres struct {
@@ -40,6 +104,7 @@ type Config struct {
 		User             string
 		Pass             secret.String
 		DB               string
+		WaitOnStart      bool
 	}
 }
 
@@ -70,9 +135,26 @@ func ParseFlags() Config {
 	flag.StringVar(&cfg.Postgres.User, "postgres-user", "postgres", "PostgreSQL user.")
 	pgPass := flag.String("postgres-pass", "postgres", "PostgreSQL password.")
 	flag.StringVar(&cfg.Postgres.DB, "postgres-db", "postgres", "PostgreSQL database.")
+	flag.BoolVar(
+		&cfg.Postgres.WaitOnStart,
+		"postgres-wait-on-start",
+		true,
+		"Exit if PostgreSQL is unreachable on start (if false app starts and is not ready until it connects).",
+	)
 
 	flag.StringVar(&cfg.HTTP.Addr, "http-addr", "localhost:8080", "HTTP service address.")
+	flag.StringVar(
+		&cfg.HTTP.PublicURL,
+		"http-public-url",
+		"http://localhost:8080",
+		"Public URL of service, used in links sent by email.",
+	)
 	httpShutdownTimeoutSec := flag.Int("http-shutdown", 10, "HTTP service graceful shutdown timeout (sec).")
+	httpShutdownDelaySec := flag.Int(
+		"http-shutdown-delay",
+		0,
+		"Delay between readiness starts failing and HTTP service stops accepting requests (sec).",
+	)
 	corsAllowedOrigins := flag.String(
 		"http-cors-allowed-origins",
 		"*",
@@ -88,6 +170,182 @@ func ParseFlags() Config {
 		"*",
 		"The list which indicates which headers are safe to expose.",
 	)
+	trustedProxies := flag.String(
+		"http-trusted-proxies",
+		"127.0.0.1,::1",
+		"Comma separated IPs or CIDRs of reverse proxies, client address is taken from "+
+			"True-Client-IP, X-Real-IP or X-Forwarded-For headers only on their requests (if empty headers are ignored).",
+	)
+	flag.IntVar(
+		&cfg.HTTP.RateLimit,
+		"http-rate-limit",
+		600,
+		"Max requests per minute from single IP (0 disables limit).",
+	)
+	flag.IntVar(
+		&cfg.HTTP.LoginRateLimit,
+		"http-login-rate-limit",
+		10,
+		"Max login requests per minute from single IP (0 disables limit).",
+	)
+	flag.BoolVar(
+		&cfg.HTTP.CookieSecure,
+		"http-cookie-secure",
+		false,
+		"Set Secure attribute and __Host- name prefix on cookies (requires HTTPS).",
+	)
+	cookieKeys := flag.String(
+		"http-cookie-keys",
+		"",
+		"Comma separated keys to sign session cookie, first one signs new cookies, "+
+			"others are only verified for rotation (at least 32 bytes each, if empty cookie is not signed).",
+	)
+	flag.BoolVar(
+		&cfg.HTTP.CookieEncrypt,
+		"http-cookie-encrypt",
+		false,
+		"Encrypt session cookie with keys from http-cookie-keys.",
+	)
+
+	flag.IntVar(
+		&cfg.Auth.LoginMaxFailures,
+		"auth-login-max-failures",
+		5,
+		"Failed login attempts after which login is locked (0 disables lockout).",
+	)
+	authLoginLockoutSec := flag.Int("auth-login-lockout", 900, "Login lockout duration (sec).")
+	authSessionMaxAgeSec := flag.Int(
+		"auth-session-max-age",
+		60*60*24*31,
+		"Absolute session lifetime since login (sec).",
+	)
+	authSessionIdleTimeoutSec := flag.Int(
+		"auth-session-idle-timeout",
+		60*60*24*7,
+		"Session expires after inactivity, each request prolongs it up to max age (sec, 0 disables).",
+	)
+	authSessionRenewIntervalSec := flag.Int(
+		"auth-session-renew-interval",
+		60*5,
+		"Minimal interval between session activity updates in database (sec).",
+	)
+
+	authSessionGCIntervalSec := flag.Int(
+		"auth-session-gc-interval",
+		60*60,
+		"Interval between deletions of expired sessions (sec, 0 disables).",
+	)
+	flag.IntVar(
+		&cfg.Auth.SessionGCBatchSize,
+		"auth-session-gc-batch-size",
+		1000,
+		"Max count of expired sessions deleted by single query.",
+	)
+	authLoginAttemptsRetentionSec := flag.Int(
+		"auth-login-attempts-retention",
+		60*60*24*90,
+		"Login attempts older than this are deleted by session gc (sec, 0 keeps them forever).",
+	)
+
+	flag.IntVar(
+		&cfg.Auth.PasswordMinLength,
+		"auth-password-min-length",
+		10,
+		"Minimal password length.",
+	)
+	flag.BoolVar(
+		&cfg.Auth.PasswordRejectCommon,
+		"auth-password-reject-common",
+		true,
+		"Reject passwords from built-in list of common passwords.",
+	)
+	flag.StringVar(
+		&cfg.Auth.PasswordHasher,
+		"auth-password-hasher",
+		"argon2id",
+		"Algorithm for new password hashes (argon2id, bcrypt), outdated hashes are upgraded on login.",
+	)
+	authPasswordResetTTLSec := flag.Int(
+		"auth-password-reset-ttl",
+		60*60,
+		"Password reset link lifetime (sec).",
+	)
+
+	flag.StringVar(
+		&cfg.Auth.TOTPIssuer,
+		"auth-totp-issuer",
+		"My app",
+		"Issuer of two-factor keys, authenticator apps show it next to login.",
+	)
+
+	flag.StringVar(
+		&cfg.OIDC.IssuerURL,
+		"oidc-issuer-url",
+		"",
+		"OpenID Connect provider URL (if empty sign in with provider is disabled).",
+	)
+	flag.StringVar(&cfg.OIDC.ClientID, "oidc-client-id", "", "OpenID Connect client id.")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret (empty for public client).")
+	flag.StringVar(
+		&cfg.OIDC.Name,
+		"oidc-name",
+		"sso",
+		"Provider name stored with linked accounts, must not be changed after users signed in.",
+	)
+	oidcScopes := flag.String("oidc-scopes", "email,profile", "Comma separated scopes requested in addition to openid.")
+	flag.StringVar(&cfg.OIDC.GroupsClaim, "oidc-groups-claim", "groups", "ID token claim with user groups.")
+	flag.BoolVar(
+		&cfg.OIDC.CreateUsers,
+		"oidc-create-users",
+		false,
+		"Create user on first sign in if there is no user with the same verified email.",
+	)
+	flag.BoolVar(
+		&cfg.OIDC.TrustProviderMFA,
+		"oidc-trust-provider-mfa",
+		false,
+		"Skip local two-factor check on sign in with provider, provider must enforce second factor itself.",
+	)
+
+	flag.StringVar(
+		&cfg.Mail.Transport,
+		"mail-transport",
+		"log",
+		"How to send emails (smtp | file | log), file and log are for development.",
+	)
+	flag.StringVar(&cfg.Mail.From, "mail-from", "noreply@localhost", "Sender address.")
+	flag.StringVar(&cfg.Mail.SMTPAddr, "mail-smtp-addr", "localhost:25", "SMTP server address.")
+	flag.StringVar(&cfg.Mail.SMTPUser, "mail-smtp-user", "", "SMTP user (if empty no authentication is used).")
+	mailSMTPPass := flag.String("mail-smtp-pass", "", "SMTP password.")
+	flag.StringVar(&cfg.Mail.FileDir, "mail-file-dir", "./tmp/mail", "Directory for emails in file transport.")
+
+	flag.BoolVar(&cfg.Metrics.Enabled, "metrics-enabled", true, "Expose Prometheus metrics on /metrics.")
+	flag.StringVar(
+		&cfg.Metrics.Addr,
+		"metrics-addr",
+		"localhost:9090",
+		"Private listener for metrics, it must not be reachable publicly (if empty metrics are served without authentication on http-addr).",
+	)
+
+	flag.StringVar(
+		&cfg.Tracing.Exporter,
+		"tracing-exporter",
+		"none",
+		"How to export spans (none | otlp | file), file is for development.",
+	)
+	flag.StringVar(
+		&cfg.Tracing.OTLPEndpoint,
+		"tracing-otlp-endpoint",
+		"http://localhost:4318/v1/traces",
+		"OpenTelemetry collector endpoint of OTLP/HTTP traces.",
+	)
+	flag.StringVar(&cfg.Tracing.File, "tracing-file", "./tmp/traces.jsonl", "File for spans in file exporter.")
+	flag.Float64Var(
+		&cfg.Tracing.SampleRatio,
+		"tracing-sample-ratio",
+		1,
+		"Ratio of new traces which are exported (0..1), traces continued from traceparent header follow its sampled flag.",
+	)
 
 	flagutils.Prefix = EnvPrefix
 	flagutils.Parse()
@@ -101,13 +359,36 @@ func ParseFlags() Config {
 		*pgPass = ""
 	}
 
+	cfg.Mail.SMTPPass = secret.NewString(*mailSMTPPass)
+	*mailSMTPPass = ""
+
+	cfg.OIDC.ClientSecret = secret.NewString(*oidcClientSecret)
+	*oidcClientSecret = ""
+
+	if *cookieKeys != "" {
+		for _, key := range strings.Split(*cookieKeys, ",") {
+			cfg.HTTP.CookieKeys = append(cfg.HTTP.CookieKeys, secret.NewString(strings.TrimSpace(key)))
+		}
+		*cookieKeys = ""
+	}
+
 	slogLevel := slogutils.ParseLogLevel(*logLevel)
 
 	cfg.Log.Level = slogLevel
 	cfg.HTTP.ShutdownTimeoutSec = time.Duration(*httpShutdownTimeoutSec) * time.Second
+	cfg.HTTP.ShutdownDelay = time.Duration(*httpShutdownDelaySec) * time.Second
 	cfg.HTTP.CorsAllowedOrigins = strings.Split(*corsAllowedOrigins, ",")
 	cfg.HTTP.CorsAllowedHeaders = strings.Split(*corsAllowedHeaders, ",")
 	cfg.HTTP.CorsExposedHeaders = strings.Split(*corsExposedHeaders, ",")
+	cfg.HTTP.TrustedProxies = strings.Split(*trustedProxies, ",")
+	cfg.OIDC.Scopes = strings.Split(*oidcScopes, ",")
+	cfg.Auth.LoginLockout = time.Duration(*authLoginLockoutSec) * time.Second
+	cfg.Auth.SessionMaxAge = time.Duration(*authSessionMaxAgeSec) * time.Second
+	cfg.Auth.SessionIdleTimeout = time.Duration(*authSessionIdleTimeoutSec) * time.Second
+	cfg.Auth.SessionRenewInterval = time.Duration(*authSessionRenewIntervalSec) * time.Second
+	cfg.Auth.SessionGCInterval = time.Duration(*authSessionGCIntervalSec) * time.Second
+	cfg.Auth.LoginAttemptsRetention = time.Duration(*authLoginAttemptsRetentionSec) * time.Second
+	cfg.Auth.PasswordResetTTL = time.Duration(*authPasswordResetTTLSec) * time.Second
 
 	if slogLevel == slog.LevelDebug {
 		cfg.Debug = true
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/validator"
+)
+
+type accountTwoFactorPageData struct {
+	Enabled bool
+	// Secret and URI are shown while enrollment is started but not confirmed
+	Secret string
+	// URI has otpauth scheme which html/template treats as unsafe
+	URI template.URL
+	// RecoveryCodes are shown once right after enrollment
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+
+	Form twoFactorForm
+}
+
+type twoFactorForm struct {
+	Code string
+
+	validator.Validator
+}
+
+// @SSR
+func (s *UserController) AccountTwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	data, err := s.accountTwoFactorData(r)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить настройки", err)
+		return
+	}
+	s.Render(w, r, http.StatusOK, "account-2fa.tmpl.html", renderer.SmartBlock, data)
+}
+
+// @HTMX
+func (s *UserController) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := auth.MustUserFromContext(r.Context())
+	if _, err := s.authenticator.BeginTOTPEnrollment(r.Context(), user); err != nil && !errors.Is(err, auth.ErrTOTPEnabled) {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось начать настройку двухфакторной аутентификации", err)
+		return
+	}
+
+	data, err := s.accountTwoFactorData(r)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить настройки", err)
+		return
+	}
+	s.Render(w, r, http.StatusOK, "account-2fa.tmpl.html", "account-2fa", data)
+}
+
+// @HTMX
+func (s *UserController) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := auth.MustUserFromContext(r.Context())
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	form := twoFactorForm{Code: strings.TrimSpace(r.PostForm.Get("code"))}
+	form.CheckField(validator.NotBlank(form.Code), "code", "Код не может быть пустым")
+	if form.Valid() {
+		codes, err := s.authenticator.ConfirmTOTPEnrollment(r.Context(), user, form.Code)
+		switch {
+		case err == nil:
+			recordUserAudit(r, s.auditRecorder, audit.ActionUserTOTPEnable, user.ID, map[string]audit.Change{
+				"totp": {Before: false, After: true},
+			})
+			data := accountTwoFactorPageData{Enabled: true, RecoveryCodes: codes, RecoveryCodesLeft: len(codes)}
+			s.Render(w, r, http.StatusOK, "account-2fa.tmpl.html", "account-2fa", data)
+			return
+		case errors.Is(err, auth.ErrInvalidCode):
+			form.AddFieldError("code", "Неверный код")
+		default:
+			s.Error(w, r, http.StatusInternalServerError, "Не удалось включить двухфакторную аутентификацию", err)
+			return
+		}
+	}
+
+	data, err := s.accountTwoFactorData(r)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить настройки", err)
+		return
+	}
+	data.Form = form
+	s.Render(w, r, http.StatusOK, "account-2fa.tmpl.html", "account-2fa", data)
+}
+
+// @HTMX
+func (s *UserController) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := auth.MustUserFromContext(r.Context())
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	form := twoFactorForm{Code: strings.TrimSpace(r.PostForm.Get("code"))}
+	form.CheckField(validator.NotBlank(form.Code), "code", "Код не может быть пустым")
+	if form.Valid() {
+		err := s.authenticator.DisableTOTP(r.Context(), user, form.Code)
+		switch {
+		case err == nil:
+			recordUserAudit(r, s.auditRecorder, audit.ActionUserTOTPDisable, user.ID, map[string]audit.Change{
+				"totp": {Before: true, After: false},
+			})
+		case errors.Is(err, auth.ErrInvalidCode):
+			form.AddFieldError("code", "Неверный код")
+		default:
+			s.Error(w, r, http.StatusInternalServerError, "Не удалось отключить двухфакторную аутентификацию", err)
+			return
+		}
+	}
+
+	data, err := s.accountTwoFactorData(r)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить настройки", err)
+		return
+	}
+	data.Form = form
+	s.Render(w, r, http.StatusOK, "account-2fa.tmpl.html", "account-2fa", data)
+}
+
+// accountTwoFactorData loads enrollment state, it does not change it, enrollment is started by EnrollTwoFactor
+func (s *UserController) accountTwoFactorData(r *http.Request) (accountTwoFactorPageData, error) {
+	user := auth.MustUserFromContext(r.Context())
+
+	totp, err := s.userStorage.FetchUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return accountTwoFactorPageData{}, nil
+		}
+		return accountTwoFactorPageData{}, err
+	}
+	if totp.IsConfirmed {
+		left, err := s.userStorage.CountUserRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			return accountTwoFactorPageData{}, err
+		}
+		return accountTwoFactorPageData{Enabled: true, RecoveryCodesLeft: left}, nil
+	}
+
+	return accountTwoFactorPageData{
+		Secret: totp.Secret,
+		URI:    template.URL(auth.TOTPURI(s.totpIssuer, user.Login, totp.Secret)),
+	}, nil
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/adminapi"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/validator"
+)
+
+// apiMaxBodySize limits JSON request body
+const apiMaxBodySize = 1 << 20
+
+// APIController serves JSON for scripts and services authenticated by API tokens
+type APIController struct {
+	userStorage    storage.UserStorage
+	passwordPolicy model.PasswordPolicy
+	auditRecorder  *audit.Recorder
+}
+
+func NewAPIController(
+	userStorage storage.UserStorage,
+	passwordPolicy model.PasswordPolicy,
+	auditRecorder *audit.Recorder,
+) *APIController {
+	return &APIController{
+		userStorage:    userStorage,
+		passwordPolicy: passwordPolicy,
+		auditRecorder:  auditRecorder,
+	}
+}
+
+// @API
+func (s *APIController) Me(w http.ResponseWriter, r *http.Request) {
+	user := auth.MustUserFromContext(r.Context())
+	renderer.WriteJSON(w, http.StatusOK, adminapi.MeResponse{
+		ID:          user.ID,
+		Login:       user.Login,
+		Email:       user.Email,
+		Permissions: user.Permissions,
+	})
+}
+
+// Error writes problem details, internal errors are logged and never exposed to client
+func (s *APIController) Error(w http.ResponseWriter, r *http.Request, status int, msg string, err error) {
+	e := httptools.NewError(status, msg, err)
+	httptools.LogError(r, e)
+	httptools.WriteProblem(w, r, e)
+}
+
+// StorageError maps storage errors to statuses, so missing and duplicate entities look the same in every handler
+func (s *APIController) StorageError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		s.Error(w, r, http.StatusNotFound, msg, err)
+	case errors.Is(err, storage.ErrDuplicate):
+		s.Error(w, r, http.StatusConflict, msg, err)
+	default:
+		s.Error(w, r, http.StatusInternalServerError, msg, err)
+	}
+}
+
+// ValidationError writes field errors of validator as problem details
+func (s *APIController) ValidationError(w http.ResponseWriter, r *http.Request, status int, v validator.Validator) {
+	msg := "Невалидные данные"
+	if len(v.NonFieldErrors) > 0 {
+		msg = v.NonFieldErrors[0]
+	}
+	e := httptools.NewError(status, msg, nil)
+	e.Fields = v.FieldErrors
+	httptools.WriteProblem(w, r, e)
+}
+
+// decodeJSON reads request body into v, unknown fields are rejected to catch typos in clients
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("could not decode json: %w", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("body must contain single json object")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/adminapi"
+)
+
+// APIRoute is endpoint of API contract with handler bound to controller
+type APIRoute struct {
+	adminapi.Route
+	Handler http.HandlerFunc
+}
+
+// Routes returns API endpoints, handlers are bound to controller by operation id
+func (s *APIController) Routes() []APIRoute {
+	handlers := map[string]http.HandlerFunc{
+		"getMe":              s.Me,
+		"listUsers":          s.ListUsers,
+		"createUser":         s.CreateUser,
+		"getUser":            s.GetUser,
+		"updateUser":         s.UpdateUser,
+		"listUserSessions":   s.ListUserSessions,
+		"revokeUserSessions": s.RevokeUserSessions,
+		"revokeUserSession":  s.RevokeUserSession,
+	}
+
+	routes := adminapi.Routes()
+	res := make([]APIRoute, 0, len(routes))
+	for _, route := range routes {
+		handler, ok := handlers[route.OperationID]
+		if !ok {
+			panic(fmt.Sprintf("api: no handler for operation %q", route.OperationID))
+		}
+		res = append(res, APIRoute{Route: route, Handler: handler})
+	}
+	return res
+}
+
+// @API
+func (s *APIController) OpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	doc, err := adminapi.NewDocument()
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось построить OpenAPI документ", err)
+		return
+	}
+	renderer.WriteJSON(w, http.StatusOK, doc)
+}
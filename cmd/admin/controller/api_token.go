@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/validator"
+)
+
+// apiTokenTTLDays are lifetimes offered in token form, the first one is default
+var apiTokenTTLDays = []int{30, 90, 365}
+
+type userTokensPageData struct {
+	User   model.User
+	Tokens []apiTokenRow
+	// Own is set on account page, only owner can create tokens and see plain one,
+	// admins can only list and revoke tokens of other users
+	Own  bool
+	Form apiTokenForm
+	// CreatedToken is plain token, it is shown only once right after creation
+	CreatedToken string
+}
+
+func (d userTokensPageData) Scopes() []model.Permission {
+	return model.Permissions
+}
+
+func (d userTokensPageData) TTLDays() []int {
+	return apiTokenTTLDays
+}
+
+type apiTokenRow struct {
+	model.APIToken
+	IsExpired bool
+}
+
+type apiTokenForm struct {
+	Name    string
+	Scopes  []model.Permission
+	TTLDays int
+
+	validator.Validator
+}
+
+func (f apiTokenForm) HasScope(scope model.Permission) bool {
+	return slices.Contains(f.Scopes, scope)
+}
+
+// @SSR
+func (s *UserController) UserTokensPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := s.userTokensPageData(r, user, apiTokenForm{})
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить API-токены", err)
+		return
+	}
+	s.Render(w, r, http.StatusOK, "user-tokens.tmpl.html", renderer.SmartBlock, data)
+}
+
+// @HTMX
+func (s *UserController) RevokeUserToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchManagedUser(w, r)
+	if !ok {
+		return
+	}
+	s.revokeToken(w, r, user)
+}
+
+// @SSR
+func (s *UserController) AccountTokensPage(w http.ResponseWriter, r *http.Request) {
+	user := auth.MustUserFromContext(r.Context())
+
+	data, err := s.userTokensPageData(r, user, apiTokenForm{TTLDays: apiTokenTTLDays[0]})
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить API-токены", err)
+		return
+	}
+	data.Own = true
+	s.Render(w, r, http.StatusOK, "user-tokens.tmpl.html", renderer.SmartBlock, data)
+}
+
+// @HTMX
+func (s *UserController) CreateAccountToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.MustUserFromContext(r.Context())
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	form := apiTokenForm{Name: strings.TrimSpace(r.PostForm.Get("name"))}
+	for _, scope := range r.PostForm["scopes"] {
+		form.Scopes = append(form.Scopes, model.Permission(scope))
+	}
+	form.TTLDays, _ = strconv.Atoi(r.PostForm.Get("ttl_days"))
+	form.CheckField(validator.NotBlank(form.Name), "name", "Название не может быть пустым")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "Название должно быть не длиннее 100 символов")
+	form.CheckField(len(form.Scopes) > 0, "scopes", "Выберите хотя бы одно право")
+	for _, scope := range form.Scopes {
+		form.CheckField(slices.Contains(model.Permissions, scope), "scopes", "Неизвестное право")
+	}
+	form.CheckField(validator.PermittedValue(form.TTLDays, apiTokenTTLDays...), "ttl_days", "Недопустимый срок действия")
+	if !form.Valid() {
+		s.renderAccountTokens(w, r, user, form, "")
+		return
+	}
+
+	ttl := time.Duration(form.TTLDays) * 24 * time.Hour
+	token, plain, err := auth.CreateAPIToken(r.Context(), s.userStorage, user.ID, form.Name, form.Scopes, ttl)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось создать API-токен", err)
+		return
+	}
+	recordAPITokenAudit(r, s.auditRecorder, audit.ActionAPITokenCreate, token.ID, audit.Diff(nil, audit.APITokenFields(token)))
+
+	s.renderAccountTokens(w, r, user, apiTokenForm{TTLDays: apiTokenTTLDays[0]}, plain)
+}
+
+// @HTMX
+func (s *UserController) RevokeAccountToken(w http.ResponseWriter, r *http.Request) {
+	s.revokeToken(w, r, auth.MustUserFromContext(r.Context()))
+}
+
+func (s *UserController) revokeToken(w http.ResponseWriter, r *http.Request, user *model.User) {
+	tokenID, err := httptools.GetPathInt64(r, "tokenID")
+	if err != nil {
+		s.Error(w, r, http.StatusNotFound, "API-токен не найден", err)
+		return
+	}
+
+	tokens, err := s.userStorage.FilterUserAPITokens(r.Context(), user.ID)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось отозвать API-токен", err)
+		return
+	}
+	i := slices.IndexFunc(tokens, func(t model.APIToken) bool { return t.ID == tokenID })
+	if i < 0 {
+		s.Error(w, r, http.StatusNotFound, "API-токен не найден", nil)
+		return
+	}
+	if err := s.userStorage.DeleteUserAPIToken(r.Context(), user.ID, tokenID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.Error(w, r, http.StatusNotFound, "API-токен не найден", err)
+			return
+		}
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось отозвать API-токен", err)
+		return
+	}
+	recordAPITokenAudit(r, s.auditRecorder, audit.ActionAPITokenRevoke, tokenID, audit.Diff(audit.APITokenFields(&tokens[i]), nil))
+
+	// empty response removes token row
+}
+
+func (s *UserController) renderAccountTokens(
+	w http.ResponseWriter,
+	r *http.Request,
+	user *model.User,
+	form apiTokenForm,
+	createdToken string,
+) {
+	data, err := s.userTokensPageData(r, user, form)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить API-токены", err)
+		return
+	}
+	data.Own = true
+	data.CreatedToken = createdToken
+	s.Render(w, r, http.StatusOK, "user-tokens.tmpl.html", "user-tokens", data)
+}
+
+func (s *UserController) userTokensPageData(
+	r *http.Request,
+	user *model.User,
+	form apiTokenForm,
+) (userTokensPageData, error) {
+	tokens, err := s.userStorage.FilterUserAPITokens(r.Context(), user.ID)
+	if err != nil {
+		return userTokensPageData{}, err
+	}
+
+	now := time.Now()
+	rows := make([]apiTokenRow, len(tokens))
+	for i, token := range tokens {
+		rows[i] = apiTokenRow{APIToken: token, IsExpired: token.IsExpired(now)}
+	}
+	return userTokensPageData{User: *user, Tokens: rows, Form: form}, nil
+}
+
+func recordAPITokenAudit(
+	r *http.Request,
+	recorder *audit.Recorder,
+	action audit.Action,
+	tokenID int64,
+	changes map[string]audit.Change,
+) {
+	recorder.Record(r.Context(), audit.Entry{
+		Actor:      auditActor(r),
+		Action:     action,
+		TargetType: audit.EntityAPIToken,
+		TargetID:   strconv.FormatInt(tokenID, 10),
+		Changes:    changes,
+	})
+}
@@ -0,0 +1,338 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/adminapi"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/postgres/pagination"
+)
+
+// parseAPIUsersQuery reads offset, limit, sort, order and q query parameters
+func parseAPIUsersQuery(r *http.Request) (storage.UserFilterParams, error) {
+	q := r.URL.Query()
+
+	offset, err := httptools.GetQueryInt64(r, "offset")
+	if err != nil {
+		return storage.UserFilterParams{}, fmt.Errorf("offset: %w", err)
+	}
+	limit, err := httptools.GetQueryInt64(r, "limit")
+	if err != nil {
+		return storage.UserFilterParams{}, fmt.Errorf("limit: %w", err)
+	}
+	if offset < 0 || limit < 0 {
+		return storage.UserFilterParams{}, fmt.Errorf("offset and limit can't be negative")
+	}
+	if limit > adminapi.UsersMaxLimit {
+		return storage.UserFilterParams{}, fmt.Errorf("limit can't be greater than %d", adminapi.UsersMaxLimit)
+	}
+	if limit == 0 {
+		limit = adminapi.UsersDefaultLimit
+	}
+
+	sort := strings.ToLower(q.Get("sort"))
+	if sort == "" {
+		sort = "id"
+	}
+	order, err := pagination.OrderFromString(q.Get("order"))
+	if err != nil {
+		return storage.UserFilterParams{}, err
+	}
+	if order == "" {
+		order = pagination.OrderAsc
+	}
+
+	filter := storage.UserFilterParams{
+		Pagination: pagination.Pagination{
+			Offset: uint64(offset),
+			Limit:  uint64(limit),
+			Sort:   []pagination.Sort{{By: sort, Order: order}},
+		},
+		Login: strings.TrimSpace(q.Get("q")),
+	}
+	if err := filter.Validate(adminapi.UserSortColumns); err != nil {
+		return filter, err
+	}
+	// Validate only checks sort fields, columns are mapped here
+	filter.Sort[0].By = adminapi.UserSortColumns[sort]
+
+	return filter, nil
+}
+
+// @API
+func (s *APIController) ListUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAPIUsersQuery(r)
+	if err != nil {
+		s.Error(w, r, http.StatusBadRequest, fmt.Sprintf("Невалидные параметры списка: %s", err), err)
+		return
+	}
+
+	users, err := s.userStorage.FilterUsers(r.Context(), filter)
+	if err != nil {
+		s.StorageError(w, r, "Не удалось загрузить пользователей", err)
+		return
+	}
+	total, err := s.userStorage.CountUsers(r.Context(), filter)
+	if err != nil {
+		s.StorageError(w, r, "Не удалось загрузить пользователей", err)
+		return
+	}
+
+	resp := adminapi.UsersResponse{
+		Items:  make([]adminapi.User, 0, len(users)),
+		Total:  total,
+		Offset: filter.Offset,
+		Limit:  filter.Limit,
+	}
+	for _, user := range users {
+		resp.Items = append(resp.Items, adminapi.NewUser(&user))
+	}
+	renderer.WriteJSON(w, http.StatusOK, resp)
+}
+
+// @API
+func (s *APIController) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return
+	}
+	renderer.WriteJSON(w, http.StatusOK, adminapi.NewUser(user))
+}
+
+// @API
+func (s *APIController) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req adminapi.CreateUserRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидный JSON", err)
+		return
+	}
+
+	form := userForm{
+		Login:    strings.TrimSpace(req.Login),
+		Email:    strings.TrimSpace(req.Email),
+		Password: req.Password,
+		// API has no confirmation field, password is typed by script
+		PasswordConfirmation: req.Password,
+		IsActive:             req.IsActive == nil || *req.IsActive,
+	}
+	form.checkLogin()
+	form.checkEmail()
+	form.checkPassword(s.passwordPolicy, form.Login)
+	if form.Valid() {
+		if err := checkEmailTaken(r, s.userStorage, &form, 0); err != nil {
+			s.StorageError(w, r, "Не удалось создать пользователя", err)
+			return
+		}
+	}
+	if !form.Valid() {
+		s.ValidationError(w, r, http.StatusUnprocessableEntity, form.Validator)
+		return
+	}
+
+	passwordHash, err := model.HashUserPassword(form.Password)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось создать пользователя", err)
+		return
+	}
+	user := &model.User{
+		Login:          form.Login,
+		Email:          form.Email,
+		HashedPassword: string(passwordHash),
+		IsActive:       form.IsActive,
+	}
+	if err := s.userStorage.CreateUser(r.Context(), user); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			form.AddFieldError("login", "Пользователь с таким логином уже существует")
+			s.ValidationError(w, r, http.StatusConflict, form.Validator)
+			return
+		}
+		s.StorageError(w, r, "Не удалось создать пользователя", err)
+		return
+	}
+	recordUserAudit(r, s.auditRecorder, audit.ActionUserCreate, user.ID, audit.Diff(nil, audit.UserFields(user)))
+
+	renderer.WriteJSON(w, http.StatusCreated, adminapi.NewUser(user))
+}
+
+// @API
+func (s *APIController) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchManagedUser(w, r)
+	if !ok {
+		return
+	}
+	var req adminapi.UpdateUserRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидный JSON", err)
+		return
+	}
+
+	form := userForm{
+		Login:    strings.TrimSpace(req.Login),
+		Email:    strings.TrimSpace(req.Email),
+		IsActive: req.IsActive,
+	}
+	form.checkLogin()
+	form.checkEmail()
+	current := auth.MustUserFromContext(r.Context())
+	form.CheckField(form.IsActive || user.ID != current.ID, "is_active", "Нельзя заблокировать самого себя")
+	if form.Valid() {
+		if err := checkEmailTaken(r, s.userStorage, &form, user.ID); err != nil {
+			s.StorageError(w, r, "Не удалось сохранить пользователя", err)
+			return
+		}
+	}
+	if !form.Valid() {
+		s.ValidationError(w, r, http.StatusUnprocessableEntity, form.Validator)
+		return
+	}
+
+	before := audit.UserFields(user)
+	emailChanged := !strings.EqualFold(user.Email, form.Email)
+	user.Login = form.Login
+	user.Email = form.Email
+	user.IsActive = form.IsActive
+	if err := s.userStorage.UpdateUser(r.Context(), user); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			form.AddFieldError("login", "Пользователь с таким логином уже существует")
+			s.ValidationError(w, r, http.StatusConflict, form.Validator)
+			return
+		}
+		s.StorageError(w, r, "Не удалось сохранить пользователя", err)
+		return
+	}
+	recordUserAudit(r, s.auditRecorder, audit.ActionUserUpdate, user.ID, audit.Diff(before, audit.UserFields(user)))
+
+	// reset links were sent to old address
+	if emailChanged {
+		if err := s.userStorage.DeleteUserPasswordResetTokens(r.Context(), user.ID); err != nil {
+			s.StorageError(w, r, "Пользователь сохранен, но не удалось отозвать ссылки сброса пароля", err)
+			return
+		}
+	}
+
+	renderer.WriteJSON(w, http.StatusOK, adminapi.NewUser(user))
+}
+
+// @API
+func (s *APIController) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return
+	}
+
+	filter := storage.UserSessionsFilterParams{UserID: user.ID, IsActive: true}
+	sessions, err := s.userStorage.FilterUserSessions(r.Context(), filter)
+	if err != nil {
+		s.StorageError(w, r, "Не удалось загрузить сессии пользователя", err)
+		return
+	}
+
+	resp := adminapi.SessionsResponse{Items: make([]adminapi.Session, 0, len(sessions))}
+	for _, session := range sessions {
+		resp.Items = append(resp.Items, adminapi.NewSession(&session))
+	}
+	renderer.WriteJSON(w, http.StatusOK, resp)
+}
+
+// @API
+func (s *APIController) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return
+	}
+
+	filter := storage.UserSessionsFilterParams{UserID: user.ID}
+	sessions, err := s.userStorage.FilterUserSessions(r.Context(), filter)
+	if err != nil {
+		s.StorageError(w, r, "Не удалось завершить сессии пользователя", err)
+		return
+	}
+	s.revokeSessions(w, r, user, sessions)
+}
+
+// @API
+func (s *APIController) RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return
+	}
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		s.Error(w, r, http.StatusNotFound, "Сессия не найдена", err)
+		return
+	}
+
+	session, err := s.userStorage.FetchUserSession(r.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.Error(w, r, http.StatusNotFound, "Сессия не найдена", err)
+			return
+		}
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить сессию", err)
+		return
+	}
+	// session of other user is indistinguishable from missing one
+	if session.UserID != user.ID {
+		s.Error(w, r, http.StatusNotFound, "Сессия не найдена", nil)
+		return
+	}
+	s.revokeSessions(w, r, user, []model.UserSession{*session})
+}
+
+func (s *APIController) revokeSessions(w http.ResponseWriter, r *http.Request, user *model.User, sessions []model.UserSession) {
+	if err := s.userStorage.DeleteUserSessions(r.Context(), sessions); err != nil {
+		s.StorageError(w, r, "Не удалось завершить сессии пользователя", err)
+		return
+	}
+	recordUserAudit(r, s.auditRecorder, audit.ActionSessionsRevoke, user.ID, map[string]audit.Change{
+		"revoked_sessions": {After: len(sessions)},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fetchPathUser fetches user by id from path and writes error if it fails
+func (s *APIController) fetchPathUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	id, err := httptools.GetPathInt64(r, "id")
+	if err != nil {
+		s.Error(w, r, http.StatusNotFound, "Пользователь не найден", err)
+		return nil, false
+	}
+
+	user, err := s.userStorage.FetchUserByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.Error(w, r, http.StatusNotFound, "Пользователь не найден", err)
+			return nil, false
+		}
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить пользователя", err)
+		return nil, false
+	}
+	return user, true
+}
+
+// fetchManagedUser loads user of path and refuses it if user has permissions which token owner lacks
+func (s *APIController) fetchManagedUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if err := s.userStorage.FetchUserRoles(r.Context(), user); err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить пользователя", err)
+		return nil, false
+	}
+	if !auth.MustUserFromContext(r.Context()).CanManage(user) {
+		s.Error(w, r, http.StatusForbidden, "Недостаточно прав для управления этим пользователем", auth.ErrForbidden)
+		return nil, false
+	}
+	return user, true
+}
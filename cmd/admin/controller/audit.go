@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/postgres/pagination"
+)
+
+const (
+	auditEntriesPerPage = 50
+
+	auditTableTarget = "audit-table"
+)
+
+var auditActionTitles = map[audit.Action]string{
+	audit.ActionUserCreate:         "Создание пользователя",
+	audit.ActionUserUpdate:         "Изменение пользователя",
+	audit.ActionUserActivate:       "Разблокировка пользователя",
+	audit.ActionUserDeactivate:     "Блокировка пользователя",
+	audit.ActionUserPasswordChange: "Смена пароля",
+	audit.ActionUserPasswordReset:  "Восстановление пароля",
+	audit.ActionUserRoleGrant:      "Выдача роли",
+	audit.ActionUserRoleRevoke:     "Отзыв роли",
+	audit.ActionUserTOTPEnable:     "Включение 2FA",
+	audit.ActionUserTOTPDisable:    "Отключение 2FA",
+	audit.ActionUserTOTPReset:      "Сброс 2FA",
+	audit.ActionAPITokenCreate:     "Выпуск API-токена",
+	audit.ActionAPITokenRevoke:     "Отзыв API-токена",
+	audit.ActionSessionsRevoke:     "Завершение сессий",
+	audit.ActionSessionsGC:         "Очистка истекших сессий",
+}
+
+type AuditController struct {
+	auditStorage audit.Storage
+
+	*renderer.HTMLRenderer
+}
+
+func NewAuditController(r *renderer.HTMLRenderer, auditStorage audit.Storage) *AuditController {
+	return &AuditController{
+		auditStorage: auditStorage,
+		HTMLRenderer: r,
+	}
+}
+
+type auditPageData struct {
+	Entries []auditEntryRow
+	Actions []auditActionOption
+
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Page       int
+	TotalPages int
+	Total      int
+}
+
+type auditActionOption struct {
+	Value string
+	Title string
+}
+
+type auditEntryRow struct {
+	audit.Entry
+	ActionTitle string
+	Changes     []auditChangeRow
+}
+
+type auditChangeRow struct {
+	Field  string
+	Before string
+	After  string
+}
+
+func (d auditPageData) HasPrev() bool {
+	return d.Page > 1
+}
+
+func (d auditPageData) HasNext() bool {
+	return d.Page < d.TotalPages
+}
+
+func (d auditPageData) PrevURL() string {
+	return d.pageURL(d.Page - 1)
+}
+
+func (d auditPageData) NextURL() string {
+	return d.pageURL(d.Page + 1)
+}
+
+func (d auditPageData) pageURL(page int) string {
+	q := url.Values{}
+	for key, value := range map[string]string{
+		"actor":       d.Actor,
+		"action":      d.Action,
+		"target_type": d.TargetType,
+		"target_id":   d.TargetID,
+	} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return "/audit?" + q.Encode()
+}
+
+// @SSR @HTMX
+func (s *AuditController) AuditPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data := auditPageData{
+		Actor:      strings.TrimSpace(query.Get("actor")),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   strings.TrimSpace(query.Get("target_id")),
+	}
+	page, err := parsePage(r)
+	if err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные параметры списка", err)
+		return
+	}
+	data.Page = page
+
+	filter := audit.FilterParams{
+		Pagination: pagination.Pagination{
+			Offset: uint64((data.Page - 1) * auditEntriesPerPage),
+			Limit:  auditEntriesPerPage,
+		},
+		ActorLogin: data.Actor,
+		Action:     audit.Action(data.Action),
+		TargetType: data.TargetType,
+		TargetID:   data.TargetID,
+	}
+	entries, err := s.auditStorage.FilterEntries(r.Context(), filter)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить журнал действий", err)
+		return
+	}
+	total, err := s.auditStorage.CountEntries(r.Context(), filter)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить журнал действий", err)
+		return
+	}
+
+	data.Entries = make([]auditEntryRow, 0, len(entries))
+	for _, entry := range entries {
+		data.Entries = append(data.Entries, auditEntryRow{
+			Entry:       entry,
+			ActionTitle: auditActionTitle(entry.Action),
+			Changes:     auditChangeRows(entry.Changes),
+		})
+	}
+	data.Total = total
+	data.TotalPages = max(1, int(math.Ceil(float64(total)/auditEntriesPerPage)))
+
+	// search and pagination swap only the table
+	if r.Header.Get("HX-Target") == auditTableTarget {
+		s.Render(w, r, http.StatusOK, "audit.tmpl.html", auditTableTarget, data)
+		return
+	}
+
+	data.Actions = make([]auditActionOption, 0, len(audit.Actions))
+	for _, action := range audit.Actions {
+		data.Actions = append(data.Actions, auditActionOption{Value: string(action), Title: auditActionTitle(action)})
+	}
+	s.Render(w, r, http.StatusOK, "audit.tmpl.html", renderer.SmartBlock, data)
+}
+
+func auditActionTitle(action audit.Action) string {
+	if title, ok := auditActionTitles[action]; ok {
+		return title
+	}
+	return string(action)
+}
+
+func auditChangeRows(changes map[string]audit.Change) []auditChangeRow {
+	rows := make([]auditChangeRow, 0, len(changes))
+	for field, change := range changes {
+		rows = append(rows, auditChangeRow{
+			Field:  field,
+			Before: formatAuditValue(change.Before),
+			After:  formatAuditValue(change.After),
+		})
+	}
+	slices.SortFunc(rows, func(a, b auditChangeRow) int { return strings.Compare(a.Field, b.Field) })
+	return rows
+}
+
+func formatAuditValue(v any) string {
+	if v == nil || v == "" {
+		return "—"
+	}
+	return fmt.Sprint(v)
+}
+
+// auditActor describes user who makes request, anonymous requests have only ip and request id
+func auditActor(r *http.Request) audit.Actor {
+	actor := audit.Actor{
+		Source:    audit.SourceWeb,
+		IP:        httptools.RemoteIP(r),
+		RequestID: httptools.GetTraceID(r),
+	}
+	if user, err := auth.UserFromContext(r.Context()); err == nil {
+		actor.UserID = user.ID
+		actor.Login = user.Login
+	}
+	if _, err := auth.APITokenFromContext(r.Context()); err == nil {
+		actor.Source = audit.SourceAPI
+	}
+	return actor
+}
+
+func userAuditTarget(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func recordUserAudit(
+	r *http.Request,
+	recorder *audit.Recorder,
+	action audit.Action,
+	userID int64,
+	changes map[string]audit.Change,
+) {
+	recorder.Record(r.Context(), audit.Entry{
+		Actor:      auditActor(r),
+		Action:     action,
+		TargetType: audit.EntityUser,
+		TargetID:   userAuditTarget(userID),
+		Changes:    changes,
+	})
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/agalitsyn/goth/internal/auth"
+)
+
+// @SSR
+func (s *UserController) ExternalLogin(w http.ResponseWriter, r *http.Request) {
+	if s.externalAuthenticator == nil {
+		s.Error(w, r, http.StatusNotFound, "Вход через SSO не настроен", nil)
+		return
+	}
+
+	authURL, err := s.externalAuthenticator.BeginLogin(w, r)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Сервис аутентификации недоступен", err)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// @SSR
+func (s *UserController) ExternalLoginCallback(w http.ResponseWriter, r *http.Request) {
+	if s.externalAuthenticator == nil {
+		s.Error(w, r, http.StatusNotFound, "Вход через SSO не настроен", nil)
+		return
+	}
+
+	session, err := s.externalAuthenticator.CompleteLogin(w, r)
+	if err != nil {
+		// code form is posted from our page, so session cookie set by LoginSecondFactor is sent on redirect
+		var secondFactorErr *auth.SecondFactorRequiredError
+		if errors.As(err, &secondFactorErr) {
+			data := loginPageData{ChallengeID: secondFactorErr.ChallengeID}
+			s.Render(w, r, http.StatusOK, "login.tmpl.html", "loginbase", data)
+			return
+		}
+
+		data := s.loginPageData()
+		switch {
+		case errors.Is(err, auth.ErrExternalLoginState):
+			data.Form.AddNonFieldError("Время входа истекло, попробуйте еще раз")
+		case errors.Is(err, auth.ErrExternalLoginDenied):
+			data.Form.AddNonFieldError("Вход отменен")
+		case errors.Is(err, auth.ErrExternalUserNotFound), errors.Is(err, auth.ErrForbidden):
+			data.Form.AddNonFieldError("Пользователю запрещен вход")
+		default:
+			data.Form.AddNonFieldError("Сервис аутентификации недоступен")
+		}
+		s.Render(w, r, http.StatusOK, "login.tmpl.html", "loginbase", data)
+		return
+	}
+
+	cookie, err := s.authenticator.MakeSessionCookie(session.UUID)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Сервис аутентификации недоступен", err)
+		return
+	}
+	http.SetCookie(w, cookie)
+
+	// Session cookie is SameSite=Strict and browser does not send it on redirect chain started by provider,
+	// so navigation to app starts from page of our site instead of redirect
+	s.Render(w, r, http.StatusOK, "login-redirect.tmpl.html", "loginbase", nil)
+}
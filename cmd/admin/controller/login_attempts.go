@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/postgres/pagination"
+)
+
+const (
+	loginAttemptsPerPage = 50
+
+	loginAttemptsTableTarget = "login-attempts-table"
+)
+
+var loginOutcomeTitles = map[model.LoginOutcome]string{
+	model.LoginOutcomePending:              "Проверяется",
+	model.LoginOutcomeSuccess:              "Успешный вход",
+	model.LoginOutcomeInvalidCredentials:   "Неверный логин или пароль",
+	model.LoginOutcomeForbidden:            "Вход запрещен",
+	model.LoginOutcomeLocked:               "Заблокирован после неудачных попыток",
+	model.LoginOutcomeSecondFactorRequired: "Запрошен второй фактор",
+	model.LoginOutcomeInvalidCode:          "Неверный код второго фактора",
+	model.LoginOutcomeError:                "Ошибка",
+}
+
+type loginAttemptsPageData struct {
+	Attempts []loginAttemptRow
+
+	Login      string
+	Page       int
+	TotalPages int
+	Total      int
+}
+
+type loginAttemptRow struct {
+	model.LoginAttempt
+	OutcomeTitle string
+	IsFailure    bool
+}
+
+func (d loginAttemptsPageData) HasPrev() bool {
+	return d.Page > 1
+}
+
+func (d loginAttemptsPageData) HasNext() bool {
+	return d.Page < d.TotalPages
+}
+
+func (d loginAttemptsPageData) PrevURL() string {
+	return loginAttemptsURL(d.Login, d.Page-1)
+}
+
+func (d loginAttemptsPageData) NextURL() string {
+	return loginAttemptsURL(d.Login, d.Page+1)
+}
+
+func loginAttemptsURL(login string, page int) string {
+	q := url.Values{}
+	if login != "" {
+		q.Set("login", login)
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return "/audit/logins?" + q.Encode()
+}
+
+// @SSR @HTMX
+func (s *UserController) LoginAttemptsPage(w http.ResponseWriter, r *http.Request) {
+	data := loginAttemptsPageData{
+		Login: strings.TrimSpace(r.URL.Query().Get("login")),
+	}
+	page, err := parsePage(r)
+	if err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные параметры списка", err)
+		return
+	}
+	data.Page = page
+
+	filter := storage.LoginAttemptsFilterParams{
+		Pagination: pagination.Pagination{
+			Offset: uint64((data.Page - 1) * loginAttemptsPerPage),
+			Limit:  loginAttemptsPerPage,
+		},
+		Login: data.Login,
+	}
+	attempts, err := s.userStorage.FilterLoginAttempts(r.Context(), filter)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить журнал входов", err)
+		return
+	}
+	total, err := s.userStorage.CountLoginAttempts(r.Context(), filter)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить журнал входов", err)
+		return
+	}
+
+	data.Attempts = make([]loginAttemptRow, 0, len(attempts))
+	for _, attempt := range attempts {
+		title, ok := loginOutcomeTitles[attempt.Outcome]
+		if !ok {
+			title = string(attempt.Outcome)
+		}
+		data.Attempts = append(data.Attempts, loginAttemptRow{
+			LoginAttempt: attempt,
+			OutcomeTitle: title,
+			IsFailure:    attempt.Outcome != model.LoginOutcomeSuccess && attempt.Outcome != model.LoginOutcomeSecondFactorRequired,
+		})
+	}
+	data.Total = total
+	data.TotalPages = max(1, int(math.Ceil(float64(total)/loginAttemptsPerPage)))
+
+	// search and pagination swap only the table
+	if r.Header.Get("HX-Target") == loginAttemptsTableTarget {
+		s.Render(w, r, http.StatusOK, "login-attempts.tmpl.html", loginAttemptsTableTarget, data)
+		return
+	}
+	s.Render(w, r, http.StatusOK, "login-attempts.tmpl.html", renderer.SmartBlock, data)
+}
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/agalitsyn/goth/pkg/httptools"
+)
+
+// maxPage bounds page query parameter, so offset of page can't overflow
+const maxPage = 100_000
+
+// parsePage returns page number from query, missing or non-positive page is the first one
+// and page beyond maxPage is clamped
+func parsePage(r *http.Request) (int, error) {
+	page, err := httptools.GetQueryInt64(r, "page")
+	if err != nil {
+		return 1, err
+	}
+	return int(min(max(page, 1), maxPage)), nil
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/validator"
+)
+
+type PasswordResetController struct {
+	resetter       *auth.PasswordResetter
+	passwordPolicy model.PasswordPolicy
+	auditRecorder  *audit.Recorder
+
+	*renderer.HTMLRenderer
+}
+
+func NewPasswordResetController(
+	r *renderer.HTMLRenderer,
+	resetter *auth.PasswordResetter,
+	passwordPolicy model.PasswordPolicy,
+	auditRecorder *audit.Recorder,
+) *PasswordResetController {
+	return &PasswordResetController{
+		resetter:       resetter,
+		passwordPolicy: passwordPolicy,
+		auditRecorder:  auditRecorder,
+		HTMLRenderer:   r,
+	}
+}
+
+type forgotPasswordPageData struct {
+	// Sent is set after request, it does not mean that email exists
+	Sent bool
+	Form forgotPasswordForm
+}
+
+type forgotPasswordForm struct {
+	Email string
+
+	validator.Validator
+}
+
+// @SSR
+func (s *PasswordResetController) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	s.Render(w, r, http.StatusOK, "password-forgot.tmpl.html", "loginbase", forgotPasswordPageData{})
+}
+
+// @HTMX
+func (s *PasswordResetController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	form := forgotPasswordForm{Email: strings.TrimSpace(r.PostForm.Get("email"))}
+	form.CheckField(validator.NotBlank(form.Email), "email", "Email не может быть пустым")
+	if form.Valid() {
+		_, err := mail.ParseAddress(form.Email)
+		form.CheckField(err == nil, "email", "Невалидный email")
+	}
+	if !form.Valid() {
+		s.Render(w, r, http.StatusOK, "password-forgot.tmpl.html", renderer.SmartBlock, forgotPasswordPageData{Form: form})
+		return
+	}
+
+	// mail is sent in background, response must be the same for registered and unknown emails
+	s.resetter.RequestResetAsync(r.Context(), form.Email)
+	s.Render(w, r, http.StatusOK, "password-forgot.tmpl.html", renderer.SmartBlock, forgotPasswordPageData{Sent: true})
+}
+
+type resetPasswordPageData struct {
+	Token string
+	// Invalid is set when token is unknown, expired or used
+	Invalid bool
+	Done    bool
+	Form    userForm
+}
+
+// @SSR
+func (s *PasswordResetController) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	// token is in url, don't leak it to other sites
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	data := resetPasswordPageData{Token: r.URL.Query().Get("token")}
+	if _, err := s.resetter.CheckToken(r.Context(), data.Token); err != nil {
+		if !errors.Is(err, auth.ErrInvalidResetToken) {
+			s.Error(w, r, http.StatusInternalServerError, "Не удалось проверить ссылку", err)
+			return
+		}
+		data = resetPasswordPageData{Invalid: true}
+	}
+	s.Render(w, r, http.StatusOK, "password-reset.tmpl.html", "loginbase", data)
+}
+
+// @HTMX
+func (s *PasswordResetController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	data := resetPasswordPageData{
+		Token: r.PostForm.Get("token"),
+		Form: userForm{
+			Password:             r.PostForm.Get("password"),
+			PasswordConfirmation: r.PostForm.Get("password_confirmation"),
+		},
+	}
+	user, err := s.resetter.CheckToken(r.Context(), data.Token)
+	if err != nil {
+		s.renderResetError(w, r, err)
+		return
+	}
+	data.Form.checkPassword(s.passwordPolicy, user.Login)
+	if !data.Form.Valid() {
+		// never send passwords back
+		data.Form.Password = ""
+		data.Form.PasswordConfirmation = ""
+		s.Render(w, r, http.StatusOK, "password-reset.tmpl.html", renderer.SmartBlock, data)
+		return
+	}
+
+	user, err = s.resetter.ResetPassword(r.Context(), data.Token, data.Form.Password)
+	if err != nil {
+		s.renderResetError(w, r, err)
+		return
+	}
+	// request is anonymous, user proved identity with token
+	actor := auditActor(r)
+	actor.UserID = user.ID
+	actor.Login = user.Login
+	s.auditRecorder.Record(r.Context(), audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionUserPasswordReset,
+		TargetType: audit.EntityUser,
+		TargetID:   userAuditTarget(user.ID),
+		Changes:    map[string]audit.Change{"password": {After: audit.Redacted}},
+	})
+	s.Render(w, r, http.StatusOK, "password-reset.tmpl.html", renderer.SmartBlock, resetPasswordPageData{Done: true})
+}
+
+func (s *PasswordResetController) renderResetError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, auth.ErrInvalidResetToken) {
+		s.Render(w, r, http.StatusOK, "password-reset.tmpl.html", renderer.SmartBlock, resetPasswordPageData{Invalid: true})
+		return
+	}
+	s.Error(w, r, http.StatusInternalServerError, "Не удалось сменить пароль, попробуйте позже", err)
+}
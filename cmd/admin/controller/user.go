@@ -3,40 +3,70 @@ package controller
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/audit"
 	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/model"
 	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/pkg/httptools"
 	"github.com/agalitsyn/validator"
 )
 
 type UserController struct {
 	authenticator *auth.SessionAuthenticator
-	userStorage   storage.UserStorage
+	// externalAuthenticator is nil when sign in with identity provider is disabled
+	externalAuthenticator *auth.ExternalAuthenticator
+	userStorage           storage.UserStorage
+	passwordPolicy        model.PasswordPolicy
+	totpIssuer            string
+	auditRecorder         *audit.Recorder
 
 	*renderer.HTMLRenderer
 }
 
-func NewUserController(r *renderer.HTMLRenderer, authenticator *auth.SessionAuthenticator, userStorage storage.UserStorage) *UserController {
+func NewUserController(
+	r *renderer.HTMLRenderer,
+	authenticator *auth.SessionAuthenticator,
+	externalAuthenticator *auth.ExternalAuthenticator,
+	userStorage storage.UserStorage,
+	passwordPolicy model.PasswordPolicy,
+	totpIssuer string,
+	auditRecorder *audit.Recorder,
+) *UserController {
 	return &UserController{
-		authenticator: authenticator,
-		userStorage:   userStorage,
-		HTMLRenderer:  r,
+		authenticator:         authenticator,
+		externalAuthenticator: externalAuthenticator,
+		userStorage:           userStorage,
+		passwordPolicy:        passwordPolicy,
+		totpIssuer:            totpIssuer,
+		auditRecorder:         auditRecorder,
+		HTMLRenderer:          r,
 	}
 }
 
 // @SSR
 func (s *UserController) LoginPage(w http.ResponseWriter, r *http.Request) {
-	s.Render(w, r, http.StatusOK, "login.tmpl.html", "loginbase", loginPageData{})
+	s.Render(w, r, http.StatusOK, "login.tmpl.html", "loginbase", s.loginPageData())
 }
 
 type loginPageData struct {
-	Form loginForm
+	// ChallengeID is set when password is valid and second factor is required
+	ChallengeID string
+	// ExternalLogin shows link to sign in with identity provider
+	ExternalLogin bool
+	Form          loginForm
+}
+
+func (s *UserController) loginPageData() loginPageData {
+	return loginPageData{ExternalLogin: s.externalAuthenticator != nil}
 }
 
 type loginForm struct {
 	Login    string
-	Password string
+	Password string `json:"-"`
+	Code     string
 
 	validator.Validator
 }
@@ -44,7 +74,7 @@ type loginForm struct {
 // @HTMX
 func (s *UserController) Login(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		s.Error(w, r, http.StatusOK, "Невалидные данные для аутентификации", nil)
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные для аутентификации", err)
 		return
 	}
 
@@ -56,34 +86,121 @@ func (s *UserController) Login(w http.ResponseWriter, r *http.Request) {
 	form.CheckField(validator.NotBlank(form.Login), "login", "Логин не может быть пустым")
 	form.CheckField(validator.NotBlank(form.Password), "password", "Пароль не может быть пустым")
 	if !form.Valid() {
-		data := loginPageData{Form: form}
-		s.Render(w, r, http.StatusOK, "login.tmpl.html", renderer.ContentBlock, data)
+		data := s.loginPageData()
+		data.Form = form
+		s.Render(w, r, http.StatusOK, "login.tmpl.html", renderer.SmartBlock, data)
 		return
 	}
 
-	session, err := s.authenticator.CreateSession(r.Context(), form.Login, form.Password)
+	session, err := s.authenticator.CreateSession(r.Context(), form.Login, form.Password, auth.NewClientInfo(r))
 	if err != nil {
+		var secondFactorErr *auth.SecondFactorRequiredError
+		if errors.As(err, &secondFactorErr) {
+			data := loginPageData{ChallengeID: secondFactorErr.ChallengeID}
+			s.Render(w, r, http.StatusOK, "login.tmpl.html", renderer.SmartBlock, data)
+			return
+		}
+		var lockedErr *auth.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			httptools.SetRetryAfter(w, lockedErr.RetryAfter)
+			s.Error(w, r, http.StatusTooManyRequests, "Слишком много неудачных попыток входа, попробуйте позже", nil)
+			return
+		}
 		if errors.Is(err, auth.ErrLoginPassword) {
-			s.Error(w, r, http.StatusOK, "Неверный логин или пароль", nil)
+			s.Error(w, r, http.StatusUnauthorized, "Неверный логин или пароль", nil)
+			return
+		}
+		if errors.Is(err, auth.ErrForbidden) {
+			s.Error(w, r, http.StatusForbidden, "Пользователю запрещен вход", nil)
+			return
+		}
+		s.Error(w, r, http.StatusInternalServerError, "Сервис аутентификации недоступен", err)
+		return
+	}
+
+	s.startSession(w, r, session)
+}
+
+// @HTMX
+func (s *UserController) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные для аутентификации", err)
+		return
+	}
+
+	data := loginPageData{
+		ChallengeID: r.PostForm.Get("challenge"),
+		Form:        loginForm{Code: strings.TrimSpace(r.PostForm.Get("code"))},
+	}
+	data.Form.CheckField(validator.NotBlank(data.Form.Code), "code", "Код не может быть пустым")
+	if !data.Form.Valid() {
+		s.Render(w, r, http.StatusOK, "login.tmpl.html", renderer.SmartBlock, data)
+		return
+	}
+
+	session, err := s.authenticator.CompleteSecondFactor(r.Context(), data.ChallengeID, data.Form.Code, auth.NewClientInfo(r))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCode) {
+			data.Form.AddFieldError("code", "Неверный код")
+			s.Render(w, r, http.StatusOK, "login.tmpl.html", renderer.SmartBlock, data)
+			return
+		}
+		if errors.Is(err, auth.ErrChallengeExpired) {
+			// start over with password
+			data = s.loginPageData()
+			data.Form.AddNonFieldError("Время подтверждения истекло, войдите заново")
+			s.Render(w, r, http.StatusOK, "login.tmpl.html", renderer.SmartBlock, data)
 			return
 		}
 		if errors.Is(err, auth.ErrForbidden) {
-			s.Error(w, r, http.StatusOK, "Пользователю запрещен вход", nil)
+			s.Error(w, r, http.StatusForbidden, "Пользователю запрещен вход", nil)
 			return
 		}
-		s.Error(w, r, http.StatusOK, "Сервис аутентификации недоступен", nil)
+		s.Error(w, r, http.StatusInternalServerError, "Сервис аутентификации недоступен", err)
 		return
 	}
 
-	cookie := s.authenticator.MakeSessionCookie(session.UUID)
+	s.startSession(w, r, session)
+}
+
+func (s *UserController) startSession(w http.ResponseWriter, r *http.Request, session *model.UserSession) {
+	cookie, err := s.authenticator.MakeSessionCookie(session.UUID)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Сервис аутентификации недоступен", err)
+		return
+	}
 	http.SetCookie(w, cookie)
 
 	w.Header().Set("HX-Redirect", "/")
 }
 
-// @HTMX
+// @SSR
 func (s *UserController) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := s.authenticator.RevokeSession(r); err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось завершить сессию", err)
+		return
+	}
+
 	cookie := s.authenticator.DeletionSessionCookie()
 	http.SetCookie(w, cookie)
-	http.Redirect(w, r, "/", http.StatusMovedPermanently)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// @SSR
+func (s *UserController) LogoutOtherSessions(w http.ResponseWriter, r *http.Request) {
+	session, err := auth.SessionFromContext(r.Context())
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось завершить сессии", err)
+		return
+	}
+
+	count, err := s.authenticator.RevokeOtherSessions(r.Context(), session)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось завершить сессии", err)
+		return
+	}
+	recordUserAudit(r, s.auditRecorder, audit.ActionSessionsRevoke, session.UserID, map[string]audit.Change{
+		"revoked_sessions": {After: count},
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -0,0 +1,583 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/adminapi"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/postgres/pagination"
+	"github.com/agalitsyn/validator"
+)
+
+const (
+	usersPerPage = 20
+
+	usersTableTarget = "users-table"
+)
+
+type usersPageData struct {
+	Users     []userRow
+	CanManage bool
+
+	Search     string
+	Sort       string
+	Order      string
+	Page       int
+	TotalPages int
+	Total      int
+}
+
+type userRow struct {
+	model.User
+	IsCurrent bool
+	CanManage bool
+}
+
+type usersJSONView struct {
+	Users      []userJSONView `json:"users"`
+	Search     string         `json:"search,omitempty"`
+	Sort       string         `json:"sort"`
+	Order      string         `json:"order"`
+	Page       int            `json:"page"`
+	TotalPages int            `json:"total_pages"`
+	Total      int            `json:"total"`
+}
+
+type userJSONView struct {
+	adminapi.User
+	IsCurrent bool `json:"is_current"`
+}
+
+func (d usersPageData) JSONView() any {
+	view := usersJSONView{
+		Users:      make([]userJSONView, 0, len(d.Users)),
+		Search:     d.Search,
+		Sort:       d.Sort,
+		Order:      d.Order,
+		Page:       d.Page,
+		TotalPages: d.TotalPages,
+		Total:      d.Total,
+	}
+	for _, row := range d.Users {
+		view.Users = append(view.Users, userJSONView{User: adminapi.NewUser(&row.User), IsCurrent: row.IsCurrent})
+	}
+	return view
+}
+
+func (d usersPageData) SortURL(field string) string {
+	order := "asc"
+	if d.Sort == field && d.Order == "asc" {
+		order = "desc"
+	}
+	return usersListURL(d.Search, field, order, 1)
+}
+
+func (d usersPageData) HasPrev() bool {
+	return d.Page > 1
+}
+
+func (d usersPageData) HasNext() bool {
+	return d.Page < d.TotalPages
+}
+
+func (d usersPageData) PrevURL() string {
+	return usersListURL(d.Search, d.Sort, d.Order, d.Page-1)
+}
+
+func (d usersPageData) NextURL() string {
+	return usersListURL(d.Search, d.Sort, d.Order, d.Page+1)
+}
+
+func usersListURL(search, sort, order string, page int) string {
+	q := url.Values{}
+	if search != "" {
+		q.Set("q", search)
+	}
+	q.Set("sort", sort)
+	q.Set("order", order)
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return "/users?" + q.Encode()
+}
+
+func parseUsersQuery(r *http.Request) (usersPageData, storage.UserFilterParams, error) {
+	q := r.URL.Query()
+	data := usersPageData{
+		Search: strings.TrimSpace(q.Get("q")),
+		Sort:   strings.ToLower(q.Get("sort")),
+		Order:  strings.ToLower(q.Get("order")),
+	}
+	if data.Sort == "" {
+		data.Sort = "id"
+	}
+	if data.Order == "" {
+		data.Order = "asc"
+	}
+
+	page, err := parsePage(r)
+	data.Page = page
+	if err != nil {
+		return data, storage.UserFilterParams{}, err
+	}
+
+	order, err := pagination.OrderFromString(data.Order)
+	if err != nil {
+		return data, storage.UserFilterParams{}, err
+	}
+
+	filter := storage.UserFilterParams{
+		Pagination: pagination.Pagination{
+			Offset: uint64((data.Page - 1) * usersPerPage),
+			Limit:  usersPerPage,
+			Sort:   []pagination.Sort{{By: data.Sort, Order: order}},
+		},
+		Login: data.Search,
+	}
+	if err := filter.Validate(adminapi.UserSortColumns); err != nil {
+		return data, filter, err
+	}
+	// Validate only checks sort fields, columns are mapped here
+	filter.Sort[0].By = adminapi.UserSortColumns[data.Sort]
+
+	return data, filter, nil
+}
+
+// @SSR @HTMX
+func (s *UserController) UsersPage(w http.ResponseWriter, r *http.Request) {
+	data, filter, err := parseUsersQuery(r)
+	if err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные параметры списка", err)
+		return
+	}
+
+	users, err := s.userStorage.FilterUsers(r.Context(), filter)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить пользователей", err)
+		return
+	}
+	total, err := s.userStorage.CountUsers(r.Context(), filter)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить пользователей", err)
+		return
+	}
+
+	current := auth.MustUserFromContext(r.Context())
+	data.CanManage = current.HasPermission(model.PermissionUsersManage)
+	data.Users = make([]userRow, 0, len(users))
+	for _, user := range users {
+		data.Users = append(data.Users, userRow{
+			User:      user,
+			IsCurrent: user.ID == current.ID,
+			CanManage: data.CanManage,
+		})
+	}
+	data.Total = total
+	data.TotalPages = max(1, int(math.Ceil(float64(total)/usersPerPage)))
+
+	// sorting, search and pagination swap only the table
+	if r.Header.Get("HX-Target") == usersTableTarget {
+		s.Render(w, r, http.StatusOK, "users.tmpl.html", usersTableTarget, data)
+		return
+	}
+	s.Render(w, r, http.StatusOK, "users.tmpl.html", renderer.SmartBlock, data)
+}
+
+type userFormPageData struct {
+	// ID is zero for new user
+	ID   int64
+	Form userForm
+}
+
+type userForm struct {
+	Login                string
+	Email                string
+	Password             string `json:"-"`
+	PasswordConfirmation string `json:"-"`
+	IsActive             bool
+
+	validator.Validator
+}
+
+func (f *userForm) checkLogin() {
+	f.CheckField(validator.NotBlank(f.Login), "login", "Логин не может быть пустым")
+	f.CheckField(validator.MaxChars(f.Login, 64), "login", "Логин не может быть длиннее 64 символов")
+}
+
+// checkEmail allows empty email, user without email can't reset password by himself
+func (f *userForm) checkEmail() {
+	if f.Email == "" {
+		return
+	}
+	addr, err := mail.ParseAddress(f.Email)
+	f.CheckField(err == nil && addr.Address == f.Email, "email", "Невалидный email")
+	f.CheckField(validator.MaxChars(f.Email, 254), "email", "Email не может быть длиннее 254 символов")
+}
+
+// checkEmailTaken reports email of another user as field error, storage can only tell about duplicate
+func checkEmailTaken(r *http.Request, userStorage storage.UserStorage, f *userForm, userID int64) error {
+	if f.Email == "" {
+		return nil
+	}
+	other, err := userStorage.FetchUserByEmail(r.Context(), f.Email)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	f.CheckField(other.ID == userID, "email", "Пользователь с таким email уже существует")
+	return nil
+}
+
+func (f *userForm) checkPassword(policy model.PasswordPolicy, login string) {
+	if err := policy.Validate(login, f.Password); err != nil {
+		f.AddFieldError("password", passwordPolicyMessage(policy, err))
+	}
+	f.CheckField(
+		f.Password == f.PasswordConfirmation,
+		"password_confirmation",
+		"Пароли не совпадают",
+	)
+}
+
+// @SSR
+func (s *UserController) NewUserPage(w http.ResponseWriter, r *http.Request) {
+	data := userFormPageData{Form: userForm{IsActive: true}}
+	s.Render(w, r, http.StatusOK, "user-form.tmpl.html", renderer.SmartBlock, data)
+}
+
+// @HTMX
+func (s *UserController) CreateUser(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	form := userForm{
+		Login:                strings.TrimSpace(r.PostForm.Get("login")),
+		Email:                strings.TrimSpace(r.PostForm.Get("email")),
+		Password:             r.PostForm.Get("password"),
+		PasswordConfirmation: r.PostForm.Get("password_confirmation"),
+		IsActive:             r.PostForm.Get("is_active") == "on",
+	}
+	form.checkLogin()
+	form.checkEmail()
+	form.checkPassword(s.passwordPolicy, form.Login)
+	if form.Valid() {
+		if err := checkEmailTaken(r, s.userStorage, &form, 0); err != nil {
+			s.Error(w, r, http.StatusInternalServerError, "Не удалось создать пользователя", err)
+			return
+		}
+	}
+	if !form.Valid() {
+		s.renderUserForm(w, r, 0, form)
+		return
+	}
+
+	passwordHash, err := model.HashUserPassword(form.Password)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось создать пользователя", err)
+		return
+	}
+	user := &model.User{
+		Login:          form.Login,
+		Email:          form.Email,
+		HashedPassword: string(passwordHash),
+		IsActive:       form.IsActive,
+	}
+	if err := s.userStorage.CreateUser(r.Context(), user); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			form.AddFieldError("login", "Пользователь с таким логином уже существует")
+			s.renderUserForm(w, r, 0, form)
+			return
+		}
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось создать пользователя", err)
+		return
+	}
+	recordUserAudit(r, s.auditRecorder, audit.ActionUserCreate, user.ID, audit.Diff(nil, audit.UserFields(user)))
+
+	w.Header().Set("HX-Redirect", "/users")
+}
+
+// @SSR
+func (s *UserController) EditUserPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	data := userFormPageData{
+		ID:   user.ID,
+		Form: userForm{Login: user.Login, Email: user.Email, IsActive: user.IsActive},
+	}
+	s.Render(w, r, http.StatusOK, "user-form.tmpl.html", renderer.SmartBlock, data)
+}
+
+// @HTMX
+func (s *UserController) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchManagedUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	form := userForm{
+		Login:    strings.TrimSpace(r.PostForm.Get("login")),
+		Email:    strings.TrimSpace(r.PostForm.Get("email")),
+		IsActive: r.PostForm.Get("is_active") == "on",
+	}
+	form.checkLogin()
+	form.checkEmail()
+	current := auth.MustUserFromContext(r.Context())
+	form.CheckField(form.IsActive || user.ID != current.ID, "is_active", "Нельзя заблокировать самого себя")
+	if form.Valid() {
+		if err := checkEmailTaken(r, s.userStorage, &form, user.ID); err != nil {
+			s.Error(w, r, http.StatusInternalServerError, "Не удалось сохранить пользователя", err)
+			return
+		}
+	}
+	if !form.Valid() {
+		s.renderUserForm(w, r, user.ID, form)
+		return
+	}
+
+	before := audit.UserFields(user)
+	emailChanged := !strings.EqualFold(user.Email, form.Email)
+	user.Login = form.Login
+	user.Email = form.Email
+	user.IsActive = form.IsActive
+	if err := s.userStorage.UpdateUser(r.Context(), user); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			form.AddFieldError("login", "Пользователь с таким логином уже существует")
+			s.renderUserForm(w, r, user.ID, form)
+			return
+		}
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось сохранить пользователя", err)
+		return
+	}
+	recordUserAudit(r, s.auditRecorder, audit.ActionUserUpdate, user.ID, audit.Diff(before, audit.UserFields(user)))
+
+	// reset links were sent to old address
+	if emailChanged {
+		if err := s.userStorage.DeleteUserPasswordResetTokens(r.Context(), user.ID); err != nil {
+			s.Error(w, r, http.StatusInternalServerError, "Пользователь сохранен, но не удалось отозвать ссылки сброса пароля", err)
+			return
+		}
+	}
+
+	w.Header().Set("HX-Redirect", "/users")
+}
+
+func (s *UserController) renderUserForm(w http.ResponseWriter, r *http.Request, id int64, form userForm) {
+	// never send passwords back
+	form.Password = ""
+	form.PasswordConfirmation = ""
+	data := userFormPageData{ID: id, Form: form}
+	s.Render(w, r, http.StatusOK, "user-form.tmpl.html", "user-form", data)
+}
+
+// @HTMX
+func (s *UserController) ActivateUser(w http.ResponseWriter, r *http.Request) {
+	s.setUserActive(w, r, true)
+}
+
+// @HTMX
+func (s *UserController) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	s.setUserActive(w, r, false)
+}
+
+func (s *UserController) setUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	user, ok := s.fetchManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	current := auth.MustUserFromContext(r.Context())
+	if !active && user.ID == current.ID {
+		s.Error(w, r, http.StatusBadRequest, "Нельзя заблокировать самого себя", nil)
+		return
+	}
+
+	before := audit.UserFields(user)
+	user.IsActive = active
+	if err := s.userStorage.UpdateUser(r.Context(), user); err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось сохранить пользователя", err)
+		return
+	}
+	action := audit.ActionUserDeactivate
+	if active {
+		action = audit.ActionUserActivate
+	}
+	recordUserAudit(r, s.auditRecorder, action, user.ID, audit.Diff(before, audit.UserFields(user)))
+
+	s.Render(w, r, http.StatusOK, "users.tmpl.html", "user-row", userRow{User: *user, CanManage: true})
+}
+
+type userPasswordPageData struct {
+	User model.User
+	Form userForm
+}
+
+// @SSR
+func (s *UserController) UserPasswordPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	data := userPasswordPageData{User: *user}
+	s.Render(w, r, http.StatusOK, "user-password.tmpl.html", renderer.SmartBlock, data)
+}
+
+// @HTMX
+func (s *UserController) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchManagedUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.Error(w, r, http.StatusBadRequest, "Невалидные данные формы", err)
+		return
+	}
+
+	form := userForm{
+		Password:             r.PostForm.Get("password"),
+		PasswordConfirmation: r.PostForm.Get("password_confirmation"),
+	}
+	form.checkPassword(s.passwordPolicy, user.Login)
+	if !form.Valid() {
+		form.Password = ""
+		form.PasswordConfirmation = ""
+		data := userPasswordPageData{User: *user, Form: form}
+		s.Render(w, r, http.StatusOK, "user-password.tmpl.html", "user-password-form", data)
+		return
+	}
+
+	passwordHash, err := model.HashUserPassword(form.Password)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось сменить пароль", err)
+		return
+	}
+	user.HashedPassword = string(passwordHash)
+	if err := s.userStorage.UpdateUserPassword(r.Context(), user); err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось сменить пароль", err)
+		return
+	}
+	recordUserAudit(r, s.auditRecorder, audit.ActionUserPasswordChange, user.ID, map[string]audit.Change{
+		"password": {After: audit.Redacted},
+	})
+
+	// reset link requested before must not override password set by admin
+	if err := s.userStorage.DeleteUserPasswordResetTokens(r.Context(), user.ID); err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Пароль изменен, но не удалось отозвать ссылки сброса пароля", err)
+		return
+	}
+
+	// sign out user everywhere, except admin who changes own password
+	current := auth.MustUserFromContext(r.Context())
+	if user.ID != current.ID {
+		filter := storage.UserSessionsFilterParams{UserID: user.ID}
+		sessions, err := s.userStorage.FilterUserSessions(r.Context(), filter)
+		if err == nil {
+			err = s.userStorage.DeleteUserSessions(r.Context(), sessions)
+		}
+		if err != nil {
+			s.Error(w, r, http.StatusInternalServerError, "Пароль изменен, но не удалось завершить сессии пользователя", err)
+			return
+		}
+	}
+
+	w.Header().Set("HX-Redirect", "/users")
+}
+
+type userSessionsPageData struct {
+	User     model.User
+	Sessions []model.UserSession
+}
+
+// @SSR
+func (s *UserController) UserSessionsPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return
+	}
+
+	filter := storage.UserSessionsFilterParams{UserID: user.ID, IsActive: true}
+	sessions, err := s.userStorage.FilterUserSessions(r.Context(), filter)
+	if err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить сессии пользователя", err)
+		return
+	}
+
+	data := userSessionsPageData{User: *user, Sessions: sessions}
+	s.Render(w, r, http.StatusOK, "user-sessions.tmpl.html", renderer.SmartBlock, data)
+}
+
+// fetchPathUser fetches user by id from path and renders error if it fails
+func (s *UserController) fetchPathUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	id, err := httptools.GetPathInt64(r, "id")
+	if err != nil {
+		s.Error(w, r, http.StatusNotFound, "Пользователь не найден", err)
+		return nil, false
+	}
+
+	user, err := s.userStorage.FetchUserByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.Error(w, r, http.StatusNotFound, "Пользователь не найден", err)
+			return nil, false
+		}
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить пользователя", err)
+		return nil, false
+	}
+	return user, true
+}
+
+// fetchManagedUser loads user of path and refuses it if user has permissions which current user lacks,
+// e.g. operator can't take over superuser by resetting its password
+func (s *UserController) fetchManagedUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	user, ok := s.fetchPathUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if err := s.userStorage.FetchUserRoles(r.Context(), user); err != nil {
+		s.Error(w, r, http.StatusInternalServerError, "Не удалось загрузить пользователя", err)
+		return nil, false
+	}
+	if !auth.MustUserFromContext(r.Context()).CanManage(user) {
+		s.Error(w, r, http.StatusForbidden, "Недостаточно прав для управления этим пользователем", auth.ErrForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+func passwordPolicyMessage(policy model.PasswordPolicy, err error) string {
+	switch {
+	case errors.Is(err, model.ErrPasswordTooShort):
+		return fmt.Sprintf("Пароль должен быть не короче %d символов", policy.MinLength)
+	case errors.Is(err, model.ErrPasswordTooLong):
+		return fmt.Sprintf("Пароль должен быть не длиннее %d символов", policy.MaxLength)
+	case errors.Is(err, model.ErrPasswordEqualsLogin):
+		return "Пароль не должен совпадать с логином"
+	case errors.Is(err, model.ErrPasswordCommon):
+		return "Пароль слишком простой"
+	default:
+		return "Недопустимый пароль"
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"sort"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,9 +17,16 @@ import (
 
 	"github.com/agalitsyn/goth/cmd/admin/controller"
 	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/audit"
 	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/mailer"
+	"github.com/agalitsyn/goth/internal/model"
 	postgresStorage "github.com/agalitsyn/goth/internal/storage/postgres"
+	"github.com/agalitsyn/goth/migrations"
 	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/goth/pkg/metrics"
+	"github.com/agalitsyn/goth/pkg/tracing"
+	"github.com/agalitsyn/goth/pkg/version"
 	"github.com/agalitsyn/postgres"
 	"github.com/agalitsyn/slogutils"
 )
@@ -29,6 +37,8 @@ func main() {
 
 	cfg := ParseFlags()
 	slogutils.SetupGlobalLogger(cfg.Log.Level, os.Stdout)
+	// slog.*Context calls get request_id and user_id of request context
+	slog.SetDefault(slog.New(httptools.NewContextHandler(slog.Default().Handler())))
 
 	if cfg.Debug {
 		slog.Debug("running with config")
@@ -53,6 +63,16 @@ func main() {
 		fmt.Fprintln(os.Stdout, names)
 	}
 
+	tracer := newTracer(cfg)
+	tracing.SetDefault(tracer)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("could not export spans on shutdown", "error", err)
+		}
+	}()
+
 	pgCfg := postgres.Config{
 		URI:            cfg.Postgres.ConnectionString,
 		Host:           cfg.Postgres.Host,
@@ -65,30 +85,148 @@ func main() {
 	if cfg.Debug {
 		pgCfg.TracerLogLevel = "debug"
 	}
-	pg, err := postgres.New(ctx, pgCfg)
+	pg, err := postgresStorage.NewDB(ctx, pgCfg)
 	if err != nil {
 		slogutils.Fatal("could not create postgres client", "error", err)
 	}
 	defer pg.Close()
 
-	if err = pg.RetryConnect(ctx); err != nil {
-		slogutils.Fatal("could not connect to postgres", "error", err)
+	if cfg.Postgres.WaitOnStart {
+		if err = pg.RetryConnect(ctx); err != nil {
+			slogutils.Fatal("could not connect to postgres", "error", err)
+		}
+	} else if err = pg.Ping(ctx); err != nil {
+		// pool connects on demand, so app becomes ready when postgres is reachable
+		slog.Warn("postgres is unreachable, app is not ready until it connects", "error", err)
 	}
 
+	health := httptools.NewHealth(2*time.Second, time.Second)
+	health.AddCheck("postgres", httptools.CheckerFunc(pg.Ping))
+	health.AddCheck("migrations", httptools.CheckerFunc(
+		postgresStorage.PendingMigrationsCheck(pg, migrations.MigrationsFiles),
+	))
+
 	userStorage := postgresStorage.NewUserStorage(pg)
+	auditStorage := postgresStorage.NewAuditStorage(pg)
+	auditRecorder := audit.NewRecorder(auditStorage)
+
+	var cookieCodec *auth.CookieCodec
+	if len(cfg.HTTP.CookieKeys) > 0 {
+		keys := make([]string, 0, len(cfg.HTTP.CookieKeys))
+		for _, key := range cfg.HTTP.CookieKeys {
+			keys = append(keys, key.Unmask())
+		}
+		cookieCodec, err = auth.NewCookieCodec(keys, cfg.HTTP.CookieEncrypt)
+		if err != nil {
+			slogutils.Fatal("could not create cookie codec", "error", err)
+		}
+	} else {
+		if cfg.HTTP.CookieEncrypt {
+			slogutils.Fatal("cookie encryption requires cookie keys")
+		}
+		slog.Warn("session cookie is not signed, set cookie keys in production")
+	}
+
+	passwordHasher, err := model.PasswordHasherByName(cfg.Auth.PasswordHasher)
+	if err != nil {
+		slogutils.Fatal("could not create password hasher", "error", err)
+	}
+	model.SetPasswordHasher(passwordHasher)
 
 	authenticatorCfg := auth.SessionAuthenticatorConfig{
-		LoginRedirectURL:  "/login",
-		PageRedirectURL:   "/",
-		SessionMaxAgeInDB: time.Hour * 24 * 31, // 1 month
-		CookieName:        "admin_session_id",
-		CookieMaxAge:      60 * 60 * 24 * 365, // 1 year in seconds
-		// TODO: configure if https
-		CookieSecure: false,
+		LoginRedirectURL:     "/login",
+		PageRedirectURL:      "/",
+		SessionMaxAgeInDB:    cfg.Auth.SessionMaxAge,
+		SessionIdleTimeout:   cfg.Auth.SessionIdleTimeout,
+		SessionRenewInterval: cfg.Auth.SessionRenewInterval,
+		CookieName:           httptools.CookieName("admin_session_id", cfg.HTTP.CookieSecure),
+		CookieMaxAge:         60 * 60 * 24 * 365, // 1 year in seconds
+		CookieSecure:         cfg.HTTP.CookieSecure,
+		CookieCodec:          cookieCodec,
+
+		// lockout is computed from login audit, so it is shared by all instances
+		LoginMaxFailures:     cfg.Auth.LoginMaxFailures,
+		LoginLockoutDuration: cfg.Auth.LoginLockout,
 	}
 	authenticator := auth.NewSessionAuthenticator(authenticatorCfg, userStorage, checkUserIsActive)
 
-	userCtrl := controller.NewUserController(htmlRenderer, authenticator, userStorage)
+	sessionGC := auth.NewSessionGC(auth.SessionGCConfig{
+		Interval:  cfg.Auth.SessionGCInterval,
+		BatchSize: cfg.Auth.SessionGCBatchSize,
+
+		LoginAttemptsRetention: cfg.Auth.LoginAttemptsRetention,
+	}, userStorage)
+	sessionGCDone := make(chan struct{})
+	go func() {
+		defer close(sessionGCDone)
+		sessionGC.Run(ctx)
+	}()
+
+	passwordPolicy := model.DefaultPasswordPolicy
+	passwordPolicy.MinLength = cfg.Auth.PasswordMinLength
+	passwordPolicy.RejectCommon = cfg.Auth.PasswordRejectCommon
+	var externalAuthenticator *auth.ExternalAuthenticator
+	if cfg.OIDC.IssuerURL != "" {
+		provider := auth.NewOIDCProvider(auth.OIDCConfig{
+			Name:         cfg.OIDC.Name,
+			IssuerURL:    cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret.Unmask(),
+			RedirectURL:  strings.TrimSuffix(cfg.HTTP.PublicURL, "/") + "/login/oidc/callback",
+			Scopes:       cfg.OIDC.Scopes,
+			GroupsClaim:  cfg.OIDC.GroupsClaim,
+		})
+		externalAuthenticator = auth.NewExternalAuthenticator(auth.ExternalAuthenticatorConfig{
+			CreateUsers:      cfg.OIDC.CreateUsers,
+			TrustProviderMFA: cfg.OIDC.TrustProviderMFA,
+			CookieName:       httptools.CookieName("admin_oidc_state", cfg.HTTP.CookieSecure),
+			CookieSecure:     cfg.HTTP.CookieSecure,
+			CookieCodec:      cookieCodec,
+		}, provider, authenticator, userStorage)
+	}
+
+	userCtrl := controller.NewUserController(
+		htmlRenderer,
+		authenticator,
+		externalAuthenticator,
+		userStorage,
+		passwordPolicy,
+		cfg.Auth.TOTPIssuer,
+		auditRecorder,
+	)
+	auditCtrl := controller.NewAuditController(htmlRenderer, auditStorage)
+
+	apiTokenAuthenticator := auth.NewAPITokenAuthenticator(auth.APITokenAuthenticatorConfig{
+		LastUsedInterval: time.Minute,
+	}, userStorage, checkUserIsActive)
+	apiCtrl := controller.NewAPIController(userStorage, passwordPolicy, auditRecorder)
+
+	var mail mailer.Mailer
+	switch cfg.Mail.Transport {
+	case "smtp":
+		mail = mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Addr:     cfg.Mail.SMTPAddr,
+			Username: cfg.Mail.SMTPUser,
+			Password: cfg.Mail.SMTPPass.Unmask(),
+			From:     cfg.Mail.From,
+		})
+	case "file":
+		mail = mailer.NewFileMailer(cfg.Mail.FileDir, cfg.Mail.From)
+	case "log":
+		mail = mailer.NewLogMailer()
+	default:
+		slogutils.Fatal("unknown mail transport", "transport", cfg.Mail.Transport)
+	}
+	passwordResetter := auth.NewPasswordResetter(auth.PasswordResetConfig{
+		ResetURL: strings.TrimSuffix(cfg.HTTP.PublicURL, "/") + "/reset-password",
+		TokenTTL: cfg.Auth.PasswordResetTTL,
+	}, userStorage, mail)
+	passwordResetCtrl := controller.NewPasswordResetController(htmlRenderer, passwordResetter, passwordPolicy, auditRecorder)
+
+	trustedProxies, err := httptools.ParseTrustedProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		slogutils.Fatal("could not parse trusted proxies", "error", err)
+	}
 
 	corsCfg := cors.Options{
 		AllowedOrigins:   cfg.HTTP.CorsAllowedOrigins,
@@ -99,11 +237,69 @@ func main() {
 	}
 	corsMiddleware := cors.New(corsCfg)
 
+	csrfCfg := httptools.CSRFConfig{
+		CookieName:   httptools.CookieName(httptools.DefaultCSRFCookieName, cfg.HTTP.CookieSecure),
+		CookieSecure: cfg.HTTP.CookieSecure,
+		// token issued before login is not accepted after it
+		SessionCookieName: authenticatorCfg.CookieName,
+		UnboundPaths:      unboundCSRFPaths,
+		// API is authenticated by bearer tokens, not cookies
+		IgnoredPaths: []string{"/api/"},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request) {
+			htmlRenderer.Error(w, r, http.StatusForbidden, "Сессия устарела, обновите страницу", nil)
+		},
+	}
+	csrfMiddleware := httptools.CSRF(csrfCfg)
+
+	rateLimitHandler := func(w http.ResponseWriter, r *http.Request) {
+		htmlRenderer.Error(w, r, http.StatusTooManyRequests, "Слишком много запросов, попробуйте позже", nil)
+	}
+	rateLimitMiddleware := httptools.RateLimit(httptools.RateLimitConfig{
+		Requests:     cfg.HTTP.RateLimit,
+		Period:       time.Minute,
+		LimitHandler: rateLimitHandler,
+	})
+	loginRateLimitMiddleware := httptools.RateLimit(httptools.RateLimitConfig{
+		Requests:     cfg.HTTP.LoginRateLimit,
+		Period:       time.Minute,
+		LimitHandler: rateLimitHandler,
+	})
+
+	metricsRegistry := metrics.NewRegistry()
+	metricsMiddleware := func(next http.Handler) http.Handler { return next }
+	var metricsHandler http.Handler
+	if cfg.Metrics.Enabled {
+		metricsMiddleware = httptools.Metrics(metricsRegistry)
+		auth.RegisterMetrics(metricsRegistry)
+		tracing.RegisterMetrics(metricsRegistry)
+		metricsRegistry.MustRegister(postgresStorage.PoolCollector(pg))
+		if cfg.Metrics.Addr == "" {
+			metricsHandler = metricsRegistry.Handler()
+		}
+	}
+
 	router, err := NewRouter(
+		httptools.RealIP(trustedProxies),
 		corsMiddleware.Handler,
+		csrfMiddleware,
+		rateLimitMiddleware,
+		loginRateLimitMiddleware,
 		authenticator.LoginRequiredMiddleware,
+		apiTokenAuthenticator.BearerTokenMiddleware,
+		metricsMiddleware,
+		metricsHandler,
+		health,
+		httptools.DebugHandler(httptools.DebugConfig{
+			Config: cfg,
+			Stats: map[string]func() any{
+				"postgres": func() any { return postgresStorage.GetPoolStats(pg) },
+			},
+		}),
 		htmlRenderer,
 		userCtrl,
+		passwordResetCtrl,
+		auditCtrl,
+		apiCtrl,
 	)
 	if err != nil {
 		slog.Error("could not create router", "error", err)
@@ -117,6 +313,11 @@ func main() {
 	}
 	go func() {
 		<-ctx.Done()
+		health.Shutdown()
+		if cfg.HTTP.ShutdownDelay > 0 {
+			slog.Info("waiting for load balancer to notice app is not ready", "delay", cfg.HTTP.ShutdownDelay)
+			time.Sleep(cfg.HTTP.ShutdownDelay)
+		}
 		// make a new context for the Shutdown
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeoutSec)
 		defer cancel()
@@ -126,8 +327,58 @@ func main() {
 			slog.Error("shutting down http server", "error", err)
 		}
 	}()
+	if cfg.Metrics.Enabled && cfg.Metrics.Addr != "" {
+		go serveMetrics(ctx, cfg.Metrics.Addr, metricsRegistry)
+	}
+
 	slog.Info("starting http server", "addr", httpServer.Addr)
 	if err = httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("server", "error", err)
 	}
+
+	// stop background jobs if server exited by itself
+	stop()
+	<-sessionGCDone
+	passwordResetter.Wait()
+}
+
+// newTracer returns tracer with exporter of config, without exporter trace context is only propagated
+func newTracer(cfg Config) *tracing.Tracer {
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint)
+	case "file":
+		exporter = tracing.NewFileExporter(cfg.Tracing.File)
+	case "none":
+	default:
+		slogutils.Fatal("unknown tracing exporter", "exporter", cfg.Tracing.Exporter)
+	}
+	return tracing.NewTracer(tracing.Config{
+		Resource: tracing.Resource{ServiceName: "admin", ServiceVersion: version.String()},
+		Exporter: exporter,
+		Sampler:  tracing.TraceIDRatio(cfg.Tracing.SampleRatio),
+	})
+}
+
+// serveMetrics runs listener only for metrics, so scrapers can reach it on private network
+// while main listener is public. Failure of it does not stop the app.
+func serveMetrics(ctx context.Context, addr string, reg *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", reg.Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		if err := server.Close(); err != nil {
+			slog.Error("closing metrics server", "error", err)
+		}
+	}()
+	slog.Info("starting metrics server", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics server", "error", err)
+	}
 }
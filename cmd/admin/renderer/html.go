@@ -19,20 +19,26 @@ const (
 
 type pageData struct {
 	// data for layout
-	User *model.User
-	Path string
+	User      *model.User
+	Path      string
+	CSRFToken string
 
 	// data for block
 	Data any
 }
 
 type errorData struct {
-	Message template.HTML
-	Error   string
+	Status    int
+	Message   template.HTML
+	Error     string
+	RequestID string
 }
 
 func newPageData(r *http.Request) pageData {
-	data := pageData{Path: r.URL.Path}
+	data := pageData{
+		Path:      r.URL.Path,
+		CSRFToken: httptools.GetCSRFToken(r),
+	}
 	user, err := auth.UserFromContext(r.Context())
 	if err == nil {
 		data.User = user
@@ -51,17 +57,33 @@ func NewHTMLRenderer(renderer *httptools.TemplateRenderer) *HTMLRenderer {
 	}
 }
 
+// Render writes data as page, htmx fragment or JSON depending on request, see Negotiate.
+// JSON is written only for data implementing JSONViewer, other pages respond with 406 Not Acceptable.
 func (c *HTMLRenderer) Render(w http.ResponseWriter, r *http.Request, status int, template, block string, data any) {
+	// the same url gives different representations
+	w.Header().Add("Vary", "Accept, HX-Request")
+
 	logAttrs := []any{"template", template, "block", block}
-	if block != SmartBlock && isHTMXRequest(r) {
-		slog.Debug("render html", logAttrs...)
-		c.templateRenderer.Render(w, status, template, block, data)
+	format := Negotiate(r)
+	if format == FormatJSON {
+		view, ok := data.(JSONViewer)
+		if !ok {
+			c.RenderError(w, r, httptools.NewError(http.StatusNotAcceptable, "Страница недоступна в формате JSON", nil))
+			return
+		}
+		slog.DebugContext(r.Context(), "render json", logAttrs...)
+		WriteJSON(w, status, view.JSONView())
+		return
+	}
+	if block != SmartBlock && format == FormatFragment {
+		slog.DebugContext(r.Context(), "render html", logAttrs...)
+		c.templateRenderer.Render(w, r, status, template, block, data)
 		return
 	}
 
 	if block == SmartBlock {
 		block = BaseBlock
-		if isHTMXRequest(r) {
+		if format == FormatFragment {
 			block = ContentBlock
 		}
 	}
@@ -70,34 +92,48 @@ func (c *HTMLRenderer) Render(w http.ResponseWriter, r *http.Request, status int
 	pd.Data = data
 
 	logAttrs = append(logAttrs, "path", pd.Path, "authenticated", pd.User != nil)
-	slog.Debug("render html", logAttrs...)
-	c.templateRenderer.Render(w, status, template, block, pd)
+	slog.DebugContext(r.Context(), "render html", logAttrs...)
+	c.templateRenderer.Render(w, r, status, template, block, pd)
 }
 
+// Error renders error with message for user, err is logged for server errors and shown in debug mode
 func (c *HTMLRenderer) Error(w http.ResponseWriter, r *http.Request, status int, msg string, err error) {
+	e := httptools.NewError(status, msg, err)
+	httptools.LogError(r, e)
+	c.RenderError(w, r, e)
+}
+
+// RenderError is httptools.ErrorHandler which responds with the same format as Render does
+func (c *HTMLRenderer) RenderError(w http.ResponseWriter, r *http.Request, e *httptools.Error) {
+	format := Negotiate(r)
+	if format == FormatJSON {
+		w.Header().Add("Vary", "Accept, HX-Request")
+		httptools.WriteProblem(w, r, e)
+		return
+	}
+
 	data := errorData{
-		Message: template.HTML(msg),
+		Status:    e.Status,
+		Message:   template.HTML(e.Message),
+		RequestID: httptools.GetTraceID(r),
 	}
-	if c.Debug && err != nil {
-		data.Error = err.Error()
+	if c.Debug && e.Cause != nil {
+		data.Error = e.Cause.Error()
 	}
 
-	// Any HTMX errors are rendered as a static block on defined in template page region
-	if isHTMXRequest(r) {
+	// Any HTMX errors are rendered as a static block on defined in template page region,
+	// htmxtools.js swaps error responses which are retargeted there
+	if format == FormatFragment {
 		w.Header().Add("HX-Retarget", "#general-error")
 		w.Header().Add("HX-Reswap", "innerHTML")
-		c.Render(w, r, http.StatusOK, "error.tmpl.html", ErrorBlock, data)
+		c.Render(w, r, e.Status, "error.tmpl.html", ErrorBlock, data)
 		return
 	}
 
-	if status == http.StatusNotFound {
-		c.Render(w, r, status, "404.tmpl.html", BaseBlock, data)
+	if e.Status == http.StatusNotFound {
+		c.Render(w, r, e.Status, "404.tmpl.html", BaseBlock, data)
 		return
 	}
 
-	c.Render(w, r, status, "500.tmpl.html", BaseBlock, data)
-}
-
-func isHTMXRequest(r *http.Request) bool {
-	return r.Header.Get("HX-Request") == "true"
+	c.Render(w, r, e.Status, "500.tmpl.html", BaseBlock, data)
 }
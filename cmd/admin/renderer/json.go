@@ -0,0 +1,15 @@
+package renderer
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("could not write json response", "error", err)
+	}
+}
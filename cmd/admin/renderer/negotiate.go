@@ -0,0 +1,44 @@
+package renderer
+
+import (
+	"net/http"
+
+	"github.com/agalitsyn/goth/pkg/httptools"
+)
+
+// Format is representation of handler data in response
+type Format int
+
+const (
+	// FormatPage is html page with layout
+	FormatPage Format = iota
+	// FormatFragment is html block swapped by htmx
+	FormatFragment
+	// FormatJSON is JSON view of handler data for API clients and scripts, see JSONViewer
+	FormatJSON
+)
+
+// JSONViewer is implemented by page data which is served as JSON too.
+// Page data is made for templates, it has forms with validators and may have secrets shown once,
+// e.g. TOTP secret, so it is never serialized as is: handler opts in by returning explicit view model.
+type JSONViewer interface {
+	JSONView() any
+}
+
+// Negotiate picks response format by HX-Request and Accept headers.
+// htmx always gets html because it swaps markup, browsers get html because they prefer it.
+func Negotiate(r *http.Request) Format {
+	if isHTMXRequest(r) {
+		return FormatFragment
+	}
+	if httptools.NegotiateContentType(r, "text/html", "application/json") == "application/json" {
+		return FormatJSON
+	}
+	return FormatPage
+}
+
+// isHTMXRequest reports if request expects partial content.
+// History restore requests are made by htmx on cache miss and expect full page.
+func isHTMXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-History-Restore-Request") != "true"
+}
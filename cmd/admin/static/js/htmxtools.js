@@ -1,4 +1,15 @@
 document.body.addEventListener('htmx:configRequest', (event) => {
-  const csrfToken = document.querySelector('meta[name="csrf-token"]').content
-  event.detail.headers['X-CSRF-Token'] = csrfToken
+  const csrfMeta = document.querySelector('meta[name="csrf-token"]')
+  if (csrfMeta) {
+    event.detail.headers['X-CSRF-Token'] = csrfMeta.content
+  }
+})
+
+// htmx does not swap error responses, but server renders errors as fragment and retargets it to #general-error
+document.body.addEventListener('htmx:beforeSwap', (event) => {
+  const xhr = event.detail.xhr
+  if (xhr.status >= 400 && xhr.getResponseHeader('HX-Retarget')) {
+    event.detail.shouldSwap = true
+    event.detail.isError = false
+  }
 })
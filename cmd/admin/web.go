@@ -17,34 +17,67 @@ import (
 	"github.com/agalitsyn/goth/pkg/version"
 )
 
+// unboundCSRFPaths are public forms which accept csrf token issued without session,
+// password reset page is opened by link from email and is not authorized by session
+var unboundCSRFPaths = []string{"/reset-password"}
+
 func NewRouter(
+	realIPMiddleware func(http.Handler) http.Handler,
 	corsMiddleware func(http.Handler) http.Handler,
+	csrfMiddleware func(http.Handler) http.Handler,
+	rateLimitMiddleware func(http.Handler) http.Handler,
+	loginRateLimitMiddleware func(http.Handler) http.Handler,
 	authMiddleware func(http.Handler) http.Handler,
+	apiAuthMiddleware func(http.Handler) http.Handler,
+	metricsMiddleware func(http.Handler) http.Handler,
+	// metricsHandler is nil if metrics are disabled or served by separate listener
+	metricsHandler http.Handler,
+	health *httptools.Health,
+	debugHandler http.Handler,
 	htmlRenderer *renderer.HTMLRenderer,
 	userCtrl *controller.UserController,
+	passwordResetCtrl *controller.PasswordResetController,
+	auditCtrl *controller.AuditController,
+	apiCtrl *controller.APIController,
 ) (*routegroup.Bundle, error) {
 	router := routegroup.New(http.NewServeMux())
 
-	// TODO: add rate limiter
-	// TODO: add CSRF middleware
 	router.Use(
-		httptools.RequestLogger([]string{"/static", "/favicon.ico", "/robots.txt"}),
-		httptools.RealIP,
+		httptools.RequestLogger([]string{"/static", "/favicon.ico", "/robots.txt", "/metrics", "/healthz", "/readyz"}),
+		metricsMiddleware,
+		realIPMiddleware,
+		httptools.Trace,
+		httptools.ContextLogger,
+		httptools.ErrorRenderer(htmlRenderer.RenderError),
 		httptools.Recoverer(),
+		rateLimitMiddleware,
 		corsMiddleware,
-		httptools.Trace,
+		csrfMiddleware,
 		httptools.AppInfo("admin", version.String()),
 	)
 
 	// Note: order is important
-	router.Handle("GET /static/*", httptools.FileServerHandlerFunc(EmbedFiles, "static"))
+	router.Handle("GET /static/", httptools.FileServerHandlerFunc(EmbedFiles, "static"))
 	router.HandleFunc("GET /robots.txt", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("User-agent: *\nDisallow: /"))
 	})
+	if metricsHandler != nil {
+		router.Handle("GET /metrics", metricsHandler)
+	}
+	router.HandleFunc("GET /healthz", health.Liveness)
+	router.HandleFunc("GET /readyz", health.Readiness)
 
 	router.HandleFunc("GET /login", userCtrl.LoginPage)
-	router.HandleFunc("POST /login", userCtrl.Login)
-	router.HandleFunc("GET /logout", userCtrl.Logout)
+	router.With(loginRateLimitMiddleware).HandleFunc("POST /login", userCtrl.Login)
+	router.With(loginRateLimitMiddleware).HandleFunc("POST /login/2fa", userCtrl.LoginSecondFactor)
+	router.HandleFunc("GET /login/oidc", userCtrl.ExternalLogin)
+	router.With(loginRateLimitMiddleware).HandleFunc("GET /login/oidc/callback", userCtrl.ExternalLoginCallback)
+	// logout does not require valid session, it only revokes one if it exists
+	router.HandleFunc("POST /logout", userCtrl.Logout)
+	router.HandleFunc("GET /forgot-password", passwordResetCtrl.ForgotPasswordPage)
+	router.With(loginRateLimitMiddleware).HandleFunc("POST /forgot-password", passwordResetCtrl.ForgotPassword)
+	router.HandleFunc("GET /reset-password", passwordResetCtrl.ResetPasswordPage)
+	router.With(loginRateLimitMiddleware).HandleFunc("POST /reset-password", passwordResetCtrl.ResetPassword)
 
 	router.Group().Route(func(protected *routegroup.Bundle) {
 		protected.Use(authMiddleware)
@@ -52,6 +85,69 @@ func NewRouter(
 		protected.HandleFunc("GET /app", func(w http.ResponseWriter, r *http.Request) {
 			htmlRenderer.Render(w, r, http.StatusOK, "home.tmpl.html", "", nil)
 		})
+		protected.HandleFunc("POST /logout/others", userCtrl.LogoutOtherSessions)
+		protected.HandleFunc("GET /account/2fa", userCtrl.AccountTwoFactorPage)
+		protected.HandleFunc("POST /account/2fa/enroll", userCtrl.EnrollTwoFactor)
+		protected.HandleFunc("POST /account/2fa", userCtrl.EnableTwoFactor)
+		protected.HandleFunc("POST /account/2fa/disable", userCtrl.DisableTwoFactor)
+		protected.HandleFunc("GET /account/tokens", userCtrl.AccountTokensPage)
+		protected.HandleFunc("POST /account/tokens", userCtrl.CreateAccountToken)
+		protected.HandleFunc("POST /account/tokens/{tokenID}/revoke", userCtrl.RevokeAccountToken)
+
+		protected.Group().Route(func(users *routegroup.Bundle) {
+			users.Use(auth.RequirePermission(model.PermissionUsersView))
+
+			users.HandleFunc("GET /users", userCtrl.UsersPage)
+			users.HandleFunc("GET /users/{id}/sessions", userCtrl.UserSessionsPage)
+			users.HandleFunc("GET /audit/logins", userCtrl.LoginAttemptsPage)
+		})
+
+		protected.Group().Route(func(audit *routegroup.Bundle) {
+			audit.Use(auth.RequirePermission(model.PermissionAuditView))
+
+			audit.HandleFunc("GET /audit", auditCtrl.AuditPage)
+		})
+
+		protected.Group().Route(func(debug *routegroup.Bundle) {
+			debug.Use(auth.RequirePermission(model.PermissionDebugView))
+
+			debug.Handle("GET /debug/", debugHandler)
+		})
+
+		protected.Group().Route(func(users *routegroup.Bundle) {
+			users.Use(auth.RequirePermission(model.PermissionUsersManage))
+
+			users.HandleFunc("GET /users/new", userCtrl.NewUserPage)
+			users.HandleFunc("POST /users", userCtrl.CreateUser)
+			users.HandleFunc("GET /users/{id}", userCtrl.EditUserPage)
+			users.HandleFunc("POST /users/{id}", userCtrl.UpdateUser)
+			users.HandleFunc("POST /users/{id}/activate", userCtrl.ActivateUser)
+			users.HandleFunc("POST /users/{id}/deactivate", userCtrl.DeactivateUser)
+			users.HandleFunc("GET /users/{id}/password", userCtrl.UserPasswordPage)
+			users.HandleFunc("POST /users/{id}/password", userCtrl.ResetUserPassword)
+			users.HandleFunc("GET /users/{id}/tokens", userCtrl.UserTokensPage)
+			users.HandleFunc("POST /users/{id}/tokens/{tokenID}/revoke", userCtrl.RevokeUserToken)
+		})
+	})
+
+	// API is for scripts and services, they are authenticated by token instead of session cookie
+	router.Group().Route(func(api *routegroup.Bundle) {
+		// clients of API are not browsers, errors are always problem details
+		api.Use(httptools.ErrorRenderer(httptools.WriteProblem), apiAuthMiddleware)
+
+		for _, route := range apiCtrl.Routes() {
+			pattern := route.Method + " " + route.Pattern
+			if route.Permission == "" {
+				api.HandleFunc(pattern, route.Handler)
+				continue
+			}
+			api.With(auth.RequirePermission(route.Permission)).HandleFunc(pattern, route.Handler)
+		}
+	})
+	// API contract is browsed by signed in admins, docs page is static and loads document with session cookie
+	router.With(authMiddleware).HandleFunc("GET /api/openapi.json", apiCtrl.OpenAPIDocument)
+	router.With(authMiddleware).HandleFunc("GET /api/docs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/static/api-docs/", http.StatusFound)
 	})
 
 	// Stub browser requests on favicon
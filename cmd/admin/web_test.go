@@ -0,0 +1,1740 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/cmd/admin/controller"
+	"github.com/agalitsyn/goth/cmd/admin/renderer"
+	"github.com/agalitsyn/goth/internal/adminapi"
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/auth/oidctest"
+	"github.com/agalitsyn/goth/internal/mailer"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/goth/pkg/metrics"
+	"github.com/agalitsyn/secret"
+)
+
+const testSessionCookie = "admin_session_id"
+
+type testApp struct {
+	server        *httptest.Server
+	userStorage   *memory.UserStorage
+	auditStorage  *memory.AuditStorage
+	authenticator *auth.SessionAuthenticator
+	user          *model.User
+	// oidc is identity provider enabled for sign in
+	oidc *oidctest.Server
+	// mailDir has emails sent by app
+	mailDir          string
+	passwordResetter *auth.PasswordResetter
+	health           *httptools.Health
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	templates, err := httptools.NewTemplateCache(EmbedFiles, "templates", templateFuncs())
+	require.NoError(t, err)
+	htmlRenderer := renderer.NewHTMLRenderer(httptools.NewTemplateRenderer(templates))
+
+	userStorage := memory.NewUserStorage()
+	hash, err := model.HashUserPassword("secret-password")
+	require.NoError(t, err)
+	user := &model.User{Login: "admin", Email: "admin@example.com", HashedPassword: string(hash), IsActive: true}
+	require.NoError(t, userStorage.CreateUser(context.Background(), user))
+
+	cookieCodec, err := auth.NewCookieCodec([]string{strings.Repeat("k", auth.MinCookieKeyLength)}, false)
+	require.NoError(t, err)
+	authenticator := auth.NewSessionAuthenticator(auth.SessionAuthenticatorConfig{
+		LoginRedirectURL:  "/login",
+		PageRedirectURL:   "/",
+		SessionMaxAgeInDB: time.Hour,
+		CookieName:        testSessionCookie,
+		CookieCodec:       cookieCodec,
+	}, userStorage, checkUserIsActive)
+	// server is started after router is built, but callback URL is needed by provider before that
+	server := httptest.NewUnstartedServer(nil)
+	t.Cleanup(server.Close)
+	oidcServer, err := oidctest.NewServer("admin", "client-secret")
+	require.NoError(t, err)
+	t.Cleanup(oidcServer.Close)
+	externalAuthenticator := auth.NewExternalAuthenticator(auth.ExternalAuthenticatorConfig{
+		CookieName:  "admin_oidc_state",
+		CookieCodec: cookieCodec,
+	}, auth.NewOIDCProvider(auth.OIDCConfig{
+		Name:         "sso",
+		IssuerURL:    oidcServer.URL,
+		ClientID:     oidcServer.ClientID,
+		ClientSecret: oidcServer.ClientSecret,
+		RedirectURL:  "http://" + server.Listener.Addr().String() + "/login/oidc/callback",
+	}), authenticator, userStorage)
+
+	auditStorage := memory.NewAuditStorage()
+	auditRecorder := audit.NewRecorder(auditStorage)
+	userCtrl := controller.NewUserController(
+		htmlRenderer,
+		authenticator,
+		externalAuthenticator,
+		userStorage,
+		model.DefaultPasswordPolicy,
+		"Test app",
+		auditRecorder,
+	)
+	auditCtrl := controller.NewAuditController(htmlRenderer, auditStorage)
+	apiTokenAuthenticator := auth.NewAPITokenAuthenticator(auth.APITokenAuthenticatorConfig{}, userStorage, checkUserIsActive)
+
+	mailDir := t.TempDir()
+	passwordResetter := auth.NewPasswordResetter(auth.PasswordResetConfig{
+		ResetURL: "http://admin.test/reset-password",
+	}, userStorage, mailer.NewFileMailer(mailDir, "noreply@admin.test"))
+	passwordResetCtrl := controller.NewPasswordResetController(
+		htmlRenderer,
+		passwordResetter,
+		model.DefaultPasswordPolicy,
+		auditRecorder,
+	)
+
+	passthrough := func(next http.Handler) http.Handler { return next }
+	health := httptools.NewHealth(time.Second, 0)
+	metricsRegistry := metrics.NewRegistry()
+	auth.RegisterMetrics(metricsRegistry)
+	router, err := NewRouter(
+		passthrough,
+		passthrough,
+		httptools.CSRF(httptools.CSRFConfig{
+			SessionCookieName: testSessionCookie,
+			UnboundPaths:      unboundCSRFPaths,
+			IgnoredPaths:      []string{"/api/"},
+		}),
+		passthrough,
+		passthrough,
+		authenticator.LoginRequiredMiddleware,
+		apiTokenAuthenticator.BearerTokenMiddleware,
+		httptools.Metrics(metricsRegistry),
+		metricsRegistry.Handler(),
+		health,
+		httptools.DebugHandler(httptools.DebugConfig{
+			Config: map[string]any{"Postgres": map[string]any{"Pass": secret.NewString("postgres")}},
+			Stats:  map[string]func() any{"postgres": func() any { return map[string]int{"total_conns": 1} }},
+		}),
+		htmlRenderer,
+		userCtrl,
+		passwordResetCtrl,
+		auditCtrl,
+		controller.NewAPIController(userStorage, model.DefaultPasswordPolicy, auditRecorder),
+	)
+	require.NoError(t, err)
+
+	server.Config.Handler = router
+	server.Start()
+
+	return &testApp{
+		server:           server,
+		userStorage:      userStorage,
+		auditStorage:     auditStorage,
+		authenticator:    authenticator,
+		user:             user,
+		oidc:             oidcServer,
+		mailDir:          mailDir,
+		passwordResetter: passwordResetter,
+		health:           health,
+	}
+}
+
+// login creates session directly and returns browser-like client with session cookie
+func (a *testApp) login(t *testing.T) (*http.Client, *model.UserSession) {
+	t.Helper()
+	return a.loginAs(t, "admin", "secret-password")
+}
+
+func (a *testApp) loginAs(t *testing.T, login, password string) (*http.Client, *model.UserSession) {
+	t.Helper()
+
+	session, err := a.authenticator.CreateSession(context.Background(), login, password, auth.ClientInfo{})
+	require.NoError(t, err)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	u, err := url.Parse(a.server.URL)
+	require.NoError(t, err)
+	cookie, err := a.authenticator.MakeSessionCookie(session.UUID)
+	require.NoError(t, err)
+	jar.SetCookies(u, []*http.Cookie{cookie})
+
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return client, session
+}
+
+var csrfMetaRe = regexp.MustCompile(`<meta name="csrf-token" content="([^"]+)"`)
+
+// csrfToken loads login page to get csrf cookie into client's jar and token from page
+func (a *testApp) csrfToken(t *testing.T, client *http.Client) string {
+	t.Helper()
+
+	resp, err := client.Get(a.server.URL + "/login")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	m := csrfMetaRe.FindSubmatch(body)
+	require.NotNil(t, m, "csrf meta tag not found")
+	return string(m[1])
+}
+
+func (a *testApp) postForm(t *testing.T, client *http.Client, path, token string) *http.Response {
+	t.Helper()
+
+	form := url.Values{}
+	if token != "" {
+		form.Set(httptools.DefaultCSRFFormField, token)
+	}
+	resp, err := client.PostForm(a.server.URL+path, form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func (a *testApp) sessionExists(t *testing.T, session *model.UserSession) bool {
+	t.Helper()
+
+	_, err := a.userStorage.FetchUserSession(context.Background(), session.UUID)
+	if err != nil {
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		return false
+	}
+	return true
+}
+
+func findCookie(resp *http.Response, name string) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestLogout(t *testing.T) {
+	t.Run("revokes session", func(t *testing.T) {
+		app := newTestApp(t)
+		client, session := app.login(t)
+		token := app.csrfToken(t, client)
+
+		resp := app.postForm(t, client, "/logout", token)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, "/login", resp.Header.Get("Location"))
+		cookie := findCookie(resp, testSessionCookie)
+		require.NotNil(t, cookie)
+		assert.Negative(t, cookie.MaxAge)
+		assert.False(t, app.sessionExists(t, session))
+
+		// copied cookie does not work anymore
+		stolenCookie, err := app.authenticator.MakeSessionCookie(session.UUID)
+		require.NoError(t, err)
+		stolen := &http.Client{}
+		req, err := http.NewRequest(http.MethodGet, app.server.URL+"/app", nil)
+		require.NoError(t, err)
+		req.AddCookie(stolenCookie)
+		resp, err = stolen.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "/login", resp.Request.URL.Path)
+	})
+
+	t.Run("without session", func(t *testing.T) {
+		app := newTestApp(t)
+		client, session := app.login(t)
+		u, _ := url.Parse(app.server.URL)
+		client.Jar.SetCookies(u, []*http.Cookie{app.authenticator.DeletionSessionCookie()})
+		// token is bound to session, so it is issued without session too
+		token := app.csrfToken(t, client)
+
+		resp := app.postForm(t, client, "/logout", token)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.True(t, app.sessionExists(t, session))
+	})
+
+	t.Run("requires csrf token", func(t *testing.T) {
+		app := newTestApp(t)
+		client, session := app.login(t)
+		app.csrfToken(t, client)
+
+		resp := app.postForm(t, client, "/logout", "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.True(t, app.sessionExists(t, session))
+	})
+
+	t.Run("get is not allowed", func(t *testing.T) {
+		app := newTestApp(t)
+		client, session := app.login(t)
+
+		resp, err := client.Get(app.server.URL + "/logout")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.True(t, app.sessionExists(t, session))
+	})
+}
+
+func TestLogoutOtherSessions(t *testing.T) {
+	t.Run("keeps current session", func(t *testing.T) {
+		app := newTestApp(t)
+		_, other := app.login(t)
+		client, current := app.login(t)
+		token := app.csrfToken(t, client)
+
+		resp := app.postForm(t, client, "/logout/others", token)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Nil(t, findCookie(resp, testSessionCookie))
+		assert.True(t, app.sessionExists(t, current))
+		assert.False(t, app.sessionExists(t, other))
+	})
+
+	t.Run("requires authentication", func(t *testing.T) {
+		app := newTestApp(t)
+		_, other := app.login(t)
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		client := &http.Client{Jar: jar}
+		token := app.csrfToken(t, client)
+
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		resp := app.postForm(t, client, "/logout/others", token)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, "/login", resp.Header.Get("Location"))
+		assert.True(t, app.sessionExists(t, other))
+	})
+
+	t.Run("requires csrf token", func(t *testing.T) {
+		app := newTestApp(t)
+		_, other := app.login(t)
+		client, _ := app.login(t)
+		app.csrfToken(t, client)
+
+		resp := app.postForm(t, client, "/logout/others", "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.True(t, app.sessionExists(t, other))
+	})
+}
+
+func TestHeaderLogoutForms(t *testing.T) {
+	app := newTestApp(t)
+	client, _ := app.login(t)
+
+	resp, err := client.Get(app.server.URL + "/app")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	page := string(body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, page, `action="/logout"`)
+	assert.Contains(t, page, `action="/logout/others"`)
+	assert.NotContains(t, page, `href="/logout"`)
+}
+
+// htmxPost sends form like htmx does and returns response with body
+func (a *testApp) htmxPost(t *testing.T, client *http.Client, path, token string, form url.Values) (*http.Response, string) {
+	t.Helper()
+
+	form.Set(httptools.DefaultCSRFFormField, token)
+	req, err := http.NewRequest(http.MethodPost, a.server.URL+path, strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestLoginErrors(t *testing.T) {
+	app := newTestApp(t)
+	hash, err := model.HashUserPassword("secret-password")
+	require.NoError(t, err)
+	require.NoError(t, app.userStorage.CreateUser(context.Background(),
+		&model.User{Login: "blocked", HashedPassword: string(hash), IsActive: false}))
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	token := app.csrfToken(t, client)
+
+	resp, body := app.htmxPost(t, client, "/login", token, url.Values{"login": {"admin"}, "password": {"wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "#general-error", resp.Header.Get("HX-Retarget"), "htmxtools.js swaps retargeted errors")
+	assert.Contains(t, body, "Неверный логин или пароль")
+	assert.Nil(t, findCookie(resp, testSessionCookie))
+
+	resp, body = app.htmxPost(t, client, "/login", token, url.Values{"login": {"blocked"}, "password": {"secret-password"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, body, "Пользователю запрещен вход")
+	assert.Nil(t, findCookie(resp, testSessionCookie))
+
+	form := url.Values{"login": {"admin"}, "password": {"wrong"}, httptools.DefaultCSRFFormField: {token}}
+	req, err := http.NewRequest(http.MethodPost, app.server.URL+"/login", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, httptools.ProblemContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, http.StatusUnauthorized, decodeProblem(t, string(data)).Status)
+}
+
+var challengeInputRe = regexp.MustCompile(`name="challenge" value="([^"]+)"`)
+
+func TestLoginSecondFactor(t *testing.T) {
+	app := newTestApp(t)
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	token := app.csrfToken(t, client)
+
+	// validation errors re-render the form
+	resp, body := app.htmxPost(t, client, "/login", token, url.Values{"login": {""}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "Логин не может быть пустым")
+
+	secret, err := auth.GenerateTOTPSecret()
+	require.NoError(t, err)
+	require.NoError(t, app.userStorage.SaveUserTOTP(context.Background(), &model.UserTOTP{
+		UserID:      app.user.ID,
+		Secret:      secret,
+		IsConfirmed: true,
+	}))
+
+	login := url.Values{"login": {"admin"}, "password": {"secret-password"}}
+	resp, body = app.htmxPost(t, client, "/login", token, login)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("HX-Redirect"))
+	assert.Nil(t, findCookie(resp, testSessionCookie))
+	m := challengeInputRe.FindStringSubmatch(body)
+	require.NotNil(t, m, "second factor form expected")
+	challenge := m[1]
+
+	resp, body = app.htmxPost(t, client, "/login/2fa", token, url.Values{"challenge": {challenge}, "code": {"abcdef"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "Неверный код")
+	assert.Nil(t, findCookie(resp, testSessionCookie))
+
+	code, err := auth.TOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	resp, _ = app.htmxPost(t, client, "/login/2fa", token, url.Values{"challenge": {challenge}, "code": {code}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/", resp.Header.Get("HX-Redirect"))
+	assert.NotNil(t, findCookie(resp, testSessionCookie))
+
+	// token issued before login is bound to no session
+	resp, _ = app.htmxPost(t, client, "/login/2fa", token, url.Values{"challenge": {challenge}, "code": {code}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// challenge can't be used twice
+	token = app.csrfToken(t, client)
+	resp, body = app.htmxPost(t, client, "/login/2fa", token, url.Values{"challenge": {challenge}, "code": {code}})
+	assert.Empty(t, resp.Header.Get("HX-Redirect"))
+	assert.Contains(t, body, "Время подтверждения истекло")
+}
+
+func TestAccountTwoFactor(t *testing.T) {
+	app := newTestApp(t)
+	client, _ := app.login(t)
+
+	resp, err := client.Get(app.server.URL + "/account/2fa")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `hx-post="/account/2fa/enroll"`)
+	_, err = app.userStorage.FetchUserTOTP(context.Background(), app.user.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound, "page does not start enrollment")
+
+	token := app.csrfToken(t, client)
+	resp, page := app.htmxPost(t, client, "/account/2fa/enroll", token, url.Values{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, page, "otpauth://totp/Test%20app:admin")
+
+	totp, err := app.userStorage.FetchUserTOTP(context.Background(), app.user.ID)
+	require.NoError(t, err)
+	assert.False(t, totp.IsConfirmed)
+
+	resp, _ = app.htmxPost(t, client, "/account/2fa/enroll", token, url.Values{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	again, err := app.userStorage.FetchUserTOTP(context.Background(), app.user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, totp.Secret, again.Secret, "repeated enrollment keeps scanned secret")
+
+	code, err := auth.TOTPCode(totp.Secret, time.Now())
+	require.NoError(t, err)
+	resp, page = app.htmxPost(t, client, "/account/2fa", token, url.Values{"code": {code}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, page, "Сохраните коды восстановления")
+
+	totp, err = app.userStorage.FetchUserTOTP(context.Background(), app.user.ID)
+	require.NoError(t, err)
+	assert.True(t, totp.IsConfirmed)
+}
+
+func TestPasswordResetTokensRevokedByAdmin(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleSuperuser))
+	other := &model.User{Login: "operator", Email: "operator@example.com", IsActive: true}
+	require.NoError(t, app.userStorage.CreateUser(ctx, other))
+
+	client, _ := app.login(t)
+	csrf := app.csrfToken(t, client)
+	requestToken := func(email string) string {
+		require.NoError(t, app.passwordResetter.RequestReset(ctx, email))
+		tokens := app.sentResetTokens(t)
+		require.NotEmpty(t, tokens)
+		return tokens[len(tokens)-1]
+	}
+
+	token := requestToken("operator@example.com")
+	resp, _ := app.htmxPost(t, client, fmt.Sprintf("/users/%d", other.ID), csrf, url.Values{
+		"login":     {"operator"},
+		"email":     {"operator@example.com"},
+		"is_active": {"on"},
+	})
+	require.Equal(t, "/users", resp.Header.Get("HX-Redirect"))
+	_, err := app.passwordResetter.CheckToken(ctx, token)
+	require.NoError(t, err, "token is kept when email is not changed")
+
+	resp, _ = app.htmxPost(t, client, fmt.Sprintf("/users/%d", other.ID), csrf, url.Values{
+		"login":     {"operator"},
+		"email":     {"new-operator@example.com"},
+		"is_active": {"on"},
+	})
+	require.Equal(t, "/users", resp.Header.Get("HX-Redirect"))
+	_, err = app.passwordResetter.CheckToken(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidResetToken, "link sent to old email is revoked")
+
+	token = requestToken("new-operator@example.com")
+	resp, _ = app.htmxPost(t, client, fmt.Sprintf("/users/%d/password", other.ID), csrf, url.Values{
+		"password":              {"brand-new-password"},
+		"password_confirmation": {"brand-new-password"},
+	})
+	require.Equal(t, "/users", resp.Header.Get("HX-Redirect"))
+	_, err = app.passwordResetter.CheckToken(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidResetToken, "link is revoked by password set by admin")
+}
+
+func TestUserHandlersPrivileges(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleSuperuser))
+	app.userStorage.AddRole(model.Role{Name: model.RoleOperator, Permissions: []model.Permission{model.PermissionUsersManage}})
+
+	hash, err := model.HashUserPassword("secret-password")
+	require.NoError(t, err)
+	manager := &model.User{Login: "manager", HashedPassword: string(hash), IsActive: true}
+	require.NoError(t, app.userStorage.CreateUser(ctx, manager))
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, manager.ID, model.RoleOperator))
+	other := &model.User{Login: "other", HashedPassword: string(hash), IsActive: true}
+	require.NoError(t, app.userStorage.CreateUser(ctx, other))
+
+	client, _ := app.loginAs(t, "manager", "secret-password")
+	csrf := app.csrfToken(t, client)
+	adminPath := fmt.Sprintf("/users/%d", app.user.ID)
+
+	t.Run("superuser is refused", func(t *testing.T) {
+		for _, path := range []string{adminPath, adminPath + "/password"} {
+			resp, err := client.Get(app.server.URL + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, path)
+		}
+
+		resp, _ := app.htmxPost(t, client, adminPath+"/deactivate", csrf, url.Values{})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp, _ = app.htmxPost(t, client, adminPath+"/password", csrf, url.Values{
+			"password":              {"violet-tractor-42"},
+			"password_confirmation": {"violet-tractor-42"},
+		})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp, _ = app.htmxPost(t, client, adminPath, csrf, url.Values{
+			"login":     {"admin"},
+			"email":     {"attacker@example.com"},
+			"is_active": {"on"},
+		})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		admin, err := app.userStorage.FetchUserByID(ctx, app.user.ID)
+		require.NoError(t, err)
+		assert.True(t, admin.IsActive)
+		assert.Equal(t, "admin@example.com", admin.Email)
+		require.NoError(t, app.userStorage.FetchUserPassword(ctx, admin))
+		assert.NoError(t, model.CompareUserPassword([]byte(admin.HashedPassword), "secret-password"))
+	})
+
+	t.Run("user without extra permissions is managed", func(t *testing.T) {
+		resp, _ := app.htmxPost(t, client, fmt.Sprintf("/users/%d/deactivate", other.ID), csrf, url.Values{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("own account can be activated", func(t *testing.T) {
+		resp, _ := app.htmxPost(t, client, fmt.Sprintf("/users/%d/activate", manager.ID), csrf, url.Values{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, body := app.htmxPost(t, client, fmt.Sprintf("/users/%d/deactivate", manager.ID), csrf, url.Values{})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, "Нельзя заблокировать самого себя")
+	})
+}
+
+func TestUserHandlers(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleSuperuser))
+
+	client, _ := app.login(t)
+	csrf := app.csrfToken(t, client)
+
+	t.Run("create validates form", func(t *testing.T) {
+		resp, body := app.htmxPost(t, client, "/users", csrf, url.Values{
+			"login":                 {" "},
+			"email":                 {"not an email"},
+			"password":              {"violet-tractor-42"},
+			"password_confirmation": {"violet-tractor-43"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("HX-Redirect"))
+		assert.Contains(t, body, `id="user-form"`)
+		assert.Contains(t, body, "Логин не может быть пустым")
+		assert.Contains(t, body, "Невалидный email")
+		assert.Contains(t, body, "Пароли не совпадают")
+		assert.NotContains(t, body, "violet-tractor", "password is not sent back")
+
+		resp, body = app.htmxPost(t, client, "/users", csrf, url.Values{
+			"login":                 {"operator"},
+			"password":              {"short"},
+			"password_confirmation": {"short"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, fmt.Sprintf("Пароль должен быть не короче %d символов", model.DefaultPasswordPolicy.MinLength))
+
+		resp, body = app.htmxPost(t, client, "/users", csrf, url.Values{
+			"login":                 {"other"},
+			"email":                 {"ADMIN@example.com"},
+			"password":              {"violet-tractor-42"},
+			"password_confirmation": {"violet-tractor-42"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, "Пользователь с таким email уже существует")
+
+		resp, body = app.htmxPost(t, client, "/users", csrf, url.Values{
+			"login":                 {"admin"},
+			"password":              {"violet-tractor-42"},
+			"password_confirmation": {"violet-tractor-42"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, "Пользователь с таким логином уже существует")
+
+		count, err := app.userStorage.CountUsers(ctx, storage.UserFilterParams{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, count, "no user is created")
+	})
+
+	var operator *model.User
+	t.Run("create", func(t *testing.T) {
+		resp, _ := app.htmxPost(t, client, "/users", csrf, url.Values{
+			"login":                 {" operator "},
+			"email":                 {"operator@example.com"},
+			"password":              {"violet-tractor-42"},
+			"password_confirmation": {"violet-tractor-42"},
+			"is_active":             {"on"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "/users", resp.Header.Get("HX-Redirect"))
+
+		var err error
+		operator, err = app.userStorage.FetchUserByLogin(ctx, "operator")
+		require.NoError(t, err)
+		assert.Equal(t, "operator@example.com", operator.Email)
+		assert.True(t, operator.IsActive)
+		require.NoError(t, app.userStorage.FetchUserPassword(ctx, operator))
+		assert.NoError(t, model.CompareUserPassword([]byte(operator.HashedPassword), "violet-tractor-42"))
+	})
+	require.NotNil(t, operator)
+	operatorPath := fmt.Sprintf("/users/%d", operator.ID)
+
+	t.Run("update validates form", func(t *testing.T) {
+		resp, body := app.htmxPost(t, client, operatorPath, csrf, url.Values{
+			"login": {strings.Repeat("x", 65)},
+			"email": {"admin@example.com"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("HX-Redirect"))
+		assert.Contains(t, body, "Логин не может быть длиннее 64 символов")
+
+		resp, body = app.htmxPost(t, client, operatorPath, csrf, url.Values{
+			"login":     {"operator"},
+			"email":     {"admin@example.com"},
+			"is_active": {"on"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, "Пользователь с таким email уже существует")
+
+		resp, body = app.htmxPost(t, client, operatorPath, csrf, url.Values{
+			"login":     {"admin"},
+			"is_active": {"on"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, "Пользователь с таким логином уже существует")
+
+		resp, body = app.htmxPost(t, client, fmt.Sprintf("/users/%d", app.user.ID), csrf, url.Values{
+			"login": {"admin"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, "Нельзя заблокировать самого себя")
+
+		user, err := app.userStorage.FetchUserByID(ctx, operator.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "operator", user.Login)
+		assert.Equal(t, "operator@example.com", user.Email)
+		admin, err := app.userStorage.FetchUserByID(ctx, app.user.ID)
+		require.NoError(t, err)
+		assert.True(t, admin.IsActive)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		resp, _ := app.htmxPost(t, client, operatorPath, csrf, url.Values{
+			"login": {"operator2"},
+			"email": {""},
+		})
+		assert.Equal(t, "/users", resp.Header.Get("HX-Redirect"))
+
+		user, err := app.userStorage.FetchUserByID(ctx, operator.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "operator2", user.Login)
+		assert.Empty(t, user.Email)
+		assert.False(t, user.IsActive)
+	})
+
+	t.Run("activate and deactivate", func(t *testing.T) {
+		resp, body := app.htmxPost(t, client, operatorPath+"/activate", csrf, url.Values{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, fmt.Sprintf(`<tr id="user-%d">`, operator.ID))
+		assert.Contains(t, body, "Активен")
+		user, err := app.userStorage.FetchUserByID(ctx, operator.ID)
+		require.NoError(t, err)
+		assert.True(t, user.IsActive)
+
+		resp, body = app.htmxPost(t, client, operatorPath+"/deactivate", csrf, url.Values{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, "Заблокирован")
+		user, err = app.userStorage.FetchUserByID(ctx, operator.ID)
+		require.NoError(t, err)
+		assert.False(t, user.IsActive)
+
+		resp, _ = app.htmxPost(t, client, fmt.Sprintf("/users/%d/deactivate", app.user.ID), csrf, url.Values{})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "admin can't deactivate own account")
+
+		resp, _ = app.htmxPost(t, client, "/users/999/activate", csrf, url.Values{})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("password validates form", func(t *testing.T) {
+		resp, body := app.htmxPost(t, client, operatorPath+"/password", csrf, url.Values{
+			"password":              {"operator2"},
+			"password_confirmation": {"something-else"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("HX-Redirect"))
+		assert.Contains(t, body, "Пароль должен быть не короче")
+		assert.Contains(t, body, "Пароли не совпадают")
+
+		user, err := app.userStorage.FetchUserByID(ctx, operator.ID)
+		require.NoError(t, err)
+		require.NoError(t, app.userStorage.FetchUserPassword(ctx, user))
+		assert.NoError(t, model.CompareUserPassword([]byte(user.HashedPassword), "violet-tractor-42"), "password is kept")
+	})
+
+	t.Run("password", func(t *testing.T) {
+		session := &model.UserSession{UserID: operator.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		require.NoError(t, app.userStorage.CreateUserSession(ctx, session))
+
+		resp, _ := app.htmxPost(t, client, operatorPath+"/password", csrf, url.Values{
+			"password":              {"brand-new-password"},
+			"password_confirmation": {"brand-new-password"},
+		})
+		assert.Equal(t, "/users", resp.Header.Get("HX-Redirect"))
+
+		user, err := app.userStorage.FetchUserByID(ctx, operator.ID)
+		require.NoError(t, err)
+		require.NoError(t, app.userStorage.FetchUserPassword(ctx, user))
+		assert.NoError(t, model.CompareUserPassword([]byte(user.HashedPassword), "brand-new-password"))
+		assert.False(t, app.sessionExists(t, session), "user is signed out")
+	})
+
+	t.Run("page is clamped", func(t *testing.T) {
+		for _, page := range []string{"-5", "9223372036854775807"} {
+			req, err := http.NewRequest(http.MethodGet, app.server.URL+"/users?page="+page, nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept", "application/json")
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			var data struct {
+				Page  int               `json:"page"`
+				Users []json.RawMessage `json:"users"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&data))
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode, page)
+			assert.Positive(t, data.Page, page)
+		}
+	})
+}
+
+var resetLinkRe = regexp.MustCompile(`http://admin\.test/reset-password\?token=(\S+)`)
+
+// sentResetTokens returns tokens from reset links in sent emails
+func (a *testApp) sentResetTokens(t *testing.T) []string {
+	t.Helper()
+
+	// emails are sent in background
+	a.passwordResetter.Wait()
+	files, err := filepath.Glob(filepath.Join(a.mailDir, "*.eml"))
+	require.NoError(t, err)
+	var tokens []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		require.NoError(t, err)
+		if m := resetLinkRe.FindSubmatch(data); m != nil {
+			tokens = append(tokens, string(m[1]))
+		}
+	}
+	return tokens
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApp(t)
+	_, oldSession := app.login(t)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	csrf := app.csrfToken(t, client)
+
+	resp, err := client.Get(app.server.URL + "/forgot-password")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// unknown email gets the same response and no email
+	resp, body := app.htmxPost(t, client, "/forgot-password", csrf, url.Values{"email": {"nobody@example.com"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "Если этот email зарегистрирован")
+	assert.Empty(t, app.sentResetTokens(t))
+
+	_, body = app.htmxPost(t, client, "/forgot-password", csrf, url.Values{"email": {"ADMIN@example.com"}})
+	assert.Contains(t, body, "Если этот email зарегистрирован")
+	tokens := app.sentResetTokens(t)
+	require.Len(t, tokens, 1)
+	token := tokens[0]
+
+	resp, err = client.Get(app.server.URL + "/reset-password?token=" + token)
+	require.NoError(t, err)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "no-referrer", resp.Header.Get("Referrer-Policy"))
+	assert.Contains(t, string(page), `name="token" value="`+token+`"`)
+
+	// policy is checked
+	_, body = app.htmxPost(t, client, "/reset-password", csrf, url.Values{
+		"token":                 {token},
+		"password":              {"admin"},
+		"password_confirmation": {"admin"},
+	})
+	assert.Contains(t, body, "Пароль должен быть не короче")
+
+	_, body = app.htmxPost(t, client, "/reset-password", csrf, url.Values{
+		"token":                 {token},
+		"password":              {"brand-new-password"},
+		"password_confirmation": {"brand-new-password"},
+	})
+	assert.Contains(t, body, "Пароль изменен")
+	assert.False(t, app.sessionExists(t, oldSession))
+
+	_, err = app.authenticator.CreateSession(context.Background(), "admin", "brand-new-password", auth.ClientInfo{})
+	require.NoError(t, err)
+
+	// link is single use
+	resp, err = client.Get(app.server.URL + "/reset-password?token=" + token)
+	require.NoError(t, err)
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "Ссылка недействительна")
+}
+
+func TestPasswordResetFromEmailLink(t *testing.T) {
+	app := newTestApp(t)
+	client, _ := app.login(t)
+	csrf := app.csrfToken(t, client)
+
+	_, body := app.htmxPost(t, client, "/forgot-password", csrf, url.Values{"email": {"admin@example.com"}})
+	assert.Contains(t, body, "Если этот email зарегистрирован")
+	tokens := app.sentResetTokens(t)
+	require.Len(t, tokens, 1)
+
+	// link from email is cross-site navigation, browser sends lax csrf cookie but not strict session cookie
+	u, err := url.Parse(app.server.URL)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, app.server.URL+"/reset-password?token="+tokens[0], nil)
+	require.NoError(t, err)
+	for _, c := range client.Jar.Cookies(u) {
+		if c.Name != testSessionCookie {
+			req.AddCookie(c)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	m := csrfMetaRe.FindSubmatch(page)
+	require.NotNil(t, m)
+
+	// form submit is same-site and carries session cookie
+	resp, body = app.htmxPost(t, client, "/reset-password", string(m[1]), url.Values{
+		"token":                 {tokens[0]},
+		"password":              {"brand-new-password"},
+		"password_confirmation": {"brand-new-password"},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "Пароль изменен")
+}
+
+func TestLoginAttemptsPage(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	_, err := app.authenticator.CreateSession(ctx, "admin", "wrong", auth.ClientInfo{IP: "192.0.2.7", RequestID: "req-42"})
+	require.ErrorIs(t, err, auth.ErrLoginPassword)
+	client, _ := app.login(t)
+
+	// page requires users:view permission
+	resp, err := client.Get(app.server.URL + "/audit/logins")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	app.userStorage.AddRole(model.Role{Name: model.RoleOperator, Permissions: []model.Permission{model.PermissionUsersView}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleOperator))
+
+	resp, err = client.Get(app.server.URL + "/audit/logins?login=admin")
+	require.NoError(t, err)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(page), "Неверный логин или пароль")
+	assert.Contains(t, string(page), "Успешный вход")
+	assert.Contains(t, string(page), "192.0.2.7")
+	assert.Contains(t, string(page), "req-42")
+
+	resp, err = client.Get(app.server.URL + "/audit/logins?login=nobody")
+	require.NoError(t, err)
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "Записей нет")
+
+	// offset of huge page does not overflow
+	resp, err = client.Get(app.server.URL + "/audit/logins?page=9223372036854775807")
+	require.NoError(t, err)
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(page), "Записей нет")
+}
+
+func TestAuditLog(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	other := &model.User{Login: "operator", IsActive: true}
+	require.NoError(t, app.userStorage.CreateUser(ctx, other))
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleSuperuser))
+
+	client, _ := app.login(t)
+	token := app.csrfToken(t, client)
+	form := url.Values{httptools.DefaultCSRFFormField: {token}}
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/users/%d/deactivate", app.server.URL, other.ID),
+		strings.NewReader(form.Encode()),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	entries, err := app.auditStorage.FilterEntries(ctx, audit.FilterParams{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, audit.ActionUserDeactivate, entry.Action)
+	assert.Equal(t, app.user.ID, entry.Actor.UserID)
+	assert.Equal(t, "admin", entry.Actor.Login)
+	assert.Equal(t, audit.SourceWeb, entry.Actor.Source)
+	assert.NotEmpty(t, entry.Actor.RequestID)
+	assert.Equal(t, audit.EntityUser, entry.TargetType)
+	assert.Equal(t, strconv.FormatInt(other.ID, 10), entry.TargetID)
+	assert.Equal(t, map[string]audit.Change{"is_active": {Before: true, After: false}}, entry.Changes)
+
+	resp, err = client.Get(app.server.URL + "/audit?action=user.deactivate&actor=admin")
+	require.NoError(t, err)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(page), "Блокировка пользователя")
+	assert.Contains(t, string(page), "is_active")
+	assert.Contains(t, string(page), entry.Actor.RequestID)
+
+	resp, err = client.Get(app.server.URL + "/audit?action=user.create")
+	require.NoError(t, err)
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "Записей нет")
+
+	// offset of huge page does not overflow
+	resp, err = client.Get(app.server.URL + "/audit?page=9223372036854775807")
+	require.NoError(t, err)
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(page), "Записей нет")
+}
+
+var apiTokenRe = regexp.MustCompile(`readonly value="(goth_[^"]+)"`)
+
+func TestAPITokens(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleSuperuser))
+
+	client, _ := app.login(t)
+	csrf := app.csrfToken(t, client)
+
+	apiMe := func(t *testing.T, c *http.Client, token string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, app.server.URL+"/api/v1/me", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, _ := apiMe(t, client, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "session cookie does not authenticate api")
+
+	path := "/account/tokens"
+	resp, body := app.htmxPost(t, client, path, csrf, url.Values{"name": {""}, "ttl_days": {"30"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "Название не может быть пустым")
+	assert.Contains(t, body, "Выберите хотя бы одно право")
+
+	form := url.Values{"name": {"ci"}, "scopes": {string(model.PermissionUsersView)}, "ttl_days": {"30"}}
+	resp, body = app.htmxPost(t, client, path, csrf, form)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	m := apiTokenRe.FindStringSubmatch(body)
+	require.NotNil(t, m, "created token is shown")
+	plain := m[1]
+
+	resp, body = apiMe(t, http.DefaultClient, plain)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"id":1,"login":"admin","email":"admin@example.com","permissions":["users:view"]}`, body)
+
+	tokens, err := app.userStorage.FilterUserAPITokens(ctx, app.user.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.False(t, tokens[0].LastUsedAt.IsZero())
+
+	resp, err = client.Get(app.server.URL + path)
+	require.NoError(t, err)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(page), tokens[0].Prefix)
+	assert.NotContains(t, string(page), plain, "token is shown only once")
+
+	resp, _ = app.htmxPost(t, client, fmt.Sprintf("%s/%d/revoke", path, tokens[0].ID), csrf, url.Values{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = apiMe(t, http.DefaultClient, plain)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	t.Run("admin only lists and revokes tokens of other user", func(t *testing.T) {
+		hash, err := model.HashUserPassword("secret-password")
+		require.NoError(t, err)
+		other := &model.User{Login: "other", HashedPassword: string(hash), IsActive: true}
+		require.NoError(t, app.userStorage.CreateUser(ctx, other))
+		token, plain, err := auth.CreateAPIToken(ctx, app.userStorage, other.ID, "ci",
+			[]model.Permission{model.PermissionUsersView}, time.Hour)
+		require.NoError(t, err)
+		otherPath := fmt.Sprintf("/users/%d/tokens", other.ID)
+
+		resp, err := client.Get(app.server.URL + otherPath)
+		require.NoError(t, err)
+		page, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, string(page), token.Prefix)
+		assert.NotContains(t, string(page), `hx-post="/account/tokens"`, "admin has no token form")
+
+		resp, _ = app.htmxPost(t, client, otherPath, csrf, form)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "admin can't create token of other user")
+		otherTokens, err := app.userStorage.FilterUserAPITokens(ctx, other.ID)
+		require.NoError(t, err)
+		assert.Len(t, otherTokens, 1)
+
+		resp, _ = app.htmxPost(t, client, fmt.Sprintf("/account/tokens/%d/revoke", token.ID), csrf, url.Values{})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "account page revokes only own tokens")
+		resp, _ = app.htmxPost(t, client, fmt.Sprintf("%s/%d/revoke", otherPath, token.ID), csrf, url.Values{})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, _ = apiMe(t, http.DefaultClient, plain)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	entries, err := app.auditStorage.FilterEntries(ctx, audit.FilterParams{TargetType: audit.EntityAPIToken})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, audit.ActionAPITokenRevoke, entries[0].Action)
+	assert.Equal(t, audit.ActionAPITokenRevoke, entries[1].Action)
+	assert.Equal(t, audit.ActionAPITokenCreate, entries[2].Action)
+}
+
+// apiRequest calls JSON API with bearer token, body is sent as is
+func (a *testApp) apiRequest(t *testing.T, method, path, token, body string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, a.server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(respBody)
+}
+
+func TestAPIUsers(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleSuperuser))
+
+	_, viewer, err := auth.CreateAPIToken(ctx, app.userStorage, app.user.ID, "viewer",
+		[]model.Permission{model.PermissionUsersView}, time.Hour)
+	require.NoError(t, err)
+	_, manager, err := auth.CreateAPIToken(ctx, app.userStorage, app.user.ID, "manager",
+		[]model.Permission{model.PermissionUsersManage, model.PermissionUsersView}, time.Hour)
+	require.NoError(t, err)
+
+	t.Run("create validates body", func(t *testing.T) {
+		resp, body := app.apiRequest(t, http.MethodPost, "/api/v1/users", manager, `{"login":"","email":"bad","password":"x"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Contains(t, body, `"code":"validation_failed"`)
+		assert.Contains(t, body, `"login":["Логин не может быть пустым"]`)
+		assert.Contains(t, body, `"email":["Невалидный email"]`)
+		assert.Contains(t, body, `"password":`)
+
+		resp, body = app.apiRequest(t, http.MethodPost, "/api/v1/users", manager, `{"login":"bob","pasword":"typo"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, `"code":"bad_request"`)
+	})
+
+	t.Run("create and update", func(t *testing.T) {
+		resp, body := app.apiRequest(t, http.MethodPost, "/api/v1/users", manager,
+			`{"login":"bob","email":"bob@example.com","password":"brand-new-password"}`)
+		require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"id":2,"login":"bob","email":"bob@example.com","is_active":true}`, body)
+
+		resp, body = app.apiRequest(t, http.MethodPost, "/api/v1/users", manager,
+			`{"login":"bob","password":"brand-new-password"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.Contains(t, body, `"code":"conflict"`)
+		assert.Contains(t, body, `"login":["Пользователь с таким логином уже существует"]`)
+
+		resp, body = app.apiRequest(t, http.MethodPut, "/api/v1/users/2", manager,
+			`{"login":"robert","email":"bob@example.com","is_active":false}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.JSONEq(t, `{"id":2,"login":"robert","email":"bob@example.com","is_active":false}`, body)
+
+		// token scopes limit owner, so scoped token can't change superuser, even its owner
+		resp, body = app.apiRequest(t, http.MethodPut, fmt.Sprintf("/api/v1/users/%d", app.user.ID), manager,
+			`{"login":"admin","email":"attacker@example.com","is_active":true}`)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, body, `"code":"forbidden"`)
+
+		_, superuser, err := auth.CreateAPIToken(ctx, app.userStorage, app.user.ID, "superuser",
+			[]model.Permission{model.PermissionAll}, time.Hour)
+		require.NoError(t, err)
+		resp, body = app.apiRequest(t, http.MethodPut, fmt.Sprintf("/api/v1/users/%d", app.user.ID), superuser,
+			`{"login":"admin","is_active":false}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Contains(t, body, `"is_active":["Нельзя заблокировать самого себя"]`)
+
+		entries, err := app.auditStorage.FilterEntries(ctx, audit.FilterParams{TargetType: audit.EntityUser, TargetID: "2"})
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, audit.ActionUserUpdate, entries[0].Action)
+		assert.Equal(t, audit.SourceAPI, entries[0].Actor.Source)
+		assert.Equal(t, "admin", entries[0].Actor.Login)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		resp, body := app.apiRequest(t, http.MethodGet, "/api/v1/users?sort=login&order=desc&limit=1", viewer, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.JSONEq(t, `{
+			"items":[{"id":2,"login":"robert","email":"bob@example.com","is_active":false}],
+			"total":2,"offset":0,"limit":1
+		}`, body)
+
+		resp, body = app.apiRequest(t, http.MethodGet, "/api/v1/users?offset=1&limit=1", viewer, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.Contains(t, body, `"login":"robert"`)
+
+		resp, body = app.apiRequest(t, http.MethodGet, fmt.Sprintf("/api/v1/users?limit=%d", adminapi.UsersMaxLimit), viewer, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.Contains(t, body, fmt.Sprintf(`"limit":%d`, adminapi.UsersMaxLimit))
+
+		for _, query := range []string{
+			"sort=password", "order=up", "offset=-1", "limit=abc",
+			fmt.Sprintf("limit=%d", adminapi.UsersMaxLimit+1), "limit=100000000",
+		} {
+			resp, body = app.apiRequest(t, http.MethodGet, "/api/v1/users?"+query, viewer, "")
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			assert.Contains(t, body, `"code":"bad_request"`, query)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		resp, body := app.apiRequest(t, http.MethodGet, fmt.Sprintf("/api/v1/users/%d", app.user.ID), viewer, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.JSONEq(t, `{"id":1,"login":"admin","email":"admin@example.com","is_active":true}`, body)
+
+		resp, body = app.apiRequest(t, http.MethodGet, "/api/v1/users/999", viewer, "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, httptools.ProblemContentType, resp.Header.Get("Content-Type"))
+		problem := decodeProblem(t, body)
+		assert.Equal(t, "not_found", problem.Code)
+		assert.Equal(t, "Пользователь не найден", problem.Detail)
+		assert.Equal(t, "/api/v1/users/999", problem.Instance)
+		assert.Equal(t, resp.Header.Get("X-Request-ID"), problem.RequestID)
+	})
+
+	t.Run("scopes are checked", func(t *testing.T) {
+		resp, body := app.apiRequest(t, http.MethodPost, "/api/v1/users", viewer, `{"login":"eve"}`)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, "forbidden", decodeProblem(t, body).Code)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		_, session := app.login(t)
+		other := &model.UserSession{UserID: 2, ExpiresAt: time.Now().Add(time.Hour)}
+		require.NoError(t, app.userStorage.CreateUserSession(ctx, other))
+
+		path := fmt.Sprintf("/api/v1/users/%d/sessions", app.user.ID)
+		resp, body := app.apiRequest(t, http.MethodGet, path, viewer, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.Contains(t, body, session.UUID.String())
+		assert.NotContains(t, body, other.UUID.String())
+
+		resp, _ = app.apiRequest(t, http.MethodDelete, path+"/"+other.UUID.String(), manager, "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "session of other user")
+		resp, _ = app.apiRequest(t, http.MethodDelete, path+"/not-uuid", manager, "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, _ = app.apiRequest(t, http.MethodDelete, path+"/"+session.UUID.String(), manager, "")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		_, err := app.userStorage.FetchUserSession(ctx, session.UUID)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		resp, _ = app.apiRequest(t, http.MethodDelete, "/api/v1/users/2/sessions", manager, "")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		_, err = app.userStorage.FetchUserSession(ctx, other.UUID)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+}
+
+func TestAPIDocument(t *testing.T) {
+	app := newTestApp(t)
+
+	anonymous := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := anonymous.Get(app.server.URL + "/api/openapi.json")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode, "document is shown to signed in users only")
+
+	client, _ := app.login(t)
+	resp, err = client.Get(app.server.URL + "/api/openapi.json")
+	require.NoError(t, err)
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+	for _, route := range controller.NewAPIController(nil, model.PasswordPolicy{}, nil).Routes() {
+		assert.Contains(t, doc.Paths[route.Pattern], strings.ToLower(route.Method), route.Pattern)
+	}
+
+	resp, err = client.Get(app.server.URL + "/api/docs")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/static/api-docs/", resp.Header.Get("Location"))
+
+	for _, path := range []string{"/static/api-docs/", "/static/api-docs/init.js", "/static/vendor/swagger-ui@5.18.2/swagger-ui-bundle.js"} {
+		resp, err = client.Get(app.server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	app := newTestApp(t)
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(context.Background(), app.user.ID, model.RoleSuperuser))
+	client, _ := app.login(t)
+
+	get := func(t *testing.T, path string, headers map[string]string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, app.server.URL+path, nil)
+		require.NoError(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	t.Run("browser gets page", func(t *testing.T) {
+		resp, body := get(t, "/users", map[string]string{"Accept": "text/html,application/xhtml+xml,*/*;q=0.8"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+		assert.Contains(t, body, "<html")
+		assert.Contains(t, resp.Header.Values("Vary"), "Accept, HX-Request")
+	})
+
+	t.Run("htmx gets fragment even if it accepts json", func(t *testing.T) {
+		resp, body := get(t, "/users", map[string]string{"HX-Request": "true", "Accept": "application/json"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+		assert.NotContains(t, body, "<html")
+		assert.Contains(t, body, "admin")
+	})
+
+	t.Run("json client gets data", func(t *testing.T) {
+		resp, body := get(t, "/users?sort=login", map[string]string{"Accept": "application/json"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var data struct {
+			Users []struct {
+				ID        int64  `json:"id"`
+				Login     string `json:"login"`
+				IsCurrent bool   `json:"is_current"`
+			} `json:"users"`
+			Total int    `json:"total"`
+			Sort  string `json:"sort"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &data))
+		assert.Equal(t, 1, data.Total)
+		assert.Equal(t, "login", data.Sort)
+		require.Len(t, data.Users, 1)
+		assert.Equal(t, "admin", data.Users[0].Login)
+		assert.True(t, data.Users[0].IsCurrent)
+		assert.NotContains(t, body, "HashedPassword", "secrets are never serialized")
+		assert.NotContains(t, body, "CanManage", "template data is not serialized")
+	})
+
+	t.Run("pages without json view are not acceptable", func(t *testing.T) {
+		resp, body := get(t, "/account/2fa", map[string]string{"Accept": "application/json"})
+		assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+		assert.Equal(t, httptools.ProblemContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "not_acceptable", decodeProblem(t, body).Code)
+		assert.NotContains(t, body, "Secret")
+	})
+
+	t.Run("json errors", func(t *testing.T) {
+		resp, body := get(t, "/users/999", map[string]string{"Accept": "application/json"})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, httptools.ProblemContentType, resp.Header.Get("Content-Type"))
+		problem := decodeProblem(t, body)
+		assert.Equal(t, "not_found", problem.Code)
+		assert.Equal(t, "Пользователь не найден", problem.Detail)
+
+		resp, body = get(t, "/users?sort=password", map[string]string{"Accept": "application/json"})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, `"code":"bad_request"`)
+	})
+}
+
+// decodeProblem parses problem details of error response
+func decodeProblem(t *testing.T, body string) httptools.Problem {
+	t.Helper()
+	var problem httptools.Problem
+	require.NoError(t, json.Unmarshal([]byte(body), &problem), body)
+	return problem
+}
+
+func TestErrorResponses(t *testing.T) {
+	app := newTestApp(t)
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(context.Background(), app.user.ID, model.RoleSuperuser))
+	client, _ := app.login(t)
+
+	get := func(t *testing.T, client *http.Client, path string, headers map[string]string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, app.server.URL+path, nil)
+		require.NoError(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	t.Run("page has request id", func(t *testing.T) {
+		resp, body := get(t, client, "/users/999", map[string]string{"X-Request-ID": "req-1"})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Contains(t, body, "<html")
+		assert.Contains(t, body, "Пользователь не найден")
+		assert.Contains(t, body, "Код запроса: req-1")
+	})
+
+	t.Run("htmx fragment keeps status", func(t *testing.T) {
+		resp, body := get(t, client, "/users/999", map[string]string{"HX-Request": "true"})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, "#general-error", resp.Header.Get("HX-Retarget"))
+		assert.NotContains(t, body, "<html")
+		assert.Contains(t, body, "Пользователь не найден")
+		assert.Contains(t, body, "Код запроса: "+resp.Header.Get("X-Request-ID"))
+	})
+
+	t.Run("permission error is rendered by app", func(t *testing.T) {
+		require.NoError(t, app.userStorage.RevokeUserRole(context.Background(), app.user.ID, model.RoleSuperuser))
+		t.Cleanup(func() {
+			require.NoError(t, app.userStorage.GrantUserRole(context.Background(), app.user.ID, model.RoleSuperuser))
+		})
+
+		resp, body := get(t, client, "/audit", nil)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, body, "Недостаточно прав")
+
+		resp, body = get(t, client, "/audit", map[string]string{"Accept": "application/json"})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, "forbidden", decodeProblem(t, body).Code)
+	})
+
+	t.Run("api errors are problems without accept header", func(t *testing.T) {
+		resp, body := get(t, http.DefaultClient, "/api/v1/me", nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+		assert.Equal(t, httptools.ProblemContentType, resp.Header.Get("Content-Type"))
+		problem := decodeProblem(t, body)
+		assert.Equal(t, http.StatusUnauthorized, problem.Status)
+		assert.Equal(t, "unauthorized", problem.Code)
+		assert.NotEmpty(t, problem.RequestID)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	app := newTestApp(t)
+	client, _ := app.login(t)
+	resp, err := client.Get(app.server.URL + "/no/such/page")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	anonymous := &http.Client{Jar: jar}
+	token := app.csrfToken(t, anonymous)
+	app.htmxPost(t, anonymous, "/login", token, url.Values{"login": {"admin"}, "password": {"wrong"}})
+
+	resp, err = http.Get(app.server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, metrics.TextContentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), `http_requests_total{method="GET",route="GET /",code="404"}`,
+		"unknown pages are counted by catch-all pattern")
+	assert.Contains(t, string(body), `http_request_duration_seconds_bucket{method="GET",route="GET /",le="+Inf"}`)
+	assert.Contains(t, string(body), `auth_login_attempts_total{outcome="invalid_credentials"}`)
+	assert.Contains(t, string(body), "auth_sessions_created_total")
+}
+
+func TestHealthProbes(t *testing.T) {
+	app := newTestApp(t)
+	var dbDown atomic.Bool
+	app.health.AddCheck("postgres", httptools.CheckerFunc(func(context.Context) error {
+		if dbDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}))
+
+	probe := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(app.server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	// probes do not require session
+	status, body := probe("/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+	status, body = probe("/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status":"ok","checks":{"postgres":"ok"}}`, body)
+
+	dbDown.Store(true)
+	status, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.JSONEq(t, `{"status":"fail","checks":{"postgres":"fail"}}`, body)
+	status, _ = probe("/healthz")
+	assert.Equal(t, http.StatusOK, status, "app is live while database is unreachable")
+
+	dbDown.Store(false)
+	app.health.Shutdown()
+	status, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.JSONEq(t, `{"status":"shutting_down"}`, body)
+}
+
+func TestDebug(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	get := func(client *http.Client, path string) (*http.Response, string) {
+		t.Helper()
+		resp, err := client.Get(app.server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	anonymous := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, _ := get(anonymous, "/debug/stats")
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/login", resp.Header.Get("Location"))
+
+	client, _ := app.login(t)
+	resp, _ = get(client, "/debug/stats")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "debug:view permission is required")
+
+	app.userStorage.AddRole(model.Role{Name: model.RoleSuperuser, Permissions: []model.Permission{model.PermissionAll}})
+	require.NoError(t, app.userStorage.GrantUserRole(ctx, app.user.ID, model.RoleSuperuser))
+
+	resp, body := get(client, "/debug/stats")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var stats struct {
+		Runtime struct {
+			Goroutines int `json:"goroutines"`
+		} `json:"runtime"`
+		Postgres map[string]int `json:"postgres"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &stats))
+	assert.Positive(t, stats.Runtime.Goroutines)
+	assert.Equal(t, 1, stats.Postgres["total_conns"])
+
+	resp, body = get(client, "/debug/config")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"Pass": "xxx"`)
+	assert.NotContains(t, body, `"postgres"`, "secrets are masked")
+
+	resp, body = get(client, "/debug/buildinfo")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"go_version"`)
+
+	resp, body = get(client, "/debug/pprof/goroutine?debug=1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "goroutine profile")
+
+	resp, _ = get(client, "/debug/pprof/cmdline")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "flags may contain secrets")
+}
+
+func TestExternalLogin(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	newClient := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		return &http.Client{Jar: jar}
+	}
+	loginPage := func(t *testing.T, client *http.Client) string {
+		t.Helper()
+		resp, err := client.Get(app.server.URL + "/login/oidc")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("login page has link", func(t *testing.T) {
+		resp, err := http.Get(app.server.URL + "/login")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `href="/login/oidc"`)
+	})
+
+	t.Run("not linked account", func(t *testing.T) {
+		app.oidc.SetUser(oidctest.User{Subject: "sub-1", Email: "nobody@example.com", EmailVerified: true})
+		body := loginPage(t, newClient())
+		assert.Contains(t, body, "Пользователю запрещен вход")
+	})
+
+	t.Run("signs in user with verified email", func(t *testing.T) {
+		app.oidc.SetUser(oidctest.User{
+			Subject:       "sub-2",
+			Email:         "admin@example.com",
+			EmailVerified: true,
+			Groups:        []string{"admins"},
+		})
+		client := newClient()
+		body := loginPage(t, client)
+		assert.Contains(t, body, `http-equiv="refresh"`)
+
+		resp, err := client.Get(app.server.URL + "/account/2fa")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "/account/2fa", resp.Request.URL.Path, "session cookie is set")
+
+		user, err := app.userStorage.FetchUserByID(ctx, app.user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"admins"}, user.ExternalGroups)
+	})
+
+	t.Run("requires local second factor", func(t *testing.T) {
+		secret, err := auth.GenerateTOTPSecret()
+		require.NoError(t, err)
+		require.NoError(t, app.userStorage.SaveUserTOTP(ctx, &model.UserTOTP{
+			UserID:      app.user.ID,
+			Secret:      secret,
+			IsConfirmed: true,
+		}))
+		t.Cleanup(func() { require.NoError(t, app.userStorage.DeleteUserTOTP(ctx, app.user.ID)) })
+
+		app.oidc.SetUser(oidctest.User{Subject: "sub-2", Email: "admin@example.com", EmailVerified: true})
+		client := newClient()
+		body := loginPage(t, client)
+		assert.NotContains(t, body, `http-equiv="refresh"`)
+		challenge := challengeInputRe.FindStringSubmatch(body)
+		require.NotNil(t, challenge, "second factor form expected")
+		token := csrfMetaRe.FindStringSubmatch(body)
+		require.NotNil(t, token, "csrf meta tag not found")
+
+		code, err := auth.TOTPCode(secret, time.Now())
+		require.NoError(t, err)
+		resp, _ := app.htmxPost(t, client, "/login/2fa", token[1], url.Values{"challenge": {challenge[1]}, "code": {code}})
+		assert.Equal(t, "/", resp.Header.Get("HX-Redirect"))
+		assert.NotNil(t, findCookie(resp, testSessionCookie))
+	})
+
+	t.Run("callback without state", func(t *testing.T) {
+		resp, err := http.Get(app.server.URL + "/login/oidc/callback?code=x&state=y")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "Время входа истекло")
+		assert.Nil(t, findCookie(resp, testSessionCookie))
+	})
+}
@@ -0,0 +1,6 @@
+document.body.addEventListener('htmx:configRequest', (event) => {
+  const csrfMeta = document.querySelector('meta[name="csrf-token"]')
+  if (csrfMeta) {
+    event.detail.headers['X-CSRF-Token'] = csrfMeta.content
+  }
+})
@@ -27,10 +27,33 @@ type Config struct {
 	HTTP struct {
 		Addr               string
 		ShutdownTimeoutSec time.Duration
+		ShutdownDelay      time.Duration
+		DebugAddr          string
 
 		CorsAllowedOrigins []string
 		CorsAllowedHeaders []string
 		CorsExposedHeaders []string
+
+		TrustedProxies []string
+
+		RateLimit    int
+		CookieSecure bool
+	}
+
+	Auth struct {
+		SessionGCInterval  time.Duration
+		SessionGCBatchSize int
+	}
+
+	Metrics struct {
+		Enabled bool
+		Addr    string
+	}
+
+	Tracing struct {
+		Exporter     string
+		OTLPEndpoint string
+		File         string
 	}
 
 	Postgres struct {
@@ -40,6 +63,7 @@ type Config struct {
 		User             string
 		Pass             secret.String
 		DB               string
+		WaitOnStart      bool
 	}
 }
 
@@ -70,9 +94,26 @@ func ParseFlags() Config {
 	flag.StringVar(&cfg.Postgres.User, "postgres-user", "postgres", "PostgreSQL user.")
 	pgPass := flag.String("postgres-pass", "postgres", "PostgreSQL password.")
 	flag.StringVar(&cfg.Postgres.DB, "postgres-db", "postgres", "PostgreSQL database.")
+	flag.BoolVar(
+		&cfg.Postgres.WaitOnStart,
+		"postgres-wait-on-start",
+		true,
+		"Exit if PostgreSQL is unreachable on start (if false app starts and is not ready until it connects).",
+	)
 
 	flag.StringVar(&cfg.HTTP.Addr, "http-addr", "localhost:8080", "HTTP service address.")
 	httpShutdownTimeoutSec := flag.Int("http-shutdown", 10, "HTTP service graceful shutdown timeout (sec).")
+	flag.StringVar(
+		&cfg.HTTP.DebugAddr,
+		"http-debug-addr",
+		"",
+		"Private listener for diagnostics on /debug/, e.g. localhost:6060 (if empty diagnostics are disabled).",
+	)
+	httpShutdownDelaySec := flag.Int(
+		"http-shutdown-delay",
+		0,
+		"Delay between readiness starts failing and HTTP service stops accepting requests (sec).",
+	)
 	corsAllowedOrigins := flag.String(
 		"http-cors-allowed-origins",
 		"*",
@@ -88,6 +129,58 @@ func ParseFlags() Config {
 		"*",
 		"The list which indicates which headers are safe to expose.",
 	)
+	trustedProxies := flag.String(
+		"http-trusted-proxies",
+		"127.0.0.1,::1",
+		"Comma separated IPs or CIDRs of reverse proxies, client address is taken from "+
+			"True-Client-IP, X-Real-IP or X-Forwarded-For headers only on their requests (if empty headers are ignored).",
+	)
+	flag.IntVar(
+		&cfg.HTTP.RateLimit,
+		"http-rate-limit",
+		600,
+		"Max requests per minute from single IP (0 disables limit).",
+	)
+	flag.BoolVar(
+		&cfg.HTTP.CookieSecure,
+		"http-cookie-secure",
+		false,
+		"Set Secure attribute and __Host- name prefix on cookies (requires HTTPS).",
+	)
+
+	authSessionGCIntervalSec := flag.Int(
+		"auth-session-gc-interval",
+		60*60,
+		"Interval between deletions of expired sessions (sec, 0 disables).",
+	)
+	flag.IntVar(
+		&cfg.Auth.SessionGCBatchSize,
+		"auth-session-gc-batch-size",
+		1000,
+		"Max count of expired sessions deleted by single query.",
+	)
+
+	flag.BoolVar(&cfg.Metrics.Enabled, "metrics-enabled", true, "Expose Prometheus metrics on /metrics.")
+	flag.StringVar(
+		&cfg.Metrics.Addr,
+		"metrics-addr",
+		"localhost:9090",
+		"Private listener for metrics, it must not be reachable publicly (if empty metrics are served without authentication on http-addr).",
+	)
+
+	flag.StringVar(
+		&cfg.Tracing.Exporter,
+		"tracing-exporter",
+		"none",
+		"How to export spans (none | otlp | file), file is for development.",
+	)
+	flag.StringVar(
+		&cfg.Tracing.OTLPEndpoint,
+		"tracing-otlp-endpoint",
+		"http://localhost:4318/v1/traces",
+		"OpenTelemetry collector endpoint of OTLP/HTTP traces.",
+	)
+	flag.StringVar(&cfg.Tracing.File, "tracing-file", "./tmp/traces.jsonl", "File for spans in file exporter.")
 
 	flagutils.Prefix = EnvPrefix
 	flagutils.Parse()
@@ -105,9 +198,12 @@ func ParseFlags() Config {
 
 	cfg.Log.Level = slogLevel
 	cfg.HTTP.ShutdownTimeoutSec = time.Duration(*httpShutdownTimeoutSec) * time.Second
+	cfg.HTTP.ShutdownDelay = time.Duration(*httpShutdownDelaySec) * time.Second
 	cfg.HTTP.CorsAllowedOrigins = strings.Split(*corsAllowedOrigins, ",")
 	cfg.HTTP.CorsAllowedHeaders = strings.Split(*corsAllowedHeaders, ",")
 	cfg.HTTP.CorsExposedHeaders = strings.Split(*corsExposedHeaders, ",")
+	cfg.HTTP.TrustedProxies = strings.Split(*trustedProxies, ",")
+	cfg.Auth.SessionGCInterval = time.Duration(*authSessionGCIntervalSec) * time.Second
 
 	if slogLevel == slog.LevelDebug {
 		cfg.Debug = true
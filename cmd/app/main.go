@@ -8,7 +8,15 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/agalitsyn/goth/internal/auth"
+	postgresStorage "github.com/agalitsyn/goth/internal/storage/postgres"
+	"github.com/agalitsyn/goth/migrations"
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/goth/pkg/metrics"
+	"github.com/agalitsyn/goth/pkg/tracing"
+	"github.com/agalitsyn/goth/pkg/version"
 	"github.com/agalitsyn/postgres"
 	"github.com/agalitsyn/slogutils"
 )
@@ -19,12 +27,24 @@ func main() {
 
 	cfg := ParseFlags()
 	slogutils.SetupGlobalLogger(cfg.Log.Level, os.Stdout)
+	// slog.*Context calls get request_id and user_id of request context
+	slog.SetDefault(slog.New(httptools.NewContextHandler(slog.Default().Handler())))
 
 	if cfg.Debug {
 		slog.Debug("running with config")
 		fmt.Fprintln(os.Stdout, cfg.String())
 	}
 
+	tracer := newTracer(cfg)
+	tracing.SetDefault(tracer)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("could not export spans on shutdown", "error", err)
+		}
+	}()
+
 	pgCfg := postgres.Config{
 		URI:            cfg.Postgres.ConnectionString,
 		Host:           cfg.Postgres.Host,
@@ -37,17 +57,73 @@ func main() {
 	if cfg.Debug {
 		pgCfg.TracerLogLevel = "debug"
 	}
-	pg, err := postgres.New(ctx, pgCfg)
+	pg, err := postgresStorage.NewDB(ctx, pgCfg)
 	if err != nil {
 		slogutils.Fatal("could not create postgres client", "error", err)
 	}
 	defer pg.Close()
 
-	if err = pg.RetryConnect(ctx); err != nil {
-		slogutils.Fatal("could not connect to postgres", "error", err)
+	if cfg.Postgres.WaitOnStart {
+		if err = pg.RetryConnect(ctx); err != nil {
+			slogutils.Fatal("could not connect to postgres", "error", err)
+		}
+	} else if err = pg.Ping(ctx); err != nil {
+		// pool connects on demand, so app becomes ready when postgres is reachable
+		slog.Warn("postgres is unreachable, app is not ready until it connects", "error", err)
+	}
+
+	health := httptools.NewHealth(2*time.Second, time.Second)
+	health.AddCheck("postgres", httptools.CheckerFunc(pg.Ping))
+	health.AddCheck("migrations", httptools.CheckerFunc(
+		postgresStorage.PendingMigrationsCheck(pg, migrations.MigrationsFiles),
+	))
+
+	userStorage := postgresStorage.NewUserStorage(pg)
+	sessionGC := auth.NewSessionGC(auth.SessionGCConfig{
+		Interval:  cfg.Auth.SessionGCInterval,
+		BatchSize: cfg.Auth.SessionGCBatchSize,
+	}, userStorage)
+	sessionGCDone := make(chan struct{})
+	go func() {
+		defer close(sessionGCDone)
+		sessionGC.Run(ctx)
+	}()
+
+	rateLimitMiddleware := httptools.RateLimit(httptools.RateLimitConfig{
+		Requests: cfg.HTTP.RateLimit,
+		Period:   time.Minute,
+	})
+
+	trustedProxies, err := httptools.ParseTrustedProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		slogutils.Fatal("could not parse trusted proxies", "error", err)
+	}
+
+	csrfMiddleware := httptools.CSRF(httptools.CSRFConfig{
+		CookieName:   httptools.CookieName(httptools.DefaultCSRFCookieName, cfg.HTTP.CookieSecure),
+		CookieSecure: cfg.HTTP.CookieSecure,
+	})
+
+	metricsRegistry := metrics.NewRegistry()
+	metricsMiddleware := func(next http.Handler) http.Handler { return next }
+	var metricsHandler http.Handler
+	if cfg.Metrics.Enabled {
+		metricsMiddleware = httptools.Metrics(metricsRegistry)
+		auth.RegisterMetrics(metricsRegistry)
+		metricsRegistry.MustRegister(postgresStorage.PoolCollector(pg))
+		if cfg.Metrics.Addr == "" {
+			metricsHandler = metricsRegistry.Handler()
+		}
 	}
 
-	router, err := MakeRouter()
+	router, err := MakeRouter(
+		httptools.RealIP(trustedProxies),
+		csrfMiddleware,
+		rateLimitMiddleware,
+		metricsMiddleware,
+		metricsHandler,
+		health,
+	)
 	if err != nil {
 		slog.Error("could not create router", "error", err)
 		return
@@ -56,6 +132,11 @@ func main() {
 	httpServer := &http.Server{Addr: cfg.HTTP.Addr, Handler: router}
 	go func() {
 		<-ctx.Done()
+		health.Shutdown()
+		if cfg.HTTP.ShutdownDelay > 0 {
+			slog.Info("waiting for load balancer to notice app is not ready", "delay", cfg.HTTP.ShutdownDelay)
+			time.Sleep(cfg.HTTP.ShutdownDelay)
+		}
 		// make a new context for the Shutdown
 		shutdownCtx := context.Background()
 		shutdownCtx, cancel := context.WithTimeout(ctx, cfg.HTTP.ShutdownTimeoutSec)
@@ -66,8 +147,65 @@ func main() {
 			slog.Error("shutting down http server", "error", err)
 		}
 	}()
+	if cfg.Metrics.Enabled && cfg.Metrics.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metricsRegistry.Handler())
+		go servePrivate(ctx, "metrics", cfg.Metrics.Addr, mux)
+	}
+	// diagnostics have no authentication in app, so they are served only by private listener
+	if cfg.HTTP.DebugAddr != "" {
+		go servePrivate(ctx, "debug", cfg.HTTP.DebugAddr, httptools.DebugHandler(httptools.DebugConfig{
+			Config: cfg,
+			Stats: map[string]func() any{
+				"postgres": func() any { return postgresStorage.GetPoolStats(pg) },
+			},
+		}))
+	}
+
 	slog.Info("starting http server", "addr", httpServer.Addr)
 	if err = httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("server", "error", err)
 	}
+
+	// stop background jobs if server exited by itself
+	stop()
+	<-sessionGCDone
+}
+
+// newTracer returns tracer with exporter of config, without exporter trace context is only propagated
+func newTracer(cfg Config) *tracing.Tracer {
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint)
+	case "file":
+		exporter = tracing.NewFileExporter(cfg.Tracing.File)
+	case "none":
+	default:
+		slogutils.Fatal("unknown tracing exporter", "exporter", cfg.Tracing.Exporter)
+	}
+	return tracing.NewTracer(tracing.Config{
+		Resource: tracing.Resource{ServiceName: "app", ServiceVersion: version.String()},
+		Exporter: exporter,
+	})
+}
+
+// servePrivate runs listener for metrics or diagnostics, so they can be reached on private network
+// while main listener is public. Failure of it does not stop the app.
+func servePrivate(ctx context.Context, name, addr string, handler http.Handler) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		if err := server.Close(); err != nil {
+			slog.Error("closing "+name+" server", "error", err)
+		}
+	}()
+	slog.Info("starting "+name+" server", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error(name+" server", "error", err)
+	}
 }
@@ -16,16 +16,26 @@ import (
 //go:embed assets
 var assets embed.FS
 
-func MakeRouter() (*routegroup.Bundle, error) {
+func MakeRouter(
+	realIPMiddleware func(http.Handler) http.Handler,
+	csrfMiddleware func(http.Handler) http.Handler,
+	rateLimitMiddleware func(http.Handler) http.Handler,
+	metricsMiddleware func(http.Handler) http.Handler,
+	// metricsHandler is nil if metrics are disabled or served by separate listener
+	metricsHandler http.Handler,
+	health *httptools.Health,
+) (*routegroup.Bundle, error) {
 	router := routegroup.New(http.NewServeMux())
 
-	// TODO: add rate limiter
-	// TODO: add CSRF middleware
 	router.Use(
-		httptools.RequestLogger([]string{"/static", "/favicon.ico", "/robots.txt"}),
-		httptools.RealIP,
-		httptools.Recoverer(),
+		httptools.RequestLogger([]string{"/static", "/favicon.ico", "/robots.txt", "/metrics", "/healthz", "/readyz"}),
+		metricsMiddleware,
+		realIPMiddleware,
 		httptools.Trace,
+		httptools.ContextLogger,
+		httptools.Recoverer(),
+		rateLimitMiddleware,
+		csrfMiddleware,
 		httptools.AppInfo("app", version.String()),
 	)
 
@@ -34,6 +44,11 @@ func MakeRouter() (*routegroup.Bundle, error) {
 	router.HandleFunc("GET /robots.txt", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("User-agent: *\nDisallow: /"))
 	})
+	if metricsHandler != nil {
+		router.Handle("GET /metrics", metricsHandler)
+	}
+	router.HandleFunc("GET /healthz", health.Liveness)
+	router.HandleFunc("GET /readyz", health.Readiness)
 
 	router.Group().Route(func(subgroup *routegroup.Bundle) {
 
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/user"
+
 	"github.com/spf13/cobra"
+
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/storage/postgres"
 )
 
 func NewAdminGroup(d *deps) *cobra.Command {
@@ -52,3 +59,22 @@ func NewAdminGroup(d *deps) *cobra.Command {
 
 	return cmd
 }
+
+// recordAudit saves action made through CLI, actor is OS user who runs command
+func (d deps) recordAudit(ctx context.Context, entry audit.Entry) {
+	entry.Actor = audit.Actor{
+		Login:  osUserName(),
+		Source: audit.SourceCLI,
+	}
+	audit.NewRecorder(postgres.NewAuditStorage(d.db)).Record(ctx, entry)
+}
+
+func osUserName() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
 	"github.com/agalitsyn/goth/internal/model"
 	"github.com/agalitsyn/goth/internal/storage"
 	"github.com/agalitsyn/goth/internal/storage/postgres"
@@ -21,6 +26,10 @@ func NewUserGroup(d *deps) *cobra.Command {
 
 	cmd.AddCommand(NewUserCreateCommand(d))
 	cmd.AddCommand(NewUserSessionGroup(d))
+	cmd.AddCommand(NewUserRoleGroup(d))
+	cmd.AddCommand(NewUserTwoFactorGroup(d))
+	cmd.AddCommand(NewUserTokenGroup(d))
+	cmd.AddCommand(NewUserAuditCommand(d))
 
 	for _, c := range cmd.Commands() {
 		c.SilenceErrors = true
@@ -33,7 +42,12 @@ func NewUserGroup(d *deps) *cobra.Command {
 
 type UserCreateOptions struct {
 	Login    string
+	Email    string
 	Password string
+
+	PasswordMinLength int
+	SkipPolicy        bool
+	Hasher            string
 }
 
 func NewUserCreateCommand(d *deps) *cobra.Command {
@@ -44,22 +58,76 @@ func NewUserCreateCommand(d *deps) *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			slog.Debug("run user create", "args", args, "opts", fmt.Sprintf("%+v", opts))
 
+			if !opts.SkipPolicy {
+				policy := model.DefaultPasswordPolicy
+				policy.MinLength = opts.PasswordMinLength
+				if err := policy.Validate(opts.Login, opts.Password); err != nil {
+					return fmt.Errorf("password does not satisfy policy: %w", err)
+				}
+			}
+
+			hasher, err := model.PasswordHasherByName(opts.Hasher)
+			if err != nil {
+				return err
+			}
+			model.SetPasswordHasher(hasher)
+
 			passwordHash, err := model.HashUserPassword(opts.Password)
 			if err != nil {
 				return err
 			}
 			user := &model.User{
 				Login:          opts.Login,
+				Email:          opts.Email,
 				HashedPassword: string(passwordHash),
 				IsActive:       true,
 			}
 
 			userStorage := postgres.NewUserStorage(d.db)
-			if err := userStorage.CreateUser(cmd.Context(), user); err != nil {
+			err = userStorage.CreateUser(cmd.Context(), user)
+			if err == nil {
+				d.recordAudit(cmd.Context(), audit.Entry{
+					Action:     audit.ActionUserCreate,
+					TargetType: audit.EntityUser,
+					TargetID:   strconv.FormatInt(user.ID, 10),
+					Changes:    audit.Diff(nil, audit.UserFields(user)),
+				})
+				fmt.Printf("user created: id=%d\n", user.ID)
+				return nil
+			}
+			if !errors.Is(err, storage.ErrDuplicate) {
 				return err
 			}
 
-			fmt.Printf("user created: id=%d\n", user.ID)
+			// user exists, activate and overwrite password
+			existing, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+			if err != nil {
+				return err
+			}
+			user.ID = existing.ID
+			if user.Email == "" {
+				user.Email = existing.Email
+			}
+			if err := userStorage.UpdateUser(cmd.Context(), user); err != nil {
+				return err
+			}
+			if err := userStorage.UpdateUserPassword(cmd.Context(), user); err != nil {
+				return err
+			}
+			if err := userStorage.DeleteUserPasswordResetTokens(cmd.Context(), user.ID); err != nil {
+				return err
+			}
+
+			changes := audit.Diff(audit.UserFields(existing), audit.UserFields(user))
+			changes["password"] = audit.Change{After: audit.Redacted}
+			d.recordAudit(cmd.Context(), audit.Entry{
+				Action:     audit.ActionUserUpdate,
+				TargetType: audit.EntityUser,
+				TargetID:   strconv.FormatInt(user.ID, 10),
+				Changes:    changes,
+			})
+
+			fmt.Printf("user updated: id=%d\n", user.ID)
 			return nil
 		},
 	}
@@ -71,6 +139,13 @@ func NewUserCreateCommand(d *deps) *cobra.Command {
 	)
 	cmd.MarkFlagRequired("login")
 
+	cmd.Flags().StringVar(
+		&opts.Email,
+		"email",
+		"",
+		"User email for password reset (existing email is kept if empty)",
+	)
+
 	cmd.Flags().StringVar(
 		&opts.Password,
 		"password",
@@ -79,6 +154,25 @@ func NewUserCreateCommand(d *deps) *cobra.Command {
 	)
 	cmd.MarkFlagRequired("password")
 
+	cmd.Flags().IntVar(
+		&opts.PasswordMinLength,
+		"password-min-length",
+		model.DefaultPasswordPolicy.MinLength,
+		"Minimal password length",
+	)
+	cmd.Flags().BoolVar(
+		&opts.SkipPolicy,
+		"skip-password-policy",
+		false,
+		"Do not validate password against policy, useful for development",
+	)
+	cmd.Flags().StringVar(
+		&opts.Hasher,
+		"password-hasher",
+		"argon2id",
+		"Password hash algorithm (argon2id, bcrypt)",
+	)
+
 	return cmd
 }
 
@@ -91,6 +185,7 @@ func NewUserSessionGroup(d *deps) *cobra.Command {
 	cmd.SilenceErrors = true
 
 	cmd.AddCommand(NewUserSessionDeleteCommand(d))
+	cmd.AddCommand(NewUserSessionGCCommand(d))
 
 	for _, c := range cmd.Commands() {
 		c.SilenceErrors = true
@@ -144,6 +239,20 @@ session delete --expired=false - delete all sessions (DANGER!)`,
 				return err
 			}
 
+			entry := audit.Entry{
+				Action:     audit.ActionSessionsRevoke,
+				TargetType: audit.EntitySession,
+				Changes: map[string]audit.Change{
+					"revoked_sessions": {After: len(sessions)},
+					"expired_only":     {After: opts.IsExpired},
+				},
+			}
+			if filter.UserID != 0 {
+				entry.TargetType = audit.EntityUser
+				entry.TargetID = strconv.FormatInt(filter.UserID, 10)
+			}
+			d.recordAudit(cmd.Context(), entry)
+
 			fmt.Println(msg)
 			return nil
 		},
@@ -164,3 +273,81 @@ session delete --expired=false - delete all sessions (DANGER!)`,
 
 	return cmd
 }
+
+type UserSessionGCOptions struct {
+	DryRun    bool
+	BatchSize int
+}
+
+func NewUserSessionGCCommand(d *deps) *cobra.Command {
+	var opts UserSessionGCOptions
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete expired sessions of all users",
+		Example: `
+session gc --dry-run - list expired sessions
+session gc - delete expired sessions`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run session gc", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+
+			if opts.DryRun {
+				sessions, err := userStorage.FilterExpiredUserSessions(cmd.Context(), time.Now())
+				if err != nil {
+					return err
+				}
+				logins := make(map[int64]string)
+				for _, session := range sessions {
+					login, ok := logins[session.UserID]
+					if !ok {
+						user, err := userStorage.FetchUserByID(cmd.Context(), session.UserID)
+						if err != nil {
+							return err
+						}
+						login = user.Login
+						logins[session.UserID] = login
+					}
+					fmt.Printf(
+						"session=%s, login=%s, user_id=%d, expires_at=%s, last_seen_at=%s\n",
+						session.UUID,
+						login,
+						session.UserID,
+						session.ExpiresAt.Format(time.RFC3339),
+						session.LastSeenAt.Format(time.RFC3339),
+					)
+				}
+				fmt.Printf("expired user sessions to delete: %d\n", len(sessions))
+				return nil
+			}
+
+			gc := auth.NewSessionGC(auth.SessionGCConfig{BatchSize: opts.BatchSize}, userStorage)
+			count, err := gc.Sweep(cmd.Context())
+			if err != nil {
+				return err
+			}
+			d.recordAudit(cmd.Context(), audit.Entry{
+				Action:     audit.ActionSessionsGC,
+				TargetType: audit.EntitySession,
+				Changes:    map[string]audit.Change{"deleted_sessions": {After: count}},
+			})
+			fmt.Printf("expired user sessions deleted: %d\n", count)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(
+		&opts.DryRun,
+		"dry-run",
+		false,
+		"Only list sessions which would be deleted",
+	)
+
+	cmd.Flags().IntVar(
+		&opts.BatchSize,
+		"batch-size",
+		auth.DefaultSessionGCBatchSize,
+		"Max count of sessions deleted by single query",
+	)
+
+	return cmd
+}
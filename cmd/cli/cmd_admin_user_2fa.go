@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/postgres"
+)
+
+func NewUserTwoFactorGroup(d *deps) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "2fa [action]",
+		Short: "User two-factor authentication commands",
+	}
+	cmd.Flags().SortFlags = false
+	cmd.SilenceErrors = true
+
+	cmd.AddCommand(NewUserTwoFactorResetCommand(d))
+
+	for _, c := range cmd.Commands() {
+		c.SilenceErrors = true
+		c.SilenceUsage = true
+		c.Flags().SortFlags = false
+	}
+
+	return cmd
+}
+
+type UserTwoFactorResetOptions struct {
+	Login string
+}
+
+func NewUserTwoFactorResetCommand(d *deps) *cobra.Command {
+	var opts UserTwoFactorResetOptions
+	cmd := &cobra.Command{
+		Use:   "reset",
+		Short: "Disable two-factor authentication and delete recovery codes of user",
+		Long: `Use when user lost access to authenticator app and recovery codes.
+User logs in with password only and can enroll again.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run 2fa reset", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+			user, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+			if err != nil {
+				return err
+			}
+
+			if err := userStorage.DeleteUserTOTP(cmd.Context(), user.ID); err != nil {
+				return err
+			}
+
+			// sessions could be created by someone who has stolen second factor
+			sessions, err := userStorage.FilterUserSessions(cmd.Context(), storage.UserSessionsFilterParams{UserID: user.ID})
+			if err != nil {
+				return err
+			}
+			if err := userStorage.DeleteUserSessions(cmd.Context(), sessions); err != nil {
+				return err
+			}
+
+			d.recordAudit(cmd.Context(), audit.Entry{
+				Action:     audit.ActionUserTOTPReset,
+				TargetType: audit.EntityUser,
+				TargetID:   strconv.FormatInt(user.ID, 10),
+				Changes: map[string]audit.Change{
+					"totp":             {After: false},
+					"revoked_sessions": {After: len(sessions)},
+				},
+			})
+
+			fmt.Printf("two-factor authentication reset: login=%s, id=%d\n", user.Login, user.ID)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&opts.Login,
+		"login",
+		"",
+		"User login",
+	)
+	MustMarkFlagRequired(cmd, "login")
+
+	return cmd
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/postgres"
+	"github.com/agalitsyn/postgres/pagination"
+)
+
+type UserAuditOptions struct {
+	Login string
+	Limit int
+}
+
+func NewUserAuditCommand(d *deps) *cobra.Command {
+	var opts UserAuditOptions
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show login attempts of user from newest to oldest",
+		Long:  `Login does not have to exist, attempts with unknown logins are recorded too.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run user audit", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+			attempts, err := userStorage.FilterLoginAttempts(cmd.Context(), storage.LoginAttemptsFilterParams{
+				Pagination: pagination.Pagination{Limit: uint64(max(opts.Limit, 0))},
+				Login:      opts.Login,
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, a := range attempts {
+				fmt.Printf(
+					"%s outcome=%s, user_id=%d, ip=%s, request_id=%s, user_agent=%q\n",
+					a.CreatedAt.Format(time.RFC3339),
+					a.Outcome,
+					a.UserID,
+					a.IP,
+					a.RequestID,
+					a.UserAgent,
+				)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&opts.Login,
+		"login",
+		"",
+		"User login",
+	)
+	MustMarkFlagRequired(cmd, "login")
+
+	cmd.Flags().IntVar(
+		&opts.Limit,
+		"limit",
+		50,
+		"Max count of attempts to show (0 shows all)",
+	)
+
+	return cmd
+}
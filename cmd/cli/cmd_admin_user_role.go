@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/postgres"
+)
+
+func NewUserRoleGroup(d *deps) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "role [action]",
+		Short: "User role commands",
+	}
+	cmd.Flags().SortFlags = false
+	cmd.SilenceErrors = true
+
+	cmd.AddCommand(NewUserRoleListCommand(d))
+	cmd.AddCommand(NewUserRoleGrantCommand(d))
+	cmd.AddCommand(NewUserRoleRevokeCommand(d))
+
+	for _, c := range cmd.Commands() {
+		c.SilenceErrors = true
+		c.SilenceUsage = true
+		c.Flags().SortFlags = false
+	}
+
+	return cmd
+}
+
+type UserRoleListOptions struct {
+	Login string
+}
+
+func NewUserRoleListCommand(d *deps) *cobra.Command {
+	var opts UserRoleListOptions
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List roles",
+		Example: `
+role list - list all roles with permissions
+role list --login=foo - list roles granted to user`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run role list", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+
+			if opts.Login != "" {
+				user, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+				if err != nil {
+					return err
+				}
+				if err := userStorage.FetchUserRoles(cmd.Context(), user); err != nil {
+					return err
+				}
+				fmt.Printf("login=%s, id=%d, roles=%s\n", user.Login, user.ID, strings.Join(user.Roles, ","))
+				return nil
+			}
+
+			roles, err := userStorage.FilterRoles(cmd.Context())
+			if err != nil {
+				return err
+			}
+			for _, role := range roles {
+				perms := make([]string, len(role.Permissions))
+				for i, p := range role.Permissions {
+					perms[i] = string(p)
+				}
+				fmt.Printf("%s: %s (%s)\n", role.Name, strings.Join(perms, ","), role.Description)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&opts.Login,
+		"login",
+		"",
+		"User login",
+	)
+
+	return cmd
+}
+
+type UserRoleOptions struct {
+	Login string
+	Role  string
+}
+
+func NewUserRoleGrantCommand(d *deps) *cobra.Command {
+	var opts UserRoleOptions
+	cmd := &cobra.Command{
+		Use:   "grant",
+		Short: "Grant role to user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run role grant", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+			user, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+			if err != nil {
+				return err
+			}
+
+			if err := userStorage.GrantUserRole(cmd.Context(), user.ID, opts.Role); err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					return fmt.Errorf("role %q does not exist", opts.Role)
+				}
+				return err
+			}
+
+			d.recordAudit(cmd.Context(), audit.Entry{
+				Action:     audit.ActionUserRoleGrant,
+				TargetType: audit.EntityUser,
+				TargetID:   strconv.FormatInt(user.ID, 10),
+				Changes:    map[string]audit.Change{"role": {After: opts.Role}},
+			})
+
+			fmt.Printf("role granted: login=%s, id=%d, role=%s\n", user.Login, user.ID, opts.Role)
+			return nil
+		},
+	}
+	addUserRoleFlags(cmd, &opts)
+
+	return cmd
+}
+
+func NewUserRoleRevokeCommand(d *deps) *cobra.Command {
+	var opts UserRoleOptions
+	cmd := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke role from user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run role revoke", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+			user, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+			if err != nil {
+				return err
+			}
+
+			if err := userStorage.RevokeUserRole(cmd.Context(), user.ID, opts.Role); err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					return fmt.Errorf("user %q does not have role %q", opts.Login, opts.Role)
+				}
+				return err
+			}
+
+			d.recordAudit(cmd.Context(), audit.Entry{
+				Action:     audit.ActionUserRoleRevoke,
+				TargetType: audit.EntityUser,
+				TargetID:   strconv.FormatInt(user.ID, 10),
+				Changes:    map[string]audit.Change{"role": {Before: opts.Role}},
+			})
+
+			fmt.Printf("role revoked: login=%s, id=%d, role=%s\n", user.Login, user.ID, opts.Role)
+			return nil
+		},
+	}
+	addUserRoleFlags(cmd, &opts)
+
+	return cmd
+}
+
+func addUserRoleFlags(cmd *cobra.Command, opts *UserRoleOptions) {
+	cmd.Flags().StringVar(
+		&opts.Login,
+		"login",
+		"",
+		"User login",
+	)
+	MustMarkFlagRequired(cmd, "login")
+
+	cmd.Flags().StringVar(
+		&opts.Role,
+		"role",
+		"",
+		"Role name",
+	)
+	MustMarkFlagRequired(cmd, "role")
+}
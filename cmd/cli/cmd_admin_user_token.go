@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/goth/internal/auth"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/postgres"
+)
+
+func NewUserTokenGroup(d *deps) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token [action]",
+		Short: "User API token commands",
+	}
+	cmd.Flags().SortFlags = false
+	cmd.SilenceErrors = true
+
+	cmd.AddCommand(NewUserTokenCreateCommand(d))
+	cmd.AddCommand(NewUserTokenListCommand(d))
+	cmd.AddCommand(NewUserTokenRevokeCommand(d))
+
+	for _, c := range cmd.Commands() {
+		c.SilenceErrors = true
+		c.SilenceUsage = true
+		c.Flags().SortFlags = false
+	}
+
+	return cmd
+}
+
+type UserTokenCreateOptions struct {
+	Login   string
+	Name    string
+	Scopes  string
+	TTLDays int
+}
+
+func NewUserTokenCreateCommand(d *deps) *cobra.Command {
+	var opts UserTokenCreateOptions
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create API token for user",
+		Long: `Token is printed once and can't be shown again, only its hash is stored.
+Token has permissions of user limited by scopes.`,
+		Example: `
+token create --login=foo --name=ci --scopes=users:view - read-only token for 90 days`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run token create", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			if strings.TrimSpace(opts.Name) == "" {
+				return fmt.Errorf("token name can't be empty")
+			}
+			var scopes []model.Permission
+			for _, scope := range strings.Split(opts.Scopes, ",") {
+				if scope = strings.TrimSpace(scope); scope != "" {
+					scopes = append(scopes, model.Permission(scope))
+				}
+			}
+
+			userStorage := postgres.NewUserStorage(d.db)
+			user, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+			if err != nil {
+				return err
+			}
+
+			ttl := time.Duration(opts.TTLDays) * 24 * time.Hour
+			token, plain, err := auth.CreateAPIToken(cmd.Context(), userStorage, user.ID, opts.Name, scopes, ttl)
+			if err != nil {
+				return err
+			}
+
+			d.recordAudit(cmd.Context(), audit.Entry{
+				Action:     audit.ActionAPITokenCreate,
+				TargetType: audit.EntityAPIToken,
+				TargetID:   strconv.FormatInt(token.ID, 10),
+				Changes:    audit.Diff(nil, audit.APITokenFields(token)),
+			})
+
+			fmt.Printf(
+				"token created: login=%s, id=%d, expires_at=%s\n%s\n",
+				user.Login, token.ID, token.ExpiresAt.Format(time.RFC3339), plain,
+			)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&opts.Login,
+		"login",
+		"",
+		"User login",
+	)
+	cmd.Flags().StringVar(
+		&opts.Name,
+		"name",
+		"",
+		"Token name, e.g. script or service which uses it",
+	)
+	cmd.Flags().StringVar(
+		&opts.Scopes,
+		"scopes",
+		"",
+		"Comma separated permissions allowed to token, * allows all permissions of user",
+	)
+	cmd.Flags().IntVar(
+		&opts.TTLDays,
+		"ttl",
+		90,
+		"Token lifetime (days)",
+	)
+	MustMarkFlagRequired(cmd, "login")
+	MustMarkFlagRequired(cmd, "name")
+	MustMarkFlagRequired(cmd, "scopes")
+
+	return cmd
+}
+
+type UserTokenListOptions struct {
+	Login string
+}
+
+func NewUserTokenListCommand(d *deps) *cobra.Command {
+	var opts UserTokenListOptions
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List API tokens of user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run token list", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+			user, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+			if err != nil {
+				return err
+			}
+
+			tokens, err := userStorage.FilterUserAPITokens(cmd.Context(), user.ID)
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			for _, token := range tokens {
+				lastUsedAt := "never"
+				if !token.LastUsedAt.IsZero() {
+					lastUsedAt = token.LastUsedAt.Format(time.RFC3339)
+				}
+				fmt.Printf(
+					"id=%d, name=%s, prefix=%s, scopes=%s, expires_at=%s, expired=%v, last_used_at=%s\n",
+					token.ID,
+					token.Name,
+					token.Prefix,
+					joinPermissions(token.Scopes),
+					token.ExpiresAt.Format(time.RFC3339),
+					token.IsExpired(now),
+					lastUsedAt,
+				)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&opts.Login,
+		"login",
+		"",
+		"User login",
+	)
+	MustMarkFlagRequired(cmd, "login")
+
+	return cmd
+}
+
+type UserTokenRevokeOptions struct {
+	Login string
+	ID    int64
+}
+
+func NewUserTokenRevokeCommand(d *deps) *cobra.Command {
+	var opts UserTokenRevokeOptions
+	cmd := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke API token of user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run token revoke", "args", args, "opts", fmt.Sprintf("%+v", opts))
+
+			userStorage := postgres.NewUserStorage(d.db)
+			user, err := userStorage.FetchUserByLogin(cmd.Context(), opts.Login)
+			if err != nil {
+				return err
+			}
+
+			tokens, err := userStorage.FilterUserAPITokens(cmd.Context(), user.ID)
+			if err != nil {
+				return err
+			}
+			i := slices.IndexFunc(tokens, func(t model.APIToken) bool { return t.ID == opts.ID })
+			if i < 0 {
+				return fmt.Errorf("user %q does not have token %d", opts.Login, opts.ID)
+			}
+			if err := userStorage.DeleteUserAPIToken(cmd.Context(), user.ID, opts.ID); err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					return fmt.Errorf("user %q does not have token %d", opts.Login, opts.ID)
+				}
+				return err
+			}
+
+			d.recordAudit(cmd.Context(), audit.Entry{
+				Action:     audit.ActionAPITokenRevoke,
+				TargetType: audit.EntityAPIToken,
+				TargetID:   strconv.FormatInt(opts.ID, 10),
+				Changes:    audit.Diff(audit.APITokenFields(&tokens[i]), nil),
+			})
+
+			fmt.Printf("token revoked: login=%s, id=%d\n", user.Login, opts.ID)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&opts.Login,
+		"login",
+		"",
+		"User login",
+	)
+	cmd.Flags().Int64Var(
+		&opts.ID,
+		"id",
+		0,
+		"Token id",
+	)
+	MustMarkFlagRequired(cmd, "login")
+	MustMarkFlagRequired(cmd, "id")
+
+	return cmd
+}
+
+func joinPermissions(perms []model.Permission) string {
+	s := make([]string, len(perms))
+	for i, p := range perms {
+		s[i] = string(p)
+	}
+	return strings.Join(s, ",")
+}
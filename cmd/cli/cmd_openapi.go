@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/agalitsyn/goth/internal/adminapi"
+	"github.com/agalitsyn/goth/pkg/openapi"
+)
+
+func NewOpenAPIGroup() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "openapi [action]",
+		Short: "Admin API contract",
+		// document may be written to stdout, logs must not get into it
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			setupLogger(os.Stderr)
+			return nil
+		},
+	}
+	cmd.Flags().SortFlags = false
+
+	cmd.AddCommand(NewOpenAPIDumpCommand())
+
+	for _, c := range cmd.Commands() {
+		c.SilenceErrors = true
+		c.SilenceUsage = true
+		c.Flags().SortFlags = false
+	}
+
+	return cmd
+}
+
+type OpenAPIDumpOptions struct {
+	Output    string
+	ServerURL string
+}
+
+func NewOpenAPIDumpCommand() *cobra.Command {
+	opts := OpenAPIDumpOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Write OpenAPI document of admin API to file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slog.Debug("run openapi dump", "opts", opts)
+
+			doc, err := adminapi.NewDocument()
+			if err != nil {
+				return err
+			}
+			if opts.ServerURL != "" {
+				doc.Servers = []openapi.Server{{URL: opts.ServerURL}}
+			}
+
+			data, err := json.MarshalIndent(doc, "", "  ")
+			if err != nil {
+				return fmt.Errorf("could not encode document: %w", err)
+			}
+			data = append(data, '\n')
+
+			if opts.Output == "-" {
+				_, err = os.Stdout.Write(data)
+				return err
+			}
+			if err := os.WriteFile(opts.Output, data, 0o644); err != nil {
+				return fmt.Errorf("could not write document: %w", err)
+			}
+
+			fmt.Printf("path=%s, paths=%d\n", opts.Output, len(doc.Paths))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "openapi.json", "File to write document to, - for stdout")
+	cmd.Flags().StringVar(&opts.ServerURL, "server-url", "", "Public URL of admin, added to document servers")
+
+	return cmd
+}
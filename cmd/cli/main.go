@@ -29,7 +29,7 @@ var (
 		Use:   "cli",
 		Short: "",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			setupLogger()
+			setupLogger(os.Stdout)
 
 			var err error
 			d, err = initDeps()
@@ -62,6 +62,7 @@ func main() {
 
 	rootCmd.AddCommand(NewVersionCommand())
 	rootCmd.AddCommand(NewAdminGroup(&d))
+	rootCmd.AddCommand(NewOpenAPIGroup())
 
 	cobra.CheckErr(rootCmd.ExecuteContext(ctx))
 }
@@ -94,14 +95,13 @@ func (d deps) isDebug() bool {
 	return d.logLevel == slog.LevelDebug
 }
 
-func setupLogger() {
+func setupLogger(w *os.File) {
 	v, ok := os.LookupEnv("LOG_LEVEL")
 	if ok {
 		flagLogLevel = v
 	}
 
 	lvl := slogutils.ParseLogLevel(flagLogLevel)
-	w := os.Stdout
 	logger := slog.New(
 		tint.NewHandler(w, &tint.Options{
 			Level:      lvl,
@@ -0,0 +1,93 @@
+// Package adminapi is the contract of admin JSON API: request and response bodies, endpoints and OpenAPI document.
+// Admin server binds handlers to the endpoints, CLI builds the document without importing server code.
+package adminapi
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/agalitsyn/goth/internal/model"
+)
+
+const (
+	// UsersDefaultLimit is used when limit query parameter is omitted
+	UsersDefaultLimit = 20
+	// UsersMaxLimit is the largest limit documented for clients
+	UsersMaxLimit = 1000
+)
+
+// UserSortColumns is mapping between sort field in query and column in database
+var UserSortColumns = map[string]string{
+	"id":     "id",
+	"login":  "login",
+	"active": "is_active",
+}
+
+type MeResponse struct {
+	ID          int64              `json:"id"`
+	Login       string             `json:"login"`
+	Email       string             `json:"email,omitempty"`
+	Permissions []model.Permission `json:"permissions" doc:"Permissions of user limited by token scopes"`
+}
+
+type User struct {
+	ID       int64  `json:"id"`
+	Login    string `json:"login"`
+	Email    string `json:"email,omitempty"`
+	IsActive bool   `json:"is_active"`
+}
+
+func NewUser(user *model.User) User {
+	return User{
+		ID:       user.ID,
+		Login:    user.Login,
+		Email:    user.Email,
+		IsActive: user.IsActive,
+	}
+}
+
+type UsersResponse struct {
+	Items  []User `json:"items"`
+	Total  int    `json:"total"`
+	Offset uint64 `json:"offset"`
+	Limit  uint64 `json:"limit"`
+}
+
+type CreateUserRequest struct {
+	Login    string `json:"login"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password"`
+	IsActive *bool  `json:"is_active" doc:"User is active when omitted"`
+}
+
+// UpdateUserRequest replaces user attributes, password is changed by separate action in UI
+type UpdateUserRequest struct {
+	Login    string `json:"login"`
+	Email    string `json:"email,omitempty"`
+	IsActive bool   `json:"is_active"`
+}
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+func NewSession(session *model.UserSession) Session {
+	return Session{
+		ID:         session.UUID,
+		CreatedAt:  session.CreatedAt,
+		ExpiresAt:  session.ExpiresAt,
+		LastSeenAt: session.LastSeenAt,
+		IP:         session.IP,
+		UserAgent:  session.UserAgent,
+	}
+}
+
+type SessionsResponse struct {
+	Items []Session `json:"items"`
+}
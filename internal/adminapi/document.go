@@ -0,0 +1,69 @@
+package adminapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/agalitsyn/goth/pkg/httptools"
+	"github.com/agalitsyn/goth/pkg/openapi"
+	"github.com/agalitsyn/goth/pkg/version"
+)
+
+const securityScheme = "bearerAuth"
+
+// NewDocument builds OpenAPI document of Routes, it needs no running server, e.g. for client generation
+func NewDocument() (*openapi.Document, error) {
+	doc := openapi.NewDocument(openapi.Info{
+		Title:       "Admin API",
+		Description: "Requests are authenticated by personal API tokens, token scopes limit permissions of its owner.",
+		Version:     version.String(),
+	}, nil)
+	doc.Components.SecuritySchemes[securityScheme] = &openapi.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "goth_...",
+	}
+	doc.Security = []openapi.SecurityRequirement{{securityScheme: {}}}
+
+	var tags []string
+	for _, route := range Routes() {
+		op := &openapi.Operation{
+			OperationID: route.OperationID,
+			Summary:     route.Summary,
+			Tags:        []string{route.Tag},
+			Parameters:  route.Params,
+			Responses:   make(map[string]*openapi.Response),
+		}
+		if route.Permission != "" {
+			op.Description = fmt.Sprintf("Requires `%s` permission.", route.Permission)
+		}
+		if route.Request != nil {
+			op.RequestBody = doc.JSONBody(route.Request)
+		}
+		op.Responses[fmt.Sprint(route.Status)] = doc.JSONResponse(openapi.StatusText(route.Status), route.Response)
+
+		op.Responses[fmt.Sprint(http.StatusUnauthorized)] = problemResponse(doc, "Token is missing, invalid or expired")
+		if route.Permission != "" {
+			op.Responses[fmt.Sprint(http.StatusForbidden)] = problemResponse(doc, "Token has no required permission")
+		}
+		for _, status := range append(slices.Clone(route.Errors), http.StatusInternalServerError) {
+			op.Responses[fmt.Sprint(status)] = problemResponse(doc, openapi.StatusText(status))
+		}
+
+		if err := doc.AddOperation(route.Method, route.Pattern, op); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(tags, route.Tag) {
+			tags = append(tags, route.Tag)
+		}
+	}
+	for _, tag := range tags {
+		doc.Tags = append(doc.Tags, openapi.Tag{Name: tag})
+	}
+	return doc, nil
+}
+
+func problemResponse(doc *openapi.Document, description string) *openapi.Response {
+	return doc.ContentResponse(description, httptools.ProblemContentType, httptools.Problem{})
+}
@@ -0,0 +1,38 @@
+package adminapi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDocument(t *testing.T) {
+	doc, err := NewDocument()
+	require.NoError(t, err)
+
+	operationIDs := map[string]bool{}
+	for _, route := range Routes() {
+		assert.False(t, operationIDs[route.OperationID], "operation id %s is unique", route.OperationID)
+		operationIDs[route.OperationID] = true
+		require.Contains(t, doc.Paths, route.Pattern)
+	}
+
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	var decoded struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	for _, route := range Routes() {
+		assert.Contains(t, decoded.Paths[route.Pattern], strings.ToLower(route.Method), route.Pattern)
+	}
+	for _, name := range []string{"User", "UsersResponse", "CreateUserRequest", "UpdateUserRequest", "SessionsResponse", "MeResponse", "Problem"} {
+		assert.Contains(t, decoded.Components.Schemas, name)
+	}
+}
@@ -0,0 +1,188 @@
+package adminapi
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/pkg/openapi"
+)
+
+// Route describes API endpoint, the same list is used to register handlers and to build OpenAPI document
+type Route struct {
+	Method  string
+	Pattern string
+	// Permission is checked before handler, empty means any valid token
+	Permission model.Permission
+
+	// OperationID is also the key which server binds handler by
+	OperationID string
+	Summary     string
+	Tag         string
+	Params      []openapi.Parameter
+	// Request is value of request body type, nil if endpoint has no body
+	Request any
+	// Response is value of successful response body type, nil if response has no body
+	Response any
+	Status   int
+	// Errors are statuses of problem details returned by handler itself
+	Errors []int
+}
+
+var (
+	userIDParam = openapi.Parameter{
+		Name:     "id",
+		In:       "path",
+		Required: true,
+		Schema:   &openapi.Schema{Type: "integer", Format: "int64"},
+	}
+	sessionIDParam = openapi.Parameter{
+		Name:     "sessionID",
+		In:       "path",
+		Required: true,
+		Schema:   &openapi.Schema{Type: "string", Format: "uuid"},
+	}
+	usersQueryParams = []openapi.Parameter{
+		{
+			Name:   "offset",
+			In:     "query",
+			Schema: &openapi.Schema{Type: "integer", Minimum: ptr(0.0), Default: 0},
+		},
+		{
+			Name:   "limit",
+			In:     "query",
+			Schema: &openapi.Schema{Type: "integer", Minimum: ptr(0.0), Maximum: ptr(float64(UsersMaxLimit)), Default: UsersDefaultLimit},
+		},
+		{
+			Name:   "sort",
+			In:     "query",
+			Schema: &openapi.Schema{Type: "string", Enum: sortedKeys(UserSortColumns), Default: "id"},
+		},
+		{
+			Name:   "order",
+			In:     "query",
+			Schema: &openapi.Schema{Type: "string", Enum: []any{"asc", "desc"}, Default: "asc"},
+		},
+		{
+			Name:        "q",
+			In:          "query",
+			Description: "Substring of login, case insensitive",
+			Schema:      &openapi.Schema{Type: "string"},
+		},
+	}
+)
+
+// Routes returns API endpoints without handlers
+func Routes() []Route {
+	return []Route{
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/api/v1/me",
+			OperationID: "getMe",
+			Summary:     "Get user who owns the token",
+			Tag:         "account",
+			Response:    MeResponse{},
+			Status:      http.StatusOK,
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/api/v1/users",
+			Permission:  model.PermissionUsersView,
+			OperationID: "listUsers",
+			Summary:     "List users",
+			Tag:         "users",
+			Params:      usersQueryParams,
+			Response:    UsersResponse{},
+			Status:      http.StatusOK,
+			Errors:      []int{http.StatusBadRequest},
+		},
+		{
+			Method:      http.MethodPost,
+			Pattern:     "/api/v1/users",
+			Permission:  model.PermissionUsersManage,
+			OperationID: "createUser",
+			Summary:     "Create user",
+			Tag:         "users",
+			Request:     CreateUserRequest{},
+			Response:    User{},
+			Status:      http.StatusCreated,
+			Errors:      []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/api/v1/users/{id}",
+			Permission:  model.PermissionUsersView,
+			OperationID: "getUser",
+			Summary:     "Get user",
+			Tag:         "users",
+			Params:      []openapi.Parameter{userIDParam},
+			Response:    User{},
+			Status:      http.StatusOK,
+			Errors:      []int{http.StatusNotFound},
+		},
+		{
+			Method:      http.MethodPut,
+			Pattern:     "/api/v1/users/{id}",
+			Permission:  model.PermissionUsersManage,
+			OperationID: "updateUser",
+			Summary:     "Update user",
+			Tag:         "users",
+			Params:      []openapi.Parameter{userIDParam},
+			Request:     UpdateUserRequest{},
+			Response:    User{},
+			Status:      http.StatusOK,
+			Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/api/v1/users/{id}/sessions",
+			Permission:  model.PermissionUsersView,
+			OperationID: "listUserSessions",
+			Summary:     "List active sessions of user",
+			Tag:         "sessions",
+			Params:      []openapi.Parameter{userIDParam},
+			Response:    SessionsResponse{},
+			Status:      http.StatusOK,
+			Errors:      []int{http.StatusNotFound},
+		},
+		{
+			Method:      http.MethodDelete,
+			Pattern:     "/api/v1/users/{id}/sessions",
+			Permission:  model.PermissionUsersManage,
+			OperationID: "revokeUserSessions",
+			Summary:     "Sign out user everywhere",
+			Tag:         "sessions",
+			Params:      []openapi.Parameter{userIDParam},
+			Status:      http.StatusNoContent,
+			Errors:      []int{http.StatusNotFound},
+		},
+		{
+			Method:      http.MethodDelete,
+			Pattern:     "/api/v1/users/{id}/sessions/{sessionID}",
+			Permission:  model.PermissionUsersManage,
+			OperationID: "revokeUserSession",
+			Summary:     "Revoke session of user",
+			Tag:         "sessions",
+			Params:      []openapi.Parameter{userIDParam, sessionIDParam},
+			Status:      http.StatusNoContent,
+			Errors:      []int{http.StatusNotFound},
+		},
+	}
+}
+
+func sortedKeys(m map[string]string) []any {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	res := make([]any, len(keys))
+	for i, k := range keys {
+		res[i] = k
+	}
+	return res
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,146 @@
+// Package audit records who changed what through admin panel and CLI.
+// Entries are append-only, storage implementations never update or delete them.
+package audit
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/agalitsyn/goth/internal/model"
+)
+
+// Source is the interface action was made through
+type Source string
+
+const (
+	SourceWeb Source = "web"
+	SourceCLI Source = "cli"
+	// SourceAPI is JSON API called with personal API token
+	SourceAPI Source = "api"
+)
+
+type Action string
+
+const (
+	ActionUserCreate         Action = "user.create"
+	ActionUserUpdate         Action = "user.update"
+	ActionUserActivate       Action = "user.activate"
+	ActionUserDeactivate     Action = "user.deactivate"
+	ActionUserPasswordChange Action = "user.password_change"
+	// ActionUserPasswordReset is self-service reset by emailed token
+	ActionUserPasswordReset Action = "user.password_reset"
+	ActionUserRoleGrant     Action = "user.role_grant"
+	ActionUserRoleRevoke    Action = "user.role_revoke"
+	ActionUserTOTPEnable    Action = "user.totp_enable"
+	ActionUserTOTPDisable   Action = "user.totp_disable"
+	ActionUserTOTPReset     Action = "user.totp_reset"
+	ActionAPITokenCreate    Action = "api_token.create"
+	ActionAPITokenRevoke    Action = "api_token.revoke"
+	ActionSessionsRevoke    Action = "sessions.revoke"
+	ActionSessionsGC        Action = "sessions.gc"
+)
+
+// Actions lists known actions in order they are shown in filters
+var Actions = []Action{
+	ActionUserCreate,
+	ActionUserUpdate,
+	ActionUserActivate,
+	ActionUserDeactivate,
+	ActionUserPasswordChange,
+	ActionUserPasswordReset,
+	ActionUserRoleGrant,
+	ActionUserRoleRevoke,
+	ActionUserTOTPEnable,
+	ActionUserTOTPDisable,
+	ActionUserTOTPReset,
+	ActionAPITokenCreate,
+	ActionAPITokenRevoke,
+	ActionSessionsRevoke,
+	ActionSessionsGC,
+}
+
+// Target entity types
+const (
+	EntityUser     = "user"
+	EntitySession  = "session"
+	EntityAPIToken = "api_token"
+)
+
+// Redacted is recorded instead of secret values, e.g. passwords
+const Redacted = "[redacted]"
+
+type Actor struct {
+	// UserID is empty for CLI and anonymous requests
+	UserID int64
+	// Login is user login for web and OS user for CLI
+	Login     string
+	Source    Source
+	IP        string
+	RequestID string
+}
+
+type Change struct {
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+type Entry struct {
+	ID         int64
+	Actor      Actor
+	Action     Action
+	TargetType string
+	TargetID   string
+	// Changes maps field name to its values before and after action
+	Changes   map[string]Change
+	CreatedAt time.Time
+}
+
+// Fields is a snapshot of entity fields which are worth recording
+type Fields map[string]any
+
+// UserFields snapshots user without secrets, nil user gives nil snapshot
+func UserFields(user *model.User) Fields {
+	if user == nil {
+		return nil
+	}
+	return Fields{
+		"login":     user.Login,
+		"email":     user.Email,
+		"is_active": user.IsActive,
+	}
+}
+
+// APITokenFields snapshots token without hash, nil token gives nil snapshot
+func APITokenFields(token *model.APIToken) Fields {
+	if token == nil {
+		return nil
+	}
+	scopes := make([]string, len(token.Scopes))
+	for i, scope := range token.Scopes {
+		scopes[i] = string(scope)
+	}
+	return Fields{
+		"user_id":    token.UserID,
+		"name":       token.Name,
+		"scopes":     strings.Join(scopes, ","),
+		"expires_at": token.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+// Diff returns changed fields, missing field is recorded as nil value
+func Diff(before, after Fields) map[string]Change {
+	changes := make(map[string]Change)
+	for name, b := range before {
+		a, ok := after[name]
+		if !ok || !reflect.DeepEqual(a, b) {
+			changes[name] = Change{Before: b, After: a}
+		}
+	}
+	for name, a := range after {
+		if _, ok := before[name]; !ok {
+			changes[name] = Change{After: a}
+		}
+	}
+	return changes
+}
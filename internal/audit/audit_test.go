@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/model"
+)
+
+func TestDiff(t *testing.T) {
+	before := UserFields(&model.User{Login: "foo", Email: "foo@example.com", IsActive: true})
+	after := UserFields(&model.User{Login: "bar", Email: "foo@example.com", IsActive: false})
+
+	assert.Equal(t, map[string]Change{
+		"login":     {Before: "foo", After: "bar"},
+		"is_active": {Before: true, After: false},
+	}, Diff(before, after))
+
+	assert.Empty(t, Diff(before, before))
+
+	created := Diff(nil, Fields{"login": "foo"})
+	assert.Equal(t, map[string]Change{"login": {After: "foo"}}, created)
+
+	removed := Diff(Fields{"role": "operator"}, nil)
+	assert.Equal(t, map[string]Change{"role": {Before: "operator"}}, removed)
+}
+
+type fakeStorage struct {
+	entries []Entry
+	err     error
+}
+
+func (s *fakeStorage) CreateEntry(_ context.Context, entry *Entry) error {
+	if s.err != nil {
+		return s.err
+	}
+	entry.ID = int64(len(s.entries) + 1)
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+func (s *fakeStorage) FilterEntries(context.Context, FilterParams) ([]Entry, error) {
+	return s.entries, nil
+}
+
+func (s *fakeStorage) CountEntries(context.Context, FilterParams) (int, error) {
+	return len(s.entries), nil
+}
+
+func TestRecorder(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	storage := &fakeStorage{}
+	recorder := NewRecorder(storage)
+	recorder.now = func() time.Time { return now }
+
+	recorder.Record(context.Background(), Entry{
+		Actor:      Actor{Login: "admin", Source: SourceCLI},
+		Action:     ActionUserRoleGrant,
+		TargetType: EntityUser,
+		TargetID:   "1",
+	})
+	require.Len(t, storage.entries, 1)
+	entry := storage.entries[0]
+	assert.Equal(t, now, entry.CreatedAt)
+	assert.NotNil(t, entry.Changes, "changes are stored as JSON object")
+	assert.Equal(t, ActionUserRoleGrant, entry.Action)
+
+	// failure is logged and does not panic
+	storage.err = errors.New("boom")
+	recorder.Record(context.Background(), Entry{Action: ActionUserUpdate})
+	assert.Len(t, storage.entries, 1)
+}
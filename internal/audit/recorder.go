@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type Recorder struct {
+	storage Storage
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewRecorder(storage Storage) *Recorder {
+	return &Recorder{
+		storage: storage,
+		now:     time.Now,
+	}
+}
+
+// Record saves entry. Action is already done when it is recorded,
+// so failure is only logged with the whole entry to not lose it.
+func (r *Recorder) Record(ctx context.Context, entry Entry) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = r.now().UTC()
+	}
+	if entry.Changes == nil {
+		entry.Changes = map[string]Change{}
+	}
+
+	if err := r.storage.CreateEntry(ctx, &entry); err != nil {
+		slog.ErrorContext(
+			ctx,
+			"could not record audit entry",
+			"error", err,
+			"actor", entry.Actor.Login,
+			"source", entry.Actor.Source,
+			"action", entry.Action,
+			"target_type", entry.TargetType,
+			"target_id", entry.TargetID,
+			"changes", fmt.Sprintf("%+v", entry.Changes),
+		)
+	}
+}
@@ -0,0 +1,23 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/agalitsyn/postgres/pagination"
+)
+
+type FilterParams struct {
+	pagination.Pagination
+
+	ActorLogin string
+	Action     Action
+	TargetType string
+	TargetID   string
+}
+
+type Storage interface {
+	CreateEntry(ctx context.Context, entry *Entry) error
+	// FilterEntries returns newest entries first
+	FilterEntries(ctx context.Context, params FilterParams) ([]Entry, error)
+	CountEntries(ctx context.Context, params FilterParams) (int, error)
+}
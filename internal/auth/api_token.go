@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/pkg/httptools"
+)
+
+const (
+	// APITokenPrefix marks tokens of this app, so leaked tokens are easy to find by secret scanners
+	APITokenPrefix = "goth_"
+	// apiTokenDisplayLength is length of token beginning which is stored to recognize token in list
+	apiTokenDisplayLength = len(APITokenPrefix) + 6
+)
+
+var (
+	ErrInvalidAPIToken = errors.New("auth: invalid or expired api token")
+	ErrInvalidScope    = errors.New("auth: unknown api token scope")
+)
+
+type APITokenAuthenticatorConfig struct {
+	// LastUsedInterval limits how often token usage is written to storage
+	LastUsedInterval time.Duration
+}
+
+// APITokenAuthenticator authenticates scripts and services by personal access tokens of users
+type APITokenAuthenticator struct {
+	cfg                APITokenAuthenticatorConfig
+	userStorage        storage.UserStorage
+	userValidationFunc model.UserValidationFunc
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewAPITokenAuthenticator(
+	cfg APITokenAuthenticatorConfig,
+	userStorage storage.UserStorage,
+	userValidationFunc model.UserValidationFunc,
+) *APITokenAuthenticator {
+	return &APITokenAuthenticator{
+		cfg:                cfg,
+		userStorage:        userStorage,
+		userValidationFunc: userValidationFunc,
+		now:                time.Now,
+	}
+}
+
+// CreateAPIToken issues token for user, plain token is returned only here and can't be shown again
+func CreateAPIToken(
+	ctx context.Context,
+	userStorage storage.UserStorage,
+	userID int64,
+	name string,
+	scopes []model.Permission,
+	ttl time.Duration,
+) (*model.APIToken, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(model.Permissions, scope) {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	if ttl <= 0 {
+		return nil, "", fmt.Errorf("token lifetime must be positive")
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	plain := APITokenPrefix + secret
+
+	now := time.Now().UTC()
+	token := &model.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plain[:apiTokenDisplayLength],
+		TokenHash: hashToken(plain),
+		Scopes:    slices.Clone(scopes),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := userStorage.CreateAPIToken(ctx, token); err != nil {
+		return nil, "", fmt.Errorf("could not create api token: %w", err)
+	}
+	slog.InfoContext(ctx, "api token created", "target_user_id", userID, "token_id", token.ID)
+	return token, plain, nil
+}
+
+// Authenticate returns active user of valid token, user permissions are limited by token scopes
+func (a *APITokenAuthenticator) Authenticate(ctx context.Context, plain string) (*model.User, *model.APIToken, error) {
+	if !strings.HasPrefix(plain, APITokenPrefix) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+
+	token, err := a.userStorage.FetchAPITokenByHash(ctx, hashToken(plain))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrInvalidAPIToken
+		}
+		return nil, nil, fmt.Errorf("could not fetch api token: %w", err)
+	}
+	now := a.now()
+	if token.IsExpired(now) {
+		slog.WarnContext(ctx, "api token expired", "token_id", token.ID, "user_id", token.UserID)
+		return nil, nil, ErrInvalidAPIToken
+	}
+
+	user, err := a.userStorage.FetchUserByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrInvalidAPIToken
+		}
+		return nil, nil, fmt.Errorf("could not fetch user: %w", err)
+	}
+	if err := a.userValidationFunc(user); err != nil {
+		slog.WarnContext(ctx, "api token of invalid user", "token_id", token.ID, "user_id", user.ID, "error", err)
+		return nil, nil, ErrInvalidAPIToken
+	}
+	if err := a.userStorage.FetchUserRoles(ctx, user); err != nil {
+		return nil, nil, fmt.Errorf("could not fetch user roles: %w", err)
+	}
+	user.Permissions = token.ScopePermissions(user.Permissions)
+
+	// usage tracking is informational, failure does not break request
+	if now.Sub(token.LastUsedAt) >= a.cfg.LastUsedInterval {
+		token.LastUsedAt = now
+		if err := a.userStorage.UpdateAPITokenLastUsed(ctx, token.ID, now); err != nil {
+			slog.ErrorContext(ctx, "could not update api token last use", "token_id", token.ID, "error", err)
+		}
+	}
+	return user, token, nil
+}
+
+// BearerTokenMiddleware authenticates request by API token in Authorization header.
+// User is put into context the same way as LoginRequiredMiddleware does, so handlers work with both.
+func (a *APITokenAuthenticator) BearerTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plain, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httptools.WriteError(w, r, httptools.NewError(http.StatusUnauthorized, "Токен не передан", nil))
+			return
+		}
+
+		user, token, err := a.Authenticate(r.Context(), plain)
+		if err != nil {
+			if errors.Is(err, ErrInvalidAPIToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				httptools.WriteError(w, r, httptools.NewError(http.StatusUnauthorized, "Токен недействителен или истек", err))
+				return
+			}
+			httptools.WriteError(w, r, httptools.NewError(http.StatusInternalServerError, "Не удалось проверить токен",
+				fmt.Errorf("could not authenticate api token: %w", err)))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, apiTokenContextKey, token)
+		ctx = httptools.WithLogAttrs(ctx, slog.Int64("user_id", user.ID), slog.Int64("token_id", token.ID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bearerToken reads token from Authorization header, scheme is case insensitive
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// APITokenFromContext returns token which authenticated request, it is set by BearerTokenMiddleware
+func APITokenFromContext(ctx context.Context) (*model.APIToken, error) {
+	token, ok := ctx.Value(apiTokenContextKey).(*model.APIToken)
+	if !ok {
+		return nil, fmt.Errorf("no api token in context")
+	}
+	return token, nil
+}
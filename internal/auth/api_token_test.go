@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+)
+
+func newTestAPITokenAuthenticator(t *testing.T) (*APITokenAuthenticator, *memory.UserStorage, *model.User) {
+	t.Helper()
+
+	store := memory.NewUserStorage()
+	store.AddRole(model.Role{
+		Name:        model.RoleOperator,
+		Permissions: []model.Permission{model.PermissionUsersView, model.PermissionAuditView},
+	})
+	user := &model.User{Login: "bot", IsActive: true}
+	require.NoError(t, store.CreateUser(context.Background(), user))
+	require.NoError(t, store.GrantUserRole(context.Background(), user.ID, model.RoleOperator))
+
+	a := NewAPITokenAuthenticator(APITokenAuthenticatorConfig{LastUsedInterval: time.Minute}, store, func(u *model.User) error {
+		if !u.IsActive {
+			return ErrInvalidUser
+		}
+		return nil
+	})
+	return a, store, user
+}
+
+func TestAPITokenAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	a, store, user := newTestAPITokenAuthenticator(t)
+	a.now = func() time.Time { return now }
+
+	token, plain, err := CreateAPIToken(ctx, store, user.ID, "ci", []model.Permission{model.PermissionUsersView}, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(plain, APITokenPrefix))
+	assert.True(t, strings.HasPrefix(plain, token.Prefix))
+	assert.NotContains(t, token.TokenHash, plain, "plain token is not stored")
+
+	authUser, authToken, err := a.Authenticate(ctx, plain)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, authUser.ID)
+	assert.Equal(t, token.ID, authToken.ID)
+	assert.Equal(t, []model.Permission{model.PermissionUsersView}, authUser.Permissions, "scopes limit permissions")
+
+	stored, err := store.FilterUserAPITokens(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, now, stored[0].LastUsedAt)
+
+	// last use is not written again within interval
+	a.now = func() time.Time { return now.Add(30 * time.Second) }
+	_, _, err = a.Authenticate(ctx, plain)
+	require.NoError(t, err)
+	stored, err = store.FilterUserAPITokens(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, now, stored[0].LastUsedAt)
+
+	_, _, err = a.Authenticate(ctx, plain+"x")
+	assert.ErrorIs(t, err, ErrInvalidAPIToken)
+	_, _, err = a.Authenticate(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidAPIToken)
+
+	a.now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, _, err = a.Authenticate(ctx, plain)
+	assert.ErrorIs(t, err, ErrInvalidAPIToken, "expired")
+	a.now = func() time.Time { return now }
+
+	user.IsActive = false
+	require.NoError(t, store.UpdateUser(ctx, user))
+	_, _, err = a.Authenticate(ctx, plain)
+	assert.ErrorIs(t, err, ErrInvalidAPIToken, "inactive user")
+	user.IsActive = true
+	require.NoError(t, store.UpdateUser(ctx, user))
+
+	require.NoError(t, store.DeleteUserAPIToken(ctx, user.ID, token.ID))
+	_, _, err = a.Authenticate(ctx, plain)
+	assert.ErrorIs(t, err, ErrInvalidAPIToken, "revoked")
+}
+
+func TestCreateAPITokenValidatesScopes(t *testing.T) {
+	ctx := context.Background()
+	_, store, user := newTestAPITokenAuthenticator(t)
+
+	_, _, err := CreateAPIToken(ctx, store, user.ID, "ci", nil, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	_, _, err = CreateAPIToken(ctx, store, user.ID, "ci", []model.Permission{"users:delete"}, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	_, _, err = CreateAPIToken(ctx, store, user.ID, "ci", []model.Permission{model.PermissionUsersView}, 0)
+	assert.Error(t, err)
+}
+
+func TestBearerTokenMiddleware(t *testing.T) {
+	ctx := context.Background()
+	a, store, user := newTestAPITokenAuthenticator(t)
+	_, plain, err := CreateAPIToken(ctx, store, user.ID, "ci", []model.Permission{model.PermissionAll}, time.Hour)
+	require.NoError(t, err)
+
+	handler := a.BearerTokenMiddleware(RequirePermission(model.PermissionAuditView)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := MustUserFromContext(r.Context())
+			token, err := APITokenFromContext(r.Context())
+			require.NoError(t, err)
+			w.Write([]byte(u.Login + ":" + token.Name))
+		}),
+	))
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantAuth   string
+	}{
+		{name: "no header", wantStatus: http.StatusUnauthorized, wantAuth: "Bearer"},
+		{name: "basic auth", header: "Basic Ym90OnNlY3JldA==", wantStatus: http.StatusUnauthorized, wantAuth: "Bearer"},
+		{
+			name:       "unknown token",
+			header:     "Bearer " + APITokenPrefix + "unknown",
+			wantStatus: http.StatusUnauthorized,
+			wantAuth:   `Bearer error="invalid_token"`,
+		},
+		{name: "valid token", header: "bearer " + plain, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantAuth, w.Header().Get("WWW-Authenticate"))
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, "bot:ci", w.Body.String())
+			}
+		})
+	}
+}
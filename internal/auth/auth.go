@@ -12,21 +12,44 @@ import (
 
 	"github.com/agalitsyn/goth/internal/model"
 	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/pkg/httptools"
 )
 
 type SessionAuthenticatorConfig struct {
-	LoginRedirectURL  string
-	PageRedirectURL   string
+	LoginRedirectURL string
+	PageRedirectURL  string
+
+	// SessionMaxAgeInDB is absolute session lifetime since login
 	SessionMaxAgeInDB time.Duration
-	CookieName        string
-	CookieMaxAge      int
-	CookieSecure      bool
+	// SessionIdleTimeout expires session after inactivity, each renewal slides expiration up to max age.
+	// Zero disables sliding expiration.
+	SessionIdleTimeout time.Duration
+	// SessionRenewInterval limits how often session activity is written to storage
+	SessionRenewInterval time.Duration
+
+	CookieName   string
+	CookieMaxAge int
+	CookieSecure bool
+	// CookieCodec signs session id in cookie, nil keeps raw session id
+	CookieCodec *CookieCodec
+
+	// LoginMaxFailures is count of failed attempts after which login is locked, zero disables lockout
+	LoginMaxFailures     int
+	LoginLockoutDuration time.Duration
+	// LoginThrottler overrides lockout by login attempts in storage built from LoginMaxFailures and LoginLockoutDuration
+	LoginThrottler LoginThrottler
+	// LoginChallengeTTL is time to enter two-factor code after password, 5 minutes by default
+	LoginChallengeTTL time.Duration
 }
 
 type SessionAuthenticator struct {
 	cfg                SessionAuthenticatorConfig
 	userValidationFunc model.UserValidationFunc
 	userStorage        storage.UserStorage
+	loginThrottler     LoginThrottler
+
+	// now is replaced in tests
+	now func() time.Time
 }
 
 func NewSessionAuthenticator(
@@ -34,11 +57,22 @@ func NewSessionAuthenticator(
 	userStorage storage.UserStorage,
 	userValidationFunc model.UserValidationFunc,
 ) *SessionAuthenticator {
-	return &SessionAuthenticator{
+	s := &SessionAuthenticator{
 		cfg:                cfg,
 		userStorage:        userStorage,
 		userValidationFunc: userValidationFunc,
+		now:                time.Now,
 	}
+	if s.cfg.LoginChallengeTTL <= 0 {
+		s.cfg.LoginChallengeTTL = defaultLoginChallengeTTL
+	}
+	switch {
+	case cfg.LoginThrottler != nil:
+		s.loginThrottler = cfg.LoginThrottler
+	case cfg.LoginMaxFailures > 0:
+		s.loginThrottler = NewStorageLoginThrottle(cfg.LoginMaxFailures, cfg.LoginLockoutDuration, userStorage)
+	}
+	return s
 }
 
 var (
@@ -46,33 +80,215 @@ var (
 	ErrInvalidUser   = errors.New("auth: invalid user")
 	ErrForbidden     = errors.New("auth: user dont have enough permissions")
 	ErrInternal      = errors.New("auth: internal error")
+
+	ErrTooManyAttempts = errors.New("auth: too many login attempts")
 )
 
-func (s *SessionAuthenticator) CreateSession(ctx context.Context, login string, password string) (*model.UserSession, error) {
+// LoginLockedError is returned when login is temporarily locked after failed attempts
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("auth: login is locked, retry after %s", e.RetryAfter)
+}
+
+func (e *LoginLockedError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// ClientInfo describes client which makes request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	// RequestID links login attempt with request logs
+	RequestID string
+}
+
+func NewClientInfo(r *http.Request) ClientInfo {
+	return ClientInfo{
+		IP:        httptools.RemoteIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: httptools.GetTraceID(r),
+	}
+}
+
+func (s *SessionAuthenticator) CreateSession(
+	ctx context.Context,
+	login string,
+	password string,
+	client ClientInfo,
+) (*model.UserSession, error) {
+	// attempt is reserved before throttle check, so storage throttle counts concurrent attempts
+	attempt := s.reserveLoginAttempt(ctx, login, client)
+	if s.loginThrottler != nil {
+		retryAfter, err := s.loginThrottler.RetryAfter(ctx, attempt)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not check login throttle", "error", err)
+			s.recordLoginAttempt(ctx, attempt, 0, model.LoginOutcomeError)
+			return nil, ErrInternal
+		}
+		if retryAfter > 0 {
+			slog.WarnContext(ctx, "login is locked", "login", attempt.Login, "retry_after", retryAfter)
+			s.recordLoginAttempt(ctx, attempt, 0, model.LoginOutcomeLocked)
+			return nil, &LoginLockedError{RetryAfter: retryAfter}
+		}
+	}
+
 	user, err := s.userStorage.FetchUserByLogin(ctx, login)
 	if err != nil {
-		slog.Error("could not fetch user", "error", err)
+		slog.ErrorContext(ctx, "could not fetch user", "error", err)
 		// mask not found error as invalid login or password
 		if errors.Is(err, storage.ErrNotFound) {
+			s.recordLoginAttempt(ctx, attempt, 0, model.LoginOutcomeInvalidCredentials)
 			return nil, ErrLoginPassword
 		}
+		s.recordLoginAttempt(ctx, attempt, 0, model.LoginOutcomeError)
 		return nil, ErrInternal
 	}
 	if err := s.userValidationFunc(user); err != nil {
-		slog.Error("invalid user", "user_id", user.ID, "error", err)
+		slog.ErrorContext(ctx, "invalid user", "user_id", user.ID, "error", err)
+		s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeForbidden)
 		return nil, ErrForbidden
 	}
 
 	if err := s.userStorage.FetchUserPassword(ctx, user); err != nil {
-		slog.Error("could not fetch user password", "error", err)
+		slog.ErrorContext(ctx, "could not fetch user password", "error", err)
+		s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeError)
 		return nil, ErrInternal
 	}
 
 	if err := model.CompareUserPassword([]byte(user.HashedPassword), password); err != nil {
-		slog.Error("user password input and hash mismatch", "error", err)
+		slog.ErrorContext(ctx, "user password input and hash mismatch", "error", err)
+		s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeInvalidCredentials)
 		return nil, ErrLoginPassword
 	}
+	s.rehashPassword(ctx, user, password)
+
+	if err := s.requireSecondFactor(ctx, attempt, user); err != nil {
+		return nil, err
+	}
+
+	return s.completeLogin(ctx, attempt, user, client)
+}
+
+// requireSecondFactor returns SecondFactorRequiredError with a new login challenge when user has confirmed TOTP,
+// login is completed by CompleteSecondFactor then. Nil error means second factor is not enabled.
+func (s *SessionAuthenticator) requireSecondFactor(
+	ctx context.Context,
+	attempt *model.LoginAttempt,
+	user *model.User,
+) error {
+	totp, err := s.userStorage.FetchUserTOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.ErrorContext(ctx, "could not fetch user totp", "user_id", user.ID, "error", err)
+		s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeError)
+		return ErrInternal
+	}
+	if totp == nil || !totp.IsConfirmed {
+		return nil
+	}
 
+	challengeID, err := s.createLoginChallenge(ctx, user.ID, attempt.Login)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not create login challenge", "error", err)
+		s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeError)
+		return ErrInternal
+	}
+	s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeSecondFactorRequired)
+	return &SecondFactorRequiredError{ChallengeID: challengeID}
+}
+
+// completeLogin starts session and records login outcome
+func (s *SessionAuthenticator) completeLogin(
+	ctx context.Context,
+	attempt *model.LoginAttempt,
+	user *model.User,
+	client ClientInfo,
+) (*model.UserSession, error) {
+	session, err := s.startSession(ctx, user, client)
+	if err != nil {
+		s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeError)
+		return nil, err
+	}
+	s.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeSuccess)
+	return session, nil
+}
+
+// newLoginAttempt returns unsaved attempt of login, client supplied values are truncated
+func (s *SessionAuthenticator) newLoginAttempt(login string, client ClientInfo) *model.LoginAttempt {
+	attempt := &model.LoginAttempt{
+		Login:     login,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		RequestID: client.RequestID,
+		Outcome:   model.LoginOutcomePending,
+		CreatedAt: s.now(),
+	}
+	attempt.Truncate()
+	return attempt
+}
+
+// reserveLoginAttempt saves pending attempt, recordLoginAttempt sets its outcome later.
+// Attempt is returned unsaved if it could not be saved, then it is created on record.
+func (s *SessionAuthenticator) reserveLoginAttempt(ctx context.Context, login string, client ClientInfo) *model.LoginAttempt {
+	attempt := s.newLoginAttempt(login, client)
+	if err := s.userStorage.CreateLoginAttempt(ctx, attempt); err != nil {
+		slog.ErrorContext(ctx, "could not reserve login attempt", "login", attempt.Login, "error", err)
+		attempt.ID = 0
+	}
+	return attempt
+}
+
+// recordLoginAttempt writes audit trail, failure to write it does not affect login
+func (s *SessionAuthenticator) recordLoginAttempt(
+	ctx context.Context,
+	attempt *model.LoginAttempt,
+	userID int64,
+	outcome model.LoginOutcome,
+) {
+	attempt.UserID = userID
+	attempt.Outcome = outcome
+	loginAttemptsTotal.Inc(string(outcome))
+
+	var err error
+	if attempt.ID != 0 {
+		err = s.userStorage.UpdateLoginAttemptOutcome(ctx, attempt)
+	} else {
+		err = s.userStorage.CreateLoginAttempt(ctx, attempt)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not record login attempt", "login", attempt.Login, "outcome", outcome, "error", err)
+	}
+}
+
+// rehashPassword upgrades stored hash made by outdated algorithm or parameters,
+// plain password is known only at login so it is the only place to do it.
+// Errors are logged, login proceeds with old hash.
+func (s *SessionAuthenticator) rehashPassword(ctx context.Context, user *model.User, password string) {
+	if !model.PasswordNeedsRehash([]byte(user.HashedPassword)) {
+		return
+	}
+
+	hash, err := model.HashUserPassword(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not rehash user password", "user_id", user.ID, "error", err)
+		return
+	}
+	user.HashedPassword = string(hash)
+	if err := s.userStorage.UpdateUserPassword(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "could not update user password hash", "user_id", user.ID, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "user password hash upgraded", "user_id", user.ID)
+}
+
+// startSession creates session for authenticated user
+func (s *SessionAuthenticator) startSession(
+	ctx context.Context,
+	user *model.User,
+	client ClientInfo,
+) (*model.UserSession, error) {
 	// delete expired sessions
 	// if it fails allow to proceed anyway, will try to delete next time
 	filter := storage.UserSessionsFilterParams{
@@ -81,53 +297,89 @@ func (s *SessionAuthenticator) CreateSession(ctx context.Context, login string,
 	}
 	sessions, err := s.userStorage.FilterUserSessions(ctx, filter)
 	if err != nil {
-		slog.Error("could not filter user sessions", "error", err)
+		slog.ErrorContext(ctx, "could not filter user sessions", "error", err)
 	}
 	if len(sessions) > 0 {
 		if err := s.userStorage.DeleteUserSessions(ctx, sessions); err != nil {
-			slog.Error("could not delete user sessions", "error", err)
+			slog.ErrorContext(ctx, "could not delete user sessions", "error", err)
 		}
 	}
 
+	now := s.now()
 	session := &model.UserSession{
-		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(s.cfg.SessionMaxAgeInDB),
+		UserID:     user.ID,
+		ExpiresAt:  s.sessionExpiresAt(now, now),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
 	}
+	session.Truncate()
 	if err := s.userStorage.CreateUserSession(ctx, session); err != nil {
-		slog.Error("could not create user session", "error", err)
+		slog.ErrorContext(ctx, "could not create user session", "error", err)
 		return nil, ErrInternal
 	}
+	sessionsCreatedTotal.Inc()
 	return session, nil
 }
 
+// sessionExpiresAt slides expiration by idle timeout, but not later than max age since creation
+func (s *SessionAuthenticator) sessionExpiresAt(createdAt, now time.Time) time.Time {
+	expiresAt := createdAt.Add(s.cfg.SessionMaxAgeInDB)
+	if s.cfg.SessionIdleTimeout > 0 {
+		if idleExpiresAt := now.Add(s.cfg.SessionIdleTimeout); idleExpiresAt.Before(expiresAt) {
+			return idleExpiresAt
+		}
+	}
+	return expiresAt
+}
+
+// renewSession records session activity and slides expiration.
+// Writes happen not more often than renew interval, failure does not break request.
+func (s *SessionAuthenticator) renewSession(ctx context.Context, session *model.UserSession, client ClientInfo) {
+	now := s.now()
+	if now.Sub(session.LastSeenAt) < s.cfg.SessionRenewInterval {
+		return
+	}
+
+	session.LastSeenAt = now
+	session.ExpiresAt = s.sessionExpiresAt(session.CreatedAt, now)
+	session.IP = client.IP
+	session.UserAgent = client.UserAgent
+	session.Truncate()
+	if err := s.userStorage.UpdateUserSession(ctx, session); err != nil {
+		slog.ErrorContext(ctx, "could not renew user session", "session_id", session.UUID, "error", err)
+	}
+}
+
 func (s *SessionAuthenticator) LoginRequiredMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie(s.cfg.CookieName)
+		sid, err := s.sessionIDFromRequest(r)
 		if err != nil {
-			slog.Error("could not get session cookie", "error", err)
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			if errors.Is(err, http.ErrNoCookie) {
+				slog.DebugContext(r.Context(), "no session cookie")
+				s.redirectToLogin(w, r, false)
+				return
+			}
+			slog.WarnContext(r.Context(), "malformed session cookie", "error", err)
+			s.redirectToLogin(w, r, true)
 			return
 		}
-
-		sid := uuid.MustParse(cookie.Value)
 		session, err := s.userStorage.FetchUserSession(r.Context(), sid)
 		if err != nil {
 			// delete session cookie if session is not found
 			if errors.Is(err, storage.ErrNotFound) {
-				cookie := s.DeletionSessionCookie()
-				http.SetCookie(w, cookie)
-			} else {
-				slog.Error("could not fetch user session", "session_id", sid, "error", err)
+				s.redirectToLogin(w, r, true)
+				return
 			}
 
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			httptools.WriteError(w, r, httptools.NewError(http.StatusInternalServerError, "Не удалось проверить сессию",
+				fmt.Errorf("could not fetch user session %s: %w", sid, err)))
 			return
 		}
-		if session.ExpiresAt.Before(time.Now()) {
-			slog.Warn("user session expired", "session_id", sid, "user_id", session.UserID)
-			cookie := s.DeletionSessionCookie()
-			http.SetCookie(w, cookie)
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		if session.ExpiresAt.Before(s.now()) {
+			slog.WarnContext(r.Context(), "user session expired", "session_id", sid, "user_id", session.UserID)
+			s.redirectToLogin(w, r, true)
 			return
 		}
 
@@ -135,23 +387,28 @@ func (s *SessionAuthenticator) LoginRequiredMiddleware(next http.Handler) http.H
 		if err != nil {
 			// delete session cookie if user is not found
 			if errors.Is(err, storage.ErrNotFound) {
-				cookie := s.DeletionSessionCookie()
-				http.SetCookie(w, cookie)
-			} else {
-				slog.Error("could not fetch user", "user_id", session.UserID, "error", err)
+				s.redirectToLogin(w, r, true)
+				return
 			}
 
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			httptools.WriteError(w, r, httptools.NewError(http.StatusInternalServerError, "Не удалось проверить сессию",
+				fmt.Errorf("could not fetch user %d: %w", session.UserID, err)))
 			return
 		}
 		if err := s.userValidationFunc(user); err != nil {
-			slog.Error("invalid user", "user_id", user.ID, "error", err)
-			cookie := s.DeletionSessionCookie()
-			http.SetCookie(w, cookie)
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			slog.ErrorContext(r.Context(), "invalid user", "user_id", user.ID, "error", err)
+			s.redirectToLogin(w, r, true)
 			return
 		}
 
+		if err := s.userStorage.FetchUserRoles(r.Context(), user); err != nil {
+			httptools.WriteError(w, r, httptools.NewError(http.StatusInternalServerError, "Не удалось проверить сессию",
+				fmt.Errorf("could not fetch roles of user %d: %w", user.ID, err)))
+			return
+		}
+
+		s.renewSession(r.Context(), session, NewClientInfo(r))
+
 		// Redirect to non-login page if user is already on login page
 		if r.URL.Path == s.cfg.LoginRedirectURL {
 			http.Redirect(w, r, s.cfg.PageRedirectURL, http.StatusMovedPermanently)
@@ -159,33 +416,130 @@ func (s *SessionAuthenticator) LoginRequiredMiddleware(next http.Handler) http.H
 		}
 
 		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, sessionContextKey, session)
+		ctx = httptools.WithLogAttrs(ctx, slog.Int64("user_id", user.ID))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (s *SessionAuthenticator) MakeSessionCookie(value fmt.Stringer) *http.Cookie {
+// RevokeSession deletes session referenced by request cookie from storage,
+// so the cookie stops working even if it was copied.
+// Request without valid session is not an error, there is nothing to revoke.
+func (s *SessionAuthenticator) RevokeSession(r *http.Request) error {
+	sid, err := s.sessionIDFromRequest(r)
+	if err != nil {
+		return nil
+	}
+
+	session, err := s.userStorage.FetchUserSession(r.Context(), sid)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("could not fetch user session: %w", err)
+	}
+	if err := s.userStorage.DeleteUserSessions(r.Context(), []model.UserSession{*session}); err != nil {
+		return fmt.Errorf("could not delete user session: %w", err)
+	}
+	sessionsRevokedTotal.Inc()
+	return nil
+}
+
+// RevokeOtherSessions deletes all sessions of session's user except the given one.
+// Returns count of deleted sessions.
+func (s *SessionAuthenticator) RevokeOtherSessions(ctx context.Context, current *model.UserSession) (int, error) {
+	filter := storage.UserSessionsFilterParams{UserID: current.UserID}
+	sessions, err := s.userStorage.FilterUserSessions(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("could not filter user sessions: %w", err)
+	}
+
+	others := make([]model.UserSession, 0, len(sessions))
+	for _, session := range sessions {
+		if session.UUID != current.UUID {
+			others = append(others, session)
+		}
+	}
+	if err := s.userStorage.DeleteUserSessions(ctx, others); err != nil {
+		return 0, fmt.Errorf("could not delete user sessions: %w", err)
+	}
+	sessionsRevokedTotal.Add(float64(len(others)))
+	return len(others), nil
+}
+
+// redirectToLogin sends unauthenticated client to login page.
+// Browsers get See Other redirect, htmx requests get HX-Redirect header because
+// htmx would follow plain redirect with XHR and swap login page into target.
+func (s *SessionAuthenticator) redirectToLogin(w http.ResponseWriter, r *http.Request, clearCookie bool) {
+	if clearCookie {
+		http.SetCookie(w, s.DeletionSessionCookie())
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", s.cfg.LoginRedirectURL)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, s.cfg.LoginRedirectURL, http.StatusSeeOther)
+}
+
+// sessionIDFromRequest reads and verifies session cookie.
+// Returns http.ErrNoCookie if there is no cookie.
+func (s *SessionAuthenticator) sessionIDFromRequest(r *http.Request) (uuid.UUID, error) {
+	cookie, err := r.Cookie(s.cfg.CookieName)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	value := cookie.Value
+	if s.cfg.CookieCodec != nil {
+		value, err = s.cfg.CookieCodec.Decode(s.cfg.CookieName, value)
+		if err != nil {
+			return uuid.Nil, err
+		}
+	}
+	return uuid.Parse(value)
+}
+
+func (s *SessionAuthenticator) MakeSessionCookie(value fmt.Stringer) (*http.Cookie, error) {
+	cookieValue := value.String()
+	if s.cfg.CookieCodec != nil {
+		var err error
+		cookieValue, err = s.cfg.CookieCodec.Encode(s.cfg.CookieName, cookieValue)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode session cookie: %w", err)
+		}
+	}
+
 	return &http.Cookie{
 		Name:     s.cfg.CookieName,
-		Value:    value.String(),
+		Value:    cookieValue,
 		Path:     "/",
 		HttpOnly: true,
 		MaxAge:   s.cfg.CookieMaxAge,
 		SameSite: http.SameSiteStrictMode,
 		Secure:   s.cfg.CookieSecure,
-	}
+	}, nil
 }
 
 func (s *SessionAuthenticator) DeletionSessionCookie() *http.Cookie {
 	return &http.Cookie{
-		Name:   s.cfg.CookieName,
-		Path:   "/",
-		MaxAge: -1,
+		Name:     s.cfg.CookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		// browsers ignore __Host- prefixed cookies without Secure, even for deletion
+		Secure: s.cfg.CookieSecure,
 	}
 }
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey     contextKey = "user"
+	sessionContextKey  contextKey = "session"
+	apiTokenContextKey contextKey = "api_token"
+)
 
 func UserFromContext(ctx context.Context) (*model.User, error) {
 	user, ok := ctx.Value(userContextKey).(*model.User)
@@ -205,3 +559,12 @@ func MustUserFromContext(ctx context.Context) *model.User {
 	}
 	return user
 }
+
+// SessionFromContext returns session of authenticated user, it is set by LoginRequiredMiddleware
+func SessionFromContext(ctx context.Context) (*model.UserSession, error) {
+	session, ok := ctx.Value(sessionContextKey).(*model.UserSession)
+	if !ok {
+		return nil, fmt.Errorf("no session in context")
+	}
+	return session, nil
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+)
+
+func TestSessionExpiresAt(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &SessionAuthenticator{cfg: SessionAuthenticatorConfig{
+		SessionMaxAgeInDB:  30 * 24 * time.Hour,
+		SessionIdleTimeout: 7 * 24 * time.Hour,
+	}}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "idle timeout since login",
+			now:  createdAt,
+			want: createdAt.Add(7 * 24 * time.Hour),
+		},
+		{
+			name: "slides with activity",
+			now:  createdAt.Add(10 * 24 * time.Hour),
+			want: createdAt.Add(17 * 24 * time.Hour),
+		},
+		{
+			name: "capped by max age",
+			now:  createdAt.Add(25 * 24 * time.Hour),
+			want: createdAt.Add(30 * 24 * time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.sessionExpiresAt(createdAt, tt.now))
+		})
+	}
+
+	s.cfg.SessionIdleTimeout = 0
+	assert.Equal(t, createdAt.Add(30*24*time.Hour), s.sessionExpiresAt(createdAt, createdAt))
+}
+
+func TestLoginRequiredMiddleware(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	userStorage := memory.NewUserStorage()
+	userStorage.SetNow(func() time.Time { return now })
+
+	active := &model.User{Login: "active", IsActive: true}
+	require.NoError(t, userStorage.CreateUser(ctx, active))
+	inactive := &model.User{Login: "inactive", IsActive: false}
+	require.NoError(t, userStorage.CreateUser(ctx, inactive))
+
+	newSession := func(userID int64, expiresAt time.Time) string {
+		session := &model.UserSession{UserID: userID, ExpiresAt: expiresAt, LastSeenAt: now}
+		require.NoError(t, userStorage.CreateUserSession(ctx, session))
+		return session.UUID.String()
+	}
+	validSession := newSession(active.ID, now.Add(time.Hour))
+	expiredSession := newSession(active.ID, now.Add(-time.Hour))
+	inactiveUserSession := newSession(inactive.ID, now.Add(time.Hour))
+
+	s := NewSessionAuthenticator(SessionAuthenticatorConfig{
+		LoginRedirectURL:     "/login",
+		PageRedirectURL:      "/",
+		SessionMaxAgeInDB:    24 * time.Hour,
+		SessionRenewInterval: time.Hour,
+		CookieName:           "session_id",
+	}, userStorage, func(user *model.User) error {
+		if !user.IsActive {
+			return errors.New("inactive user")
+		}
+		return nil
+	})
+	s.now = func() time.Time { return now }
+
+	handler := s.LoginRequiredMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := MustUserFromContext(r.Context())
+		_, err := SessionFromContext(r.Context())
+		require.NoError(t, err)
+		w.Write([]byte(user.Login))
+	}))
+
+	tests := []struct {
+		name   string
+		cookie string
+		htmx   bool
+
+		wantStatus      int
+		wantLocation    string
+		wantHXRedirect  string
+		wantClearCookie bool
+		wantBody        string
+	}{
+		{
+			name:         "no cookie",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/login",
+		},
+		{
+			name:           "no cookie htmx",
+			htmx:           true,
+			wantStatus:     http.StatusUnauthorized,
+			wantHXRedirect: "/login",
+		},
+		{
+			name:            "malformed cookie",
+			cookie:          "not-a-uuid",
+			wantStatus:      http.StatusSeeOther,
+			wantLocation:    "/login",
+			wantClearCookie: true,
+		},
+		{
+			name:            "malformed cookie htmx",
+			cookie:          "not-a-uuid",
+			htmx:            true,
+			wantStatus:      http.StatusUnauthorized,
+			wantHXRedirect:  "/login",
+			wantClearCookie: true,
+		},
+		{
+			name:            "unknown session",
+			cookie:          uuid.NewString(),
+			wantStatus:      http.StatusSeeOther,
+			wantLocation:    "/login",
+			wantClearCookie: true,
+		},
+		{
+			name:            "expired session",
+			cookie:          expiredSession,
+			wantStatus:      http.StatusSeeOther,
+			wantLocation:    "/login",
+			wantClearCookie: true,
+		},
+		{
+			name:            "inactive user",
+			cookie:          inactiveUserSession,
+			htmx:            true,
+			wantStatus:      http.StatusUnauthorized,
+			wantHXRedirect:  "/login",
+			wantClearCookie: true,
+		},
+		{
+			name:       "valid session",
+			cookie:     validSession,
+			wantStatus: http.StatusOK,
+			wantBody:   "active",
+		},
+		{
+			name:       "valid session htmx",
+			cookie:     validSession,
+			htmx:       true,
+			wantStatus: http.StatusOK,
+			wantBody:   "active",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/app", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session_id", Value: tt.cookie})
+			}
+			if tt.htmx {
+				req.Header.Set("HX-Request", "true")
+			}
+			rec := httptest.NewRecorder()
+
+			assert.NotPanics(t, func() { handler.ServeHTTP(rec, req) })
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+			assert.Equal(t, tt.wantHXRedirect, rec.Header().Get("HX-Redirect"))
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+			}
+
+			var cleared bool
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == "session_id" && c.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			assert.Equal(t, tt.wantClearCookie, cleared)
+		})
+	}
+}
+
+func TestRenewSession(t *testing.T) {
+	ctx := context.Background()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := createdAt
+
+	userStorage := memory.NewUserStorage()
+	userStorage.SetNow(func() time.Time { return now })
+	user := &model.User{Login: "admin", IsActive: true}
+	require.NoError(t, userStorage.CreateUser(ctx, user))
+	session := &model.UserSession{
+		UserID:     user.ID,
+		CreatedAt:  createdAt,
+		LastSeenAt: createdAt,
+		ExpiresAt:  createdAt.Add(7 * 24 * time.Hour),
+		IP:         "192.0.2.1",
+		UserAgent:  "old",
+	}
+	require.NoError(t, userStorage.CreateUserSession(ctx, session))
+
+	s := NewSessionAuthenticator(SessionAuthenticatorConfig{
+		SessionMaxAgeInDB:    30 * 24 * time.Hour,
+		SessionIdleTimeout:   7 * 24 * time.Hour,
+		SessionRenewInterval: 5 * time.Minute,
+	}, userStorage, func(*model.User) error { return nil })
+	s.now = func() time.Time { return now }
+
+	client := ClientInfo{IP: "192.0.2.2", UserAgent: "curl/8.0 \xff" + strings.Repeat("x", model.UserSessionUserAgentMaxLength)}
+	renew := func() *model.UserSession {
+		t.Helper()
+		stored, err := userStorage.FetchUserSession(ctx, session.UUID)
+		require.NoError(t, err)
+		s.renewSession(ctx, stored, client)
+		stored, err = userStorage.FetchUserSession(ctx, session.UUID)
+		require.NoError(t, err)
+		return stored
+	}
+
+	now = createdAt.Add(4 * time.Minute)
+	stored := renew()
+	assert.Equal(t, createdAt, stored.LastSeenAt, "no write before renew interval")
+	assert.Equal(t, createdAt.Add(7*24*time.Hour), stored.ExpiresAt)
+	assert.Equal(t, "192.0.2.1", stored.IP)
+	assert.Equal(t, "old", stored.UserAgent)
+
+	now = createdAt.Add(10 * time.Minute)
+	stored = renew()
+	assert.Equal(t, now, stored.LastSeenAt)
+	assert.Equal(t, now.Add(7*24*time.Hour), stored.ExpiresAt, "expiration slides with activity")
+	assert.Equal(t, "192.0.2.2", stored.IP)
+	assert.Len(t, stored.UserAgent, model.UserSessionUserAgentMaxLength, "user agent is truncated")
+	assert.True(t, strings.HasPrefix(stored.UserAgent, "curl/8.0 \uFFFD"))
+
+	now = createdAt.Add(25 * 24 * time.Hour)
+	stored = renew()
+	assert.Equal(t, now, stored.LastSeenAt)
+	assert.Equal(t, createdAt.Add(30*24*time.Hour), stored.ExpiresAt, "expiration is capped by max age")
+}
+
+func TestCreateSessionRehashesPassword(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewUserStorage()
+
+	// stored hash made by legacy bcrypt hasher
+	legacy, err := model.NewBcryptHasher(bcrypt.MinCost).Hash("password")
+	require.NoError(t, err)
+	user := &model.User{Login: "admin", HashedPassword: legacy, IsActive: true}
+	require.NoError(t, store.CreateUser(ctx, user))
+
+	s := NewSessionAuthenticator(SessionAuthenticatorConfig{
+		SessionMaxAgeInDB: time.Hour,
+	}, store, func(*model.User) error { return nil })
+
+	// wrong password does not touch hash
+	_, err = s.CreateSession(ctx, "admin", "wrong", ClientInfo{})
+	require.ErrorIs(t, err, ErrLoginPassword)
+	require.NoError(t, store.FetchUserPassword(ctx, user))
+	assert.Equal(t, legacy, user.HashedPassword)
+
+	_, err = s.CreateSession(ctx, "admin", "password", ClientInfo{})
+	require.NoError(t, err)
+	require.NoError(t, store.FetchUserPassword(ctx, user))
+	assert.NotEqual(t, legacy, user.HashedPassword)
+	assert.False(t, model.PasswordNeedsRehash([]byte(user.HashedPassword)))
+
+	// upgraded hash still works
+	_, err = s.CreateSession(ctx, "admin", "password", ClientInfo{})
+	require.NoError(t, err)
+}
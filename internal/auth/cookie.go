@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MinCookieKeyLength is minimal length of cookie key secret
+const MinCookieKeyLength = 32
+
+var ErrInvalidCookie = errors.New("auth: invalid cookie value")
+
+// CookieCodec signs cookie values with HMAC-SHA256 and optionally encrypts them with AES-GCM.
+// Values are always encoded with the first key and decoded with any of the keys,
+// so a new key can be prepended and the old one removed after cookies max age.
+type CookieCodec struct {
+	keys    []cookieKey
+	encrypt bool
+}
+
+type cookieKey struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+func NewCookieCodec(secrets []string, encrypt bool) (*CookieCodec, error) {
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("at least one cookie key is required")
+	}
+
+	c := &CookieCodec{encrypt: encrypt}
+	for i, secret := range secrets {
+		if len(secret) < MinCookieKeyLength {
+			return nil, fmt.Errorf("cookie key #%d is shorter than %d bytes", i+1, MinCookieKeyLength)
+		}
+
+		// separate keys are derived for signing and encryption, so a single secret is enough
+		key := cookieKey{hashKey: deriveCookieKey(secret, "hmac")}
+		block, err := aes.NewCipher(deriveCookieKey(secret, "aes"))
+		if err != nil {
+			return nil, fmt.Errorf("could not create cipher: %w", err)
+		}
+		if key.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("could not create cipher: %w", err)
+		}
+		c.keys = append(c.keys, key)
+	}
+	return c, nil
+}
+
+func deriveCookieKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("goth cookie " + purpose))
+	return mac.Sum(nil)
+}
+
+// Encode returns "payload.signature" where both parts are base64 url encoded.
+// Signature covers cookie name, so value can't be moved to another cookie.
+func (c *CookieCodec) Encode(name, value string) (string, error) {
+	key := c.keys[0]
+
+	payload := []byte(value)
+	if c.encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", fmt.Errorf("could not generate nonce: %w", err)
+		}
+		payload = key.aead.Seal(nonce, nonce, payload, []byte(name))
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature := base64.RawURLEncoding.EncodeToString(signCookie(key.hashKey, name, encoded))
+	return encoded + "." + signature, nil
+}
+
+func (c *CookieCodec) Decode(name, encoded string) (string, error) {
+	payloadPart, signaturePart, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return "", ErrInvalidCookie
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(signaturePart)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+
+	for _, key := range c.keys {
+		if !hmac.Equal(signature, signCookie(key.hashKey, name, payloadPart)) {
+			continue
+		}
+		if !c.encrypt {
+			return string(payload), nil
+		}
+
+		nonceSize := key.aead.NonceSize()
+		if len(payload) < nonceSize {
+			return "", ErrInvalidCookie
+		}
+		value, err := key.aead.Open(nil, payload[:nonceSize], payload[nonceSize:], []byte(name))
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+		return string(value), nil
+	}
+	return "", ErrInvalidCookie
+}
+
+func signCookie(hashKey []byte, name, payload string) []byte {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'|'})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieCodec(t *testing.T) {
+	oldKey := strings.Repeat("o", MinCookieKeyLength)
+	newKey := strings.Repeat("n", MinCookieKeyLength)
+	const value = "0b7f4a3e-5c2d-4e1f-9a8b-7c6d5e4f3a2b"
+
+	for _, encrypt := range []bool{false, true} {
+		name := "signed"
+		if encrypt {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			codec, err := NewCookieCodec([]string{oldKey}, encrypt)
+			require.NoError(t, err)
+
+			encoded, err := codec.Encode("session", value)
+			require.NoError(t, err)
+			assert.Equal(t, !encrypt, strings.Contains(encoded, "MGI3ZjRhM2"), "payload is visible only when not encrypted")
+
+			decoded, err := codec.Decode("session", encoded)
+			require.NoError(t, err)
+			assert.Equal(t, value, decoded)
+
+			// value is bound to cookie name
+			_, err = codec.Decode("other", encoded)
+			assert.ErrorIs(t, err, ErrInvalidCookie)
+
+			// tampered payload
+			tampered := "A" + encoded[1:]
+			if tampered == encoded {
+				tampered = "B" + encoded[1:]
+			}
+			_, err = codec.Decode("session", tampered)
+			assert.ErrorIs(t, err, ErrInvalidCookie)
+
+			// rotation: new key signs, old one is still accepted
+			rotated, err := NewCookieCodec([]string{newKey, oldKey}, encrypt)
+			require.NoError(t, err)
+			decoded, err = rotated.Decode("session", encoded)
+			require.NoError(t, err)
+			assert.Equal(t, value, decoded)
+
+			reencoded, err := rotated.Encode("session", value)
+			require.NoError(t, err)
+			_, err = codec.Decode("session", reencoded)
+			assert.ErrorIs(t, err, ErrInvalidCookie)
+
+			// old key removed
+			onlyNew, err := NewCookieCodec([]string{newKey}, encrypt)
+			require.NoError(t, err)
+			_, err = onlyNew.Decode("session", encoded)
+			assert.ErrorIs(t, err, ErrInvalidCookie)
+		})
+	}
+
+	for _, encoded := range []string{"", "raw-value", "a.b.c", "!!!.???"} {
+		codec, err := NewCookieCodec([]string{oldKey}, false)
+		require.NoError(t, err)
+		_, err = codec.Decode("session", encoded)
+		assert.ErrorIs(t, err, ErrInvalidCookie, encoded)
+	}
+
+	_, err := NewCookieCodec(nil, false)
+	assert.Error(t, err)
+	_, err = NewCookieCodec([]string{"short"}, false)
+	assert.Error(t, err)
+}
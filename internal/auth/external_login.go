@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+var (
+	ErrExternalLoginState = errors.New("auth: invalid or expired external login state")
+	// ErrExternalLoginDenied is returned when provider reports error, e.g. user cancelled login
+	ErrExternalLoginDenied = errors.New("auth: external login denied by provider")
+	// ErrExternalUserNotFound is returned when account is not linked and users are not created automatically
+	ErrExternalUserNotFound = errors.New("auth: no user for external account")
+)
+
+const defaultExternalLoginStateTTL = 10 * time.Minute
+
+type ExternalAuthenticatorConfig struct {
+	// CreateUsers creates user on first sign in when account can't be linked by verified email
+	CreateUsers bool
+	// StateTTL limits time spent on provider side, 10 minutes by default
+	StateTTL time.Duration
+	// TrustProviderMFA skips local second factor, provider is expected to enforce it.
+	// Users with confirmed TOTP pass the same login challenge as with password by default.
+	TrustProviderMFA bool
+
+	// CookieName keeps state between redirect to provider and callback
+	CookieName   string
+	CookieSecure bool
+	// CookieCodec signs state cookie, nil keeps raw value
+	CookieCodec *CookieCodec
+}
+
+// ExternalAuthenticator signs users in with IdentityProvider and ends with the same session as password login.
+// Users are linked by provider subject, first sign in links existing user by verified email.
+type ExternalAuthenticator struct {
+	cfg         ExternalAuthenticatorConfig
+	provider    IdentityProvider
+	sessions    *SessionAuthenticator
+	userStorage storage.UserStorage
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewExternalAuthenticator(
+	cfg ExternalAuthenticatorConfig,
+	provider IdentityProvider,
+	sessions *SessionAuthenticator,
+	userStorage storage.UserStorage,
+) *ExternalAuthenticator {
+	if cfg.StateTTL <= 0 {
+		cfg.StateTTL = defaultExternalLoginStateTTL
+	}
+	return &ExternalAuthenticator{
+		cfg:         cfg,
+		provider:    provider,
+		sessions:    sessions,
+		userStorage: userStorage,
+		now:         time.Now,
+	}
+}
+
+// externalLoginState is kept in cookie, so callback can be handled by any instance
+type externalLoginState struct {
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// BeginLogin sets state cookie and returns URL of provider login page
+func (a *ExternalAuthenticator) BeginLogin(w http.ResponseWriter, r *http.Request) (string, error) {
+	var (
+		state externalLoginState
+		err   error
+	)
+	if state.State, err = randomToken(); err != nil {
+		return "", err
+	}
+	if state.Nonce, err = randomToken(); err != nil {
+		return "", err
+	}
+	if state.CodeVerifier, err = randomToken(); err != nil {
+		return "", err
+	}
+	state.ExpiresAt = a.now().Add(a.cfg.StateTTL)
+
+	authURL, err := a.provider.AuthCodeURL(r.Context(), state.State, state.Nonce, CodeChallenge(state.CodeVerifier))
+	if err != nil {
+		return "", err
+	}
+
+	cookie, err := a.stateCookie(state)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, cookie)
+	return authURL, nil
+}
+
+// CompleteLogin handles provider callback, state cookie is deleted in any case.
+// SecondFactorRequiredError is returned when user has to confirm login with local TOTP.
+func (a *ExternalAuthenticator) CompleteLogin(w http.ResponseWriter, r *http.Request) (*model.UserSession, error) {
+	ctx := r.Context()
+	client := NewClientInfo(r)
+	http.SetCookie(w, a.deletionStateCookie())
+
+	state, err := a.stateFromRequest(r)
+	if err != nil {
+		slog.WarnContext(ctx, "invalid external login state cookie", "error", err)
+		return nil, ErrExternalLoginState
+	}
+	query := r.URL.Query()
+	if query.Get("state") != state.State {
+		slog.WarnContext(ctx, "external login state mismatch")
+		return nil, ErrExternalLoginState
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		slog.WarnContext(ctx, "external login denied", "error", providerErr, "description", query.Get("error_description"))
+		return nil, ErrExternalLoginDenied
+	}
+
+	identity, err := a.provider.Exchange(ctx, query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not exchange external login code", "provider", a.provider.Name(), "error", err)
+		return nil, ErrInternal
+	}
+
+	// login is the best human readable account name for login audit
+	login := identity.Email
+	if login == "" {
+		login = a.provider.Name() + ":" + identity.Subject
+	}
+	attempt := a.sessions.newLoginAttempt(login, client)
+
+	user, err := a.linkUser(ctx, identity)
+	if err != nil {
+		if errors.Is(err, ErrExternalUserNotFound) {
+			a.sessions.recordLoginAttempt(ctx, attempt, 0, model.LoginOutcomeForbidden)
+			return nil, err
+		}
+		slog.ErrorContext(ctx, "could not link external account", "provider", a.provider.Name(), "error", err)
+		a.sessions.recordLoginAttempt(ctx, attempt, 0, model.LoginOutcomeError)
+		return nil, ErrInternal
+	}
+	if err := a.sessions.userValidationFunc(user); err != nil {
+		slog.ErrorContext(ctx, "invalid user", "user_id", user.ID, "error", err)
+		a.sessions.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeForbidden)
+		return nil, ErrForbidden
+	}
+
+	if !slices.Equal(user.ExternalGroups, identity.Groups) {
+		user.ExternalGroups = identity.Groups
+		if err := a.userStorage.UpdateUserExternalGroups(ctx, user); err != nil {
+			slog.ErrorContext(ctx, "could not update user external groups", "user_id", user.ID, "error", err)
+			a.sessions.recordLoginAttempt(ctx, attempt, user.ID, model.LoginOutcomeError)
+			return nil, ErrInternal
+		}
+	}
+
+	if !a.cfg.TrustProviderMFA {
+		if err := a.sessions.requireSecondFactor(ctx, attempt, user); err != nil {
+			return nil, err
+		}
+	}
+	return a.sessions.completeLogin(ctx, attempt, user, client)
+}
+
+// linkUser finds user by linked account, links user with the same verified email or creates a new one
+func (a *ExternalAuthenticator) linkUser(ctx context.Context, identity *Identity) (*model.User, error) {
+	provider := a.provider.Name()
+
+	linked, err := a.userStorage.FetchUserIdentity(ctx, provider, identity.Subject)
+	if err == nil {
+		return a.userStorage.FetchUserByID(ctx, linked.UserID)
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("could not fetch user identity: %w", err)
+	}
+
+	var user *model.User
+	// unverified email could be set by anyone, it must not give access to existing user
+	if identity.Email != "" && identity.EmailVerified {
+		user, err = a.userStorage.FetchUserByEmail(ctx, identity.Email)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("could not fetch user by email: %w", err)
+		}
+	}
+	if user == nil {
+		if !a.cfg.CreateUsers {
+			slog.WarnContext(ctx, "external account is not linked", "provider", provider, "subject", identity.Subject)
+			return nil, ErrExternalUserNotFound
+		}
+		if user, err = a.createUser(ctx, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	err = a.userStorage.CreateUserIdentity(ctx, &model.UserIdentity{
+		Provider:  provider,
+		Subject:   identity.Subject,
+		UserID:    user.ID,
+		CreatedAt: a.now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not link user identity: %w", err)
+	}
+	slog.InfoContext(ctx, "external account linked", "provider", provider, "subject", identity.Subject, "user_id", user.ID)
+	return user, nil
+}
+
+// createUser creates user without password, it can sign in only with provider until admin sets password
+func (a *ExternalAuthenticator) createUser(ctx context.Context, identity *Identity) (*model.User, error) {
+	user := &model.User{
+		Login:    identity.Login,
+		IsActive: true,
+	}
+	if user.Login == "" {
+		user.Login = identity.Email
+	}
+	if user.Login == "" {
+		user.Login = a.provider.Name() + ":" + identity.Subject
+	}
+	if identity.EmailVerified {
+		user.Email = identity.Email
+	}
+	if err := a.userStorage.CreateUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("could not create user %q: %w", user.Login, err)
+	}
+	return user, nil
+}
+
+func (a *ExternalAuthenticator) stateCookie(state externalLoginState) (*http.Cookie, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode login state: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+	if a.cfg.CookieCodec != nil {
+		if value, err = a.cfg.CookieCodec.Encode(a.cfg.CookieName, value); err != nil {
+			return nil, fmt.Errorf("could not encode login state cookie: %w", err)
+		}
+	}
+
+	return &http.Cookie{
+		Name:     a.cfg.CookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   int(a.cfg.StateTTL.Seconds()),
+		// callback is a cross-site redirect from provider, strict cookie would not be sent
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.cfg.CookieSecure,
+	}, nil
+}
+
+func (a *ExternalAuthenticator) deletionStateCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     a.cfg.CookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.cfg.CookieSecure,
+	}
+}
+
+func (a *ExternalAuthenticator) stateFromRequest(r *http.Request) (*externalLoginState, error) {
+	cookie, err := r.Cookie(a.cfg.CookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	value := cookie.Value
+	if a.cfg.CookieCodec != nil {
+		if value, err = a.cfg.CookieCodec.Decode(a.cfg.CookieName, value); err != nil {
+			return nil, err
+		}
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var state externalLoginState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	if state.State == "" || a.now().After(state.ExpiresAt) {
+		return nil, fmt.Errorf("state expired")
+	}
+	return &state, nil
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/auth/oidctest"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+)
+
+type externalLoginTest struct {
+	srv           *oidctest.Server
+	userStorage   *memory.UserStorage
+	authenticator *ExternalAuthenticator
+}
+
+func newExternalLoginTest(t *testing.T, createUsers bool) *externalLoginTest {
+	t.Helper()
+
+	srv := newTestOIDCServer(t)
+	userStorage := memory.NewUserStorage()
+	sessions := NewSessionAuthenticator(SessionAuthenticatorConfig{
+		SessionMaxAgeInDB: time.Hour,
+	}, userStorage, func(user *model.User) error {
+		if !user.IsActive {
+			return ErrInvalidUser
+		}
+		return nil
+	})
+	authenticator := NewExternalAuthenticator(ExternalAuthenticatorConfig{
+		CreateUsers: createUsers,
+		CookieName:  "oidc_state",
+	}, newTestOIDCProvider(srv), sessions, userStorage)
+
+	return &externalLoginTest{srv: srv, userStorage: userStorage, authenticator: authenticator}
+}
+
+// login goes through redirect to provider and back to callback
+func (tt *externalLoginTest) login(t *testing.T) (*model.UserSession, error) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	authURL, err := tt.authenticator.BeginLogin(w, httptest.NewRequest(http.MethodGet, "/login/oidc", nil))
+	require.NoError(t, err)
+	stateCookie := w.Result().Cookies()[0]
+	assert.Equal(t, http.SameSiteLaxMode, stateCookie.SameSite)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	callbackURL := resp.Header.Get("Location")
+	require.True(t, strings.HasPrefix(callbackURL, "http://admin.test/login/oidc/callback?"), callbackURL)
+
+	r := httptest.NewRequest(http.MethodGet, callbackURL, nil)
+	r.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	session, err := tt.authenticator.CompleteLogin(w, r)
+
+	deleted := w.Result().Cookies()
+	require.Len(t, deleted, 1)
+	assert.Equal(t, -1, deleted[0].MaxAge, "state cookie is deleted")
+	return session, err
+}
+
+func TestExternalLoginLinksUserByVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	tt := newExternalLoginTest(t, false)
+
+	user := &model.User{Login: "admin", Email: "Admin@example.com", IsActive: true}
+	require.NoError(t, tt.userStorage.CreateUser(ctx, user))
+
+	tt.srv.SetUser(oidctest.User{Subject: "sub-1", Email: "admin@example.com", EmailVerified: false})
+	_, err := tt.login(t)
+	require.ErrorIs(t, err, ErrExternalUserNotFound, "unverified email must not link account")
+
+	tt.srv.SetUser(oidctest.User{
+		Subject:       "sub-1",
+		Email:         "admin@example.com",
+		EmailVerified: true,
+		Groups:        []string{"admins"},
+	})
+	session, err := tt.login(t)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, session.UserID)
+
+	stored, err := tt.userStorage.FetchUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admins"}, stored.ExternalGroups)
+
+	// linked account is found by subject even if email is changed on provider side
+	tt.srv.SetUser(oidctest.User{Subject: "sub-1", Email: "renamed@example.com", Groups: []string{"dev"}})
+	session, err = tt.login(t)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, session.UserID)
+	stored, err = tt.userStorage.FetchUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev"}, stored.ExternalGroups)
+}
+
+func TestExternalLoginCreatesUser(t *testing.T) {
+	ctx := context.Background()
+	tt := newExternalLoginTest(t, true)
+
+	tt.srv.SetUser(oidctest.User{
+		Subject:           "sub-2",
+		Email:             "new@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "new",
+	})
+	session, err := tt.login(t)
+	require.NoError(t, err)
+
+	user, err := tt.userStorage.FetchUserByID(ctx, session.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "new", user.Login)
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.True(t, user.IsActive)
+
+	// user without password can't sign in with password
+	sessions := tt.authenticator.sessions
+	_, err = sessions.CreateSession(ctx, "new", "", ClientInfo{})
+	assert.ErrorIs(t, err, ErrLoginPassword)
+}
+
+func TestExternalLoginRejectsInactiveUser(t *testing.T) {
+	ctx := context.Background()
+	tt := newExternalLoginTest(t, false)
+
+	user := &model.User{Login: "blocked", Email: "blocked@example.com", IsActive: false}
+	require.NoError(t, tt.userStorage.CreateUser(ctx, user))
+	tt.srv.SetUser(oidctest.User{Subject: "sub-3", Email: "blocked@example.com", EmailVerified: true})
+
+	_, err := tt.login(t)
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestExternalLoginState(t *testing.T) {
+	tt := newExternalLoginTest(t, true)
+	tt.srv.SetUser(oidctest.User{Subject: "sub-4"})
+
+	w := httptest.NewRecorder()
+	_, err := tt.authenticator.BeginLogin(w, httptest.NewRequest(http.MethodGet, "/login/oidc", nil))
+	require.NoError(t, err)
+	stateCookie := w.Result().Cookies()[0]
+
+	tests := []struct {
+		name   string
+		url    string
+		cookie *http.Cookie
+		want   error
+	}{
+		{name: "no cookie", url: "/login/oidc/callback?code=x&state=y", want: ErrExternalLoginState},
+		{name: "state mismatch", url: "/login/oidc/callback?code=x&state=y", cookie: stateCookie, want: ErrExternalLoginState},
+		{
+			name:   "tampered cookie",
+			url:    "/login/oidc/callback?code=x&state=y",
+			cookie: &http.Cookie{Name: stateCookie.Name, Value: "garbage"},
+			want:   ErrExternalLoginState,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.cookie != nil {
+				r.AddCookie(tc.cookie)
+			}
+			_, err := tt.authenticator.CompleteLogin(httptest.NewRecorder(), r)
+			assert.ErrorIs(t, err, tc.want)
+		})
+	}
+
+	t.Run("provider error", func(t *testing.T) {
+		state, err := tt.authenticator.stateFromRequest(func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(stateCookie)
+			return r
+		}())
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?error=access_denied&state="+state.State, nil)
+		r.AddCookie(stateCookie)
+		_, err = tt.authenticator.CompleteLogin(httptest.NewRecorder(), r)
+		assert.ErrorIs(t, err, ErrExternalLoginDenied)
+	})
+
+	t.Run("expired state", func(t *testing.T) {
+		tt.authenticator.now = func() time.Time { return time.Now().Add(time.Hour) }
+		t.Cleanup(func() { tt.authenticator.now = time.Now })
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(stateCookie)
+		_, err := tt.authenticator.stateFromRequest(r)
+		assert.Error(t, err)
+	})
+}
+
+func TestExternalLoginRequiresSecondFactor(t *testing.T) {
+	ctx := context.Background()
+	tt := newExternalLoginTest(t, false)
+
+	user := &model.User{Login: "admin", Email: "admin@example.com", IsActive: true}
+	require.NoError(t, tt.userStorage.CreateUser(ctx, user))
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+	require.NoError(t, tt.userStorage.SaveUserTOTP(ctx, &model.UserTOTP{UserID: user.ID, Secret: secret, IsConfirmed: true}))
+	tt.srv.SetUser(oidctest.User{Subject: "sub-1", Email: "admin@example.com", EmailVerified: true})
+
+	session, err := tt.login(t)
+	require.Nil(t, session)
+	var secondFactorErr *SecondFactorRequiredError
+	require.ErrorAs(t, err, &secondFactorErr)
+
+	code, err := TOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	session, err = tt.authenticator.sessions.CompleteSecondFactor(ctx, secondFactorErr.ChallengeID, code, ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, session.UserID)
+
+	tt.authenticator.cfg.TrustProviderMFA = true
+	session, err = tt.login(t)
+	require.NoError(t, err, "second factor is left to provider")
+	assert.Equal(t, user.ID, session.UserID)
+}
@@ -0,0 +1,19 @@
+package auth
+
+import "github.com/agalitsyn/goth/pkg/metrics"
+
+var (
+	loginAttemptsTotal = metrics.NewCounterVec("auth_login_attempts_total",
+		"Login attempts by outcome, the same ones are recorded to login audit.", "outcome")
+	sessionsCreatedTotal = metrics.NewCounterVec("auth_sessions_created_total",
+		"Sessions started by successful logins.")
+	sessionsRevokedTotal = metrics.NewCounterVec("auth_sessions_revoked_total",
+		"Sessions revoked by logout.")
+	sessionsExpiredTotal = metrics.NewCounterVec("auth_sessions_expired_total",
+		"Expired sessions deleted by garbage collector.")
+)
+
+// RegisterMetrics adds login and session counters to registry, counters are shared by all authenticators
+func RegisterMetrics(reg *metrics.Registry) {
+	reg.MustRegister(loginAttemptsTotal, sessionsCreatedTotal, sessionsRevokedTotal, sessionsExpiredTotal)
+}
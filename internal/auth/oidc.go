@@ -0,0 +1,442 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidIDToken = errors.New("auth: invalid id token")
+
+const (
+	defaultOIDCGroupsClaim = "groups"
+	// oidcClockSkew tolerates clock difference between provider and us
+	oidcClockSkew = time.Minute
+)
+
+type OIDCConfig struct {
+	// Name identifies provider in linked accounts
+	Name string
+	// IssuerURL is used for discovery and must match iss claim
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to openid, email and profile by default
+	Scopes []string
+	// GroupsClaim is ID token claim with list of user groups, "groups" by default
+	GroupsClaim string
+
+	HTTPClient *http.Client
+}
+
+// OIDCProvider implements OpenID Connect authorization code flow with PKCE.
+// Provider metadata is discovered on first use, so app starts even if provider is down.
+// Only RS256 signed ID tokens are supported, it is the only algorithm required by the spec.
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *oidcMetadata
+	keys     map[string]*rsa.PublicKey
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = defaultOIDCGroupsClaim
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{
+		cfg:    cfg,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := append([]string{"openid"}, slices.DeleteFunc(slices.Clone(p.cfg.Scopes), func(s string) bool {
+		return s == "openid"
+	})...)
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return metadata.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		// public client identifies itself in body
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// client_secret_basic requires form encoding of credentials, RFC 6749 section 2.3.1
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &token)
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange code: %w", err)
+	}
+	if status != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("could not exchange code: status %d: %s %s", status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, metadata, token.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return p.identity(claims)
+}
+
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type idTokenClaims struct {
+	Issuer   string          `json:"iss"`
+	Subject  string          `json:"sub"`
+	Audience json.RawMessage `json:"aud"`
+	Expiry   int64           `json:"exp"`
+	IssuedAt int64           `json:"iat"`
+	Nonce    string          `json:"nonce"`
+	// AuthorizedParty is client the token is issued to, required when token has several audiences
+	AuthorizedParty string `json:"azp"`
+
+	// payload has all claims including custom ones, e.g. groups
+	payload []byte
+}
+
+// verifyIDToken checks signature and claims as required by OpenID Connect Core section 3.1.3.7
+func (p *OIDCProvider) verifyIDToken(
+	ctx context.Context,
+	metadata *oidcMetadata,
+	raw string,
+	nonce string,
+) (*idTokenClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	var header idTokenHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrInvalidIDToken, err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidIDToken, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrInvalidIDToken, err)
+	}
+	key, err := p.signingKey(ctx, metadata, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var claims idTokenClaims
+	if claims.payload, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrInvalidIDToken, err)
+	}
+	if err := json.Unmarshal(claims.payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrInvalidIDToken, err)
+	}
+
+	now := p.now()
+	aud := audiences(claims.Audience)
+	switch {
+	case claims.Issuer != metadata.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !slices.Contains(aud, p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: token is issued for another client", ErrInvalidIDToken)
+	case !validAuthorizedParty(claims.AuthorizedParty, aud, p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: unexpected authorized party %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidIDToken)
+	case claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: token is issued in future", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: empty subject", ErrInvalidIDToken)
+	}
+	return &claims, nil
+}
+
+func (p *OIDCProvider) identity(claims *idTokenClaims) (*Identity, error) {
+	identity := &Identity{Subject: claims.Subject}
+
+	var profile struct {
+		Email             string `json:"email"`
+		EmailVerified     any    `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := json.Unmarshal(claims.payload, &profile); err != nil {
+		return nil, fmt.Errorf("%w: profile claims: %w", ErrInvalidIDToken, err)
+	}
+	var custom map[string]json.RawMessage
+	if err := json.Unmarshal(claims.payload, &custom); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrInvalidIDToken, err)
+	}
+	identity.Email = profile.Email
+	// some providers send boolean claims as strings
+	identity.EmailVerified = profile.EmailVerified == true || profile.EmailVerified == "true"
+	identity.Login = profile.PreferredUsername
+
+	if raw, ok := custom[p.cfg.GroupsClaim]; ok {
+		// groups claim is either list or a single string
+		if err := json.Unmarshal(raw, &identity.Groups); err != nil {
+			var group string
+			if err := json.Unmarshal(raw, &group); err != nil {
+				return nil, fmt.Errorf("%w: claim %q is not a list of strings", ErrInvalidIDToken, p.cfg.GroupsClaim)
+			}
+			identity.Groups = []string{group}
+		}
+	}
+	return identity, nil
+}
+
+// signingKey returns key by id, key set is fetched again on unknown id because provider could rotate keys
+func (p *OIDCProvider) signingKey(ctx context.Context, metadata *oidcMetadata, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	keys, err := p.fetchKeys(ctx, metadata.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+// lookupKey must be called under lock, empty kid is allowed when provider has the only key
+func (p *OIDCProvider) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create jwks request: %w", err)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	status, err := p.doJSON(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch jwks: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch jwks: status %d", status)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", k.Kid, err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent of key %q", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	}
+	return keys, nil
+}
+
+// discover fetches metadata without lock, so slow provider does not block logins waiting for cached metadata.
+// Concurrent first requests may fetch it several times, result is the same.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	cached := p.metadata
+	p.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	issuer := strings.TrimSuffix(p.cfg.IssuerURL, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create discovery request: %w", err)
+	}
+	var metadata oidcMetadata
+	status, err := p.doJSON(req, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover provider: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("could not discover provider: status %d", status)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("provider issuer %q does not match configured %q", metadata.Issuer, p.cfg.IssuerURL)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("provider metadata is incomplete")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata == nil {
+		p.metadata = &metadata
+	}
+	return p.metadata, nil
+}
+
+// doJSON decodes response body regardless of status, error responses of token endpoint are JSON too
+func (p *OIDCProvider) doJSON(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("could not decode response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// audiences returns aud claim which is either a single string or a list
+func audiences(aud json.RawMessage) []string {
+	var single string
+	if err := json.Unmarshal(aud, &single); err == nil {
+		return []string{single}
+	}
+	var list []string
+	if err := json.Unmarshal(aud, &list); err == nil {
+		return list
+	}
+	return nil
+}
+
+// validAuthorizedParty checks azp claim, it is required when token has several audiences
+// and must be our client when present
+func validAuthorizedParty(azp string, audiences []string, clientID string) bool {
+	if azp == "" {
+		return len(audiences) == 1
+	}
+	return azp == clientID
+}
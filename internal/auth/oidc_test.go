@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/auth/oidctest"
+)
+
+func newTestOIDCServer(t *testing.T) *oidctest.Server {
+	t.Helper()
+
+	srv, err := oidctest.NewServer("admin", "client-secret")
+	require.NoError(t, err)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestOIDCProvider(srv *oidctest.Server) *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Name:         "test",
+		IssuerURL:    srv.URL,
+		ClientID:     srv.ClientID,
+		ClientSecret: srv.ClientSecret,
+		RedirectURL:  "http://admin.test/login/oidc/callback",
+	})
+}
+
+// authorizeOIDC passes provider login page and returns code from callback URL
+func authorizeOIDC(t *testing.T, p *OIDCProvider, nonce, verifier string) string {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", nonce, CodeChallenge(verifier))
+	require.NoError(t, err)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "state", callback.Query().Get("state"))
+	return callback.Query().Get("code")
+}
+
+func TestOIDCProvider(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestOIDCServer(t)
+	srv.SetUser(oidctest.User{
+		Subject:       "sub-1",
+		Email:         "admin@example.com",
+		EmailVerified: true,
+		Groups:        []string{"admins", "dev"},
+	})
+
+	t.Run("exchanges code for identity", func(t *testing.T) {
+		p := newTestOIDCProvider(srv)
+		code := authorizeOIDC(t, p, "nonce", "verifier")
+
+		identity, err := p.Exchange(ctx, code, "verifier", "nonce")
+		require.NoError(t, err)
+		assert.Equal(t, &Identity{
+			Subject:       "sub-1",
+			Email:         "admin@example.com",
+			EmailVerified: true,
+			Groups:        []string{"admins", "dev"},
+		}, identity)
+
+		_, err = p.Exchange(ctx, code, "verifier", "nonce")
+		assert.Error(t, err, "code is one-time")
+	})
+
+	t.Run("requires pkce verifier", func(t *testing.T) {
+		p := newTestOIDCProvider(srv)
+		code := authorizeOIDC(t, p, "nonce", "verifier")
+
+		_, err := p.Exchange(ctx, code, "other-verifier", "nonce")
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("checks nonce", func(t *testing.T) {
+		p := newTestOIDCProvider(srv)
+		code := authorizeOIDC(t, p, "nonce", "verifier")
+
+		_, err := p.Exchange(ctx, code, "verifier", "other-nonce")
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+
+	t.Run("checks expiration", func(t *testing.T) {
+		p := newTestOIDCProvider(srv)
+		p.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		code := authorizeOIDC(t, p, "nonce", "verifier")
+
+		_, err := p.Exchange(ctx, code, "verifier", "nonce")
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+
+	invalidClaims := []struct {
+		name   string
+		claims map[string]any
+	}{
+		{name: "audience", claims: map[string]any{"aud": []string{"other-client"}}},
+		{name: "authorized party", claims: map[string]any{"azp": "other-client"}},
+		{name: "audience list without authorized party", claims: map[string]any{"aud": []string{"other-client", "admin"}}},
+		{name: "issuer", claims: map[string]any{"iss": "https://evil.test"}},
+		{name: "subject", claims: map[string]any{"sub": nil}},
+		{name: "groups", claims: map[string]any{"groups": 42}},
+	}
+	for _, tt := range invalidClaims {
+		t.Run("rejects invalid "+tt.name, func(t *testing.T) {
+			srv.OverrideClaims(tt.claims)
+			t.Cleanup(func() { srv.OverrideClaims(nil) })
+
+			p := newTestOIDCProvider(srv)
+			code := authorizeOIDC(t, p, "nonce", "verifier")
+			_, err := p.Exchange(ctx, code, "verifier", "nonce")
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("accepts audience list and single group", func(t *testing.T) {
+		srv.OverrideClaims(map[string]any{
+			"aud":    []string{"other-client", srv.ClientID},
+			"azp":    srv.ClientID,
+			"groups": "admins",
+		})
+		t.Cleanup(func() { srv.OverrideClaims(nil) })
+
+		p := newTestOIDCProvider(srv)
+		code := authorizeOIDC(t, p, "nonce", "verifier")
+		identity, err := p.Exchange(ctx, code, "verifier", "nonce")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"admins"}, identity.Groups)
+	})
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestOIDCServer(t)
+	p := newTestOIDCProvider(srv)
+	metadata, err := p.discover(ctx)
+	require.NoError(t, err)
+
+	claims := map[string]any{
+		"iss":   srv.URL,
+		"sub":   "sub-1",
+		"aud":   srv.ClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "nonce",
+	}
+
+	token, err := srv.Sign(map[string]any{"alg": "RS256", "kid": "test-key"}, claims)
+	require.NoError(t, err)
+	_, err = p.verifyIDToken(ctx, metadata, token, "nonce")
+	require.NoError(t, err)
+
+	_, err = p.verifyIDToken(ctx, metadata, token[:len(token)-4]+"AAAA", "nonce")
+	assert.ErrorIs(t, err, ErrInvalidIDToken, "bad signature")
+
+	token, err = srv.Sign(map[string]any{"alg": "none"}, claims)
+	require.NoError(t, err)
+	_, err = p.verifyIDToken(ctx, metadata, token, "nonce")
+	assert.ErrorIs(t, err, ErrInvalidIDToken, "unsigned token")
+
+	token, err = srv.Sign(map[string]any{"alg": "RS256", "kid": "unknown"}, claims)
+	require.NoError(t, err)
+	_, err = p.verifyIDToken(ctx, metadata, token, "nonce")
+	assert.ErrorIs(t, err, ErrInvalidIDToken, "unknown key")
+}
+
+// blockingTransport holds requests until release is closed
+type blockingTransport struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.started <- struct{}{}
+	select {
+	case <-t.release:
+		return http.DefaultTransport.RoundTrip(r)
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+}
+
+func TestOIDCDiscoverDoesNotBlock(t *testing.T) {
+	srv := newTestOIDCServer(t)
+	transport := &blockingTransport{started: make(chan struct{}, 2), release: make(chan struct{})}
+	p := NewOIDCProvider(OIDCConfig{
+		Name:       "test",
+		IssuerURL:  srv.URL,
+		ClientID:   srv.ClientID,
+		HTTPClient: &http.Client{Transport: transport},
+	})
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := p.discover(context.Background())
+		slow <- err
+	}()
+	<-transport.started
+
+	// other login gives up by its own deadline instead of waiting for slow discovery
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := p.discover(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(transport.release)
+	require.NoError(t, <-slow)
+	metadata, err := p.discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL, metadata.Issuer)
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/agalitsyn/goth/internal/mailer"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+const (
+	defaultPasswordResetTokenTTL = time.Hour
+	// passwordResetRequestTimeout limits background request, SMTP server may hang
+	passwordResetRequestTimeout = time.Minute
+)
+
+var ErrInvalidResetToken = errors.New("auth: invalid or expired password reset token")
+
+type PasswordResetConfig struct {
+	// ResetURL is absolute URL of reset page, token is passed in "token" query parameter
+	ResetURL string
+	TokenTTL time.Duration
+}
+
+// PasswordResetter implements self-service password reset by single-use tokens sent by email
+type PasswordResetter struct {
+	cfg         PasswordResetConfig
+	userStorage storage.UserStorage
+	mailer      mailer.Mailer
+	// requests are background requests of RequestResetAsync
+	requests sync.WaitGroup
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewPasswordResetter(
+	cfg PasswordResetConfig,
+	userStorage storage.UserStorage,
+	mailer mailer.Mailer,
+) *PasswordResetter {
+	if cfg.TokenTTL == 0 {
+		cfg.TokenTTL = defaultPasswordResetTokenTTL
+	}
+	return &PasswordResetter{
+		cfg:         cfg,
+		userStorage: userStorage,
+		mailer:      mailer,
+		now:         time.Now,
+	}
+}
+
+// RequestResetAsync runs RequestReset in background and logs errors. Response of caller does not depend
+// on whether email exists or mail is sent, so neither its time nor its content reveals registered emails.
+func (p *PasswordResetter) RequestResetAsync(ctx context.Context, email string) {
+	// request context is canceled when response is written, values like request id are kept for logs
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), passwordResetRequestTimeout)
+	p.requests.Add(1)
+	go func() {
+		defer p.requests.Done()
+		defer cancel()
+		if err := p.RequestReset(ctx, email); err != nil {
+			slog.ErrorContext(ctx, "could not request password reset", "error", err)
+		}
+	}()
+}
+
+// Wait blocks until background requests are done, it is called on shutdown
+func (p *PasswordResetter) Wait() {
+	p.requests.Wait()
+}
+
+// RequestReset sends reset link to active user with given email.
+// Unknown email is not an error, so caller's response does not reveal registered emails.
+func (p *PasswordResetter) RequestReset(ctx context.Context, email string) error {
+	user, err := p.userStorage.FetchUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			slog.InfoContext(ctx, "password reset requested for unknown email")
+			return nil
+		}
+		return fmt.Errorf("could not fetch user: %w", err)
+	}
+	if !user.IsActive {
+		slog.InfoContext(ctx, "password reset requested for inactive user", "user_id", user.ID)
+		return nil
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+	now := p.now()
+	err = p.userStorage.CreatePasswordResetToken(ctx, &model.PasswordResetToken{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: now.Add(p.cfg.TokenTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create password reset token: %w", err)
+	}
+
+	link, err := p.resetLink(token)
+	if err != nil {
+		return err
+	}
+	msg := mailer.Message{
+		To:      []string{user.Email},
+		Subject: "Восстановление пароля",
+		Body: fmt.Sprintf(
+			"Здравствуйте, %s!\n\n"+
+				"Для смены пароля перейдите по ссылке:\n%s\n\n"+
+				"Ссылка действует %s и может быть использована один раз.\n"+
+				"Если вы не запрашивали смену пароля, просто проигнорируйте это письмо.\n",
+			user.Login, link, p.cfg.TokenTTL,
+		),
+	}
+	if err := p.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("could not send password reset email: %w", err)
+	}
+	slog.InfoContext(ctx, "password reset email sent", "user_id", user.ID)
+	return nil
+}
+
+// CheckToken returns user of valid token without using it
+func (p *PasswordResetter) CheckToken(ctx context.Context, token string) (*model.User, error) {
+	if token == "" {
+		return nil, ErrInvalidResetToken
+	}
+	t, err := p.userStorage.FetchPasswordResetToken(ctx, hashToken(token), p.now())
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		return nil, fmt.Errorf("could not fetch password reset token: %w", err)
+	}
+	return p.fetchActiveUser(ctx, t.UserID)
+}
+
+// ResetPassword uses token and sets new password. Password policy is checked by caller.
+// Other tokens and all sessions of user are revoked, since reset usually means credentials leaked.
+func (p *PasswordResetter) ResetPassword(ctx context.Context, token, password string) (*model.User, error) {
+	if token == "" {
+		return nil, ErrInvalidResetToken
+	}
+	t, err := p.userStorage.UsePasswordResetToken(ctx, hashToken(token), p.now())
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		return nil, fmt.Errorf("could not use password reset token: %w", err)
+	}
+	user, err := p.fetchActiveUser(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := model.HashUserPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash password: %w", err)
+	}
+	user.HashedPassword = string(hash)
+	if err := p.userStorage.UpdateUserPassword(ctx, user); err != nil {
+		return nil, fmt.Errorf("could not update password: %w", err)
+	}
+
+	if err := p.userStorage.DeleteUserPasswordResetTokens(ctx, user.ID); err != nil {
+		slog.ErrorContext(ctx, "could not delete password reset tokens", "user_id", user.ID, "error", err)
+	}
+	sessions, err := p.userStorage.FilterUserSessions(ctx, storage.UserSessionsFilterParams{UserID: user.ID})
+	if err != nil {
+		slog.ErrorContext(ctx, "could not filter user sessions", "user_id", user.ID, "error", err)
+	} else if len(sessions) > 0 {
+		if err := p.userStorage.DeleteUserSessions(ctx, sessions); err != nil {
+			slog.ErrorContext(ctx, "could not delete user sessions", "user_id", user.ID, "error", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "user password reset", "user_id", user.ID)
+	return user, nil
+}
+
+func (p *PasswordResetter) fetchActiveUser(ctx context.Context, userID int64) (*model.User, error) {
+	user, err := p.userStorage.FetchUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		return nil, fmt.Errorf("could not fetch user: %w", err)
+	}
+	if !user.IsActive {
+		return nil, ErrInvalidResetToken
+	}
+	return user, nil
+}
+
+func (p *PasswordResetter) resetLink(token string) (string, error) {
+	u, err := url.Parse(p.cfg.ResetURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid reset url: %w", err)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func generateResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken makes stored tokens useless if database leaks,
+// tokens have enough entropy so fast hash is sufficient
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/mailer"
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+)
+
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var resetLinkRe = regexp.MustCompile(`https://admin\.example\.com/reset-password\?token=\S+`)
+
+// resetToken extracts token from the last sent message
+func (m *fakeMailer) resetToken(t *testing.T) string {
+	t.Helper()
+	require.NotEmpty(t, m.sent)
+	link := resetLinkRe.FindString(m.sent[len(m.sent)-1].Body)
+	require.NotEmpty(t, link)
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	return u.Query().Get("token")
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	newEnv := func(t *testing.T) (*PasswordResetter, *memory.UserStorage, *fakeMailer, *model.User) {
+		store := memory.NewUserStorage()
+		store.SetNow(func() time.Time { return now })
+		hash, err := model.HashUserPassword("old-password")
+		require.NoError(t, err)
+		user := &model.User{Login: "admin", Email: "Admin@Example.com", HashedPassword: string(hash), IsActive: true}
+		require.NoError(t, store.CreateUser(ctx, user))
+
+		m := &fakeMailer{}
+		p := NewPasswordResetter(PasswordResetConfig{
+			ResetURL: "https://admin.example.com/reset-password",
+			TokenTTL: time.Hour,
+		}, store, m)
+		p.now = func() time.Time { return now }
+		return p, store, m, user
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		p, store, m, user := newEnv(t)
+		require.NoError(t, store.CreateUserSession(ctx, &model.UserSession{UserID: user.ID, ExpiresAt: now.Add(time.Hour)}))
+
+		require.NoError(t, p.RequestReset(ctx, "admin@example.com"))
+		require.Len(t, m.sent, 1)
+		assert.Equal(t, []string{"Admin@Example.com"}, m.sent[0].To)
+		token := m.resetToken(t)
+
+		got, err := p.CheckToken(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+
+		_, err = p.ResetPassword(ctx, token, "new-password")
+		require.NoError(t, err)
+
+		require.NoError(t, store.FetchUserPassword(ctx, user))
+		require.NoError(t, model.CompareUserPassword([]byte(user.HashedPassword), "new-password"))
+
+		sessions, err := store.FilterUserSessions(ctx, storage.UserSessionsFilterParams{UserID: user.ID})
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+
+		// token is single use
+		_, err = p.CheckToken(ctx, token)
+		require.ErrorIs(t, err, ErrInvalidResetToken)
+		_, err = p.ResetPassword(ctx, token, "other-password")
+		require.ErrorIs(t, err, ErrInvalidResetToken)
+	})
+
+	t.Run("other tokens are revoked", func(t *testing.T) {
+		p, _, m, _ := newEnv(t)
+
+		require.NoError(t, p.RequestReset(ctx, "admin@example.com"))
+		first := m.resetToken(t)
+		require.NoError(t, p.RequestReset(ctx, "admin@example.com"))
+		second := m.resetToken(t)
+		assert.NotEqual(t, first, second)
+
+		_, err := p.ResetPassword(ctx, second, "new-password")
+		require.NoError(t, err)
+		_, err = p.ResetPassword(ctx, first, "other-password")
+		require.ErrorIs(t, err, ErrInvalidResetToken)
+	})
+
+	t.Run("token expires", func(t *testing.T) {
+		p, _, m, _ := newEnv(t)
+
+		require.NoError(t, p.RequestReset(ctx, "admin@example.com"))
+		token := m.resetToken(t)
+
+		p.now = func() time.Time { return now.Add(time.Hour) }
+		_, err := p.ResetPassword(ctx, token, "new-password")
+		require.ErrorIs(t, err, ErrInvalidResetToken)
+	})
+
+	t.Run("unknown email and inactive user", func(t *testing.T) {
+		p, store, m, user := newEnv(t)
+
+		require.NoError(t, p.RequestReset(ctx, "nobody@example.com"))
+		assert.Empty(t, m.sent)
+
+		user.IsActive = false
+		require.NoError(t, store.UpdateUser(ctx, user))
+		require.NoError(t, p.RequestReset(ctx, "admin@example.com"))
+		assert.Empty(t, m.sent)
+
+		_, err := p.CheckToken(ctx, "")
+		require.ErrorIs(t, err, ErrInvalidResetToken)
+		_, err = p.CheckToken(ctx, "garbage")
+		require.ErrorIs(t, err, ErrInvalidResetToken)
+	})
+
+	t.Run("async request outlives request context", func(t *testing.T) {
+		p, _, m, _ := newEnv(t)
+
+		reqCtx, cancel := context.WithCancel(ctx)
+		p.RequestResetAsync(reqCtx, "admin@example.com")
+		cancel()
+		p.Wait()
+		require.Len(t, m.sent, 1)
+	})
+}
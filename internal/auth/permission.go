@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/pkg/httptools"
+)
+
+// RequirePermission is a middleware that allows request only if user in context has permission.
+// It must be used after middleware which puts user with loaded roles into context.
+func RequirePermission(perm model.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := UserFromContext(r.Context())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "permission check without user", "permission", perm, "error", err)
+				httptools.WriteError(w, r, httptools.NewError(http.StatusUnauthorized, "Требуется вход", err))
+				return
+			}
+			if !user.HasPermission(perm) {
+				slog.WarnContext(r.Context(), "permission denied", "permission", perm, "error", ErrForbidden)
+				httptools.WriteError(w, r, httptools.NewError(http.StatusForbidden, "Недостаточно прав", ErrForbidden))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// Identity is user account asserted by external identity provider
+type Identity struct {
+	// Subject is stable account id on provider side
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Login is preferred username, it is used for new users
+	Login  string
+	Groups []string
+}
+
+// IdentityProvider authenticates users by redirect to external service,
+// e.g. with OpenID Connect authorization code flow.
+type IdentityProvider interface {
+	// Name identifies provider in linked accounts, it must not change after users signed in
+	Name() string
+	// AuthCodeURL returns URL of provider login page, state, nonce and PKCE code challenge
+	// are passed through and must be checked on callback
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	// Exchange trades authorization code for verified identity
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+}
+
+// randomToken returns base64 url encoded random bytes, 32 bytes are enough for state, nonce and PKCE verifier
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives PKCE S256 code challenge from verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+const DefaultSessionGCBatchSize = 1000
+
+type SessionGCConfig struct {
+	// Interval between sweeps, zero disables collector
+	Interval time.Duration
+	// BatchSize limits count of sessions deleted by single query to keep transactions short
+	BatchSize int
+	// LoginAttemptsRetention is age of login attempts after which they are deleted, zero keeps them forever.
+	// Storage throttle looks only at recent attempts, so retention longer than lockout does not affect it.
+	LoginAttemptsRetention time.Duration
+}
+
+// SessionGC periodically deletes expired user sessions
+type SessionGC struct {
+	cfg         SessionGCConfig
+	userStorage storage.UserStorage
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewSessionGC(cfg SessionGCConfig, userStorage storage.UserStorage) *SessionGC {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultSessionGCBatchSize
+	}
+	return &SessionGC{
+		cfg:         cfg,
+		userStorage: userStorage,
+		now:         time.Now,
+	}
+}
+
+// Run sweeps expired sessions, password reset tokens, login challenges and old login attempts
+// on interval until context is done
+func (g *SessionGC) Run(ctx context.Context) {
+	if g.cfg.Interval <= 0 {
+		slog.InfoContext(ctx, "session gc is disabled")
+		return
+	}
+
+	slog.InfoContext(ctx, "starting session gc", "interval", g.cfg.Interval, "batch_size", g.cfg.BatchSize)
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := g.Sweep(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "could not sweep expired sessions", "error", err)
+		}
+		if _, err := g.SweepPasswordResetTokens(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "could not sweep expired password reset tokens", "error", err)
+		}
+		if _, err := g.SweepLoginChallenges(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "could not sweep expired login challenges", "error", err)
+		}
+		if _, err := g.SweepLoginAttempts(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "could not sweep old login attempts", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "session gc stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes all sessions expired by now batch by batch and returns count of deleted sessions
+func (g *SessionGC) Sweep(ctx context.Context) (int, error) {
+	total, err := g.deleteInBatches(ctx, g.userStorage.DeleteExpiredUserSessions)
+	sessionsExpiredTotal.Add(float64(total))
+	if err != nil {
+		return total, fmt.Errorf("could not delete expired sessions: %w", err)
+	}
+
+	if total > 0 {
+		slog.InfoContext(ctx, "expired sessions deleted", "count", total)
+	} else {
+		slog.DebugContext(ctx, "no expired sessions")
+	}
+	return total, nil
+}
+
+// SweepPasswordResetTokens deletes all password reset tokens expired by now, used tokens are deleted
+// when they expire too
+func (g *SessionGC) SweepPasswordResetTokens(ctx context.Context) (int, error) {
+	total, err := g.deleteInBatches(ctx, g.userStorage.DeleteExpiredPasswordResetTokens)
+	if err != nil {
+		return total, fmt.Errorf("could not delete expired password reset tokens: %w", err)
+	}
+
+	if total > 0 {
+		slog.InfoContext(ctx, "expired password reset tokens deleted", "count", total)
+	}
+	return total, nil
+}
+
+// SweepLoginChallenges deletes all login challenges expired by now, e.g. user has not entered second factor
+func (g *SessionGC) SweepLoginChallenges(ctx context.Context) (int, error) {
+	total, err := g.deleteInBatches(ctx, g.userStorage.DeleteExpiredLoginChallenges)
+	if err != nil {
+		return total, fmt.Errorf("could not delete expired login challenges: %w", err)
+	}
+
+	if total > 0 {
+		slog.InfoContext(ctx, "expired login challenges deleted", "count", total)
+	}
+	return total, nil
+}
+
+// SweepLoginAttempts deletes login attempts older than retention
+func (g *SessionGC) SweepLoginAttempts(ctx context.Context) (int, error) {
+	if g.cfg.LoginAttemptsRetention <= 0 {
+		return 0, nil
+	}
+
+	total, err := g.deleteInBatches(ctx, func(ctx context.Context, now time.Time, limit int) (int, error) {
+		return g.userStorage.DeleteLoginAttempts(ctx, now.Add(-g.cfg.LoginAttemptsRetention), limit)
+	})
+	if err != nil {
+		return total, fmt.Errorf("could not delete old login attempts: %w", err)
+	}
+
+	if total > 0 {
+		slog.InfoContext(ctx, "old login attempts deleted", "count", total)
+	}
+	return total, nil
+}
+
+// deleteInBatches calls fn until it deletes less than batch size and returns total count of deleted rows
+func (g *SessionGC) deleteInBatches(
+	ctx context.Context,
+	fn func(ctx context.Context, before time.Time, limit int) (int, error),
+) (int, error) {
+	now := g.now()
+	var total int
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		deleted, err := fn(ctx, now, g.cfg.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if deleted < g.cfg.BatchSize {
+			return total, nil
+		}
+	}
+}
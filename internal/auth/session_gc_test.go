@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+)
+
+func TestSessionGCSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	userStorage := memory.NewUserStorage()
+	userStorage.SetNow(func() time.Time { return now })
+	user := &model.User{Login: "admin", IsActive: true}
+	require.NoError(t, userStorage.CreateUser(ctx, user))
+
+	for i := range 7 {
+		session := &model.UserSession{UserID: user.ID, ExpiresAt: now.Add(-time.Duration(i+1) * time.Minute)}
+		require.NoError(t, userStorage.CreateUserSession(ctx, session))
+	}
+	active := &model.UserSession{UserID: user.ID, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, userStorage.CreateUserSession(ctx, active))
+
+	gc := NewSessionGC(SessionGCConfig{Interval: time.Minute, BatchSize: 3}, userStorage)
+	gc.now = func() time.Time { return now }
+
+	// dry run of cli lists sessions which sweep deletes
+	expired, err := userStorage.FilterExpiredUserSessions(ctx, now)
+	require.NoError(t, err)
+	require.Len(t, expired, 7)
+	assert.True(t, expired[0].ExpiresAt.Before(expired[6].ExpiresAt), "the oldest first")
+
+	deleted, err := gc.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 7, deleted)
+
+	sessions, err := userStorage.FilterUserSessions(ctx, storage.UserSessionsFilterParams{UserID: user.ID})
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, active.UUID, sessions[0].UUID)
+
+	deleted, err = gc.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func TestSessionGCSweepPasswordResetTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	userStorage := memory.NewUserStorage()
+	user := &model.User{Login: "admin", IsActive: true}
+	require.NoError(t, userStorage.CreateUser(ctx, user))
+	for i, expiresAt := range []time.Time{now.Add(-time.Hour), now.Add(-time.Minute), now.Add(time.Minute)} {
+		require.NoError(t, userStorage.CreatePasswordResetToken(ctx, &model.PasswordResetToken{
+			TokenHash: strconv.Itoa(i),
+			UserID:    user.ID,
+			ExpiresAt: expiresAt,
+		}))
+	}
+
+	gc := NewSessionGC(SessionGCConfig{Interval: time.Minute, BatchSize: 1}, userStorage)
+	gc.now = func() time.Time { return now }
+
+	deleted, err := gc.SweepPasswordResetTokens(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	_, err = userStorage.FetchPasswordResetToken(ctx, "2", now)
+	assert.NoError(t, err, "valid token is kept")
+}
+
+func TestSessionGCSweepLoginChallenges(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	userStorage := memory.NewUserStorage()
+	user := &model.User{Login: "admin", IsActive: true}
+	require.NoError(t, userStorage.CreateUser(ctx, user))
+	for i, expiresAt := range []time.Time{now.Add(-time.Minute), now.Add(time.Minute)} {
+		require.NoError(t, userStorage.CreateLoginChallenge(ctx, &model.LoginChallenge{
+			IDHash:    strconv.Itoa(i),
+			UserID:    user.ID,
+			Login:     user.Login,
+			ExpiresAt: expiresAt,
+		}))
+	}
+
+	gc := NewSessionGC(SessionGCConfig{Interval: time.Minute}, userStorage)
+	gc.now = func() time.Time { return now }
+
+	deleted, err := gc.SweepLoginChallenges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	_, err = userStorage.FetchLoginChallenge(ctx, "1", now)
+	assert.NoError(t, err, "pending challenge is kept")
+}
+
+func TestSessionGCSweepLoginAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	userStorage := memory.NewUserStorage()
+	for _, age := range []time.Duration{100 * time.Hour, 50 * time.Hour, 49 * time.Hour, time.Hour} {
+		require.NoError(t, userStorage.CreateLoginAttempt(ctx, &model.LoginAttempt{
+			Login:     "admin",
+			Outcome:   model.LoginOutcomeInvalidCredentials,
+			CreatedAt: now.Add(-age),
+		}))
+	}
+
+	gc := NewSessionGC(SessionGCConfig{Interval: time.Minute}, userStorage)
+	gc.now = func() time.Time { return now }
+	deleted, err := gc.SweepLoginAttempts(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, deleted, "attempts are kept without retention")
+
+	gc = NewSessionGC(SessionGCConfig{Interval: time.Minute, BatchSize: 1, LoginAttemptsRetention: 48 * time.Hour}, userStorage)
+	gc.now = func() time.Time { return now }
+	deleted, err = gc.SweepLoginAttempts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+
+	attempts, err := userStorage.FilterLoginAttempts(ctx, storage.LoginAttemptsFilterParams{})
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	assert.Equal(t, now.Add(-time.Hour), attempts[0].CreatedAt)
+}
+
+func TestSessionGCRunStopsOnContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	gc := NewSessionGC(SessionGCConfig{Interval: time.Hour}, memory.NewUserStorage())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gc.Run(ctx)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("session gc did not stop")
+	}
+}
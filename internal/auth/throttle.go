@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/postgres/pagination"
+)
+
+// LoginThrottler locks out login after too many failed attempts
+type LoginThrottler interface {
+	// RetryAfter returns remaining lockout duration for login of attempt, zero means login is allowed.
+	// Attempt is saved as pending before the check unless storage failed, then its ID is zero.
+	RetryAfter(ctx context.Context, attempt *model.LoginAttempt) (time.Duration, error)
+}
+
+// StorageLoginThrottle derives lockout from login attempts recorded by SessionAuthenticator,
+// so it survives restarts and is shared between instances.
+//
+// Login is locked for lockout duration after the last of maxFailures failures in a row.
+// Once lockout passes every next failure locks login again until successful login.
+//
+// Pending attempts count as failures, attempt checks only attempts saved before it,
+// so concurrent attempts can't get more than maxFailures password checks in total.
+type StorageLoginThrottle struct {
+	maxFailures int
+	lockout     time.Duration
+	userStorage storage.UserStorage
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewStorageLoginThrottle(maxFailures int, lockout time.Duration, userStorage storage.UserStorage) *StorageLoginThrottle {
+	return &StorageLoginThrottle{
+		maxFailures: maxFailures,
+		lockout:     lockout,
+		userStorage: userStorage,
+		now:         time.Now,
+	}
+}
+
+func (t *StorageLoginThrottle) RetryAfter(ctx context.Context, attempt *model.LoginAttempt) (time.Duration, error) {
+	if t.maxFailures <= 0 {
+		return 0, nil
+	}
+
+	attempts, err := t.userStorage.FilterLoginAttempts(ctx, storage.LoginAttemptsFilterParams{
+		Pagination: pagination.Pagination{Limit: uint64(t.maxFailures)},
+		Login:      attempt.Login,
+		BeforeID:   attempt.ID,
+		// locked attempts don't prolong lockout
+		Outcomes: []model.LoginOutcome{
+			model.LoginOutcomePending,
+			model.LoginOutcomeSuccess,
+			model.LoginOutcomeInvalidCredentials,
+			model.LoginOutcomeInvalidCode,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not fetch login attempts: %w", err)
+	}
+	if len(attempts) < t.maxFailures {
+		return 0, nil
+	}
+	for _, a := range attempts {
+		if !a.Outcome.IsFailure() {
+			return 0, nil
+		}
+	}
+
+	lockedUntil := attempts[0].CreatedAt.Add(t.lockout)
+	if now := t.now(); now.Before(lockedUntil) {
+		return lockedUntil.Sub(now), nil
+	}
+	return 0, nil
+}
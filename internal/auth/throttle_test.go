@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+)
+
+func TestStorageLoginThrottle(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := memory.NewUserStorage()
+	store.SetNow(func() time.Time { return now })
+
+	hash, err := model.HashUserPassword("password")
+	require.NoError(t, err)
+	user := &model.User{Login: "admin", HashedPassword: string(hash), IsActive: true}
+	require.NoError(t, store.CreateUser(ctx, user))
+
+	throttle := NewStorageLoginThrottle(3, 10*time.Minute, store)
+	throttle.now = func() time.Time { return now }
+	s := NewSessionAuthenticator(SessionAuthenticatorConfig{
+		SessionMaxAgeInDB: time.Hour,
+		LoginThrottler:    throttle,
+	}, store, func(*model.User) error { return nil })
+	s.now = func() time.Time { return now }
+
+	client := ClientInfo{IP: "10.0.0.1", UserAgent: "test", RequestID: "req-1"}
+	login := func(password string) error {
+		_, err := s.CreateSession(ctx, "admin", password, client)
+		return err
+	}
+
+	require.ErrorIs(t, login("wrong"), ErrLoginPassword)
+	require.ErrorIs(t, login("wrong"), ErrLoginPassword)
+	// success breaks series of failures
+	require.NoError(t, login("password"))
+	for range 3 {
+		require.ErrorIs(t, login("wrong"), ErrLoginPassword)
+	}
+
+	// valid password is rejected while locked
+	var lockedErr *LoginLockedError
+	require.ErrorAs(t, login("password"), &lockedErr)
+	assert.Equal(t, 10*time.Minute, lockedErr.RetryAfter)
+
+	now = now.Add(10 * time.Minute)
+	require.NoError(t, login("password"))
+
+	// unknown login is throttled too
+	for range 3 {
+		_, err := s.CreateSession(ctx, "nobody", "wrong", client)
+		require.ErrorIs(t, err, ErrLoginPassword)
+	}
+	_, err = s.CreateSession(ctx, "nobody", "wrong", client)
+	require.ErrorIs(t, err, ErrTooManyAttempts)
+
+	attempts, err := store.FilterLoginAttempts(ctx, storage.LoginAttemptsFilterParams{Login: "admin"})
+	require.NoError(t, err)
+	outcomes := make([]model.LoginOutcome, 0, len(attempts))
+	for _, a := range attempts {
+		outcomes = append(outcomes, a.Outcome)
+	}
+	// newest first
+	assert.Equal(t, []model.LoginOutcome{
+		model.LoginOutcomeSuccess,
+		model.LoginOutcomeLocked,
+		model.LoginOutcomeInvalidCredentials,
+		model.LoginOutcomeInvalidCredentials,
+		model.LoginOutcomeInvalidCredentials,
+		model.LoginOutcomeSuccess,
+		model.LoginOutcomeInvalidCredentials,
+		model.LoginOutcomeInvalidCredentials,
+	}, outcomes)
+	assert.Equal(t, user.ID, attempts[0].UserID)
+	assert.Equal(t, "10.0.0.1", attempts[0].IP)
+	assert.Equal(t, "test", attempts[0].UserAgent)
+	assert.Equal(t, "req-1", attempts[0].RequestID)
+
+	unknown, err := store.FilterLoginAttempts(ctx, storage.LoginAttemptsFilterParams{Login: "nobody"})
+	require.NoError(t, err)
+	require.NotEmpty(t, unknown)
+	assert.Zero(t, unknown[0].UserID)
+}
+
+func TestStorageLoginThrottleCountsPendingAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := memory.NewUserStorage()
+	throttle := NewStorageLoginThrottle(3, 10*time.Minute, store)
+	throttle.now = func() time.Time { return now }
+
+	// concurrent requests reserve attempts before any of them has checked password
+	attempts := make([]*model.LoginAttempt, 5)
+	for i := range attempts {
+		attempts[i] = &model.LoginAttempt{Login: "admin", Outcome: model.LoginOutcomePending, CreatedAt: now}
+		require.NoError(t, store.CreateLoginAttempt(ctx, attempts[i]))
+	}
+
+	var allowed int
+	for _, attempt := range attempts {
+		retryAfter, err := throttle.RetryAfter(ctx, attempt)
+		require.NoError(t, err)
+		if retryAfter == 0 {
+			allowed++
+		}
+	}
+	assert.Equal(t, 3, allowed, "only max failures attempts check password")
+
+	// successful pending attempt breaks series
+	attempts[2].Outcome = model.LoginOutcomeSuccess
+	require.NoError(t, store.UpdateLoginAttemptOutcome(ctx, attempts[2]))
+	retryAfter, err := throttle.RetryAfter(ctx, attempts[4])
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, code, tt.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)
+	step := totpStep(now)
+
+	code := func(at time.Time) string {
+		c, err := TOTPCode(secret, at)
+		require.NoError(t, err)
+		return c
+	}
+
+	got, ok := validateTOTP(secret, code(now), now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	// clock drift of one step is tolerated
+	got, ok = validateTOTP(secret, code(now.Add(-totpPeriod)), now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, step-1, got)
+	_, ok = validateTOTP(secret, code(now.Add(totpPeriod)), now, 0)
+	assert.True(t, ok)
+
+	_, ok = validateTOTP(secret, code(now.Add(-2*totpPeriod)), now, 0)
+	assert.False(t, ok)
+
+	// used code is rejected
+	_, ok = validateTOTP(secret, code(now), now, step)
+	assert.False(t, ok)
+
+	_, ok = validateTOTP(secret, "12345", now, 0)
+	assert.False(t, ok)
+	_, ok = validateTOTP(secret, "", now, 0)
+	assert.False(t, ok)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodesCount)
+
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		assert.Len(t, c, 11)
+		assert.False(t, seen[c])
+		seen[c] = true
+	}
+
+	// hash ignores case, spaces and dashes
+	assert.Equal(t, hashRecoveryCode(codes[0]), hashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
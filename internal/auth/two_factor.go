@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+const (
+	defaultLoginChallengeTTL = 5 * time.Minute
+	// loginChallengeMaxAttempts limits code guesses per password check
+	loginChallengeMaxAttempts = 5
+)
+
+var (
+	ErrSecondFactorRequired = errors.New("auth: second factor required")
+	ErrInvalidCode          = errors.New("auth: invalid two-factor code")
+	ErrChallengeExpired     = errors.New("auth: login challenge expired")
+	ErrTOTPNotEnrolled      = errors.New("auth: totp is not enrolled")
+	ErrTOTPEnabled          = errors.New("auth: totp is already enabled")
+)
+
+// SecondFactorRequiredError is returned by CreateSession when password is valid,
+// but user has TOTP enabled. Session is created by CompleteSecondFactor with the challenge.
+type SecondFactorRequiredError struct {
+	ChallengeID string
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "auth: second factor required"
+}
+
+func (e *SecondFactorRequiredError) Unwrap() error {
+	return ErrSecondFactorRequired
+}
+
+// createLoginChallenge stores login which passed password check, so second factor can be entered
+// on any instance of app. Challenge is stored by hash like other tokens.
+func (s *SessionAuthenticator) createLoginChallenge(ctx context.Context, userID int64, login string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate challenge id: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	now := s.now()
+	err := s.userStorage.CreateLoginChallenge(ctx, &model.LoginChallenge{
+		IDHash:    hashToken(id),
+		UserID:    userID,
+		Login:     login,
+		ExpiresAt: now.Add(s.cfg.LoginChallengeTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not create login challenge: %w", err)
+	}
+	return id, nil
+}
+
+// deleteLoginChallenge drops challenge which can't be completed, errors are only logged
+func (s *SessionAuthenticator) deleteLoginChallenge(ctx context.Context, hash string) {
+	if err := s.userStorage.DeleteLoginChallenge(ctx, hash); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.ErrorContext(ctx, "could not delete login challenge", "error", err)
+	}
+}
+
+// CompleteSecondFactor checks TOTP or recovery code for login challenge and creates session
+func (s *SessionAuthenticator) CompleteSecondFactor(
+	ctx context.Context,
+	challengeID string,
+	code string,
+	client ClientInfo,
+) (*model.UserSession, error) {
+	if challengeID == "" {
+		return nil, ErrChallengeExpired
+	}
+	hash := hashToken(challengeID)
+	ch, err := s.userStorage.FetchLoginChallenge(ctx, hash, s.now())
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrChallengeExpired
+		}
+		slog.ErrorContext(ctx, "could not fetch login challenge", "error", err)
+		return nil, ErrInternal
+	}
+
+	user, err := s.userStorage.FetchUserByID(ctx, ch.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not fetch user", "user_id", ch.UserID, "error", err)
+		if errors.Is(err, storage.ErrNotFound) {
+			s.deleteLoginChallenge(ctx, hash)
+			return nil, ErrChallengeExpired
+		}
+		return nil, ErrInternal
+	}
+	if err := s.userValidationFunc(user); err != nil {
+		slog.ErrorContext(ctx, "invalid user", "user_id", user.ID, "error", err)
+		s.deleteLoginChallenge(ctx, hash)
+		s.recordLoginAttempt(ctx, s.newLoginAttempt(ch.Login, client), user.ID, model.LoginOutcomeForbidden)
+		return nil, ErrForbidden
+	}
+
+	if err := s.verifySecondFactor(ctx, user.ID, code); err != nil {
+		if !errors.Is(err, ErrInvalidCode) {
+			slog.ErrorContext(ctx, "could not verify second factor", "user_id", user.ID, "error", err)
+			return nil, ErrInternal
+		}
+
+		slog.WarnContext(ctx, "invalid two-factor code", "user_id", user.ID)
+		s.recordLoginAttempt(ctx, s.newLoginAttempt(ch.Login, client), user.ID, model.LoginOutcomeInvalidCode)
+		if err := s.userStorage.FailLoginChallenge(ctx, hash, loginChallengeMaxAttempts); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, ErrChallengeExpired
+			}
+			slog.ErrorContext(ctx, "could not update login challenge", "error", err)
+			return nil, ErrInternal
+		}
+		return nil, ErrInvalidCode
+	}
+
+	// concurrent request with valid code has completed challenge
+	if err := s.userStorage.DeleteLoginChallenge(ctx, hash); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrChallengeExpired
+		}
+		slog.ErrorContext(ctx, "could not delete login challenge", "error", err)
+		return nil, ErrInternal
+	}
+	return s.completeLogin(ctx, s.newLoginAttempt(ch.Login, client), user, client)
+}
+
+// verifySecondFactor accepts current TOTP code or unused recovery code
+func (s *SessionAuthenticator) verifySecondFactor(ctx context.Context, userID int64, code string) error {
+	totp, err := s.userStorage.FetchUserTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrTOTPNotEnrolled
+		}
+		return fmt.Errorf("could not fetch user totp: %w", err)
+	}
+
+	if step, ok := validateTOTP(totp.Secret, code, s.now(), totp.LastUsedStep); ok {
+		if err := s.userStorage.UpdateUserTOTPStep(ctx, userID, step); err != nil {
+			// concurrent request has used the same code
+			if errors.Is(err, storage.ErrNotFound) {
+				return ErrInvalidCode
+			}
+			return fmt.Errorf("could not update user totp: %w", err)
+		}
+		return nil
+	}
+
+	if err := s.userStorage.UseUserRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrInvalidCode
+		}
+		return fmt.Errorf("could not use recovery code: %w", err)
+	}
+	slog.WarnContext(ctx, "recovery code used", "target_user_id", userID)
+	return nil
+}
+
+// BeginTOTPEnrollment generates new secret for user. Enrollment is not required on login until confirmed.
+func (s *SessionAuthenticator) BeginTOTPEnrollment(ctx context.Context, user *model.User) (*model.UserTOTP, error) {
+	existing, err := s.userStorage.FetchUserTOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("could not fetch user totp: %w", err)
+	}
+	if existing != nil {
+		if existing.IsConfirmed {
+			return nil, ErrTOTPEnabled
+		}
+		// keep secret which may be already scanned by user
+		return existing, nil
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	totp := &model.UserTOTP{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: s.now(),
+	}
+	if err := s.userStorage.SaveUserTOTP(ctx, totp); err != nil {
+		return nil, fmt.Errorf("could not save user totp: %w", err)
+	}
+	return totp, nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP if code matches and returns new recovery codes.
+// Codes are shown once, only their hashes are stored.
+func (s *SessionAuthenticator) ConfirmTOTPEnrollment(
+	ctx context.Context,
+	user *model.User,
+	code string,
+) ([]string, error) {
+	totp, err := s.userStorage.FetchUserTOTP(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return nil, fmt.Errorf("could not fetch user totp: %w", err)
+	}
+	if totp.IsConfirmed {
+		return nil, ErrTOTPEnabled
+	}
+
+	step, ok := validateTOTP(totp.Secret, code, s.now(), totp.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashRecoveryCode(c)
+	}
+	if err := s.userStorage.ConfirmUserTOTP(ctx, user.ID, step, hashes); err != nil {
+		// concurrent request has confirmed enrollment
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrTOTPEnabled
+		}
+		return nil, fmt.Errorf("could not confirm user totp: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes TOTP enrollment and recovery codes after checking code
+func (s *SessionAuthenticator) DisableTOTP(ctx context.Context, user *model.User, code string) error {
+	if err := s.verifySecondFactor(ctx, user.ID, code); err != nil {
+		return err
+	}
+	if err := s.userStorage.DeleteUserTOTP(ctx, user.ID); err != nil {
+		return fmt.Errorf("could not delete user totp: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage/memory"
+)
+
+type twoFactorTestEnv struct {
+	ctx   context.Context
+	now   time.Time
+	s     *SessionAuthenticator
+	store *memory.UserStorage
+	user  *model.User
+}
+
+func newTwoFactorTestEnv(t *testing.T) *twoFactorTestEnv {
+	t.Helper()
+
+	env := &twoFactorTestEnv{
+		ctx:   context.Background(),
+		now:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		store: memory.NewUserStorage(),
+	}
+	env.store.SetNow(func() time.Time { return env.now })
+
+	hash, err := model.HashUserPassword("password")
+	require.NoError(t, err)
+	env.user = &model.User{Login: "admin", HashedPassword: string(hash), IsActive: true}
+	require.NoError(t, env.store.CreateUser(env.ctx, env.user))
+
+	env.s = NewSessionAuthenticator(SessionAuthenticatorConfig{
+		SessionMaxAgeInDB:    time.Hour,
+		LoginMaxFailures:     10,
+		LoginLockoutDuration: time.Minute,
+	}, env.store, func(*model.User) error { return nil })
+	env.s.now = func() time.Time { return env.now }
+	return env
+}
+
+// enroll enables TOTP and returns secret and recovery codes
+func (env *twoFactorTestEnv) enroll(t *testing.T) (string, []string) {
+	t.Helper()
+
+	totp, err := env.s.BeginTOTPEnrollment(env.ctx, env.user)
+	require.NoError(t, err)
+	assert.False(t, totp.IsConfirmed)
+
+	// unconfirmed enrollment does not affect login
+	session, err := env.s.CreateSession(env.ctx, "admin", "password", ClientInfo{})
+	require.NoError(t, err)
+	require.NotNil(t, session)
+
+	_, err = env.s.ConfirmTOTPEnrollment(env.ctx, env.user, "000000")
+	require.ErrorIs(t, err, ErrInvalidCode)
+
+	codes, err := env.s.ConfirmTOTPEnrollment(env.ctx, env.user, env.code(t, totp.Secret))
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodesCount)
+
+	_, err = env.s.BeginTOTPEnrollment(env.ctx, env.user)
+	require.ErrorIs(t, err, ErrTOTPEnabled)
+
+	// code used for confirmation can't be reused
+	env.now = env.now.Add(totpPeriod)
+	return totp.Secret, codes
+}
+
+func (env *twoFactorTestEnv) code(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := TOTPCode(secret, env.now)
+	require.NoError(t, err)
+	return code
+}
+
+func (env *twoFactorTestEnv) challenge(t *testing.T) string {
+	t.Helper()
+
+	session, err := env.s.CreateSession(env.ctx, "admin", "password", ClientInfo{})
+	require.Nil(t, session)
+	var secondFactorErr *SecondFactorRequiredError
+	require.True(t, errors.As(err, &secondFactorErr), err)
+	require.ErrorIs(t, err, ErrSecondFactorRequired)
+	return secondFactorErr.ChallengeID
+}
+
+func TestSecondFactorLogin(t *testing.T) {
+	t.Run("totp code", func(t *testing.T) {
+		env := newTwoFactorTestEnv(t)
+		secret, _ := env.enroll(t)
+
+		challenge := env.challenge(t)
+		_, err := env.s.CompleteSecondFactor(env.ctx, challenge, "000000", ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidCode)
+
+		code := env.code(t, secret)
+		session, err := env.s.CompleteSecondFactor(env.ctx, challenge, code, ClientInfo{IP: "127.0.0.1"})
+		require.NoError(t, err)
+		assert.Equal(t, env.user.ID, session.UserID)
+		assert.Equal(t, "127.0.0.1", session.IP)
+
+		// challenge is single use
+		_, err = env.s.CompleteSecondFactor(env.ctx, challenge, code, ClientInfo{})
+		require.ErrorIs(t, err, ErrChallengeExpired)
+
+		// the same code is rejected in another login
+		_, err = env.s.CompleteSecondFactor(env.ctx, env.challenge(t), code, ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidCode)
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		env := newTwoFactorTestEnv(t)
+		_, codes := env.enroll(t)
+
+		session, err := env.s.CompleteSecondFactor(env.ctx, env.challenge(t), codes[0], ClientInfo{})
+		require.NoError(t, err)
+		assert.NotNil(t, session)
+
+		left, err := env.store.CountUserRecoveryCodes(env.ctx, env.user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, recoveryCodesCount-1, left)
+
+		_, err = env.s.CompleteSecondFactor(env.ctx, env.challenge(t), codes[0], ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidCode)
+	})
+
+	t.Run("challenge expires", func(t *testing.T) {
+		env := newTwoFactorTestEnv(t)
+		secret, _ := env.enroll(t)
+
+		challenge := env.challenge(t)
+		env.now = env.now.Add(defaultLoginChallengeTTL + time.Second)
+		_, err := env.s.CompleteSecondFactor(env.ctx, challenge, env.code(t, secret), ClientInfo{})
+		require.ErrorIs(t, err, ErrChallengeExpired)
+	})
+
+	t.Run("attempts are limited", func(t *testing.T) {
+		env := newTwoFactorTestEnv(t)
+		secret, _ := env.enroll(t)
+
+		challenge := env.challenge(t)
+		for range loginChallengeMaxAttempts - 1 {
+			_, err := env.s.CompleteSecondFactor(env.ctx, challenge, "000000", ClientInfo{})
+			require.ErrorIs(t, err, ErrInvalidCode)
+		}
+		_, err := env.s.CompleteSecondFactor(env.ctx, challenge, "000000", ClientInfo{})
+		require.ErrorIs(t, err, ErrChallengeExpired)
+
+		_, err = env.s.CompleteSecondFactor(env.ctx, challenge, env.code(t, secret), ClientInfo{})
+		require.ErrorIs(t, err, ErrChallengeExpired)
+	})
+
+	t.Run("challenge is shared by instances", func(t *testing.T) {
+		env := newTwoFactorTestEnv(t)
+		secret, _ := env.enroll(t)
+
+		challenge := env.challenge(t)
+		// another instance of app with the same storage, e.g. after restart
+		other := NewSessionAuthenticator(SessionAuthenticatorConfig{SessionMaxAgeInDB: time.Hour}, env.store,
+			func(*model.User) error { return nil })
+		other.now = env.s.now
+		session, err := other.CompleteSecondFactor(env.ctx, challenge, env.code(t, secret), ClientInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, env.user.ID, session.UserID)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		env := newTwoFactorTestEnv(t)
+		secret, _ := env.enroll(t)
+
+		require.ErrorIs(t, env.s.DisableTOTP(env.ctx, env.user, "000000"), ErrInvalidCode)
+		require.NoError(t, env.s.DisableTOTP(env.ctx, env.user, env.code(t, secret)))
+
+		session, err := env.s.CreateSession(env.ctx, "admin", "password", ClientInfo{})
+		require.NoError(t, err)
+		assert.NotNil(t, session)
+	})
+}
@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LogMailer writes messages to log, for development only since message may contain secrets
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	slog.Info("mail", "to", strings.Join(msg.To, ", "), "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// FileMailer writes each message to separate .eml file in directory
+type FileMailer struct {
+	dir  string
+	from string
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from, now: time.Now}
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	now := m.now()
+	data, err := formatMessage(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return fmt.Errorf("could not create mail directory: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), messageID()[:8])
+	if err := os.WriteFile(filepath.Join(m.dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("could not write message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(dir, "noreply@example.com")
+
+	err := m.Send(context.Background(), Message{
+		To:      []string{"user@example.com"},
+		Subject: "Восстановление пароля",
+		Body:    "line 1\nline 2",
+	})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	content := string(data)
+	assert.Contains(t, content, "From: noreply@example.com\r\n")
+	assert.Contains(t, content, "To: user@example.com\r\n")
+	assert.Contains(t, content, "Subject: =?utf-8?q?")
+	assert.True(t, strings.HasSuffix(content, "\r\n\r\nline 1\r\nline 2\r\n"), content)
+}
+
+func TestFormatMessageRejectsHeaderInjection(t *testing.T) {
+	_, err := formatMessage("noreply@example.com", Message{To: []string{"a@example.com\r\nBcc: b@example.com"}}, time.Now())
+	require.Error(t, err)
+
+	_, err = formatMessage("noreply@example.com", Message{}, time.Now())
+	require.Error(t, err)
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go serveFakeSMTP(ln, received)
+
+	m := NewSMTPMailer(SMTPConfig{Addr: ln.Addr().String(), From: "App <noreply@example.com>"})
+	err = m.Send(context.Background(), Message{
+		To:      []string{"user@example.com"},
+		Subject: "Test",
+		Body:    "hello\n.dot line",
+	})
+	require.NoError(t, err)
+
+	select {
+	case lines := <-received:
+		assert.Contains(t, lines, "MAIL FROM:<noreply@example.com>")
+		assert.Contains(t, lines, "RCPT TO:<user@example.com>")
+		assert.Contains(t, lines, "hello")
+		// dot is stuffed on wire and unstuffed by server
+		assert.Contains(t, lines, ".dot line")
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not received")
+	}
+}
+
+// serveFakeSMTP accepts single connection and records commands and message lines
+func serveFakeSMTP(ln net.Listener, received chan<- []string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	var lines []string
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250 localhost")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			scanner := bufio.NewScanner(tp.DotReader())
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			_ = tp.PrintfLine("250 ok")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			received <- lines
+			return
+		default:
+			lines = append(lines, line)
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
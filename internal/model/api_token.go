@@ -0,0 +1,44 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// APIToken is personal access token for scripts and services, stored by hash,
+// plain token is shown only once on creation
+type APIToken struct {
+	ID     int64
+	UserID int64
+	Name   string
+	// Prefix is the beginning of plain token, helps user to recognize token in list
+	Prefix    string
+	TokenHash string `json:"-"`
+	// Scopes limit permissions of user, token never has more permissions than its user
+	Scopes     []Permission
+	ExpiresAt  time.Time
+	LastUsedAt time.Time // zero if token was never used
+	CreatedAt  time.Time
+}
+
+func (t *APIToken) IsExpired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// ScopePermissions returns user permissions which are allowed by token scopes
+func (t *APIToken) ScopePermissions(perms []Permission) []Permission {
+	if slices.Contains(t.Scopes, PermissionAll) {
+		return perms
+	}
+	if slices.Contains(perms, PermissionAll) {
+		return slices.Clone(t.Scopes)
+	}
+
+	var res []Permission
+	for _, p := range perms {
+		if slices.Contains(t.Scopes, p) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
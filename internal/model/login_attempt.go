@@ -0,0 +1,66 @@
+package model
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type LoginOutcome string
+
+const (
+	// LoginOutcomePending is attempt which is being checked, it is reserved before throttle check
+	// and gets final outcome after, so concurrent attempts see each other
+	LoginOutcomePending LoginOutcome = "pending"
+	LoginOutcomeSuccess LoginOutcome = "success"
+	// LoginOutcomeInvalidCredentials is unknown login or wrong password
+	LoginOutcomeInvalidCredentials LoginOutcome = "invalid_credentials"
+	// LoginOutcomeForbidden is valid password of user who is not allowed to login
+	LoginOutcomeForbidden LoginOutcome = "forbidden"
+	// LoginOutcomeLocked is attempt rejected by lockout without password check
+	LoginOutcomeLocked LoginOutcome = "locked"
+	// LoginOutcomeSecondFactorRequired is valid password, session is created after second factor
+	LoginOutcomeSecondFactorRequired LoginOutcome = "second_factor_required"
+	LoginOutcomeInvalidCode          LoginOutcome = "invalid_code"
+	LoginOutcomeError                LoginOutcome = "error"
+)
+
+// IsFailure reports if outcome counts toward lockout, pending attempt counts until it succeeds
+func (o LoginOutcome) IsFailure() bool {
+	return o == LoginOutcomeInvalidCredentials || o == LoginOutcomeInvalidCode || o == LoginOutcomePending
+}
+
+// Login and user agent are sent by client as is, they are truncated to bound size of stored attempt
+const (
+	LoginAttemptLoginMaxLength     = 256
+	LoginAttemptUserAgentMaxLength = 512
+)
+
+type LoginAttempt struct {
+	ID    int64
+	Login string
+	// UserID is zero when login does not exist
+	UserID    int64
+	IP        string
+	UserAgent string
+	RequestID string
+	Outcome   LoginOutcome
+	CreatedAt time.Time
+}
+
+// Truncate cuts login and user agent to max lengths in bytes, invalid UTF-8 is replaced
+// because postgres rejects it in text columns
+func (a *LoginAttempt) Truncate() {
+	a.Login = truncateUTF8(strings.ToValidUTF8(a.Login, "\uFFFD"), LoginAttemptLoginMaxLength)
+	a.UserAgent = truncateUTF8(strings.ToValidUTF8(a.UserAgent, "\uFFFD"), LoginAttemptUserAgentMaxLength)
+}
+
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
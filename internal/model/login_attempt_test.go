@@ -0,0 +1,27 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginAttemptTruncate(t *testing.T) {
+	attempt := LoginAttempt{
+		Login:     strings.Repeat("ё", LoginAttemptLoginMaxLength),
+		UserAgent: "curl/8.0 \xff" + strings.Repeat("x", LoginAttemptUserAgentMaxLength),
+	}
+	attempt.Truncate()
+
+	assert.LessOrEqual(t, len(attempt.Login), LoginAttemptLoginMaxLength)
+	assert.True(t, utf8.ValidString(attempt.Login), "rune is not cut in half")
+	assert.Equal(t, strings.Repeat("ё", LoginAttemptLoginMaxLength/2), attempt.Login)
+	assert.Len(t, attempt.UserAgent, LoginAttemptUserAgentMaxLength)
+	assert.True(t, strings.HasPrefix(attempt.UserAgent, "curl/8.0 �"))
+
+	short := LoginAttempt{Login: "admin", UserAgent: "test"}
+	short.Truncate()
+	assert.Equal(t, LoginAttempt{Login: "admin", UserAgent: "test"}, short)
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// LoginChallenge is login which passed password check and waits for second factor.
+// It is stored by hash, plain id is only sent to browser.
+type LoginChallenge struct {
+	IDHash string `json:"-"`
+	UserID int64
+	// Login is name entered by user, it is used for throttling and login audit
+	Login string
+	// Attempts counts invalid codes entered for challenge
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
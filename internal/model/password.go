@@ -0,0 +1,258 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("password mismatch")
+
+// PasswordHasher produces self-describing hashes, algorithm and parameters are encoded in hash prefix,
+// so hashes made by different hashers can be stored in the same column.
+type PasswordHasher interface {
+	// Name is algorithm name used in config
+	Name() string
+	Hash(password string) (string, error)
+	// Matches reports if hash was produced by this algorithm
+	Matches(hash string) bool
+	// Verify returns ErrPasswordMismatch if password does not match hash
+	Verify(hash, password string) error
+	// NeedsRehash reports if hash was produced with parameters other than current ones
+	NeedsRehash(hash string) bool
+}
+
+var (
+	passwordHashersMu sync.RWMutex
+	// passwordHashers verify stored hashes, the first one hashes new passwords
+	passwordHashers = []PasswordHasher{
+		NewArgon2idHasher(DefaultArgon2idParams),
+		NewBcryptHasher(bcrypt.DefaultCost),
+	}
+)
+
+// SetPasswordHasher makes hasher default for new passwords, other known algorithms are still verified
+func SetPasswordHasher(hasher PasswordHasher) {
+	passwordHashersMu.Lock()
+	defer passwordHashersMu.Unlock()
+
+	hashers := []PasswordHasher{hasher}
+	for _, h := range passwordHashers {
+		if h.Name() != hasher.Name() {
+			hashers = append(hashers, h)
+		}
+	}
+	passwordHashers = hashers
+}
+
+// PasswordHasherByName returns hasher with default parameters
+func PasswordHasherByName(name string) (PasswordHasher, error) {
+	switch name {
+	case argon2idName:
+		return NewArgon2idHasher(DefaultArgon2idParams), nil
+	case bcryptName:
+		return NewBcryptHasher(bcrypt.DefaultCost), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher: %s", name)
+	}
+}
+
+func defaultPasswordHasher() PasswordHasher {
+	passwordHashersMu.RLock()
+	defer passwordHashersMu.RUnlock()
+	return passwordHashers[0]
+}
+
+func passwordHasherFor(hash string) (PasswordHasher, error) {
+	passwordHashersMu.RLock()
+	defer passwordHashersMu.RUnlock()
+
+	for _, h := range passwordHashers {
+		if h.Matches(hash) {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown password hash format")
+}
+
+func HashUserPassword(pass string) ([]byte, error) {
+	hash, err := defaultPasswordHasher().Hash(pass)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hash), nil
+}
+
+// CompareUserPassword returns ErrPasswordMismatch if password does not match hash
+func CompareUserPassword(hashedPass []byte, pass string) error {
+	hasher, err := passwordHasherFor(string(hashedPass))
+	if err != nil {
+		return err
+	}
+	return hasher.Verify(string(hashedPass), pass)
+}
+
+// PasswordNeedsRehash reports if hash should be replaced with hash by default hasher
+func PasswordNeedsRehash(hashedPass []byte) bool {
+	hasher := defaultPasswordHasher()
+	if !hasher.Matches(string(hashedPass)) {
+		return true
+	}
+	return hasher.NeedsRehash(string(hashedPass))
+}
+
+const bcryptName = "bcrypt"
+
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Name() string {
+	return bcryptName
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) Verify(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+const argon2idName = "argon2id"
+
+type Argon2idParams struct {
+	// Memory in KiB
+	Memory     uint32
+	Iterations uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultArgon2idParams follow OWASP recommendation for argon2id
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:     19 * 1024,
+	Iterations: 2,
+	Threads:    1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+// Argon2idHasher produces hashes in PHC string format:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (h *Argon2idHasher) Name() string {
+	return argon2idName
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate salt: %w", err)
+	}
+
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Threads, p.KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) error {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Threads, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := decodeArgon2idHash(hash)
+	return err != nil || params != h.params
+}
+
+func decodeArgon2idHash(hash string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != argon2idName {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version: %d", version)
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Threads)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id params: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
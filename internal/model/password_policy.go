@@ -0,0 +1,71 @@
+package model
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasswordTooShort    = errors.New("password is too short")
+	ErrPasswordTooLong     = errors.New("password is too long")
+	ErrPasswordCommon      = errors.New("password is too common")
+	ErrPasswordEqualsLogin = errors.New("password equals login")
+)
+
+// commonPasswordsList is an offline list of common and breached passwords
+//
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+var commonPasswords = sync.OnceValue(func() map[string]struct{} {
+	passwords := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordsList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+})
+
+type PasswordPolicy struct {
+	// MinLength and MaxLength are in characters, zero MaxLength means no limit
+	MinLength int
+	MaxLength int
+	// RejectCommon rejects passwords from embedded list of common passwords
+	RejectCommon bool
+}
+
+// DefaultPasswordPolicy follows NIST SP 800-63B recommendations.
+// MaxLength keeps hashing cost bounded.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:    10,
+	MaxLength:    128,
+	RejectCommon: true,
+}
+
+// Validate returns first policy violation as one of ErrPassword* errors
+func (p PasswordPolicy) Validate(login, password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return ErrPasswordTooShort
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return ErrPasswordTooLong
+	}
+	if login != "" && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(login)) {
+		return ErrPasswordEqualsLogin
+	}
+	if p.RejectCommon {
+		if _, ok := commonPasswords()[strings.ToLower(password)]; ok {
+			return ErrPasswordCommon
+		}
+	}
+	return nil
+}
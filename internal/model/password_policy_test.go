@@ -0,0 +1,39 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 10, MaxLength: 20, RejectCommon: true}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     error
+	}{
+		{name: "valid", login: "admin", password: "violet-tractor-42"},
+		{name: "too short", login: "admin", password: "a", want: ErrPasswordTooShort},
+		{name: "length in characters", login: "admin", password: "пароль-ёжик", want: nil},
+		{name: "too long", login: "admin", password: strings.Repeat("x", 21), want: ErrPasswordTooLong},
+		{name: "equals login", login: "administrator", password: "Administrator", want: ErrPasswordEqualsLogin},
+		{name: "common", login: "admin", password: "QwertyUiop", want: ErrPasswordCommon},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.login, tt.password)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	policy.RejectCommon = false
+	assert.NoError(t, policy.Validate("admin", "qwertyuiop"))
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// PasswordResetToken is stored by hash, plain token is only sent to user
+type PasswordResetToken struct {
+	TokenHash string `json:"-"`
+	UserID    int64
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
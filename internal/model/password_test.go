@@ -0,0 +1,85 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams keep tests fast
+var testArgon2idParams = Argon2idParams{Memory: 64, Iterations: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := []PasswordHasher{
+		NewArgon2idHasher(testArgon2idParams),
+		NewBcryptHasher(bcrypt.MinCost),
+	}
+	for _, h := range hashers {
+		t.Run(h.Name(), func(t *testing.T) {
+			hash, err := h.Hash("correct horse")
+			require.NoError(t, err)
+			assert.True(t, h.Matches(hash))
+			assert.False(t, h.NeedsRehash(hash))
+
+			require.NoError(t, h.Verify(hash, "correct horse"))
+			require.ErrorIs(t, h.Verify(hash, "wrong horse"), ErrPasswordMismatch)
+
+			other, err := h.Hash("correct horse")
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, other, "hash must be salted")
+		})
+	}
+}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2idParams)
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+
+	stronger := testArgon2idParams
+	stronger.Iterations = 2
+	assert.True(t, NewArgon2idHasher(stronger).NeedsRehash(hash))
+	// verification uses parameters from hash
+	require.NoError(t, NewArgon2idHasher(stronger).Verify(hash, "correct horse"))
+
+	for _, invalid := range []string{
+		"$argon2id$",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+	} {
+		assert.Error(t, h.Verify(invalid, "correct horse"), invalid)
+		assert.True(t, h.NeedsRehash(invalid), invalid)
+	}
+}
+
+func TestPasswordRehash(t *testing.T) {
+	defer SetPasswordHasher(defaultPasswordHasher())
+
+	SetPasswordHasher(NewBcryptHasher(bcrypt.MinCost))
+	legacy, err := HashUserPassword("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(legacy), "$2a$"))
+	assert.False(t, PasswordNeedsRehash(legacy))
+
+	SetPasswordHasher(NewArgon2idHasher(testArgon2idParams))
+	// old hashes are still verified
+	require.NoError(t, CompareUserPassword(legacy, "correct horse"))
+	require.ErrorIs(t, CompareUserPassword(legacy, "wrong"), ErrPasswordMismatch)
+	assert.True(t, PasswordNeedsRehash(legacy))
+
+	hash, err := HashUserPassword("correct horse")
+	require.NoError(t, err)
+	assert.False(t, PasswordNeedsRehash(hash))
+	require.NoError(t, CompareUserPassword(hash, "correct horse"))
+
+	// bcrypt with outdated cost
+	SetPasswordHasher(NewBcryptHasher(bcrypt.MinCost + 1))
+	assert.True(t, PasswordNeedsRehash(legacy))
+
+	require.Error(t, CompareUserPassword([]byte("plain"), "plain"))
+}
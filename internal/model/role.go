@@ -0,0 +1,55 @@
+package model
+
+import "slices"
+
+type Permission string
+
+const (
+	// PermissionAll grants every permission
+	PermissionAll Permission = "*"
+
+	PermissionUsersView   Permission = "users:view"
+	PermissionUsersManage Permission = "users:manage"
+	PermissionAuditView   Permission = "audit:view"
+	// PermissionDebugView grants diagnostics of running app, e.g. profiles and config
+	PermissionDebugView Permission = "debug:view"
+)
+
+// Permissions lists all known permissions, e.g. to validate API token scopes
+var Permissions = []Permission{
+	PermissionAll,
+	PermissionUsersView,
+	PermissionUsersManage,
+	PermissionAuditView,
+	PermissionDebugView,
+}
+
+const (
+	RoleSuperuser = "superuser"
+	RoleOperator  = "operator"
+)
+
+type Role struct {
+	Name        string
+	Description string
+	Permissions []Permission
+}
+
+// HasPermission checks permissions loaded with user roles
+func (u *User) HasPermission(perm Permission) bool {
+	return slices.Contains(u.Permissions, PermissionAll) || slices.Contains(u.Permissions, perm)
+}
+
+// CanManage reports whether u has every permission of other, so changing login, password or status
+// of other does not give u permissions it lacks. Permissions of both users must be loaded.
+func (u *User) CanManage(other *User) bool {
+	for _, perm := range other.Permissions {
+		if perm == PermissionAll && !slices.Contains(u.Permissions, PermissionAll) {
+			return false
+		}
+		if !u.HasPermission(perm) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserCanManage(t *testing.T) {
+	superuser := &User{Permissions: []Permission{PermissionAll}}
+	manager := &User{Permissions: []Permission{PermissionUsersManage, PermissionUsersView}}
+	viewer := &User{Permissions: []Permission{PermissionUsersView}}
+	plain := &User{}
+
+	assert.True(t, superuser.CanManage(superuser))
+	assert.True(t, superuser.CanManage(manager))
+	assert.False(t, manager.CanManage(superuser))
+	assert.True(t, manager.CanManage(viewer))
+	assert.True(t, manager.CanManage(manager))
+	assert.False(t, viewer.CanManage(manager))
+	assert.True(t, viewer.CanManage(plain))
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// UserTOTP is user's time-based one-time password enrollment (RFC 6238)
+type UserTOTP struct {
+	UserID int64
+	// Secret is base32 encoded shared key
+	Secret string `json:"-"`
+	// IsConfirmed is set when user entered first valid code, unconfirmed enrollment is not required on login
+	IsConfirmed bool
+	// LastUsedStep is time step of last accepted code, codes of this and previous steps are rejected to prevent replay
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
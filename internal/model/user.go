@@ -1,31 +1,50 @@
 package model
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
 	ID             int64
 	Login          string
-	HashedPassword string
+	Email          string // optional, required for self-service password reset
+	HashedPassword string `json:"-"`
 	IsActive       bool
+	// ExternalGroups are claimed by identity provider on the last sign in
+	ExternalGroups []string
+
+	// Roles and Permissions are loaded on demand
+	Roles       []string
+	Permissions []Permission
 }
 
+// UserSessionUserAgentMaxLength bounds user agent which is sent by client as is
+const UserSessionUserAgentMaxLength = 512
+
 type UserSession struct {
-	UUID      uuid.UUID
-	UserID    int64
-	ExpiresAt time.Time
+	UUID       uuid.UUID
+	UserID     int64
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IP         string
+	UserAgent  string
 }
 
-func HashUserPassword(pass string) ([]byte, error) {
-	return bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+// Truncate cuts user agent to max length in bytes, invalid UTF-8 is replaced like in login attempts
+func (s *UserSession) Truncate() {
+	s.UserAgent = truncateUTF8(strings.ToValidUTF8(s.UserAgent, "\uFFFD"), UserSessionUserAgentMaxLength)
 }
 
-func CompareUserPassword(hashedPass []byte, pass string) error {
-	return bcrypt.CompareHashAndPassword(hashedPass, []byte(pass))
+// UserIdentity links user with account in external identity provider
+type UserIdentity struct {
+	Provider  string
+	Subject   string
+	UserID    int64
+	CreatedAt time.Time
 }
 
 type UserValidationFunc func(*User) error
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/agalitsyn/goth/internal/audit"
+)
+
+type AuditStorage struct {
+	mu sync.Mutex
+	// entries are ordered from oldest to newest
+	entries []audit.Entry
+}
+
+func NewAuditStorage() *AuditStorage {
+	return &AuditStorage{}
+}
+
+func (s *AuditStorage) CreateEntry(_ context.Context, entry *audit.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = int64(len(s.entries) + 1)
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+func (s *AuditStorage) FilterEntries(_ context.Context, params audit.FilterParams) ([]audit.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.filterEntries(params)
+	offset := min(int(params.Offset), len(res))
+	res = res[offset:]
+	if params.Limit > 0 && int(params.Limit) < len(res) {
+		res = res[:params.Limit]
+	}
+	return res, nil
+}
+
+func (s *AuditStorage) CountEntries(_ context.Context, params audit.FilterParams) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.filterEntries(params)), nil
+}
+
+func (s *AuditStorage) filterEntries(params audit.FilterParams) []audit.Entry {
+	var res []audit.Entry
+	for _, entry := range slices.Backward(s.entries) {
+		if params.ActorLogin != "" && entry.Actor.Login != params.ActorLogin {
+			continue
+		}
+		if params.Action != "" && entry.Action != params.Action {
+			continue
+		}
+		if params.TargetType != "" && entry.TargetType != params.TargetType {
+			continue
+		}
+		if params.TargetID != "" && entry.TargetID != params.TargetID {
+			continue
+		}
+		res = append(res, entry)
+	}
+	return res
+}
+
+var _ audit.Storage = (*AuditStorage)(nil)
@@ -0,0 +1,861 @@
+// Package memory contains in-memory storage implementations.
+// They are not persistent and intended for tests and local experiments.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+	"github.com/agalitsyn/postgres/pagination"
+)
+
+type UserStorage struct {
+	mu        sync.Mutex
+	lastID    int64
+	users     map[int64]model.User
+	roles     map[string]model.Role
+	userRoles map[int64][]string
+	sessions  map[uuid.UUID]model.UserSession
+	totp      map[int64]model.UserTOTP
+	// recoveryCodes maps user id to code hash and used flag
+	recoveryCodes map[int64]map[string]bool
+	// resetTokens maps token hash to token, used tokens are removed
+	resetTokens map[string]model.PasswordResetToken
+	// loginChallenges maps challenge id hash to challenge
+	loginChallenges map[string]model.LoginChallenge
+	// loginAttempts are ordered from oldest to newest
+	loginAttempts      []model.LoginAttempt
+	lastLoginAttemptID int64
+	identities         map[userIdentityKey]model.UserIdentity
+	apiTokens          map[int64]model.APIToken
+	lastTokenID        int64
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewUserStorage() *UserStorage {
+	return &UserStorage{
+		users:     make(map[int64]model.User),
+		roles:     make(map[string]model.Role),
+		userRoles: make(map[int64][]string),
+		sessions:  make(map[uuid.UUID]model.UserSession),
+		totp:      make(map[int64]model.UserTOTP),
+
+		recoveryCodes: make(map[int64]map[string]bool),
+		resetTokens:   make(map[string]model.PasswordResetToken),
+
+		loginChallenges: make(map[string]model.LoginChallenge),
+		identities:      make(map[userIdentityKey]model.UserIdentity),
+		apiTokens:       make(map[int64]model.APIToken),
+		now:             time.Now,
+	}
+}
+
+// SetNow replaces clock used to check session expiration
+func (s *UserStorage) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// AddRole registers role, database has it from migrations
+func (s *UserStorage) AddRole(role model.Role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[role.Name] = role
+}
+
+func (s *UserStorage) FilterUsers(_ context.Context, params storage.UserFilterParams) ([]model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.filterUsers(params)
+	// stable sorts applied from the last key, so the first key has priority
+	for _, sort := range slices.Backward(params.Sort) {
+		slices.SortStableFunc(res, func(a, b model.User) int {
+			var c int
+			switch sort.By {
+			case "login":
+				c = strings.Compare(a.Login, b.Login)
+			case "is_active":
+				c = compareBool(a.IsActive, b.IsActive)
+			default:
+				c = cmp.Compare(a.ID, b.ID)
+			}
+			if sort.Order == pagination.OrderDesc {
+				return -c
+			}
+			return c
+		})
+	}
+
+	offset := min(int(params.Offset), len(res))
+	res = res[offset:]
+	if params.Limit > 0 && int(params.Limit) < len(res) {
+		res = res[:params.Limit]
+	}
+	return res, nil
+}
+
+func (s *UserStorage) CountUsers(_ context.Context, params storage.UserFilterParams) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.filterUsers(params)), nil
+}
+
+func (s *UserStorage) filterUsers(params storage.UserFilterParams) []model.User {
+	res := make([]model.User, 0, len(s.users))
+	for _, user := range s.users {
+		if params.Login != "" && !strings.Contains(strings.ToLower(user.Login), strings.ToLower(params.Login)) {
+			continue
+		}
+		user.HashedPassword = ""
+		res = append(res, user)
+	}
+	slices.SortFunc(res, func(a, b model.User) int { return cmp.Compare(a.ID, b.ID) })
+	return res
+}
+
+func (s *UserStorage) FetchUserByLogin(_ context.Context, login string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Login == login {
+			user.HashedPassword = ""
+			return &user, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *UserStorage) FetchUserByEmail(_ context.Context, email string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email != "" && strings.EqualFold(user.Email, email) {
+			user.HashedPassword = ""
+			return &user, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *UserStorage) FetchUserByID(_ context.Context, id int64) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	user.HashedPassword = ""
+	return &user, nil
+}
+
+func (s *UserStorage) FetchUserPassword(_ context.Context, user *model.User) error {
+	if user.ID == 0 {
+		return fmt.Errorf("cannot fetch user password with empty user id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	user.HashedPassword = stored.HashedPassword
+	return nil
+}
+
+func (s *UserStorage) CreateUser(_ context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loginTaken(user.Login, 0) || s.emailTaken(user.Email, 0) {
+		return storage.ErrDuplicate
+	}
+	s.lastID++
+	user.ID = s.lastID
+	s.users[user.ID] = model.User{
+		ID:             user.ID,
+		Login:          user.Login,
+		Email:          user.Email,
+		HashedPassword: user.HashedPassword,
+		IsActive:       user.IsActive,
+	}
+	return nil
+}
+
+func (s *UserStorage) UpdateUser(_ context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if s.loginTaken(user.Login, user.ID) || s.emailTaken(user.Email, user.ID) {
+		return storage.ErrDuplicate
+	}
+	stored.Login = user.Login
+	stored.Email = user.Email
+	stored.IsActive = user.IsActive
+	s.users[user.ID] = stored
+	return nil
+}
+
+func (s *UserStorage) UpdateUserPassword(_ context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	stored.HashedPassword = user.HashedPassword
+	s.users[user.ID] = stored
+	return nil
+}
+
+func (s *UserStorage) UpdateUserExternalGroups(_ context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	stored.ExternalGroups = slices.Clone(user.ExternalGroups)
+	s.users[user.ID] = stored
+	return nil
+}
+
+type userIdentityKey struct {
+	provider string
+	subject  string
+}
+
+func (s *UserStorage) FetchUserIdentity(_ context.Context, provider, subject string) (*model.UserIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.identities[userIdentityKey{provider: provider, subject: subject}]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &identity, nil
+}
+
+func (s *UserStorage) CreateUserIdentity(_ context.Context, identity *model.UserIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[identity.UserID]; !ok {
+		return storage.ErrNotFound
+	}
+	key := userIdentityKey{provider: identity.Provider, subject: identity.Subject}
+	if _, ok := s.identities[key]; ok {
+		return storage.ErrDuplicate
+	}
+	s.identities[key] = *identity
+	return nil
+}
+
+func (s *UserStorage) loginTaken(login string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.Login == login && user.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UserStorage) emailTaken(email string, exceptID int64) bool {
+	if email == "" {
+		return false
+	}
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) && user.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UserStorage) FetchUserRoles(_ context.Context, user *model.User) error {
+	if user.ID == 0 {
+		return fmt.Errorf("cannot fetch user roles with empty user id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.Roles = nil
+	user.Permissions = nil
+	for _, name := range s.userRoles[user.ID] {
+		user.Roles = append(user.Roles, name)
+		for _, p := range s.roles[name].Permissions {
+			if !slices.Contains(user.Permissions, p) {
+				user.Permissions = append(user.Permissions, p)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *UserStorage) FilterRoles(_ context.Context) ([]model.Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]model.Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		res = append(res, role)
+	}
+	slices.SortFunc(res, func(a, b model.Role) int { return strings.Compare(a.Name, b.Name) })
+	return res, nil
+}
+
+func (s *UserStorage) GrantUserRole(_ context.Context, userID int64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return storage.ErrNotFound
+	}
+	if _, ok := s.roles[role]; !ok {
+		return storage.ErrNotFound
+	}
+	if !slices.Contains(s.userRoles[userID], role) {
+		s.userRoles[userID] = append(s.userRoles[userID], role)
+		slices.Sort(s.userRoles[userID])
+	}
+	return nil
+}
+
+func (s *UserStorage) RevokeUserRole(_ context.Context, userID int64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := slices.Index(s.userRoles[userID], role)
+	if idx < 0 {
+		return storage.ErrNotFound
+	}
+	s.userRoles[userID] = slices.Delete(s.userRoles[userID], idx, idx+1)
+	return nil
+}
+
+func (s *UserStorage) FilterUserSessions(
+	_ context.Context,
+	params storage.UserSessionsFilterParams,
+) ([]model.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var res []model.UserSession
+	for _, session := range s.sessions {
+		if params.UserID != 0 && session.UserID != params.UserID {
+			continue
+		}
+		if params.IsExpired && !session.ExpiresAt.Before(now) {
+			continue
+		}
+		if params.IsActive && session.ExpiresAt.Before(now) {
+			continue
+		}
+		res = append(res, session)
+	}
+	slices.SortFunc(res, func(a, b model.UserSession) int { return b.LastSeenAt.Compare(a.LastSeenAt) })
+	return res, nil
+}
+
+func (s *UserStorage) FetchUserSession(_ context.Context, id uuid.UUID) (*model.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &session, nil
+}
+
+func (s *UserStorage) CreateUserSession(_ context.Context, session *model.UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.UserID]; !ok {
+		return fmt.Errorf("user %d does not exist", session.UserID)
+	}
+	session.UUID = uuid.New()
+	s.sessions[session.UUID] = *session
+	return nil
+}
+
+func (s *UserStorage) UpdateUserSession(_ context.Context, session *model.UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.UUID]
+	if !ok {
+		return nil
+	}
+	stored.ExpiresAt = session.ExpiresAt
+	stored.LastSeenAt = session.LastSeenAt
+	stored.IP = session.IP
+	stored.UserAgent = session.UserAgent
+	s.sessions[session.UUID] = stored
+	return nil
+}
+
+func (s *UserStorage) DeleteUserSessions(_ context.Context, sessions []model.UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range sessions {
+		delete(s.sessions, session.UUID)
+	}
+	return nil
+}
+
+func (s *UserStorage) FilterExpiredUserSessions(_ context.Context, before time.Time) ([]model.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []model.UserSession
+	for _, session := range s.sessions {
+		if session.ExpiresAt.Before(before) {
+			res = append(res, session)
+		}
+	}
+	slices.SortFunc(res, func(a, b model.UserSession) int { return a.ExpiresAt.Compare(b.ExpiresAt) })
+	return res, nil
+}
+
+func (s *UserStorage) DeleteExpiredUserSessions(_ context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	for id, session := range s.sessions {
+		if count >= limit {
+			break
+		}
+		if session.ExpiresAt.Before(before) {
+			delete(s.sessions, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *UserStorage) FetchUserTOTP(_ context.Context, userID int64) (*model.UserTOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &totp, nil
+}
+
+func (s *UserStorage) SaveUserTOTP(_ context.Context, totp *model.UserTOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[totp.UserID]; !ok {
+		return storage.ErrNotFound
+	}
+	s.totp[totp.UserID] = *totp
+	return nil
+}
+
+func (s *UserStorage) UpdateUserTOTPStep(_ context.Context, userID int64, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok || totp.LastUsedStep >= step {
+		return storage.ErrNotFound
+	}
+	totp.LastUsedStep = step
+	s.totp[userID] = totp
+	return nil
+}
+
+func (s *UserStorage) DeleteUserTOTP(_ context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totp, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+func (s *UserStorage) ConfirmUserTOTP(_ context.Context, userID int64, step int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok || totp.IsConfirmed {
+		return storage.ErrNotFound
+	}
+	totp.IsConfirmed = true
+	totp.LastUsedStep = step
+	s.totp[userID] = totp
+
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = false
+	}
+	s.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *UserStorage) UseUserRecoveryCode(_ context.Context, userID int64, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recoveryCodes[userID][hash]
+	if !ok || used {
+		return storage.ErrNotFound
+	}
+	s.recoveryCodes[userID][hash] = true
+	return nil
+}
+
+func (s *UserStorage) CountUserRecoveryCodes(_ context.Context, userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	for _, used := range s.recoveryCodes[userID] {
+		if !used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *UserStorage) CreatePasswordResetToken(_ context.Context, token *model.PasswordResetToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[token.UserID]; !ok {
+		return storage.ErrNotFound
+	}
+	if _, ok := s.resetTokens[token.TokenHash]; ok {
+		return storage.ErrDuplicate
+	}
+	s.resetTokens[token.TokenHash] = *token
+	return nil
+}
+
+func (s *UserStorage) FetchPasswordResetToken(
+	_ context.Context,
+	hash string,
+	now time.Time,
+) (*model.PasswordResetToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.resetTokens[hash]
+	if !ok || !token.ExpiresAt.After(now) {
+		return nil, storage.ErrNotFound
+	}
+	return &token, nil
+}
+
+func (s *UserStorage) UsePasswordResetToken(
+	_ context.Context,
+	hash string,
+	now time.Time,
+) (*model.PasswordResetToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.resetTokens[hash]
+	if !ok || !token.ExpiresAt.After(now) {
+		return nil, storage.ErrNotFound
+	}
+	delete(s.resetTokens, hash)
+	return &token, nil
+}
+
+func (s *UserStorage) DeleteUserPasswordResetTokens(_ context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.resetTokens {
+		if token.UserID == userID {
+			delete(s.resetTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (s *UserStorage) DeleteExpiredPasswordResetTokens(_ context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	for hash, token := range s.resetTokens {
+		if count >= limit {
+			break
+		}
+		if token.ExpiresAt.Before(before) {
+			delete(s.resetTokens, hash)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *UserStorage) CreateLoginChallenge(_ context.Context, challenge *model.LoginChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[challenge.UserID]; !ok {
+		return storage.ErrNotFound
+	}
+	if _, ok := s.loginChallenges[challenge.IDHash]; ok {
+		return storage.ErrDuplicate
+	}
+	s.loginChallenges[challenge.IDHash] = *challenge
+	return nil
+}
+
+func (s *UserStorage) FetchLoginChallenge(_ context.Context, hash string, now time.Time) (*model.LoginChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.loginChallenges[hash]
+	if !ok || !challenge.ExpiresAt.After(now) {
+		return nil, storage.ErrNotFound
+	}
+	return &challenge, nil
+}
+
+func (s *UserStorage) FailLoginChallenge(_ context.Context, hash string, maxAttempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.loginChallenges[hash]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	challenge.Attempts++
+	if challenge.Attempts >= maxAttempts {
+		delete(s.loginChallenges, hash)
+		return storage.ErrNotFound
+	}
+	s.loginChallenges[hash] = challenge
+	return nil
+}
+
+func (s *UserStorage) DeleteLoginChallenge(_ context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.loginChallenges[hash]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.loginChallenges, hash)
+	return nil
+}
+
+func (s *UserStorage) DeleteExpiredLoginChallenges(_ context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	for hash, challenge := range s.loginChallenges {
+		if count >= limit {
+			break
+		}
+		if challenge.ExpiresAt.Before(before) {
+			delete(s.loginChallenges, hash)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *UserStorage) CreateAPIToken(_ context.Context, token *model.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[token.UserID]; !ok {
+		return storage.ErrNotFound
+	}
+	for _, t := range s.apiTokens {
+		if t.TokenHash == token.TokenHash {
+			return storage.ErrDuplicate
+		}
+	}
+	s.lastTokenID++
+	token.ID = s.lastTokenID
+	stored := *token
+	stored.Scopes = slices.Clone(token.Scopes)
+	s.apiTokens[token.ID] = stored
+	return nil
+}
+
+func (s *UserStorage) FetchAPITokenByHash(_ context.Context, hash string) (*model.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.apiTokens {
+		if token.TokenHash == hash {
+			token.Scopes = slices.Clone(token.Scopes)
+			return &token, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *UserStorage) FilterUserAPITokens(_ context.Context, userID int64) ([]model.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []model.APIToken
+	for _, token := range s.apiTokens {
+		if token.UserID == userID {
+			token.Scopes = slices.Clone(token.Scopes)
+			res = append(res, token)
+		}
+	}
+	slices.SortFunc(res, func(a, b model.APIToken) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return res, nil
+}
+
+func (s *UserStorage) UpdateAPITokenLastUsed(_ context.Context, id int64, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.apiTokens[id]
+	if !ok {
+		return nil
+	}
+	token.LastUsedAt = usedAt
+	s.apiTokens[id] = token
+	return nil
+}
+
+func (s *UserStorage) DeleteUserAPIToken(_ context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.apiTokens[id]
+	if !ok || token.UserID != userID {
+		return storage.ErrNotFound
+	}
+	delete(s.apiTokens, id)
+	return nil
+}
+
+func (s *UserStorage) CreateLoginAttempt(_ context.Context, attempt *model.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastLoginAttemptID++
+	attempt.ID = s.lastLoginAttemptID
+	s.loginAttempts = append(s.loginAttempts, *attempt)
+	return nil
+}
+
+func (s *UserStorage) UpdateLoginAttemptOutcome(_ context.Context, attempt *model.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.loginAttempts, func(a model.LoginAttempt) bool { return a.ID == attempt.ID })
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	s.loginAttempts[i].UserID = attempt.UserID
+	s.loginAttempts[i].Outcome = attempt.Outcome
+	return nil
+}
+
+func (s *UserStorage) DeleteLoginAttempts(_ context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	s.loginAttempts = slices.DeleteFunc(s.loginAttempts, func(a model.LoginAttempt) bool {
+		if count >= limit || !a.CreatedAt.Before(before) {
+			return false
+		}
+		count++
+		return true
+	})
+	return count, nil
+}
+
+func (s *UserStorage) FilterLoginAttempts(
+	_ context.Context,
+	params storage.LoginAttemptsFilterParams,
+) ([]model.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.filterLoginAttempts(params)
+	offset := min(int(params.Offset), len(res))
+	res = res[offset:]
+	if params.Limit > 0 && int(params.Limit) < len(res) {
+		res = res[:params.Limit]
+	}
+	return res, nil
+}
+
+func (s *UserStorage) CountLoginAttempts(_ context.Context, params storage.LoginAttemptsFilterParams) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.filterLoginAttempts(params)), nil
+}
+
+func (s *UserStorage) filterLoginAttempts(params storage.LoginAttemptsFilterParams) []model.LoginAttempt {
+	var res []model.LoginAttempt
+	for _, attempt := range slices.Backward(s.loginAttempts) {
+		if params.Login != "" && attempt.Login != params.Login {
+			continue
+		}
+		if len(params.Outcomes) > 0 && !slices.Contains(params.Outcomes, attempt.Outcome) {
+			continue
+		}
+		if params.BeforeID > 0 && attempt.ID >= params.BeforeID {
+			continue
+		}
+		res = append(res, attempt)
+	}
+	return res
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+var _ storage.UserStorage = (*UserStorage)(nil)
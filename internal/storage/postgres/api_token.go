@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+func (s *UserStorage) CreateAPIToken(ctx context.Context, token *model.APIToken) error {
+	scopes := make([]string, len(token.Scopes))
+	for i, scope := range token.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	// language=PostgreSQL
+	q := `INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := s.db.QueryRow(
+		ctx,
+		q,
+		token.UserID,
+		token.Name,
+		token.Prefix,
+		token.TokenHash,
+		scopes,
+		token.ExpiresAt,
+		token.CreatedAt,
+	).Scan(&token.ID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return storage.ErrNotFound
+		}
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+const apiTokenColumns = `id, user_id, name, prefix, token_hash, scopes, expires_at, last_used_at, created_at`
+
+func (s *UserStorage) FetchAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error) {
+	// language=PostgreSQL
+	q := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE token_hash = $1`
+	token, err := scanAPIToken(s.db.QueryRow(ctx, q, hash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	return token, nil
+}
+
+func (s *UserStorage) FilterUserAPITokens(ctx context.Context, userID int64) ([]model.APIToken, error) {
+	// language=PostgreSQL
+	q := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := s.db.Query(ctx, q, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	defer rows.Close()
+
+	var res []model.APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		res = append(res, *token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate rows: %w", err)
+	}
+	return res, nil
+}
+
+func scanAPIToken(row pgx.Row) (*model.APIToken, error) {
+	var (
+		token      model.APIToken
+		scopes     []string
+		lastUsedAt *time.Time
+	)
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.Prefix,
+		&token.TokenHash,
+		&scopes,
+		&token.ExpiresAt,
+		&lastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		token.Scopes = append(token.Scopes, model.Permission(scope))
+	}
+	if lastUsedAt != nil {
+		token.LastUsedAt = *lastUsedAt
+	}
+	return &token, nil
+}
+
+func (s *UserStorage) UpdateAPITokenLastUsed(ctx context.Context, id int64, usedAt time.Time) error {
+	// language=PostgreSQL
+	q := `UPDATE api_tokens SET last_used_at = $2 WHERE id = $1`
+	if _, err := s.db.Exec(ctx, q, id, usedAt); err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) DeleteUserAPIToken(ctx context.Context, userID, id int64) error {
+	// language=PostgreSQL
+	q := `DELETE FROM api_tokens WHERE user_id = $1 AND id = $2`
+	tag, err := s.db.Exec(ctx, q, userID, id)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/agalitsyn/goth/internal/audit"
+	"github.com/agalitsyn/postgres"
+)
+
+type AuditStorage struct {
+	db *postgres.DB
+}
+
+func NewAuditStorage(db *postgres.DB) *AuditStorage {
+	return &AuditStorage{db: db}
+}
+
+func (s *AuditStorage) CreateEntry(ctx context.Context, entry *audit.Entry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return fmt.Errorf("could not encode changes: %w", err)
+	}
+
+	// language=PostgreSQL
+	q := `INSERT INTO audit_log (actor_user_id, actor_login, source, ip, request_id, action, target_type, target_id, changes, created_at)
+VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id`
+	err = s.db.QueryRow(
+		ctx,
+		q,
+		entry.Actor.UserID,
+		entry.Actor.Login,
+		entry.Actor.Source,
+		entry.Actor.IP,
+		entry.Actor.RequestID,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		changes,
+		entry.CreatedAt,
+	).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *AuditStorage) FilterEntries(ctx context.Context, params audit.FilterParams) ([]audit.Entry, error) {
+	q := applyAuditFilter(sq.Select(
+		"id",
+		"COALESCE(actor_user_id, 0)",
+		"actor_login",
+		"source",
+		"ip",
+		"request_id",
+		"action",
+		"target_type",
+		"target_id",
+		"changes",
+		"created_at",
+	).From("audit_log"), params).
+		OrderBy("created_at DESC", "id DESC").
+		Offset(params.Offset)
+	if params.Limit > 0 {
+		q = q.Limit(params.Limit)
+	}
+
+	query, args := q.PlaceholderFormat(sq.Dollar).MustSql()
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	defer rows.Close()
+
+	var res []audit.Entry
+	for rows.Next() {
+		var (
+			entry   audit.Entry
+			changes []byte
+		)
+		err = rows.Scan(
+			&entry.ID,
+			&entry.Actor.UserID,
+			&entry.Actor.Login,
+			&entry.Actor.Source,
+			&entry.Actor.IP,
+			&entry.Actor.RequestID,
+			&entry.Action,
+			&entry.TargetType,
+			&entry.TargetID,
+			&changes,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, fmt.Errorf("could not decode changes of entry %d: %w", entry.ID, err)
+		}
+		res = append(res, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate rows: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *AuditStorage) CountEntries(ctx context.Context, params audit.FilterParams) (int, error) {
+	q := applyAuditFilter(sq.Select("COUNT(*)").From("audit_log"), params)
+
+	query, args := q.PlaceholderFormat(sq.Dollar).MustSql()
+	var count int
+	if err := s.db.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return count, nil
+}
+
+func applyAuditFilter(q sq.SelectBuilder, params audit.FilterParams) sq.SelectBuilder {
+	if params.ActorLogin != "" {
+		q = q.Where("actor_login = ?", params.ActorLogin)
+	}
+	if params.Action != "" {
+		q = q.Where("action = ?", params.Action)
+	}
+	if params.TargetType != "" {
+		q = q.Where("target_type = ?", params.TargetType)
+	}
+	if params.TargetID != "" {
+		q = q.Where("target_id = ?", params.TargetID)
+	}
+	return q
+}
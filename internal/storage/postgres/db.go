@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/tracelog"
+	pglog "github.com/mcosta74/pgx-slog"
+
+	"github.com/agalitsyn/goth/pkg/tracing"
+	"github.com/agalitsyn/postgres"
+)
+
+// NewDB creates connection pool like postgres.New does, queries are logged the same way and traced with spans too.
+// postgres.Config has no hook for tracer of connections, so pool is configured here.
+func NewDB(ctx context.Context, cfg postgres.Config) (*postgres.DB, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	logLevel := tracelog.LogLevelError
+	if cfg.TracerLogLevel != "" {
+		lvl, err := tracelog.LogLevelFromString(cfg.TracerLogLevel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tracer log level: %w", err)
+		}
+		logLevel = lvl
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(cfg.URI.Unmask())
+	if err != nil {
+		return nil, fmt.Errorf("could not parse connection string: %w", err)
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	poolConfig.ConnConfig.Tracer = &QueryTracer{Next: &tracelog.TraceLog{
+		Logger:   pglog.NewLogger(slog.Default()),
+		LogLevel: logLevel,
+	}}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create connection pool: %w", err)
+	}
+	return &postgres.DB{Pool: pool}, nil
+}
+
+// QueryTracer starts client span for every query, then calls Next tracer if there is one.
+// Arguments of queries are not recorded, they may contain secrets like password hashes.
+type QueryTracer struct {
+	Next pgx.QueryTracer
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracing.Start(ctx, queryOperation(data.SQL), tracing.WithKind(tracing.SpanKindClient),
+		tracing.WithAttributes(
+			tracing.Attr("db.system", "postgresql"),
+			tracing.Attr("db.query.text", data.SQL),
+		),
+	)
+	if t.Next != nil {
+		ctx = t.Next.TraceQueryStart(ctx, conn, data)
+	}
+	return ctx
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if t.Next != nil {
+		t.Next.TraceQueryEnd(ctx, conn, data)
+	}
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttributes(tracing.Attr("db.response.rows_affected", data.CommandTag.RowsAffected()))
+	span.RecordError(data.Err)
+	span.End()
+}
+
+// queryOperation is the first keyword of query, e.g. SELECT, it names span without values of query
+func queryOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	foreignKeyViolationCode = "23503"
+	uniqueViolationCode     = "23505"
+)
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolationCode
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes LIKE pattern wildcards in user input
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,36 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/jackc/tern/v2/migrate"
+
+	"github.com/agalitsyn/postgres"
+)
+
+// migrationsTable is version table of postgres.MigrateUp
+const migrationsTable = "schema_version"
+
+// PendingMigrationsCheck fails while schema is older than migrations of app, e.g. app is deployed before migrate.
+// Migrations are numbered sequentially, so version of schema is count of applied ones.
+// Version table is not created by check, missing one means migrations were never applied.
+func PendingMigrationsCheck(db *postgres.DB, migrationsFiles fs.FS) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		paths, err := migrate.FindMigrations(migrationsFiles)
+		if err != nil {
+			return fmt.Errorf("could not find migrations: %w", err)
+		}
+
+		var version int32
+		err = db.QueryRow(ctx, "SELECT version FROM "+migrationsTable+" LIMIT 1").Scan(&version)
+		if err != nil {
+			return fmt.Errorf("could not get schema version: %w", err)
+		}
+		if pending := len(paths) - int(version); pending > 0 {
+			return fmt.Errorf("%d pending migrations, schema version is %d", pending, version)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+func (s *UserStorage) CreateLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) error {
+	// language=PostgreSQL
+	q := `INSERT INTO login_attempts (login, user_id, ip, user_agent, request_id, outcome, created_at)
+VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7)
+RETURNING id`
+	err := s.db.QueryRow(
+		ctx,
+		q,
+		attempt.Login,
+		attempt.UserID,
+		attempt.IP,
+		attempt.UserAgent,
+		attempt.RequestID,
+		attempt.Outcome,
+		attempt.CreatedAt,
+	).Scan(&attempt.ID)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) FilterLoginAttempts(
+	ctx context.Context,
+	params storage.LoginAttemptsFilterParams,
+) ([]model.LoginAttempt, error) {
+	q := applyLoginAttemptsFilter(sq.Select(
+		"id",
+		"login",
+		"COALESCE(user_id, 0)",
+		"ip",
+		"user_agent",
+		"request_id",
+		"outcome",
+		"created_at",
+	).From("login_attempts"), params).
+		OrderBy("created_at DESC", "id DESC").
+		Offset(params.Offset)
+	if params.Limit > 0 {
+		q = q.Limit(params.Limit)
+	}
+
+	query, args := q.PlaceholderFormat(sq.Dollar).MustSql()
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	defer rows.Close()
+
+	var res []model.LoginAttempt
+	for rows.Next() {
+		var attempt model.LoginAttempt
+		err = rows.Scan(
+			&attempt.ID,
+			&attempt.Login,
+			&attempt.UserID,
+			&attempt.IP,
+			&attempt.UserAgent,
+			&attempt.RequestID,
+			&attempt.Outcome,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		res = append(res, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate rows: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *UserStorage) UpdateLoginAttemptOutcome(ctx context.Context, attempt *model.LoginAttempt) error {
+	// language=PostgreSQL
+	q := `UPDATE login_attempts SET user_id = NULLIF($2, 0), outcome = $3 WHERE id = $1`
+	tag, err := s.db.Exec(ctx, q, attempt.ID, attempt.UserID, attempt.Outcome)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *UserStorage) DeleteLoginAttempts(ctx context.Context, before time.Time, limit int) (int, error) {
+	// language=PostgreSQL
+	q := `DELETE FROM login_attempts
+WHERE id IN (SELECT id FROM login_attempts WHERE created_at < $1 LIMIT $2)`
+	tag, err := s.db.Exec(ctx, q, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (s *UserStorage) CountLoginAttempts(ctx context.Context, params storage.LoginAttemptsFilterParams) (int, error) {
+	q := applyLoginAttemptsFilter(sq.Select("COUNT(*)").From("login_attempts"), params)
+
+	query, args := q.PlaceholderFormat(sq.Dollar).MustSql()
+	var count int
+	if err := s.db.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return count, nil
+}
+
+func applyLoginAttemptsFilter(q sq.SelectBuilder, params storage.LoginAttemptsFilterParams) sq.SelectBuilder {
+	if params.Login != "" {
+		q = q.Where("login = ?", params.Login)
+	}
+	if len(params.Outcomes) > 0 {
+		q = q.Where(sq.Eq{"outcome": params.Outcomes})
+	}
+	if params.BeforeID > 0 {
+		q = q.Where("id < ?", params.BeforeID)
+	}
+	return q
+}
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+func (s *UserStorage) CreateLoginChallenge(ctx context.Context, challenge *model.LoginChallenge) error {
+	// language=PostgreSQL
+	q := `INSERT INTO login_challenges (id_hash, user_id, login, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := s.db.Exec(ctx, q, challenge.IDHash, challenge.UserID, challenge.Login, challenge.ExpiresAt, challenge.CreatedAt)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return storage.ErrNotFound
+		}
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) FetchLoginChallenge(ctx context.Context, hash string, now time.Time) (*model.LoginChallenge, error) {
+	// language=PostgreSQL
+	q := `SELECT id_hash, user_id, login, attempts, expires_at, created_at FROM login_challenges
+WHERE id_hash = $1 AND expires_at > $2`
+	var challenge model.LoginChallenge
+	err := s.db.QueryRow(ctx, q, hash, now).Scan(
+		&challenge.IDHash,
+		&challenge.UserID,
+		&challenge.Login,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+		&challenge.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	return &challenge, nil
+}
+
+func (s *UserStorage) FailLoginChallenge(ctx context.Context, hash string, maxAttempts int) error {
+	// conditions are checked on locked row, so concurrent requests can't exceed attempts
+	// language=PostgreSQL
+	q := `DELETE FROM login_challenges WHERE id_hash = $1 AND attempts + 1 >= $2`
+	tag, err := s.db.Exec(ctx, q, hash, maxAttempts)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		return storage.ErrNotFound
+	}
+
+	// language=PostgreSQL
+	q = `UPDATE login_challenges SET attempts = attempts + 1 WHERE id_hash = $1`
+	tag, err = s.db.Exec(ctx, q, hash)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *UserStorage) DeleteLoginChallenge(ctx context.Context, hash string) error {
+	// language=PostgreSQL
+	q := `DELETE FROM login_challenges WHERE id_hash = $1`
+	tag, err := s.db.Exec(ctx, q, hash)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *UserStorage) DeleteExpiredLoginChallenges(ctx context.Context, before time.Time, limit int) (int, error) {
+	// language=PostgreSQL
+	q := `DELETE FROM login_challenges
+WHERE id_hash IN (SELECT id_hash FROM login_challenges WHERE expires_at < $1 LIMIT $2)`
+	tag, err := s.db.Exec(ctx, q, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"github.com/agalitsyn/goth/pkg/metrics"
+	"github.com/agalitsyn/postgres"
+)
+
+// PoolCollector exports stats of connection pool, they are read on every scrape
+func PoolCollector(db *postgres.DB) metrics.Collector {
+	return metrics.CollectorFunc(func() []metrics.Family {
+		stat := db.Stat()
+		return []metrics.Family{
+			poolFamily("pgxpool_acquired_conns", "Connections acquired from pool.",
+				metrics.TypeGauge, float64(stat.AcquiredConns())),
+			poolFamily("pgxpool_idle_conns", "Idle connections in pool.",
+				metrics.TypeGauge, float64(stat.IdleConns())),
+			poolFamily("pgxpool_constructing_conns", "Connections being established.",
+				metrics.TypeGauge, float64(stat.ConstructingConns())),
+			poolFamily("pgxpool_total_conns", "All connections in pool.",
+				metrics.TypeGauge, float64(stat.TotalConns())),
+			poolFamily("pgxpool_max_conns", "Max size of pool.",
+				metrics.TypeGauge, float64(stat.MaxConns())),
+			poolFamily("pgxpool_acquires_total", "Successful acquires of connection.",
+				metrics.TypeCounter, float64(stat.AcquireCount())),
+			poolFamily("pgxpool_acquire_duration_seconds_total", "Time spent acquiring connections.",
+				metrics.TypeCounter, stat.AcquireDuration().Seconds()),
+			poolFamily("pgxpool_empty_acquires_total", "Acquires which waited because pool was empty.",
+				metrics.TypeCounter, float64(stat.EmptyAcquireCount())),
+			poolFamily("pgxpool_canceled_acquires_total", "Acquires canceled by context.",
+				metrics.TypeCounter, float64(stat.CanceledAcquireCount())),
+			poolFamily("pgxpool_new_conns_total", "Connections opened.",
+				metrics.TypeCounter, float64(stat.NewConnsCount())),
+			poolFamily("pgxpool_max_lifetime_destroys_total", "Connections closed because of max lifetime.",
+				metrics.TypeCounter, float64(stat.MaxLifetimeDestroyCount())),
+			poolFamily("pgxpool_max_idle_destroys_total", "Connections closed because of max idle time.",
+				metrics.TypeCounter, float64(stat.MaxIdleDestroyCount())),
+		}
+	})
+}
+
+func poolFamily(name, help string, typ metrics.Type, v float64) metrics.Family {
+	return metrics.Family{Name: name, Help: help, Type: typ, Samples: []metrics.Sample{{Value: v}}}
+}
+
+// PoolStats is snapshot of connection pool for diagnostics
+type PoolStats struct {
+	AcquiredConns           int32  `json:"acquired_conns"`
+	IdleConns               int32  `json:"idle_conns"`
+	ConstructingConns       int32  `json:"constructing_conns"`
+	TotalConns              int32  `json:"total_conns"`
+	MaxConns                int32  `json:"max_conns"`
+	AcquireCount            int64  `json:"acquire_count"`
+	AcquireDuration         string `json:"acquire_duration"`
+	EmptyAcquireCount       int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount    int64  `json:"canceled_acquire_count"`
+	NewConnsCount           int64  `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64  `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64  `json:"max_idle_destroy_count"`
+}
+
+func GetPoolStats(db *postgres.DB) PoolStats {
+	stat := db.Stat()
+	return PoolStats{
+		AcquiredConns:           stat.AcquiredConns(),
+		IdleConns:               stat.IdleConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		TotalConns:              stat.TotalConns(),
+		MaxConns:                stat.MaxConns(),
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDuration:         stat.AcquireDuration().String(),
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+}
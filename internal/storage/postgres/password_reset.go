@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+func (s *UserStorage) CreatePasswordResetToken(ctx context.Context, token *model.PasswordResetToken) error {
+	// language=PostgreSQL
+	q := `INSERT INTO password_reset_tokens (token_hash, user_id, expires_at, created_at) VALUES ($1, $2, $3, $4)`
+	_, err := s.db.Exec(ctx, q, token.TokenHash, token.UserID, token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return storage.ErrNotFound
+		}
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) FetchPasswordResetToken(
+	ctx context.Context,
+	hash string,
+	now time.Time,
+) (*model.PasswordResetToken, error) {
+	// language=PostgreSQL
+	q := `SELECT token_hash, user_id, expires_at, created_at FROM password_reset_tokens
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2`
+	return scanPasswordResetToken(s.db.QueryRow(ctx, q, hash, now))
+}
+
+func (s *UserStorage) UsePasswordResetToken(
+	ctx context.Context,
+	hash string,
+	now time.Time,
+) (*model.PasswordResetToken, error) {
+	// language=PostgreSQL
+	q := `UPDATE password_reset_tokens SET used_at = $2
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+RETURNING token_hash, user_id, expires_at, created_at`
+	return scanPasswordResetToken(s.db.QueryRow(ctx, q, hash, now))
+}
+
+func scanPasswordResetToken(row pgx.Row) (*model.PasswordResetToken, error) {
+	var token model.PasswordResetToken
+	err := row.Scan(&token.TokenHash, &token.UserID, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	return &token, nil
+}
+
+func (s *UserStorage) DeleteUserPasswordResetTokens(ctx context.Context, userID int64) error {
+	// language=PostgreSQL
+	q := `DELETE FROM password_reset_tokens WHERE user_id = $1`
+	if _, err := s.db.Exec(ctx, q, userID); err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) DeleteExpiredPasswordResetTokens(ctx context.Context, before time.Time, limit int) (int, error) {
+	// language=PostgreSQL
+	q := `DELETE FROM password_reset_tokens
+WHERE token_hash IN (SELECT token_hash FROM password_reset_tokens WHERE expires_at < $1 LIMIT $2)`
+	tag, err := s.db.Exec(ctx, q, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
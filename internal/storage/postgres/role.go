@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+func (s *UserStorage) FetchUserRoles(ctx context.Context, user *model.User) error {
+	if user.ID == 0 {
+		return fmt.Errorf("cannot fetch user roles with empty user id")
+	}
+
+	// language=PostgreSQL
+	q := `SELECT ur.role, COALESCE(array_agg(rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+FROM user_roles ur
+LEFT JOIN role_permissions rp ON rp.role = ur.role
+WHERE ur.user_id = $1
+GROUP BY ur.role
+ORDER BY ur.role`
+	rows, err := s.db.Query(ctx, q, user.ID)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	defer rows.Close()
+
+	user.Roles = nil
+	user.Permissions = nil
+	for rows.Next() {
+		var (
+			role        string
+			permissions []string
+		)
+		if err = rows.Scan(&role, &permissions); err != nil {
+			return fmt.Errorf("could not scan row: %w", err)
+		}
+		user.Roles = append(user.Roles, role)
+		for _, p := range permissions {
+			if !slices.Contains(user.Permissions, model.Permission(p)) {
+				user.Permissions = append(user.Permissions, model.Permission(p))
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserStorage) FilterRoles(ctx context.Context) ([]model.Role, error) {
+	// language=PostgreSQL
+	q := `SELECT r.name, r.description,
+	COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+FROM roles r
+LEFT JOIN role_permissions rp ON rp.role = r.name
+GROUP BY r.name
+ORDER BY r.name`
+	rows, err := s.db.Query(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	defer rows.Close()
+
+	var res []model.Role
+	for rows.Next() {
+		var (
+			role        model.Role
+			permissions []string
+		)
+		if err = rows.Scan(&role.Name, &role.Description, &permissions); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		for _, p := range permissions {
+			role.Permissions = append(role.Permissions, model.Permission(p))
+		}
+		res = append(res, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate rows: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *UserStorage) GrantUserRole(ctx context.Context, userID int64, role string) error {
+	// language=PostgreSQL
+	q := `INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := s.db.Exec(ctx, q, userID, role)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return storage.ErrNotFound
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) RevokeUserRole(ctx context.Context, userID int64, role string) error {
+	// language=PostgreSQL
+	q := `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`
+	tag, err := s.db.Exec(ctx, q, userID, role)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
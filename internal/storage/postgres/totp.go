@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+func (s *UserStorage) FetchUserTOTP(ctx context.Context, userID int64) (*model.UserTOTP, error) {
+	// language=PostgreSQL
+	q := `SELECT user_id, secret, is_confirmed, last_used_step, created_at FROM user_totp WHERE user_id = $1`
+	var totp model.UserTOTP
+	err := s.db.QueryRow(ctx, q, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.IsConfirmed,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	return &totp, nil
+}
+
+func (s *UserStorage) SaveUserTOTP(ctx context.Context, totp *model.UserTOTP) error {
+	// language=PostgreSQL
+	q := `INSERT INTO user_totp (user_id, secret, is_confirmed, last_used_step, created_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret,
+	is_confirmed = EXCLUDED.is_confirmed,
+	last_used_step = EXCLUDED.last_used_step,
+	created_at = EXCLUDED.created_at`
+	_, err := s.db.Exec(ctx, q, totp.UserID, totp.Secret, totp.IsConfirmed, totp.LastUsedStep, totp.CreatedAt)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return storage.ErrNotFound
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) UpdateUserTOTPStep(ctx context.Context, userID int64, step int64) error {
+	// language=PostgreSQL
+	q := `UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1`
+	tag, err := s.db.Exec(ctx, q, step, userID)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *UserStorage) DeleteUserTOTP(ctx context.Context, userID int64) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		// language=PostgreSQL
+		if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("could not perform query: %w", err)
+		}
+		// language=PostgreSQL
+		if _, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("could not perform query: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *UserStorage) ConfirmUserTOTP(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		// language=PostgreSQL
+		q := `UPDATE user_totp SET is_confirmed = TRUE, last_used_step = $2 WHERE user_id = $1 AND NOT is_confirmed`
+		tag, err := tx.Exec(ctx, q, userID, step)
+		if err != nil {
+			return fmt.Errorf("could not perform query: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrNotFound
+		}
+
+		// language=PostgreSQL
+		if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("could not perform query: %w", err)
+		}
+		// language=PostgreSQL
+		q = `INSERT INTO user_recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])`
+		if _, err := tx.Exec(ctx, q, userID, recoveryCodeHashes); err != nil {
+			return fmt.Errorf("could not perform query: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *UserStorage) UseUserRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	// language=PostgreSQL
+	q := `UPDATE user_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := s.db.Exec(ctx, q, userID, hash)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *UserStorage) CountUserRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	// language=PostgreSQL
+	q := `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	var count int
+	if err := s.db.QueryRow(ctx, q, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return count, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
@@ -23,7 +24,7 @@ func NewUserStorage(db *postgres.DB) *UserStorage {
 }
 
 func (s *UserStorage) FilterUsers(ctx context.Context, params storage.UserFilterParams) ([]model.User, error) {
-	q := sq.Select("id", "login", "is_active").From("users").
+	q := applyUserFilter(sq.Select("id", "login", "COALESCE(email, '')", "is_active", "external_groups").From("users"), params).
 		Offset(params.Offset)
 	for _, sort := range params.Sort {
 		q = q.OrderBy(fmt.Sprintf("%s %s", sort.By, sort.Order))
@@ -45,7 +46,9 @@ func (s *UserStorage) FilterUsers(ctx context.Context, params storage.UserFilter
 		err = rows.Scan(
 			&user.ID,
 			&user.Login,
+			&user.Email,
 			&user.IsActive,
+			&user.ExternalGroups,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("could not scan row: %w", err)
@@ -59,14 +62,34 @@ func (s *UserStorage) FilterUsers(ctx context.Context, params storage.UserFilter
 	return res, nil
 }
 
+func (s *UserStorage) CountUsers(ctx context.Context, params storage.UserFilterParams) (int, error) {
+	q := applyUserFilter(sq.Select("COUNT(*)").From("users"), params)
+
+	query, args := q.PlaceholderFormat(sq.Dollar).MustSql()
+	var count int
+	if err := s.db.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return count, nil
+}
+
+func applyUserFilter(q sq.SelectBuilder, params storage.UserFilterParams) sq.SelectBuilder {
+	if params.Login != "" {
+		q = q.Where("login ILIKE ?", "%"+escapeLike(params.Login)+"%")
+	}
+	return q
+}
+
 func (s *UserStorage) FetchUserByLogin(ctx context.Context, login string) (*model.User, error) {
 	var user model.User
 	// language=PostgreSQL
-	q := `SELECT id, login, is_active FROM users WHERE login = $1`
+	q := `SELECT id, login, COALESCE(email, ''), is_active, external_groups FROM users WHERE login = $1`
 	err := s.db.QueryRow(ctx, q, login).Scan(
 		&user.ID,
 		&user.Login,
+		&user.Email,
 		&user.IsActive,
+		&user.ExternalGroups,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -80,11 +103,33 @@ func (s *UserStorage) FetchUserByLogin(ctx context.Context, login string) (*mode
 func (s *UserStorage) FetchUserByID(ctx context.Context, id int64) (*model.User, error) {
 	var user model.User
 	// language=PostgreSQL
-	q := `SELECT id, login, is_active FROM users WHERE id = $1`
+	q := `SELECT id, login, COALESCE(email, ''), is_active, external_groups FROM users WHERE id = $1`
 	err := s.db.QueryRow(ctx, q, id).Scan(
 		&user.ID,
 		&user.Login,
+		&user.Email,
+		&user.IsActive,
+		&user.ExternalGroups,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	return &user, nil
+}
+
+func (s *UserStorage) FetchUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	var user model.User
+	// language=PostgreSQL
+	q := `SELECT id, login, email, is_active, external_groups FROM users WHERE LOWER(email) = LOWER($1)`
+	err := s.db.QueryRow(ctx, q, email).Scan(
+		&user.ID,
+		&user.Login,
+		&user.Email,
 		&user.IsActive,
+		&user.ExternalGroups,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -114,16 +159,61 @@ func (s *UserStorage) FetchUserPassword(ctx context.Context, user *model.User) e
 
 func (s *UserStorage) CreateUser(ctx context.Context, user *model.User) error {
 	// language=PostgreSQL
-	q := `INSERT INTO users (login, hashed_password, is_active)
-VALUES ($1, $2, $3)
-ON CONFLICT (login) DO UPDATE SET
-	hashed_password = excluded.hashed_password,
-	is_active = excluded.is_active
-RETURNING id`
-	err := s.db.QueryRow(ctx, q, user.Login, user.HashedPassword, user.IsActive).Scan(&user.ID)
+	q := `INSERT INTO users (login, email, hashed_password, is_active) VALUES ($1, NULLIF($2, ''), $3, $4) RETURNING id`
+	err := s.db.QueryRow(ctx, q, user.Login, user.Email, user.HashedPassword, user.IsActive).Scan(&user.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStorage) UpdateUser(ctx context.Context, user *model.User) error {
+	// language=PostgreSQL
+	q := `UPDATE users SET login = $1, email = NULLIF($2, ''), is_active = $3 WHERE id = $4`
+	tag, err := s.db.Exec(ctx, q, user.Login, user.Email, user.IsActive, user.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *UserStorage) UpdateUserPassword(ctx context.Context, user *model.User) error {
+	// language=PostgreSQL
+	q := `UPDATE users SET hashed_password = $1 WHERE id = $2`
+	tag, err := s.db.Exec(ctx, q, user.HashedPassword, user.ID)
 	if err != nil {
 		return fmt.Errorf("could not perform query: %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *UserStorage) UpdateUserExternalGroups(ctx context.Context, user *model.User) error {
+	groups := user.ExternalGroups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	// language=PostgreSQL
+	q := `UPDATE users SET external_groups = $1 WHERE id = $2`
+	tag, err := s.db.Exec(ctx, q, groups, user.ID)
+	if err != nil {
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
 	return nil
 }
 
@@ -131,8 +221,9 @@ func (s *UserStorage) FilterUserSessions(
 	ctx context.Context,
 	params storage.UserSessionsFilterParams,
 ) ([]model.UserSession, error) {
-	q := sq.Select("uuid", "user_id", "expires_at").
-		From("user_sessions")
+	q := sq.Select(userSessionColumns...).
+		From("user_sessions").
+		OrderBy("last_seen_at DESC")
 
 	if params.UserID != 0 {
 		q = q.Where("user_id = ?", params.UserID)
@@ -140,6 +231,9 @@ func (s *UserStorage) FilterUserSessions(
 	if params.IsExpired {
 		q = q.Where("expires_at < NOW()")
 	}
+	if params.IsActive {
+		q = q.Where("expires_at >= NOW()")
+	}
 
 	query, args := q.PlaceholderFormat(sq.Dollar).MustSql()
 	rows, err := s.db.Query(ctx, query, args...)
@@ -151,12 +245,7 @@ func (s *UserStorage) FilterUserSessions(
 	var sessions []model.UserSession
 	for rows.Next() {
 		var res model.UserSession
-		err = rows.Scan(
-			&res.UUID,
-			&res.UserID,
-			&res.ExpiresAt,
-		)
-		if err != nil {
+		if err = scanUserSession(rows, &res); err != nil {
 			return nil, fmt.Errorf("could not scan row: %w", err)
 		}
 		sessions = append(sessions, res)
@@ -168,15 +257,26 @@ func (s *UserStorage) FilterUserSessions(
 	return sessions, nil
 }
 
-func (s *UserStorage) FetchUserSession(ctx context.Context, uuid uuid.UUID) (*model.UserSession, error) {
-	// language=PostgreSQL
-	q := `SELECT uuid, user_id, expires_at FROM user_sessions WHERE uuid = $1`
-	var session model.UserSession
-	err := s.db.QueryRow(ctx, q, uuid).Scan(
+var userSessionColumns = []string{"uuid", "user_id", "expires_at", "created_at", "last_seen_at", "ip", "user_agent"}
+
+func scanUserSession(row pgx.Row, session *model.UserSession) error {
+	return row.Scan(
 		&session.UUID,
 		&session.UserID,
 		&session.ExpiresAt,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.IP,
+		&session.UserAgent,
 	)
+}
+
+func (s *UserStorage) FetchUserSession(ctx context.Context, uuid uuid.UUID) (*model.UserSession, error) {
+	// language=PostgreSQL
+	q := `SELECT uuid, user_id, expires_at, created_at, last_seen_at, ip, user_agent
+FROM user_sessions WHERE uuid = $1`
+	var session model.UserSession
+	err := scanUserSession(s.db.QueryRow(ctx, q, uuid), &session)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, storage.ErrNotFound
@@ -188,8 +288,19 @@ func (s *UserStorage) FetchUserSession(ctx context.Context, uuid uuid.UUID) (*mo
 
 func (s *UserStorage) CreateUserSession(ctx context.Context, session *model.UserSession) error {
 	// language=PostgreSQL
-	q := `INSERT INTO user_sessions (user_id, expires_at) VALUES ($1, $2) RETURNING uuid`
-	err := s.db.QueryRow(ctx, q, session.UserID, session.ExpiresAt).Scan(&session.UUID)
+	q := `INSERT INTO user_sessions (user_id, expires_at, created_at, last_seen_at, ip, user_agent)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING uuid`
+	err := s.db.QueryRow(
+		ctx,
+		q,
+		session.UserID,
+		session.ExpiresAt,
+		session.CreatedAt,
+		session.LastSeenAt,
+		session.IP,
+		session.UserAgent,
+	).Scan(&session.UUID)
 	if err != nil {
 		return fmt.Errorf("could not perform query: %w", err)
 	}
@@ -214,10 +325,48 @@ func (s *UserStorage) DeleteUserSessions(ctx context.Context, sessions []model.U
 	return nil
 }
 
+func (s *UserStorage) FilterExpiredUserSessions(ctx context.Context, before time.Time) ([]model.UserSession, error) {
+	query, args := sq.Select(userSessionColumns...).
+		From("user_sessions").
+		Where("expires_at < ?", before).
+		OrderBy("expires_at").
+		PlaceholderFormat(sq.Dollar).
+		MustSql()
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []model.UserSession
+	for rows.Next() {
+		var res model.UserSession
+		if err = scanUserSession(rows, &res); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		sessions = append(sessions, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate rows: %w", err)
+	}
+	return sessions, nil
+}
+
+func (s *UserStorage) DeleteExpiredUserSessions(ctx context.Context, before time.Time, limit int) (int, error) {
+	// language=PostgreSQL
+	q := `DELETE FROM user_sessions
+WHERE uuid IN (SELECT uuid FROM user_sessions WHERE expires_at < $1 LIMIT $2)`
+	tag, err := s.db.Exec(ctx, q, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("could not perform query: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
 func (s *UserStorage) UpdateUserSession(ctx context.Context, session *model.UserSession) error {
 	// language=PostgreSQL
-	q := `UPDATE user_sessions SET expires_at = $1 WHERE uuid = $2`
-	_, err := s.db.Exec(ctx, q, session.ExpiresAt, session.UUID)
+	q := `UPDATE user_sessions SET expires_at = $1, last_seen_at = $2, ip = $3, user_agent = $4 WHERE uuid = $5`
+	_, err := s.db.Exec(ctx, q, session.ExpiresAt, session.LastSeenAt, session.IP, session.UserAgent, session.UUID)
 	if err != nil {
 		return fmt.Errorf("could not perform query: %w", err)
 	}
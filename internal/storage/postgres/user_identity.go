@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/agalitsyn/goth/internal/model"
+	"github.com/agalitsyn/goth/internal/storage"
+)
+
+func (s *UserStorage) FetchUserIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	var identity model.UserIdentity
+	// language=PostgreSQL
+	q := `SELECT provider, subject, user_id, created_at FROM user_identities WHERE provider = $1 AND subject = $2`
+	err := s.db.QueryRow(ctx, q, provider, subject).Scan(
+		&identity.Provider,
+		&identity.Subject,
+		&identity.UserID,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("could not perform query: %w", err)
+	}
+	return &identity, nil
+}
+
+func (s *UserStorage) CreateUserIdentity(ctx context.Context, identity *model.UserIdentity) error {
+	// language=PostgreSQL
+	q := `INSERT INTO user_identities (provider, subject, user_id, created_at) VALUES ($1, $2, $3, $4)`
+	_, err := s.db.Exec(ctx, q, identity.Provider, identity.Subject, identity.UserID, identity.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		if isForeignKeyViolation(err) {
+			return storage.ErrNotFound
+		}
+		return fmt.Errorf("could not perform query: %w", err)
+	}
+	return nil
+}
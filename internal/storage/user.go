@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -11,23 +12,118 @@ import (
 
 type UserFilterParams struct {
 	pagination.Pagination
+
+	// Login is a substring to search in user login, case insensitive
+	Login string
 }
 
 type UserSessionsFilterParams struct {
 	UserID    int64
 	IsExpired bool
+	IsActive  bool
+}
+
+type LoginAttemptsFilterParams struct {
+	pagination.Pagination
+
+	Login    string
+	Outcomes []model.LoginOutcome
+	// BeforeID selects attempts created earlier than attempt with given id, zero selects all
+	BeforeID int64
 }
 
 type UserStorage interface {
 	FetchUserByID(ctx context.Context, id int64) (*model.User, error)
 	FetchUserByLogin(ctx context.Context, login string) (*model.User, error)
+	// FetchUserByEmail matches email case insensitive
+	FetchUserByEmail(ctx context.Context, email string) (*model.User, error)
 	FetchUserPassword(ctx context.Context, user *model.User) error
 	FilterUsers(ctx context.Context, filter UserFilterParams) ([]model.User, error)
+	CountUsers(ctx context.Context, filter UserFilterParams) (int, error)
 	CreateUser(ctx context.Context, user *model.User) error
+	UpdateUser(ctx context.Context, user *model.User) error
+	UpdateUserPassword(ctx context.Context, user *model.User) error
+	UpdateUserExternalGroups(ctx context.Context, user *model.User) error
+
+	// FetchUserIdentity returns ErrNotFound if account of provider is not linked to any user
+	FetchUserIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error)
+	// CreateUserIdentity returns ErrDuplicate if account is already linked
+	CreateUserIdentity(ctx context.Context, identity *model.UserIdentity) error
+
+	FetchUserRoles(ctx context.Context, user *model.User) error
+	FilterRoles(ctx context.Context) ([]model.Role, error)
+	GrantUserRole(ctx context.Context, userID int64, role string) error
+	RevokeUserRole(ctx context.Context, userID int64, role string) error
+
+	// FetchUserTOTP returns ErrNotFound if user has no TOTP enrollment
+	FetchUserTOTP(ctx context.Context, userID int64) (*model.UserTOTP, error)
+	// SaveUserTOTP creates or replaces user's TOTP enrollment
+	SaveUserTOTP(ctx context.Context, totp *model.UserTOTP) error
+	// UpdateUserTOTPStep sets last used step only if it is greater than stored one,
+	// returns ErrNotFound otherwise, so concurrent requests can't reuse the same code
+	UpdateUserTOTPStep(ctx context.Context, userID int64, step int64) error
+	// DeleteUserTOTP deletes TOTP enrollment and recovery codes
+	DeleteUserTOTP(ctx context.Context, userID int64) error
+	// ConfirmUserTOTP confirms enrollment with last used step and replaces all recovery codes of user
+	// with given hashes in one transaction. Returns ErrNotFound if there is no unconfirmed enrollment.
+	ConfirmUserTOTP(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error
+	// UseUserRecoveryCode marks recovery code as used, returns ErrNotFound if code does not exist or used
+	UseUserRecoveryCode(ctx context.Context, userID int64, hash string) error
+	// CountUserRecoveryCodes counts unused recovery codes
+	CountUserRecoveryCodes(ctx context.Context, userID int64) (int, error)
+
+	CreatePasswordResetToken(ctx context.Context, token *model.PasswordResetToken) error
+	// FetchPasswordResetToken returns ErrNotFound if token does not exist, expired or used
+	FetchPasswordResetToken(ctx context.Context, hash string, now time.Time) (*model.PasswordResetToken, error)
+	// UsePasswordResetToken marks token as used, returns ErrNotFound if token does not exist, expired or used,
+	// so token can't be used twice by concurrent requests
+	UsePasswordResetToken(ctx context.Context, hash string, now time.Time) (*model.PasswordResetToken, error)
+	// DeleteUserPasswordResetTokens deletes all tokens of user
+	DeleteUserPasswordResetTokens(ctx context.Context, userID int64) error
+	// DeleteExpiredPasswordResetTokens deletes up to limit tokens which expired before given time
+	// and returns count of deleted tokens
+	DeleteExpiredPasswordResetTokens(ctx context.Context, before time.Time, limit int) (int, error)
+
+	CreateLoginChallenge(ctx context.Context, challenge *model.LoginChallenge) error
+	// FetchLoginChallenge returns ErrNotFound if challenge does not exist or expired
+	FetchLoginChallenge(ctx context.Context, hash string, now time.Time) (*model.LoginChallenge, error)
+	// FailLoginChallenge counts failed attempt and deletes challenge when attempts reach maxAttempts.
+	// Returns ErrNotFound if challenge does not exist or is deleted by the call.
+	FailLoginChallenge(ctx context.Context, hash string, maxAttempts int) error
+	// DeleteLoginChallenge returns ErrNotFound if challenge does not exist,
+	// so challenge can't be completed twice by concurrent requests
+	DeleteLoginChallenge(ctx context.Context, hash string) error
+	// DeleteExpiredLoginChallenges deletes up to limit challenges which expired before given time
+	// and returns count of deleted challenges
+	DeleteExpiredLoginChallenges(ctx context.Context, before time.Time, limit int) (int, error)
+
+	CreateAPIToken(ctx context.Context, token *model.APIToken) error
+	// FetchAPITokenByHash returns ErrNotFound if token does not exist, expiration is checked by caller
+	FetchAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error)
+	// FilterUserAPITokens returns tokens of user from newest to oldest
+	FilterUserAPITokens(ctx context.Context, userID int64) ([]model.APIToken, error)
+	UpdateAPITokenLastUsed(ctx context.Context, id int64, usedAt time.Time) error
+	// DeleteUserAPIToken returns ErrNotFound if user has no token with given id
+	DeleteUserAPIToken(ctx context.Context, userID, id int64) error
+
+	CreateLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) error
+	// FilterLoginAttempts returns attempts from newest to oldest
+	FilterLoginAttempts(ctx context.Context, filter LoginAttemptsFilterParams) ([]model.LoginAttempt, error)
+	CountLoginAttempts(ctx context.Context, filter LoginAttemptsFilterParams) (int, error)
+	// UpdateLoginAttemptOutcome sets user and outcome of attempt, returns ErrNotFound if attempt does not exist
+	UpdateLoginAttemptOutcome(ctx context.Context, attempt *model.LoginAttempt) error
+	// DeleteLoginAttempts deletes up to limit attempts created before given time
+	// and returns count of deleted attempts
+	DeleteLoginAttempts(ctx context.Context, before time.Time, limit int) (int, error)
 
 	UpdateUserSession(ctx context.Context, session *model.UserSession) error
 	FilterUserSessions(ctx context.Context, filter UserSessionsFilterParams) ([]model.UserSession, error)
 	FetchUserSession(ctx context.Context, uuid uuid.UUID) (*model.UserSession, error)
 	CreateUserSession(ctx context.Context, session *model.UserSession) error
 	DeleteUserSessions(ctx context.Context, sessions []model.UserSession) error
+	// FilterExpiredUserSessions returns sessions which expired before given time, the oldest first
+	FilterExpiredUserSessions(ctx context.Context, before time.Time) ([]model.UserSession, error)
+	// DeleteExpiredUserSessions deletes up to limit sessions which expired before given time
+	// and returns count of deleted sessions
+	DeleteExpiredUserSessions(ctx context.Context, before time.Time, limit int) (int, error)
 }
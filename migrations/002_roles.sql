@@ -0,0 +1,30 @@
+CREATE TABLE roles (
+    name                TEXT        PRIMARY KEY,
+    description         TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role                TEXT        NOT NULL,
+    permission          TEXT        NOT NULL,
+    PRIMARY KEY (role, permission),
+    FOREIGN KEY(role) REFERENCES roles (name) ON DELETE CASCADE
+);
+
+CREATE TABLE user_roles (
+    user_id             INTEGER     NOT NULL,
+    role                TEXT        NOT NULL,
+    PRIMARY KEY (user_id, role),
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY(role) REFERENCES roles (name) ON DELETE CASCADE
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('superuser', 'Full access'),
+    ('operator', 'Read-only access to users');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('superuser', '*'),
+    ('operator', 'users:view');
+
+-- users created before roles were introduced keep full access
+INSERT INTO user_roles (user_id, role) SELECT id, 'superuser' FROM users;
@@ -0,0 +1,7 @@
+ALTER TABLE user_sessions
+    ADD COLUMN created_at       timestamp       NOT NULL DEFAULT NOW(),
+    ADD COLUMN last_seen_at     timestamp       NOT NULL DEFAULT NOW(),
+    ADD COLUMN ip               TEXT            NOT NULL DEFAULT '',
+    ADD COLUMN user_agent       TEXT            NOT NULL DEFAULT '';
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id);
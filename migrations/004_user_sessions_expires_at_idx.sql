@@ -0,0 +1 @@
+CREATE INDEX user_sessions_expires_at_idx ON user_sessions (expires_at);
@@ -0,0 +1,16 @@
+CREATE TABLE user_totp (
+    user_id             INTEGER     PRIMARY KEY,
+    secret              TEXT        NOT NULL,
+    is_confirmed        BOOLEAN     NOT NULL DEFAULT FALSE,
+    last_used_step      BIGINT      NOT NULL DEFAULT 0,
+    created_at          timestamp   NOT NULL DEFAULT NOW(),
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_recovery_codes (
+    user_id             INTEGER     NOT NULL,
+    code_hash           TEXT        NOT NULL,
+    used_at             timestamp,
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE CASCADE
+);
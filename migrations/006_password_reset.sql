@@ -0,0 +1,15 @@
+ALTER TABLE users
+    ADD COLUMN email            TEXT;
+
+CREATE UNIQUE INDEX users_email_idx ON users (LOWER(email));
+
+CREATE TABLE password_reset_tokens (
+    token_hash          TEXT        PRIMARY KEY,
+    user_id             INTEGER     NOT NULL,
+    expires_at          timestamp   NOT NULL,
+    created_at          timestamp   NOT NULL DEFAULT NOW(),
+    used_at             timestamp,
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
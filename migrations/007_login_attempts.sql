@@ -0,0 +1,15 @@
+CREATE TABLE login_attempts (
+    id                  BIGSERIAL   PRIMARY KEY,
+    login               TEXT        NOT NULL,
+    -- user_id is empty when login does not exist
+    user_id             INTEGER,
+    ip                  TEXT        NOT NULL DEFAULT '',
+    user_agent          TEXT        NOT NULL DEFAULT '',
+    request_id          TEXT        NOT NULL DEFAULT '',
+    outcome             TEXT        NOT NULL,
+    created_at          timestamp   NOT NULL DEFAULT NOW(),
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX login_attempts_login_created_at_idx ON login_attempts (login, created_at DESC);
+CREATE INDEX login_attempts_created_at_idx ON login_attempts (created_at DESC);
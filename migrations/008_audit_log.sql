@@ -0,0 +1,22 @@
+CREATE TABLE audit_log (
+    id                  BIGSERIAL   PRIMARY KEY,
+    -- actor_user_id is empty for CLI
+    actor_user_id       INTEGER,
+    actor_login         TEXT        NOT NULL DEFAULT '',
+    source              TEXT        NOT NULL,
+    ip                  TEXT        NOT NULL DEFAULT '',
+    request_id          TEXT        NOT NULL DEFAULT '',
+    action              TEXT        NOT NULL,
+    target_type         TEXT        NOT NULL DEFAULT '',
+    target_id           TEXT        NOT NULL DEFAULT '',
+    changes             JSONB       NOT NULL DEFAULT '{}',
+    created_at          timestamp   NOT NULL DEFAULT NOW(),
+    FOREIGN KEY(actor_user_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at DESC);
+CREATE INDEX audit_log_target_idx ON audit_log (target_type, target_id, created_at DESC);
+CREATE INDEX audit_log_actor_login_idx ON audit_log (actor_login, created_at DESC);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('operator', 'audit:view');
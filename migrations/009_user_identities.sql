@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN external_groups TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE user_identities (
+    provider            TEXT        NOT NULL,
+    -- subject is user id on provider side, it is stable unlike email
+    subject             TEXT        NOT NULL,
+    user_id             INTEGER     NOT NULL,
+    created_at          timestamp   NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
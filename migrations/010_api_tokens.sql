@@ -0,0 +1,14 @@
+CREATE TABLE api_tokens (
+    id                  SERIAL      PRIMARY KEY,
+    user_id             INTEGER     NOT NULL,
+    name                TEXT        NOT NULL,
+    prefix              TEXT        NOT NULL,
+    token_hash          TEXT        UNIQUE NOT NULL,
+    scopes              TEXT[]      NOT NULL DEFAULT '{}',
+    expires_at          timestamp   NOT NULL,
+    last_used_at        timestamp,
+    created_at          timestamp   NOT NULL DEFAULT NOW(),
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
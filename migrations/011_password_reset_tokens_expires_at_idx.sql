@@ -0,0 +1 @@
+CREATE INDEX password_reset_tokens_expires_at_idx ON password_reset_tokens (expires_at);
@@ -0,0 +1,11 @@
+CREATE TABLE login_challenges (
+    id_hash             TEXT        PRIMARY KEY,
+    user_id             INTEGER     NOT NULL,
+    login               TEXT        NOT NULL,
+    attempts            INTEGER     NOT NULL DEFAULT 0,
+    expires_at          timestamp   NOT NULL,
+    created_at          timestamp   NOT NULL DEFAULT NOW(),
+    FOREIGN KEY(user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX login_challenges_expires_at_idx ON login_challenges (expires_at);
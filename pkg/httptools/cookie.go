@@ -0,0 +1,13 @@
+package httptools
+
+// HostCookiePrefix makes browser accept cookie only if it is Secure, has Path=/ and no Domain,
+// so it can't be planted by a subdomain or over plain http.
+const HostCookiePrefix = "__Host-"
+
+// CookieName returns name with HostCookiePrefix if cookie is secure
+func CookieName(name string, secure bool) string {
+	if secure {
+		return HostCookiePrefix + name
+	}
+	return name
+}
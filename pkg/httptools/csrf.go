@@ -0,0 +1,208 @@
+package httptools
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfTokenLength = 32
+
+	DefaultCSRFCookieName = "csrf_token"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+	DefaultCSRFFormField  = "csrf_token"
+)
+
+type CSRFConfig struct {
+	CookieName   string
+	CookieSecure bool
+	HeaderName   string
+	FormField    string
+	// SessionCookieName binds token to session: secret of csrf cookie is mixed with value of session cookie,
+	// so token issued before login is not valid after it and csrf cookie planted by attacker is useless
+	SessionCookieName string
+	// UnboundPaths are path prefixes of public forms which also accept token issued without session.
+	// Browser does not send SameSite=Strict session cookie on cross-site navigation, e.g. by link from email,
+	// but sends it on following form submit, so token of such page is not bound to session.
+	UnboundPaths []string
+	// IgnoredPaths are path prefixes which are not protected,
+	// e.g. API authenticated by bearer tokens which browsers never send by themselves
+	IgnoredPaths []string
+
+	// ErrorHandler is called when token is missing or invalid.
+	// Responds with 403 Forbidden by WriteError by default.
+	ErrorHandler http.HandlerFunc
+}
+
+func (c *CSRFConfig) setDefaults() {
+	if c.CookieName == "" {
+		c.CookieName = DefaultCSRFCookieName
+	}
+	if c.HeaderName == "" {
+		c.HeaderName = DefaultCSRFHeaderName
+	}
+	if c.FormField == "" {
+		c.FormField = DefaultCSRFFormField
+	}
+	if c.ErrorHandler == nil {
+		c.ErrorHandler = func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, NewError(http.StatusForbidden, "", nil))
+		}
+	}
+}
+
+// CSRF is a middleware that implements double-submit cookie protection.
+// Secret is generated once per browser session and kept in http-only cookie,
+// requests with unsafe methods must send the token from context back in header or form field.
+// Token changes with session cookie when SessionCookieName is set.
+// Token exposed to templates is masked with one-time pad on every request to mitigate BREACH.
+func CSRF(cfg CSRFConfig) func(http.Handler) http.Handler {
+	cfg.setDefaults()
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if hasPathPrefix(r.URL.Path, cfg.IgnoredPaths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Responses depend on the cookie, caches must not share them
+			w.Header().Add("Vary", "Cookie")
+
+			secret, ok := csrfSecretFromCookie(r, cfg.CookieName)
+			if !ok {
+				secret = make([]byte, csrfTokenLength)
+				if _, err := rand.Read(secret); err != nil {
+					WriteError(w, r, NewError(http.StatusInternalServerError, "", fmt.Errorf("could not generate csrf secret: %w", err)))
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     cfg.CookieName,
+					Value:    base64.RawURLEncoding.EncodeToString(secret),
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   cfg.CookieSecure,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			bound := bindCSRFSecret(secret, r, cfg.SessionCookieName)
+
+			if !isSafeMethod(r.Method) {
+				// Brand-new secret means there was no cookie, so nothing to compare with
+				if !ok {
+					slog.WarnContext(r.Context(), "csrf cookie not found", "method", r.Method, "uri", r.URL.String())
+					cfg.ErrorHandler(w, r)
+					return
+				}
+
+				sent := r.Header.Get(cfg.HeaderName)
+				if sent == "" {
+					sent = r.PostFormValue(cfg.FormField)
+				}
+				valid := validCSRFToken(bound, sent) ||
+					hasPathPrefix(r.URL.Path, cfg.UnboundPaths) && validCSRFToken(secret, sent)
+				if !valid {
+					slog.WarnContext(r.Context(), "invalid csrf token", "method", r.Method, "uri", r.URL.String())
+					cfg.ErrorHandler(w, r)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), contextKey("csrfToken"), maskCSRFSecret(bound))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// CSRFTokenFromContext returns masked csrf token for rendering in templates
+func CSRFTokenFromContext(ctx context.Context) string {
+	if token, ok := ctx.Value(contextKey("csrfToken")).(string); ok {
+		return token
+	}
+	return ""
+}
+
+// GetCSRFToken returns masked csrf token from the request context
+func GetCSRFToken(r *http.Request) string {
+	return CSRFTokenFromContext(r.Context())
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+func hasPathPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func csrfSecretFromCookie(r *http.Request, name string) ([]byte, bool) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, false
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(secret) != csrfTokenLength {
+		return nil, false
+	}
+	return secret, true
+}
+
+// bindCSRFSecret returns HMAC of session cookie value keyed by secret, secret itself if there is no session
+func bindCSRFSecret(secret []byte, r *http.Request, sessionCookieName string) []byte {
+	if sessionCookieName == "" {
+		return secret
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return secret
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(cookie.Value))
+	return mac.Sum(nil)
+}
+
+// maskCSRFSecret returns base64(pad || pad XOR secret)
+func maskCSRFSecret(secret []byte) string {
+	token := make([]byte, 2*csrfTokenLength)
+	pad := token[:csrfTokenLength]
+	if _, err := rand.Read(pad); err != nil {
+		// should never happen, fallback to zero pad which is still valid token
+		clear(pad)
+	}
+	for i := range csrfTokenLength {
+		token[csrfTokenLength+i] = pad[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func validCSRFToken(secret []byte, sent string) bool {
+	token, err := base64.RawURLEncoding.DecodeString(sent)
+	if err != nil || len(token) != 2*csrfTokenLength {
+		return false
+	}
+	unmasked := make([]byte, csrfTokenLength)
+	for i := range csrfTokenLength {
+		unmasked[i] = token[i] ^ token[csrfTokenLength+i]
+	}
+	return subtle.ConstantTimeCompare(secret, unmasked) == 1
+}
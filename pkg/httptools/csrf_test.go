@@ -0,0 +1,173 @@
+package httptools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCSRFTestHandler() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(GetCSRFToken(r)))
+	}
+	return CSRF(CSRFConfig{})(http.HandlerFunc(fn))
+}
+
+// issueCSRFToken makes safe request and returns csrf cookie and masked token from context
+func issueCSRFToken(t *testing.T, h http.Handler) (*http.Cookie, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, DefaultCSRFCookieName, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+
+	token := rec.Body.String()
+	require.NotEmpty(t, token)
+	return cookies[0], token
+}
+
+func TestCSRF_SafeMethodIssuesToken(t *testing.T) {
+	h := newCSRFTestHandler()
+	cookie, token := issueCSRFToken(t, h)
+
+	// cookie is kept, but token is masked differently on every request
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Result().Cookies())
+	assert.NotEqual(t, token, rec.Body.String())
+}
+
+func TestCSRF_UnsafeMethods(t *testing.T) {
+	h := newCSRFTestHandler()
+	cookie, token := issueCSRFToken(t, h)
+	_, otherToken := issueCSRFToken(t, h)
+
+	tests := []struct {
+		name       string
+		cookie     *http.Cookie
+		header     string
+		form       url.Values
+		wantStatus int
+	}{
+		{name: "valid header", cookie: cookie, header: token, wantStatus: http.StatusOK},
+		{name: "valid form field", cookie: cookie, form: url.Values{DefaultCSRFFormField: {token}}, wantStatus: http.StatusOK},
+		{name: "missing token", cookie: cookie, wantStatus: http.StatusForbidden},
+		{name: "garbage token", cookie: cookie, header: "blah", wantStatus: http.StatusForbidden},
+		{name: "token from other session", cookie: cookie, header: otherToken, wantStatus: http.StatusForbidden},
+		{name: "missing cookie", header: token, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			if tt.header != "" {
+				req.Header.Set(DefaultCSRFHeaderName, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestCSRF_SessionBinding(t *testing.T) {
+	h := CSRF(CSRFConfig{SessionCookieName: "session_id"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(GetCSRFToken(r)))
+	}))
+	cookie, anonymousToken := issueCSRFToken(t, h)
+
+	issue := func(session string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.AddCookie(cookie)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: session})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Result().Cookies(), "csrf cookie is kept on login")
+		return rec.Body.String()
+	}
+	post := func(session, token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", http.NoBody)
+		req.AddCookie(cookie)
+		if session != "" {
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: session})
+		}
+		req.Header.Set(DefaultCSRFHeaderName, token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	sessionToken := issue("session-1")
+	assert.Equal(t, http.StatusOK, post("", anonymousToken))
+	assert.Equal(t, http.StatusOK, post("session-1", sessionToken))
+	assert.Equal(t, http.StatusForbidden, post("session-1", anonymousToken), "token issued before login is rejected")
+	assert.Equal(t, http.StatusForbidden, post("session-2", sessionToken), "token of other session is rejected")
+	assert.Equal(t, http.StatusForbidden, post("", sessionToken), "token of session is rejected after logout")
+}
+
+func TestCSRF_UnboundPaths(t *testing.T) {
+	h := CSRF(CSRFConfig{SessionCookieName: "session_id", UnboundPaths: []string{"/reset-password"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(GetCSRFToken(r)))
+		}),
+	)
+	cookie, anonymousToken := issueCSRFToken(t, h)
+
+	post := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, http.NoBody)
+		req.AddCookie(cookie)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: "session-1"})
+		req.Header.Set(DefaultCSRFHeaderName, anonymousToken)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post("/reset-password"), "page opened without session cookie is submitted with it")
+	assert.Equal(t, http.StatusForbidden, post("/users"))
+}
+
+func TestCSRF_CustomErrorHandler(t *testing.T) {
+	cfg := CSRFConfig{
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		},
+	}
+	h := CSRF(cfg)(getTestHandlerBlah())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/", http.NoBody))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
+
+func TestCSRF_IgnoredPaths(t *testing.T) {
+	h := CSRF(CSRFConfig{IgnoredPaths: []string{"/api/"}})(getTestHandlerBlah())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/users", http.NoBody))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Result().Cookies(), "ignored paths get no csrf cookie")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users", http.NoBody))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,118 @@
+package httptools
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/agalitsyn/goth/pkg/version"
+)
+
+// DebugConfig describes app for diagnostics endpoints
+type DebugConfig struct {
+	// Config is effective config of app, it is shown as JSON, so secrets must be masked by marshaller,
+	// e.g. be secret.String
+	Config any
+	// Stats are additional stats by name, e.g. of connection pool, they are read on every request
+	Stats map[string]func() any
+}
+
+// DebugHandler serves pprof, build info, config and runtime stats under /debug/.
+// It exposes internals of app, so it must be mounted behind authentication or on private listener.
+// Only GET requests are served, so it could be mounted as "GET /debug/" next to catch-all "GET /".
+func DebugHandler(cfg DebugConfig) http.Handler {
+	startedAt := time.Now()
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /debug/{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("/debug/pprof/\n/debug/buildinfo\n/debug/config\n/debug/stats\n"))
+	})
+
+	// Index serves named profiles too, e.g. /debug/pprof/heap.
+	// pprof.Cmdline is not served, arguments may contain secrets passed as flags.
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", http.NotFound)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("GET /debug/buildinfo", func(w http.ResponseWriter, r *http.Request) {
+		info := buildInfo{Version: version.String(), GoVersion: runtime.Version()}
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			info.Path = bi.Path
+			info.Main = bi.Main
+			info.Deps = bi.Deps
+			info.Settings = bi.Settings
+		}
+		writeDebugJSON(w, r, info)
+	})
+
+	mux.HandleFunc("GET /debug/config", func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, r, cfg.Config)
+	})
+
+	mux.HandleFunc("GET /debug/stats", func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		stats := map[string]any{
+			"runtime": runtimeStats{
+				Uptime:       time.Since(startedAt).Round(time.Second).String(),
+				Goroutines:   runtime.NumGoroutine(),
+				GOMAXPROCS:   runtime.GOMAXPROCS(0),
+				NumCPU:       runtime.NumCPU(),
+				HeapAlloc:    mem.HeapAlloc,
+				HeapSys:      mem.HeapSys,
+				HeapObjects:  mem.HeapObjects,
+				Sys:          mem.Sys,
+				NumGC:        mem.NumGC,
+				PauseTotalNs: mem.PauseTotalNs,
+			},
+		}
+		for name, fn := range cfg.Stats {
+			stats[name] = fn()
+		}
+		writeDebugJSON(w, r, stats)
+	})
+
+	return mux
+}
+
+type buildInfo struct {
+	Version   string               `json:"version"`
+	GoVersion string               `json:"go_version"`
+	Path      string               `json:"path,omitempty"`
+	Main      debug.Module         `json:"main"`
+	Deps      []*debug.Module      `json:"deps,omitempty"`
+	Settings  []debug.BuildSetting `json:"settings,omitempty"`
+}
+
+type runtimeStats struct {
+	Uptime       string `json:"uptime"`
+	Goroutines   int    `json:"goroutines"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	NumCPU       int    `json:"num_cpu"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapSys      uint64 `json:"heap_sys_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"gc_pause_total_ns"`
+}
+
+func writeDebugJSON(w http.ResponseWriter, r *http.Request, v any) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		WriteError(w, r, NewError(http.StatusInternalServerError, "", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		slog.ErrorContext(r.Context(), "could not write debug response", "error", err)
+	}
+}
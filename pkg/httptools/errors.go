@@ -0,0 +1,169 @@
+package httptools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// errorCodes are machine readable codes of statuses, clients should not parse messages
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusNotAcceptable:       "not_acceptable",
+	http.StatusConflict:            "conflict",
+	http.StatusUnprocessableEntity: "validation_failed",
+	http.StatusTooManyRequests:     "too_many_requests",
+	http.StatusInternalServerError: "internal",
+}
+
+// Error is failed request outcome.
+// Message is shown to user as is, Cause is internal and only logged or shown in debug mode.
+type Error struct {
+	Status int
+	// Code is machine readable, derived from status if empty
+	Code    string
+	Message string
+	// Fields are validation errors of request fields
+	Fields map[string][]string
+	Cause  error
+}
+
+// NewError returns error with code of status, empty message is replaced by status text
+func NewError(status int, msg string, cause error) *Error {
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	return &Error{Status: status, Code: errorCode(status), Message: msg, Cause: cause}
+}
+
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return "error"
+}
+
+func (e *Error) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("%d %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("%d %s: %v", e.Status, e.Message, e.Cause)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Problem is RFC 9457 problem details, code, request_id and fields are extension members
+type Problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail,omitempty" doc:"Message for user"`
+	Instance  string              `json:"instance,omitempty"`
+	Code      string              `json:"code" doc:"Machine readable error code"`
+	RequestID string              `json:"request_id,omitempty" doc:"Include it in support requests, it is logged with request"`
+	Fields    map[string][]string `json:"fields,omitempty" doc:"Validation errors of request fields"`
+}
+
+// NewProblem describes error of request, problem types are not used, so type is about:blank and title is status text
+func NewProblem(r *http.Request, err *Error) Problem {
+	code := err.Code
+	if code == "" {
+		code = errorCode(err.Status)
+	}
+	return Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(err.Status),
+		Status:    err.Status,
+		Detail:    err.Message,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: GetTraceID(r),
+		Fields:    err.Fields,
+	}
+}
+
+// ErrorHandler writes error response
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err *Error)
+
+// ErrorRenderer is a middleware that makes WriteError respond with handler, e.g. to render html pages.
+// Use it after Trace to have request id in responses and before Recoverer to render panics.
+// Nested groups may use it again to override handler, e.g. API always responds with WriteProblem.
+func ErrorRenderer(handler ErrorHandler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), contextKey("errorHandler"), handler)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// WriteError logs error and responds with handler of ErrorRenderer, or with DefaultErrorHandler if there is none
+func WriteError(w http.ResponseWriter, r *http.Request, err *Error) {
+	LogError(r, err)
+	renderError(w, r, err)
+}
+
+func renderError(w http.ResponseWriter, r *http.Request, err *Error) {
+	if handler, ok := r.Context().Value(contextKey("errorHandler")).(ErrorHandler); ok {
+		handler(w, r, err)
+		return
+	}
+	DefaultErrorHandler(w, r, err)
+}
+
+// LogError logs cause of server errors, client errors are expected and callers log them if needed
+func LogError(r *http.Request, err *Error) {
+	if err.Status < http.StatusInternalServerError {
+		return
+	}
+	requestLogger(r).Error("request failed",
+		"status_code", err.Status,
+		"http_method", r.Method,
+		"uri", r.URL.String(),
+		"error", err.Cause,
+	)
+}
+
+// DefaultErrorHandler responds with plain text to clients which prefer html or text, e.g. browsers,
+// and with problem details to the rest
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err *Error) {
+	w.Header().Add("Vary", "Accept")
+	switch NegotiateContentType(r, ProblemContentType, "application/json", "text/html", "text/plain") {
+	case "text/html", "text/plain":
+		WriteTextError(w, r, err)
+	default:
+		WriteProblem(w, r, err)
+	}
+}
+
+// WriteProblem responds with problem details
+func WriteProblem(w http.ResponseWriter, r *http.Request, err *Error) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Status)
+	if err := json.NewEncoder(w).Encode(NewProblem(r, err)); err != nil {
+		slog.ErrorContext(r.Context(), "could not write problem response", "error", err)
+	}
+}
+
+// WriteTextError responds like http.Error does, request id is appended to message
+func WriteTextError(w http.ResponseWriter, r *http.Request, err *Error) {
+	var b strings.Builder
+	b.WriteString(err.Message)
+	if id := GetTraceID(r); id != "" {
+		b.WriteString("\nRequest ID: " + id)
+	}
+	http.Error(w, b.String(), err.Status)
+}
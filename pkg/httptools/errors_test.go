@@ -0,0 +1,108 @@
+package httptools
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteError_Default(t *testing.T) {
+	handler := Trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := NewError(http.StatusUnprocessableEntity, "Невалидные данные", nil)
+		e.Fields = map[string][]string{"login": {"Логин не может быть пустым"}}
+		WriteError(w, r, e)
+	}))
+
+	tbl := []struct {
+		accept      string
+		contentType string
+	}{
+		{accept: "", contentType: ProblemContentType},
+		{accept: "*/*", contentType: ProblemContentType},
+		{accept: "application/json", contentType: ProblemContentType},
+		{accept: "text/html,application/xhtml+xml,*/*;q=0.8", contentType: "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/users", nil)
+			req.Header.Set("X-Request-ID", "req-1")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Equal(t, tt.contentType, rec.Header().Get("Content-Type"))
+			if tt.contentType != ProblemContentType {
+				assert.Equal(t, "Невалидные данные\nRequest ID: req-1\n", rec.Body.String())
+				return
+			}
+			var problem Problem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+			assert.Equal(t, Problem{
+				Type:      "about:blank",
+				Title:     "Unprocessable Entity",
+				Status:    http.StatusUnprocessableEntity,
+				Detail:    "Невалидные данные",
+				Instance:  "/users",
+				Code:      "validation_failed",
+				RequestID: "req-1",
+				Fields:    map[string][]string{"login": {"Логин не может быть пустым"}},
+			}, problem)
+		})
+	}
+}
+
+func TestWriteError_Renderer(t *testing.T) {
+	var rendered *Error
+	renderer := ErrorRenderer(func(w http.ResponseWriter, _ *http.Request, err *Error) {
+		rendered = err
+		w.WriteHeader(err.Status)
+	})
+	cause := errors.New("db is down")
+	handler := renderer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, NewError(http.StatusInternalServerError, "", cause))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.NotNil(t, rendered)
+	assert.Equal(t, "internal", rendered.Code)
+	assert.Equal(t, "Internal Server Error", rendered.Message)
+	assert.ErrorIs(t, rendered, cause)
+
+	// nested renderer overrides outer one
+	handler = renderer(ErrorRenderer(WriteProblem)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, NewError(http.StatusTeapot, "", nil))
+	})))
+	rendered = nil
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Nil(t, rendered)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"code":"error"`)
+}
+
+func TestRecoverer_ErrorRenderer(t *testing.T) {
+	var rendered *Error
+	handler := ErrorRenderer(func(w http.ResponseWriter, _ *http.Request, err *Error) {
+		rendered = err
+		w.WriteHeader(err.Status)
+	})(Recoverer()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("oh my!")
+	})))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.NotNil(t, rendered)
+	assert.EqualError(t, rendered.Cause, "panic: oh my!")
+}
@@ -0,0 +1,149 @@
+package httptools
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checker reports whether dependency of app is usable
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is checker of function, e.g. Ping of database pool
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type namedChecker struct {
+	name    string
+	checker Checker
+}
+
+type checkResult struct {
+	name string
+	err  error
+}
+
+// Health serves liveness and readiness probes. App is live while process serves requests,
+// it is ready when all checks pass and it is not shutting down.
+type Health struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	// mu also serializes runs of checks, probes which wait for it get cached results
+	mu           sync.Mutex
+	checkers     []namedChecker
+	results      []checkResult
+	checkedAt    time.Time
+	shuttingDown atomic.Bool
+}
+
+// NewHealth returns health with timeout of all checks of one probe. Results of checks are reused
+// by probes during cacheTTL, so frequent probes of several load balancers do not load dependencies.
+// Zero cacheTTL runs checks on every probe.
+func NewHealth(timeout, cacheTTL time.Duration) *Health {
+	return &Health{timeout: timeout, cacheTTL: cacheTTL}
+}
+
+// AddCheck registers checker of readiness, names are shown in response
+func (h *Health) AddCheck(name string, checker Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers = append(h.checkers, namedChecker{name: name, checker: checker})
+	h.results = nil
+}
+
+// Shutdown makes readiness fail, so load balancer stops sending requests before server is stopped
+func (h *Health) Shutdown() {
+	h.shuttingDown.Store(true)
+}
+
+const (
+	healthStatusOK           = "ok"
+	healthStatusFail         = "fail"
+	healthStatusShuttingDown = "shutting_down"
+)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Liveness responds ok while process serves requests, it does not check dependencies,
+// because restart of app does not fix them
+func (h *Health) Liveness(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: healthStatusOK})
+}
+
+// Readiness runs all checks concurrently and responds with status of each one.
+// Errors are logged only, probes are usually public and errors may reveal internals.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: healthStatusShuttingDown})
+		return
+	}
+
+	results, fresh := h.check(r.Context())
+
+	resp := healthResponse{Status: healthStatusOK, Checks: make(map[string]string, len(results))}
+	status := http.StatusOK
+	for _, res := range results {
+		if res.err != nil {
+			// cached failures were logged by probe which ran checks
+			if fresh {
+				requestLogger(r).Warn("readiness check failed", "check", res.name, "error", res.err)
+			}
+			resp.Checks[res.name] = healthStatusFail
+			resp.Status = healthStatusFail
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[res.name] = healthStatusOK
+	}
+	writeHealth(w, status, resp)
+}
+
+// check returns results of checks, fresh is false if results are taken from cache
+func (h *Health) check(ctx context.Context) (results []checkResult, fresh bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.results != nil && time.Since(h.checkedAt) < h.cacheTTL {
+		return h.results, false
+	}
+
+	// results are shared with other probes, so they do not depend on cancellation of this one
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+	defer cancel()
+
+	results = make([]checkResult, len(h.checkers))
+	var wg sync.WaitGroup
+	for i, c := range h.checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = checkResult{name: c.name, err: c.checker.Check(ctx)}
+		}()
+	}
+	wg.Wait()
+
+	h.results, h.checkedAt = results, time.Now()
+	return results, true
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("could not write health response", "error", err)
+	}
+}
@@ -0,0 +1,106 @@
+package httptools
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	health := NewHealth(50*time.Millisecond, 0)
+	dbErr := errors.New("connection refused")
+	health.AddCheck("postgres", CheckerFunc(func(context.Context) error { return dbErr }))
+	health.AddCheck("slow", CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	health.AddCheck("cache", CheckerFunc(func(context.Context) error { return nil }))
+
+	probe := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	rec := probe(health.Liveness)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+
+	rec = probe(health.Readiness)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.JSONEq(t, `{"status":"fail","checks":{"postgres":"fail","slow":"fail","cache":"ok"}}`, rec.Body.String())
+	assert.NotContains(t, rec.Body.String(), "connection refused", "errors are not exposed")
+
+	dbErr = nil
+	health = NewHealth(time.Second, 0)
+	health.AddCheck("postgres", CheckerFunc(func(context.Context) error { return dbErr }))
+	rec = probe(health.Readiness)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok","checks":{"postgres":"ok"}}`, rec.Body.String())
+
+	health.Shutdown()
+	rec = probe(health.Readiness)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"shutting_down"}`, rec.Body.String())
+	rec = probe(health.Liveness)
+	assert.Equal(t, http.StatusOK, rec.Code, "app is live while it drains requests")
+}
+
+func TestHealthCache(t *testing.T) {
+	health := NewHealth(time.Second, time.Minute)
+	var calls atomic.Int32
+	var dbErr atomic.Pointer[error]
+	health.AddCheck("postgres", CheckerFunc(func(context.Context) error {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		if err := dbErr.Load(); err != nil {
+			return *err
+		}
+		return nil
+	}))
+
+	probe := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		health.Readiness(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, probe().Code)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, calls.Load(), "concurrent probes run checks once")
+
+	err := errors.New("connection refused")
+	dbErr.Store(&err)
+	assert.Equal(t, http.StatusOK, probe().Code, "result is cached")
+	assert.EqualValues(t, 1, calls.Load())
+
+	health.mu.Lock()
+	health.checkedAt = time.Now().Add(-time.Minute)
+	health.mu.Unlock()
+	assert.Equal(t, http.StatusServiceUnavailable, probe().Code, "expired result is checked again")
+	assert.Equal(t, http.StatusServiceUnavailable, probe().Code, "failure is cached too")
+	assert.EqualValues(t, 2, calls.Load())
+
+	health.AddCheck("cache", CheckerFunc(func(context.Context) error { return nil }))
+	assert.JSONEq(t, `{"status":"fail","checks":{"postgres":"fail","cache":"ok"}}`, probe().Body.String(),
+		"new check resets cache")
+	assert.EqualValues(t, 3, calls.Load())
+
+	health.Shutdown()
+	assert.JSONEq(t, `{"status":"shutting_down"}`, probe().Body.String(), "shutdown is not cached")
+}
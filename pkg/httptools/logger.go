@@ -0,0 +1,144 @@
+package httptools
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// ContextLogger is a middleware that puts logger with request_id, route and remote_ip into request context.
+// Use it after RealIP and Trace, authentication middlewares add user_id with WithLogAttrs.
+// Pattern of route is set by ServeMux before route handler is called, so it is logged only
+// when middleware wraps route handlers, e.g. is used by routegroup.
+func ContextLogger(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithLogAttrs(r.Context(),
+			slog.String("request_id", GetTraceID(r)),
+			slog.String("route", r.Pattern),
+			slog.String("remote_ip", RemoteIP(r)),
+		)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// WithLogAttrs returns context with attributes added to logger of LoggerFromContext
+// and to records of slog.*Context calls handled by ContextHandler
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	all := append(slices.Clip(logAttrs(ctx)), attrs...)
+	ctx = context.WithValue(ctx, contextKey("logAttrs"), all)
+	return context.WithValue(ctx, contextKey("logger"), newContextLogger(all))
+}
+
+// LoggerFromContext returns logger with attributes of context, or default logger if there are none
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey("logger")).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func logAttrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(contextKey("logAttrs")).([]slog.Attr)
+	return attrs
+}
+
+// newContextLogger binds attributes to handler of default logger, so ContextHandler does not add them twice
+func newContextLogger(attrs []slog.Attr) *slog.Logger {
+	h := slog.Default().Handler()
+	if ch, ok := h.(*ContextHandler); ok {
+		next := ch.withTopLevelAttrs(attrs)
+		return slog.New(&ContextHandler{next: next, root: next, bound: true})
+	}
+	return slog.New(h.WithAttrs(attrs))
+}
+
+// requestLogger is logger of request context, request id is added if context has no logger,
+// e.g. ContextLogger is not used
+func requestLogger(r *http.Request) *slog.Logger {
+	if _, ok := r.Context().Value(contextKey("logger")).(*slog.Logger); ok {
+		return LoggerFromContext(r.Context())
+	}
+	return slog.Default().With("request_id", GetTraceID(r))
+}
+
+// ContextHandler adds attributes of context to records, so slog.InfoContext(r.Context(), ...)
+// has request_id and user_id without passing logger around.
+// Attributes of context stay at top level when logger has groups, e.g. logger.WithGroup("db").InfoContext(ctx, ...).
+type ContextHandler struct {
+	next slog.Handler
+	// root is next before the first group, groups and attributes added after it are replayed
+	// over root with attributes of context
+	root    slog.Handler
+	grouped []handlerOp
+	// bound is set for handlers of LoggerFromContext, they have attributes of context already
+	bound bool
+}
+
+// handlerOp is WithGroup call if group is set, WithAttrs call otherwise
+type handlerOp struct {
+	group string
+	attrs []slog.Attr
+}
+
+func NewContextHandler(next slog.Handler) *ContextHandler {
+	return &ContextHandler{next: next, root: next}
+}
+
+func (h *ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	attrs := logAttrs(ctx)
+	if h.bound || len(attrs) == 0 {
+		return h.next.Handle(ctx, rec)
+	}
+	if len(h.grouped) == 0 {
+		rec = rec.Clone()
+		rec.AddAttrs(attrs...)
+		return h.next.Handle(ctx, rec)
+	}
+
+	return h.withTopLevelAttrs(attrs).Handle(ctx, rec)
+}
+
+// withTopLevelAttrs returns next with attributes added before groups are opened,
+// attributes of record and of WithAttrs after group go to the last group
+func (h *ContextHandler) withTopLevelAttrs(attrs []slog.Attr) slog.Handler {
+	next := h.root.WithAttrs(attrs)
+	for _, op := range h.grouped {
+		if op.group != "" {
+			next = next.WithGroup(op.group)
+		} else {
+			next = next.WithAttrs(op.attrs)
+		}
+	}
+	return next
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	res := &ContextHandler{next: h.next.WithAttrs(attrs), root: h.root, grouped: h.grouped, bound: h.bound}
+	if len(h.grouped) == 0 {
+		res.root = res.next
+	} else {
+		res.grouped = append(slices.Clip(h.grouped), handlerOp{attrs: attrs})
+	}
+	return res
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &ContextHandler{
+		next:    h.next.WithGroup(name),
+		root:    h.root,
+		grouped: append(slices.Clip(h.grouped), handlerOp{group: name}),
+		bound:   h.bound,
+	}
+}
@@ -0,0 +1,82 @@
+package httptools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextLogger(t *testing.T) {
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	buf := new(bytes.Buffer)
+	slog.SetDefault(slog.New(NewContextHandler(slog.NewJSONHandler(buf, nil))))
+
+	// authentication middleware adds user
+	authenticated := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithLogAttrs(r.Context(), slog.Int64("user_id", 7))))
+		})
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", Trace(ContextLogger(authenticated(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		slog.InfoContext(ctx, "context call")
+		LoggerFromContext(ctx).Info("logger call")
+		LoggerFromContext(ctx).InfoContext(ctx, "logger context call")
+		slog.Info("global call")
+	})))))
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req.RemoteAddr = "192.0.2.7:1234"
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	for _, line := range lines[:3] {
+		assert.Equal(t, 1, strings.Count(line, `"request_id"`), "attributes are not duplicated: %s", line)
+		var rec map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		assert.Equal(t, "req-1", rec["request_id"])
+		assert.Equal(t, "GET /users/{id}", rec["route"])
+		assert.Equal(t, "192.0.2.7", rec["remote_ip"])
+		assert.EqualValues(t, 7, rec["user_id"])
+	}
+	assert.NotContains(t, lines[3], "request_id", "calls without context are not enriched")
+
+	assert.Same(t, slog.Default(), LoggerFromContext(context.Background()))
+}
+
+func TestContextHandlerGroups(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := slog.New(NewContextHandler(slog.NewJSONHandler(buf, nil))).With("app", "admin")
+	ctx := WithLogAttrs(context.Background(), slog.String("request_id", "req-1"), slog.Int64("user_id", 7))
+
+	logger.WithGroup("db").With("table", "users").WithGroup("query").InfoContext(ctx, "done", "rows", 3)
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "admin", rec["app"])
+	assert.Equal(t, "req-1", rec["request_id"], "context attributes are at top level")
+	assert.EqualValues(t, 7, rec["user_id"])
+	assert.Equal(t, map[string]any{"table": "users", "query": map[string]any{"rows": float64(3)}}, rec["db"])
+
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	slog.SetDefault(logger)
+	buf.Reset()
+	LoggerFromContext(WithLogAttrs(context.Background(), slog.Int64("user_id", 7))).WithGroup("db").InfoContext(ctx, "done", "rows", 3)
+	rec = nil
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.EqualValues(t, 7, rec["user_id"])
+	assert.Nil(t, rec["request_id"], "attributes of bound logger are not added again from context")
+	assert.Equal(t, map[string]any{"rows": float64(3)}, rec["db"])
+}
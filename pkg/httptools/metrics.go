@@ -0,0 +1,41 @@
+package httptools
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/agalitsyn/goth/pkg/metrics"
+)
+
+// Metrics is a middleware that records count, latency and in-flight requests labelled by route pattern,
+// raw uri would make a series per id in path. Pattern is set by ServeMux before route handler is called,
+// so the middleware must wrap route handlers, e.g. be used by routegroup, not wrap the mux itself.
+func Metrics(reg *metrics.Registry) func(http.Handler) http.Handler {
+	requests := metrics.NewCounterVec("http_requests_total",
+		"Count of finished HTTP requests.", "method", "route", "code")
+	duration := metrics.NewHistogramVec("http_request_duration_seconds",
+		"Latency of HTTP requests.", metrics.DefBuckets, "method", "route")
+	inFlight := metrics.NewGaugeVec("http_requests_in_flight",
+		"Count of HTTP requests being served.", "route")
+	reg.MustRegister(requests, duration, inFlight)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			route := r.Pattern
+			if route == "" {
+				route = "unmatched"
+			}
+			inFlight.Inc(route)
+			defer inFlight.Dec(route)
+
+			startTime := time.Now()
+			rw := &responseWriter{w, http.StatusOK}
+			next.ServeHTTP(rw, r)
+
+			requests.Inc(r.Method, route, strconv.Itoa(rw.statusCode))
+			duration.Observe(time.Since(startTime).Seconds(), r.Method, route)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,36 @@
+package httptools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/agalitsyn/goth/pkg/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	mw := Metrics(reg)
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "0" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})))
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/0"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var b strings.Builder
+	assert.NoError(t, metrics.WriteText(&b, reg.Gather()))
+	text := b.String()
+	assert.Contains(t, text, `http_requests_total{method="GET",route="GET /users/{id}",code="200"} 2`)
+	assert.Contains(t, text, `http_requests_total{method="GET",route="GET /users/{id}",code="404"} 1`)
+	assert.Contains(t, text, `http_request_duration_seconds_count{method="GET",route="GET /users/{id}"} 3`)
+	assert.Contains(t, text, `http_requests_in_flight{route="GET /users/{id}"} 0`)
+	assert.NotContains(t, text, "/users/1", "raw paths are not labels")
+}
@@ -1,12 +1,11 @@
 package httptools
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"runtime/debug"
 	"strings"
 	"time"
@@ -60,20 +59,21 @@ func RequestLogger(ignoredPaths []string) func(http.Handler) http.Handler {
 	}
 }
 
-// Recoverer is a middleware that recovers from panic and log it
+// Recoverer is a middleware that recovers from panic, logs it and responds with internal server error
 func Recoverer() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if rvr := recover(); rvr != nil {
-					slog.Info("request panic",
+					slog.InfoContext(r.Context(), "request panic",
 						"remote_addr", r.RemoteAddr,
 						"user_agent", r.UserAgent(),
 						"error", rvr)
 					if rvr != http.ErrAbortHandler {
-						slog.Info("request panic", "stack", string(debug.Stack()))
+						slog.InfoContext(r.Context(), "request panic", "stack", string(debug.Stack()))
 					}
-					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					// panic is logged above with stack, so error is rendered without logging it again
+					renderError(w, r, NewError(http.StatusInternalServerError, "", fmt.Errorf("panic: %v", rvr)))
 				}
 			}()
 
@@ -112,52 +112,107 @@ var (
 	xRealIP       = http.CanonicalHeaderKey("X-Real-IP")
 )
 
+// ParseTrustedProxies parses IPs and CIDRs of reverse proxies, empty values are skipped
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	var res []netip.Prefix
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.Contains(v, "/") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+			}
+			res = append(res, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+		}
+		res = append(res, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return res, nil
+}
+
 // RealIP is a middleware that sets a http.Request's RemoteAddr to the results
 // of parsing either the True-Client-IP, X-Real-IP or the X-Forwarded-For headers
 // (in that order).
 //
+// Headers are honoured only when request comes from one of trusted proxies,
+// otherwise any client could spoof its address and bypass rate limits and login audit.
+// X-Forwarded-For is read from the right, addresses of trusted proxies are skipped.
+//
 // This middleware should be inserted fairly early in the middleware stack to
 // ensure that subsequent layers (e.g., request loggers) which examine the
 // RemoteAddr will see the intended value.
-//
-// You should only use this middleware if you can trust the headers passed to
-// you (in particular, the two headers this middleware uses), for example
-// because you have placed a reverse proxy like HAProxy or nginx in front of
-// chi. If your reverse proxies are configured to pass along arbitrary header
-// values from the client, or if you use this middleware without a reverse
-// proxy, malicious clients will be able to make you very sad (or, depending on
-// how you're using RemoteAddr, vulnerable to an attack of some sort).
-func RealIP(h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		if rip := realIP(r); rip != "" {
-			r.RemoteAddr = rip
+func RealIP(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if isTrustedProxy(remoteAddr(r.RemoteAddr), trustedProxies) {
+				if rip := realIP(r, trustedProxies); rip != "" {
+					r.RemoteAddr = rip
+				}
+			}
+			h.ServeHTTP(w, r)
 		}
-		h.ServeHTTP(w, r)
+		return http.HandlerFunc(fn)
 	}
-
-	return http.HandlerFunc(fn)
 }
 
-func realIP(r *http.Request) string {
-	var ip string
+func realIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	for _, header := range []string{trueClientIP, xRealIP} {
+		if v := strings.TrimSpace(r.Header.Get(header)); v != "" {
+			if addr, err := netip.ParseAddr(v); err == nil {
+				return addr.Unmap().String()
+			}
+			return ""
+		}
+	}
 
-	if tcip := r.Header.Get(trueClientIP); tcip != "" {
-		ip = tcip
-	} else if xrip := r.Header.Get(xRealIP); xrip != "" {
-		ip = xrip
-	} else if xff := r.Header.Get(xForwardedFor); xff != "" {
-		i := strings.Index(xff, ",")
-		if i == -1 {
-			i = len(xff)
+	// every proxy appends address of its peer, so only the part added by trusted proxies is reliable
+	var ip string
+	hops := strings.Split(strings.Join(r.Header.Values(xForwardedFor), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
 		}
-		ip = xff[:i]
+		ip = addr.Unmap().String()
+		if !isTrustedProxy(addr, trustedProxies) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteAddr parses host of RemoteAddr, it has no port if it was set by RealIP before
+func remoteAddr(addr string) netip.Addr {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
 	}
-	if ip == "" || net.ParseIP(ip) == nil {
-		return ""
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}
 	}
 	return ip
 }
 
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // AppInfo adds custom app-info to the response header
 func AppInfo(app, version string) func(http.Handler) http.Handler {
 	f := func(h http.Handler) http.Handler {
@@ -170,28 +225,3 @@ func AppInfo(app, version string) func(http.Handler) http.Handler {
 	}
 	return f
 }
-
-func generateCSRFToken() string {
-	b := make([]byte, 32)
-	rand.Read(b)
-	return base64.StdEncoding.EncodeToString(b)
-}
-
-func CSRFMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Generate CSRF token and store it in a cookie
-		token := generateCSRFToken()
-		http.SetCookie(w, &http.Cookie{
-			Name:     "csrf",
-			Value:    token,
-			HttpOnly: true,
-			MaxAge:   24 * int(time.Hour),
-			SameSite: http.SameSiteLaxMode,
-		})
-
-		// Set CSRF token in the form
-		r.Header.Set("X-CSRF-Token", token)
-
-		next.ServeHTTP(w, r)
-	})
-}
@@ -54,3 +54,72 @@ func TestMiddleware_Recoverer(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "blah blah", string(b))
 }
+
+func TestMiddleware_RealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.1", ""})
+	require.NoError(t, err)
+	handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "untrusted client can't spoof address",
+			remoteAddr: "203.0.113.5:1234",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1", "X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.5:1234",
+		},
+		{
+			name:       "true client ip from proxy",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"True-Client-IP": "198.51.100.1", "X-Real-IP": "198.51.100.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "real ip from proxy",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.2"},
+			want:       "198.51.100.2",
+		},
+		{
+			name:       "forwarded for skips trusted proxies from the right",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.3, 10.0.0.7"},
+			want:       "198.51.100.3",
+		},
+		{
+			name:       "forwarded for of trusted proxies only",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.8, 10.0.0.7"},
+			want:       "10.0.0.8",
+		},
+		{
+			name:       "invalid header is ignored",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"X-Real-IP": "blah"},
+			want:       "10.1.2.3:1234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Body.String())
+		})
+	}
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"localhost"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+package httptools
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// NegotiateContentType returns offered media type most preferred by Accept header of request.
+// On equal quality offer matched by more specific media range wins, e.g. application/json for "application/json, */*".
+// The first offer is returned when Accept is empty or nothing is better.
+func NegotiateContentType(r *http.Request, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQ, bestSpecificity := offers[0], 0.0, -1
+	for _, offer := range offers {
+		q, specificity := acceptQuality(accept, offer)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
+
+// acceptQuality returns quality and specificity of the most specific media range matching media type
+// (RFC 9110, section 12.5.1), specificity is -1 if nothing matches
+func acceptQuality(accept, mediaType string) (float64, int) {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rng, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		rngType, rngSubtype, _ := strings.Cut(rng, "/")
+
+		var s int
+		switch {
+		case rngType == typ && rngSubtype == subtype:
+			s = 2
+		case rngType == typ && rngSubtype == "*":
+			s = 1
+		case rngType == "*" && rngSubtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q, specificity
+}
@@ -0,0 +1,37 @@
+package httptools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"text/html", "application/json"}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: "text/html"},
+		{accept: "*/*", want: "text/html"},
+		{accept: "application/json", want: "application/json"},
+		{accept: "application/json, text/plain, */*", want: "application/json"},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: "text/html"},
+		{accept: "text/html;q=0.5, application/json", want: "application/json"},
+		{accept: "application/*", want: "application/json"},
+		{accept: "application/json;q=0, */*", want: "text/html"},
+		{accept: "image/png", want: "text/html"},
+		{accept: "application/json, text/html", want: "text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			assert.Equal(t, tt.want, NegotiateContentType(r, offers...))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package httptools
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter is an in-memory token bucket limiter keyed by arbitrary string.
+// Each key may burst up to limit requests and regains tokens evenly during period.
+type RateLimiter struct {
+	limit  float64
+	period time.Duration
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func NewRateLimiter(limit int, period time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   float64(limit),
+		period:  period,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from key's bucket. When bucket is empty it returns false and
+// time after which next request will be allowed.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.cleanup(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.limit, updatedAt: now}
+		l.buckets[key] = b
+	}
+
+	refillRate := l.limit / l.period.Seconds()
+	b.tokens = math.Min(l.limit, b.tokens+now.Sub(b.updatedAt).Seconds()*refillRate)
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / refillRate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup drops buckets which were refilled completely, they are equal to absent ones
+func (l *RateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < l.period {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updatedAt) >= l.period {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
+
+type RateLimitConfig struct {
+	// Requests is allowed count of requests per Period for a single key, zero disables limiting
+	Requests int
+	Period   time.Duration
+
+	// KeyFunc returns bucket key for request, client IP is used by default
+	KeyFunc func(r *http.Request) string
+
+	// LimitHandler is called after Retry-After header is set.
+	// Responds with 429 Too Many Requests by WriteError by default.
+	LimitHandler http.HandlerFunc
+}
+
+// RateLimit is a middleware that limits requests rate per client.
+// Use it after RealIP to key on real client address behind proxy.
+// Each call creates its own limiter, so route groups can have independent limits.
+func RateLimit(cfg RateLimitConfig) func(http.Handler) http.Handler {
+	if cfg.Requests <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = RemoteIP
+	}
+	if cfg.LimitHandler == nil {
+		cfg.LimitHandler = func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, NewError(http.StatusTooManyRequests, "", nil))
+		}
+	}
+	limiter := NewRateLimiter(cfg.Requests, cfg.Period)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if ok, retryAfter := limiter.Allow(cfg.KeyFunc(r)); !ok {
+				SetRetryAfter(w, retryAfter)
+				cfg.LimitHandler(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// SetRetryAfter sets Retry-After header rounded up to whole seconds
+func SetRetryAfter(w http.ResponseWriter, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// RemoteIP returns request's remote address without port
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// RealIP middleware sets bare IP without port
+		return r.RemoteAddr
+	}
+	return host
+}
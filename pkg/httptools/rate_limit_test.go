@@ -0,0 +1,63 @@
+package httptools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	ok, _ := limiter.Allow("a")
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("a")
+	assert.True(t, ok)
+
+	ok, retryAfter := limiter.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	// other keys have own buckets
+	ok, _ = limiter.Allow("b")
+	assert.True(t, ok)
+
+	now = now.Add(30 * time.Second)
+	ok, _ = limiter.Allow("a")
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("a")
+	assert.False(t, ok)
+
+	// idle buckets are removed
+	now = now.Add(2 * time.Minute)
+	ok, _ = limiter.Allow("a")
+	assert.True(t, ok)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestMiddleware_RateLimit(t *testing.T) {
+	h := RateLimit(RateLimitConfig{Requests: 1, Period: time.Minute})(getTestHandlerBlah())
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1234").Code)
+
+	// same IP from other port is limited
+	rec := do("10.0.0.1:4321")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// address set by RealIP has no port
+	assert.Equal(t, http.StatusOK, do("10.0.0.2").Code)
+}
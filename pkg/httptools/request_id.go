@@ -2,34 +2,86 @@ package httptools
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha1" //nolint:gosec //not used for cryptography
-	"encoding/hex"
-	"fmt"
 	"net/http"
-	"time"
+	"strings"
+
+	"github.com/agalitsyn/goth/pkg/tracing"
 )
 
 type contextKey string
 
 const traceHeader = "X-Request-ID"
 
-// Trace looks for header X-Request-ID and makes it as random id if not found, then populates it to the result's header
-// and to request context
+// Trace starts server span of request, it continues trace of W3C traceparent header if there is one.
+// Request id is taken from header X-Request-ID or is id of trace if not found, so logs could be found by trace,
+// then it is populated to the result's header and to request context.
+// Headers come from client, so malformed traceparent starts new trace and malformed X-Request-ID is replaced
+// by id of trace, request id is written to logs and audit tables as is.
+// Pattern of route is set by ServeMux before route handler is called, so span is named by it only
+// when middleware wraps route handlers, e.g. is used by routegroup.
 func Trace(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); err == nil {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+		ctx, span := tracing.Start(ctx, spanName(r), tracing.WithKind(tracing.SpanKindServer),
+			tracing.WithAttributes(
+				tracing.Attr("http.request.method", r.Method),
+				tracing.Attr("url.path", r.URL.Path),
+				tracing.Attr("http.route", r.Pattern),
+				tracing.Attr("user_agent.original", r.UserAgent()),
+			),
+		)
+		defer span.End()
+
 		traceID := r.Header.Get(traceHeader)
-		if traceID == "" {
-			traceID = randToken()
+		if !validRequestID(traceID) {
+			traceID = span.SpanContext().TraceID.String()
 		}
+		span.SetAttributes(tracing.Attr("request_id", traceID))
 		w.Header().Set(traceHeader, traceID)
-		ctx := context.WithValue(r.Context(), contextKey("requestID"), traceID)
-		r = r.WithContext(ctx)
-		next.ServeHTTP(w, r)
+		ctx = context.WithValue(ctx, contextKey("requestID"), traceID)
+
+		rw := &responseWriter{w, http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(tracing.Attr("http.response.status_code", rw.statusCode))
+		if rw.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(rw.statusCode))
+		}
 	}
 	return http.HandlerFunc(fn)
 }
 
+// maxRequestIDLength fits UUIDs and ids of common proxies and load balancers
+const maxRequestIDLength = 128
+
+// validRequestID allows only printable ASCII without spaces and quotes
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// spanName is route pattern prefixed with method, as OpenTelemetry conventions recommend
+func spanName(r *http.Request) string {
+	switch {
+	case r.Pattern == "":
+		return r.Method
+	case strings.Contains(r.Pattern, " "):
+		return r.Pattern
+	default:
+		return r.Method + " " + r.Pattern
+	}
+}
+
 // GetTraceID returns request id from the context
 func GetTraceID(r *http.Request) string {
 	if id, ok := r.Context().Value(contextKey("requestID")).(string); ok {
@@ -37,12 +89,3 @@ func GetTraceID(r *http.Request) string {
 	}
 	return ""
 }
-
-func randToken() string {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return fmt.Sprintf("%x", time.Now().Nanosecond())
-	}
-	sum := sha1.Sum(b) //nolint:gosec //not used for cryptography
-	return hex.EncodeToString(sum[:])
-}
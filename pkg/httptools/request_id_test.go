@@ -4,10 +4,14 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/agalitsyn/goth/pkg/tracing"
 )
 
 func TestTraceNoID(t *testing.T) {
@@ -62,3 +66,53 @@ func TestTraceWithID(t *testing.T) {
 	t.Logf("headers - %+v", res.Header)
 	assert.Equal(t, "123456", traceHeader, "passing original trace-id")
 }
+
+func TestTraceTraceparent(t *testing.T) {
+	var span *tracing.Span
+	handler := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		span = tracing.SpanFromContext(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/something", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	Trace(handler).ServeHTTP(rec, req)
+
+	require.NotNil(t, span)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID.String())
+	assert.NotEqual(t, "00f067aa0ba902b7", span.SpanContext().SpanID.String())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rec.Header().Get("X-Request-ID"), "trace id is request id")
+
+	// malformed header starts new trace
+	req.Header.Set("traceparent", "garbage")
+	rec = httptest.NewRecorder()
+	Trace(handler).ServeHTTP(rec, req)
+	assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID.String())
+	assert.Equal(t, span.SpanContext().TraceID.String(), rec.Header().Get("X-Request-ID"))
+}
+
+func TestTraceMalformedRequestID(t *testing.T) {
+	handler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	for _, id := range []string{
+		"req 1",
+		"req\n1",
+		`req"1`,
+		"запрос",
+		strings.Repeat("x", maxRequestIDLength+1),
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/something", nil)
+		req.Header.Set("X-Request-ID", id)
+		rec := httptest.NewRecorder()
+		Trace(handler).ServeHTTP(rec, req)
+
+		got := rec.Header().Get("X-Request-ID")
+		assert.Len(t, got, 32, "id of trace replaces %q", id)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/something", nil)
+	req.Header.Set("X-Request-ID", "Root=1-67891233-abcdef012345678912345678")
+	rec := httptest.NewRecorder()
+	Trace(handler).ServeHTTP(rec, req)
+	assert.Equal(t, "Root=1-67891233-abcdef012345678912345678", rec.Header().Get("X-Request-ID"))
+}
@@ -9,6 +9,8 @@ import (
 	"log/slog"
 	"net/http"
 	"path/filepath"
+
+	"github.com/agalitsyn/goth/pkg/tracing"
 )
 
 type TemplateRenderer struct {
@@ -21,32 +23,47 @@ func NewTemplateRenderer(templatesCache map[string]*template.Template) *Template
 	}
 }
 
-func (s *TemplateRenderer) Render(w http.ResponseWriter, status int, template, block string, data any) {
+// Render writes block of template, failures are answered by DefaultErrorHandler,
+// because error handler of request may render templates too and fail the same way
+func (s *TemplateRenderer) Render(w http.ResponseWriter, r *http.Request, status int, template, block string, data any) {
+	_, span := tracing.Start(r.Context(), "render "+template, tracing.WithAttributes(
+		tracing.Attr("template.name", template),
+		tracing.Attr("template.block", block),
+	))
+	defer span.End()
+
 	ts, ok := s.cache[template]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", template)
-		slog.Error("could not fetch template", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		span.RecordError(err)
+		s.error(w, r, err)
 		return
 	}
 
 	buf := new(bytes.Buffer)
 	err := ts.ExecuteTemplate(buf, block, data)
 	if err != nil {
-		slog.Error("could not execute template", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		span.RecordError(err)
+		s.error(w, r, fmt.Errorf("could not execute template %s: %w", template, err))
 		return
 	}
+	span.SetAttributes(tracing.Attr("template.size", buf.Len()))
 
 	w.WriteHeader(status)
 	_, err = buf.WriteTo(w)
 	if err != nil {
-		slog.Error("could not write template content", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		// headers are sent already, so there is nothing to respond
+		slog.ErrorContext(r.Context(), "could not write template content", "error", err)
 		return
 	}
 }
 
+func (s *TemplateRenderer) error(w http.ResponseWriter, r *http.Request, err error) {
+	e := NewError(http.StatusInternalServerError, "", err)
+	LogError(r, e)
+	DefaultErrorHandler(w, r, e)
+}
+
 func NewTemplateCache(
 	embedFiles embed.FS,
 	templatesFolder string,
@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests check output against Prometheus text format 0.0.4 specification,
+// see https://prometheus.io/docs/instrumenting/exposition_formats/#text-format-details.
+// There is no scraper in vendored dependencies, so output is read by parser written from the specification.
+
+type parsedSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+type parsedFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples []parsedSample
+}
+
+// parseText parses exposition and fails on anything which specification does not allow
+func parseText(t *testing.T, text string) []parsedFamily {
+	t.Helper()
+
+	require.True(t, strings.HasSuffix(text, "\n"), "last line ends with line feed")
+	var families []parsedFamily
+	seen := map[string]bool{}
+	series := map[string]bool{}
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		line := sc.Text()
+		require.True(t, utf8.ValidString(line), "line is valid UTF-8: %q", line)
+
+		if rest, ok := strings.CutPrefix(line, "# HELP "); ok {
+			name, help, _ := strings.Cut(rest, " ")
+			require.Regexp(t, metricNameRe, name)
+			require.False(t, seen[name], "family %s is written once", name)
+			seen[name] = true
+			families = append(families, parsedFamily{name: name, help: unescape(t, help, false)})
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, typ, _ := strings.Cut(rest, " ")
+			require.NotEmpty(t, families)
+			f := &families[len(families)-1]
+			require.Equal(t, f.name, name, "TYPE follows HELP of the same family")
+			require.Empty(t, f.samples, "TYPE is before samples")
+			require.Contains(t, []string{"counter", "gauge", "histogram", "summary", "untyped"}, typ)
+			f.typ = typ
+			continue
+		}
+		require.False(t, strings.HasPrefix(line, "#"), "unknown comment %q", line)
+
+		require.NotEmpty(t, families, "sample belongs to family")
+		f := &families[len(families)-1]
+		s := parseSample(t, line)
+		switch f.typ {
+		case "histogram":
+			require.Contains(t, []string{f.name + "_bucket", f.name + "_sum", f.name + "_count"}, s.name)
+		default:
+			require.Equal(t, f.name, s.name)
+		}
+		key := s.name + fmt.Sprint(sortedLabels(s.labels))
+		require.False(t, series[key], "series %s is unique", key)
+		series[key] = true
+		f.samples = append(f.samples, s)
+	}
+	require.NoError(t, sc.Err())
+	return families
+}
+
+func parseSample(t *testing.T, line string) parsedSample {
+	t.Helper()
+
+	s := parsedSample{labels: map[string]string{}}
+	i := strings.IndexAny(line, "{ ")
+	require.Positive(t, i, "sample %q has name", line)
+	s.name = line[:i]
+	require.Regexp(t, metricNameRe, s.name)
+	rest := line[i:]
+
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for !strings.HasPrefix(rest, "}") {
+			name, after, ok := strings.Cut(rest, `="`)
+			require.True(t, ok, "label in %q", line)
+			require.Regexp(t, labelNameRe, name)
+			value, n := readLabelValue(t, after)
+			_, dup := s.labels[name]
+			require.False(t, dup, "label %s is unique", name)
+			s.labels[name] = value
+			rest = after[n:]
+			rest = strings.TrimPrefix(rest, ",")
+		}
+		rest = rest[1:]
+	}
+
+	fields := strings.Fields(rest)
+	require.Len(t, fields, 1, "sample %q has value without timestamp", line)
+	switch fields[0] {
+	case "+Inf":
+		s.value = math.Inf(1)
+	case "-Inf":
+		s.value = math.Inf(-1)
+	case "NaN":
+		s.value = math.NaN()
+	default:
+		v, err := strconv.ParseFloat(fields[0], 64)
+		require.NoError(t, err)
+		s.value = v
+	}
+	return s
+}
+
+// readLabelValue returns unescaped value and length of quoted value with closing quote
+func readLabelValue(t *testing.T, s string) (string, int) {
+	t.Helper()
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return unescape(t, s[:i], true), i + 1
+		}
+	}
+	t.Fatalf("label value %q is not closed", s)
+	return "", 0
+}
+
+// unescape decodes escapes of help or label value, only \\, \n and \" in label values are allowed
+func unescape(t *testing.T, s string, quoted bool) string {
+	t.Helper()
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\n' {
+			t.Fatalf("raw line feed in %q", s)
+		}
+		if c == '"' && quoted {
+			t.Fatalf("unescaped quote in %q", s)
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		require.Less(t, i+1, len(s), "escape at the end of %q", s)
+		i++
+		switch {
+		case s[i] == '\\':
+			b.WriteByte('\\')
+		case s[i] == 'n':
+			b.WriteByte('\n')
+		case s[i] == '"' && quoted:
+			b.WriteByte('"')
+		default:
+			t.Fatalf("unknown escape \\%c in %q", s[i], s)
+		}
+	}
+	return b.String()
+}
+
+func sortedLabels(labels map[string]string) []string {
+	res := make([]string, 0, len(labels))
+	for k, v := range labels {
+		res = append(res, k+"="+v)
+	}
+	slices.Sort(res)
+	return res
+}
+
+func gather(t *testing.T, cs ...Collector) []parsedFamily {
+	t.Helper()
+
+	reg := NewRegistry()
+	reg.MustRegister(cs...)
+	var b strings.Builder
+	require.NoError(t, WriteText(&b, reg.Gather()))
+	return parseText(t, b.String())
+}
+
+func TestTextEscaping(t *testing.T) {
+	values := []string{
+		`back\slash`,
+		`"quoted"`,
+		"line\nfeed",
+		`\n is not a line feed`,
+		"юникод ✓",
+		"invalid \xff utf-8",
+		`trailing backslash\`,
+		"",
+	}
+	c := NewCounterVec("escaping_total", "Help with \\ backslash,\nline feed and \"quotes\".", "value")
+	for _, v := range values {
+		c.Inc(v)
+	}
+
+	families := gather(t, c)
+	require.Len(t, families, 1)
+	f := families[0]
+	assert.Equal(t, "Help with \\ backslash,\nline feed and \"quotes\".", f.help)
+	assert.Equal(t, "counter", f.typ)
+
+	var got []string
+	for _, s := range f.samples {
+		got = append(got, s.labels["value"])
+		assert.Equal(t, float64(1), s.value)
+	}
+	want := slices.Clone(values)
+	want[5] = "invalid � utf-8"
+	assert.ElementsMatch(t, want, got)
+}
+
+func TestTextFloatValues(t *testing.T) {
+	g := NewGaugeVec("values", "Values.", "name")
+	for name, v := range map[string]float64{
+		"inf":     math.Inf(1),
+		"neg_inf": math.Inf(-1),
+		"nan":     math.NaN(),
+		"big":     1e300,
+		"small":   -1.5e-12,
+	} {
+		g.Set(v, name)
+	}
+
+	families := gather(t, g)
+	require.Len(t, families, 1)
+	got := map[string]float64{}
+	for _, s := range families[0].samples {
+		got[s.labels["name"]] = s.value
+	}
+	assert.True(t, math.IsInf(got["inf"], 1))
+	assert.True(t, math.IsInf(got["neg_inf"], -1))
+	assert.True(t, math.IsNaN(got["nan"]))
+	assert.Equal(t, 1e300, got["big"])
+	assert.Equal(t, -1.5e-12, got["small"])
+}
+
+func TestHistogramSemantics(t *testing.T) {
+	// unsorted, duplicated and +Inf bounds are normalized
+	h := NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1, 1, math.Inf(1), 0.5}, "path")
+	observations := []float64{0.1, 0.1000001, 0.5, 1, 1.5, -1, math.Inf(1), math.NaN()}
+	for _, v := range observations {
+		h.Observe(v, "/")
+	}
+	h.Observe(0.2, "/other")
+
+	families := gather(t, h)
+	require.Len(t, families, 1)
+	f := families[0]
+	assert.Equal(t, "histogram", f.typ)
+
+	type series struct {
+		bounds  []float64
+		buckets []float64
+		sum     float64
+		count   float64
+	}
+	byPath := map[string]*series{}
+	for _, s := range f.samples {
+		path := s.labels["path"]
+		if byPath[path] == nil {
+			byPath[path] = &series{}
+		}
+		hs := byPath[path]
+		switch s.name {
+		case "latency_seconds_bucket":
+			le, ok := s.labels["le"]
+			require.True(t, ok, "bucket has le label")
+			bound, err := strconv.ParseFloat(le, 64)
+			require.NoError(t, err)
+			hs.bounds = append(hs.bounds, bound)
+			hs.buckets = append(hs.buckets, s.value)
+		case "latency_seconds_sum":
+			hs.sum = s.value
+		case "latency_seconds_count":
+			hs.count = s.value
+		}
+	}
+	require.Len(t, byPath, 2)
+
+	for path, hs := range byPath {
+		assert.True(t, slices.IsSorted(hs.bounds), "%s: bounds are ascending", path)
+		assert.Equal(t, len(hs.bounds), len(slices.Compact(slices.Clone(hs.bounds))), "%s: bounds are unique", path)
+		assert.True(t, math.IsInf(hs.bounds[len(hs.bounds)-1], 1), "%s: the last bucket is +Inf", path)
+		assert.True(t, slices.IsSorted(hs.buckets), "%s: buckets are cumulative", path)
+		assert.Equal(t, hs.count, hs.buckets[len(hs.buckets)-1], "%s: +Inf bucket equals count", path)
+	}
+
+	root := byPath["/"]
+	assert.Equal(t, []float64{0.1, 0.5, 1, math.Inf(1)}, root.bounds)
+	// bucket counts observations less than or equal to its bound
+	assert.Equal(t, []float64{2, 4, 5, 8}, root.buckets)
+	assert.Equal(t, float64(len(observations)), root.count)
+	assert.True(t, math.IsNaN(root.sum), "sum includes NaN as Prometheus client does")
+
+	other := byPath["/other"]
+	assert.Equal(t, []float64{0, 1, 1, 1}, other.buckets)
+	assert.Equal(t, 0.2, other.sum)
+}
+
+func TestInvalidNames(t *testing.T) {
+	assert.Panics(t, func() { NewCounterVec("requests-total", "") })
+	assert.Panics(t, func() { NewCounterVec("1requests", "") })
+	assert.Panics(t, func() { NewGaugeVec("in_flight", "", "http.method") })
+	assert.Panics(t, func() { NewGaugeVec("in_flight", "", "__name") })
+	assert.Panics(t, func() { NewHistogramVec("latency_seconds", "", DefBuckets, "le") })
+	assert.NotPanics(t, func() { NewCounterVec("namespace:requests_total", "", "_code") })
+}
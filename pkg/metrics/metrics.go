@@ -0,0 +1,283 @@
+// Package metrics collects counters, gauges and histograms and exposes them in Prometheus text format.
+//
+// It is a small subset of prometheus/client_golang written with the standard library only,
+// because the module is built from vendored dependencies and the client with its protobuf
+// and common/expfmt dependencies is not among them. Only text format 0.0.4 is served,
+// it is accepted by every Prometheus version and by compatible scrapers.
+// Escaping, naming rules and histogram semantics follow the format specification,
+// see https://prometheus.io/docs/instrumenting/exposition_formats/, conformance_test.go checks them.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+)
+
+// DefBuckets are latency buckets in seconds, the same as Prometheus client uses
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	// Suffix is appended to family name, e.g. _bucket of histogram
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Family is metric with all its samples
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Collector returns current values of metrics
+type Collector interface {
+	Collect() []Family
+}
+
+// CollectorFunc reads values on scrape, e.g. stats of connection pool
+type CollectorFunc func() []Family
+
+func (f CollectorFunc) Collect() []Family {
+	return f()
+}
+
+// series is set of values keyed by label values
+type series[T any] struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*T
+	keys   map[string][]string
+}
+
+// newSeries panics on names which Prometheus rejects, names are constants so it happens on start
+func newSeries[T any](name, help string, labels []string) series[T] {
+	if !metricNameRe.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !labelNameRe.MatchString(l) || strings.HasPrefix(l, "__") {
+			panic(fmt.Sprintf("metrics: %s has invalid label name %q", name, l))
+		}
+	}
+	return series[T]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*T),
+		keys:   make(map[string][]string),
+	}
+}
+
+// get returns value of label values, caller must hold the lock
+func (s *series[T]) get(labelValues []string, init func() *T) *T {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", s.name, len(s.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v, ok := s.values[key]
+	if !ok {
+		v = init()
+		s.values[key] = v
+		s.keys[key] = slices.Clone(labelValues)
+	}
+	return v
+}
+
+// initUnlabelled creates the only value of metric without labels, so it is exposed before first update
+func (s *series[T]) initUnlabelled(init func() *T) {
+	if len(s.labels) == 0 {
+		s.get(nil, init)
+	}
+}
+
+// each calls fn for values sorted by label values, so output is stable, caller must hold the lock
+func (s *series[T]) each(fn func(labels []Label, v *T)) {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		labels := make([]Label, len(s.labels))
+		for i, name := range s.labels {
+			labels[i] = Label{Name: name, Value: s.keys[key][i]}
+		}
+		fn(labels, s.values[key])
+	}
+}
+
+func newFloat() *float64 {
+	return new(float64)
+}
+
+// CounterVec is counter partitioned by labels, it only goes up
+type CounterVec struct {
+	series[float64]
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{series: newSeries[float64](name, help, labels)}
+	c.initUnlabelled(newFloat)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %s can not decrease", c.name))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labelValues, newFloat) += v
+}
+
+func (c *CounterVec) Collect() []Family {
+	return []Family{collectValues(&c.series, TypeCounter)}
+}
+
+func collectValues(s *series[float64], typ Type) Family {
+	f := Family{Name: s.name, Help: s.help, Type: typ}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.each(func(labels []Label, v *float64) {
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: *v})
+	})
+	return f
+}
+
+// GaugeVec is value partitioned by labels which goes up and down
+type GaugeVec struct {
+	series[float64]
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{series: newSeries[float64](name, help, labels)}
+	g.initUnlabelled(newFloat)
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues, newFloat) = v
+}
+
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues, newFloat) += v
+}
+
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *GaugeVec) Collect() []Family {
+	return []Family{collectValues(&g.series, TypeGauge)}
+}
+
+type histogram struct {
+	// counts are not cumulative, they are summed on collect
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec counts observations in buckets partitioned by labels
+type HistogramVec struct {
+	series[histogram]
+	buckets []float64
+}
+
+// NewHistogramVec returns histogram with upper bounds of buckets, +Inf bucket is added implicitly.
+// Label le is reserved for bucket bounds.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if slices.Contains(labels, "le") {
+		panic(fmt.Sprintf("metrics: %s uses reserved label le", name))
+	}
+	buckets = slices.DeleteFunc(slices.Clone(buckets), func(b float64) bool {
+		return math.IsInf(b, 1) || math.IsNaN(b)
+	})
+	slices.Sort(buckets)
+	buckets = slices.Compact(buckets)
+	h := &HistogramVec{series: newSeries[histogram](name, help, labels), buckets: buckets}
+	h.initUnlabelled(h.newHistogram)
+	return h
+}
+
+func (h *HistogramVec) newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(h.buckets))}
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist := h.get(labelValues, h.newHistogram)
+	// bucket counts observations less than or equal to its bound, NaN gets only into +Inf one
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) && !math.IsNaN(v) {
+		hist.counts[i]++
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) Collect() []Family {
+	f := Family{Name: h.name, Help: h.help, Type: TypeHistogram}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.each(func(labels []Label, hist *histogram) {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += hist.counts[i]
+			f.Samples = append(f.Samples, Sample{
+				Suffix: "_bucket",
+				Labels: append(slices.Clone(labels), Label{Name: "le", Value: formatFloat(upper)}),
+				Value:  float64(cumulative),
+			})
+		}
+		f.Samples = append(f.Samples,
+			Sample{
+				Suffix: "_bucket",
+				Labels: append(slices.Clone(labels), Label{Name: "le", Value: formatFloat(math.Inf(1))}),
+				Value:  float64(hist.count),
+			},
+			Sample{Suffix: "_sum", Labels: labels, Value: hist.sum},
+			Sample{Suffix: "_count", Labels: labels, Value: float64(hist.count)},
+		)
+	})
+	return []Family{f}
+}
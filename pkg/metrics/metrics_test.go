@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	reg := NewRegistry()
+	requests := NewCounterVec("requests_total", "Count of requests.", "code", "path")
+	requests.Inc("200", "/")
+	requests.Add(2, "404", `/a"b\c`)
+	requests.Inc("200", "/")
+	inFlight := NewGaugeVec("in_flight", "Requests\nbeing served.")
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+	latency := NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "path")
+	latency.Observe(0.05, "/")
+	latency.Observe(0.1, "/")
+	latency.Observe(3, "/")
+	reg.MustRegister(requests, latency, inFlight, CollectorFunc(func() []Family {
+		return []Family{{Name: "pool_conns", Help: "Connections.", Type: TypeGauge, Samples: []Sample{{Value: 4}}}}
+	}))
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, TextContentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP in_flight Requests\nbeing served.
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/",le="0.1"} 2
+latency_seconds_bucket{path="/",le="1"} 2
+latency_seconds_bucket{path="/",le="+Inf"} 3
+latency_seconds_sum{path="/"} 3.15
+latency_seconds_count{path="/"} 3
+# HELP pool_conns Connections.
+# TYPE pool_conns gauge
+pool_conns 4
+# HELP requests_total Count of requests.
+# TYPE requests_total counter
+requests_total{code="200",path="/"} 2
+requests_total{code="404",path="/a\"b\\c"} 2
+`, rec.Body.String())
+}
+
+func TestCounterVec_Panics(t *testing.T) {
+	c := NewCounterVec("requests_total", "Count of requests.", "code")
+	assert.Panics(t, func() { c.Inc() }, "label values count")
+	assert.Panics(t, func() { c.Add(-1, "200") }, "counter decrease")
+}
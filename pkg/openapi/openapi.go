@@ -0,0 +1,187 @@
+// Package openapi builds OpenAPI 3.1 documents from Go types.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const Version = "3.1.0"
+
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]*PathItem  `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []SecurityRequirement `json:"security,omitempty"`
+	Tags       []Tag                 `json:"tags,omitempty"`
+
+	// namer names component schemas of struct types
+	namer TypeNamer
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// SecurityRequirement maps security scheme name to required scopes
+type SecurityRequirement map[string][]string
+
+// PathItem maps lower case http method to operation
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// NewDocument returns document without paths, struct schemas are named by namer or by Go type name if it is nil
+func NewDocument(info Info, namer TypeNamer) *Document {
+	if namer == nil {
+		namer = defaultTypeNamer
+	}
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			SecuritySchemes: make(map[string]*SecurityScheme),
+		},
+		namer: namer,
+	}
+}
+
+// AddOperation adds operation on path, pattern is the one of http.ServeMux, e.g. "/users/{id}"
+func (d *Document) AddOperation(method, pattern string, op *Operation) error {
+	method = strings.ToLower(method)
+	switch method {
+	case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+	default:
+		return fmt.Errorf("openapi: unsupported method %q", method)
+	}
+
+	path := strings.ReplaceAll(pattern, "...}", "}")
+	for _, name := range PathParams(path) {
+		if !hasParameter(op.Parameters, name, "path") {
+			return fmt.Errorf("openapi: %s %s: path parameter %q is not described", method, pattern, name)
+		}
+	}
+
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	if _, ok := (*item)[method]; ok {
+		return fmt.Errorf("openapi: %s %s is already added", method, pattern)
+	}
+	(*item)[method] = op
+	return nil
+}
+
+var pathParamRe = regexp.MustCompile(`\{([^}.]+)(?:\.\.\.)?\}`)
+
+// PathParams returns names of wildcards in path pattern
+func PathParams(pattern string) []string {
+	var names []string
+	for _, m := range pathParamRe.FindAllStringSubmatch(pattern, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+func hasParameter(params []Parameter, name, in string) bool {
+	for _, p := range params {
+		if p.Name == name && p.In == in {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONBody describes required JSON request body of given Go value
+func (d *Document) JSONBody(v any) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content:  map[string]*MediaType{"application/json": {Schema: d.Schema(v)}},
+	}
+}
+
+// JSONResponse describes response with JSON body of given Go value, nil value gives response without body
+func (d *Document) JSONResponse(description string, v any) *Response {
+	return d.ContentResponse(description, "application/json", v)
+}
+
+// ContentResponse describes response with body of given media type, e.g. application/problem+json
+func (d *Document) ContentResponse(description, contentType string, v any) *Response {
+	if v == nil {
+		return &Response{Description: description}
+	}
+	return &Response{
+		Description: description,
+		Content:     map[string]*MediaType{contentType: {Schema: d.Schema(v)}},
+	}
+}
+
+// StatusText returns description of status for responses without own one
+func StatusText(status int) string {
+	if text := http.StatusText(status); text != "" {
+		return text
+	}
+	return strconv.Itoa(status)
+}
@@ -0,0 +1,85 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRole string
+
+type testBase struct {
+	ID int64 `json:"id"`
+}
+
+type testUser struct {
+	testBase
+	Login     string            `json:"login" doc:"Unique login"`
+	Email     string            `json:"email,omitempty"`
+	Roles     []testRole        `json:"roles"`
+	Manager   *testUser         `json:"manager"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	SessionID uuid.UUID         `json:"session_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Secret    string            `json:"-"`
+	internal  string
+}
+
+func TestSchema(t *testing.T) {
+	doc := NewDocument(Info{Title: "test", Version: "1"}, nil)
+
+	s := doc.Schema(testUser{})
+	assert.Equal(t, &Schema{Ref: "#/components/schemas/testUser"}, s)
+	assert.Equal(t, &Schema{Type: "array", Items: s}, doc.Schema([]testUser{}))
+
+	user := doc.Components.Schemas["testUser"]
+	require.NotNil(t, user)
+	assert.Equal(t, []string{"id", "login", "roles", "session_id", "created_at"}, user.Required)
+	assert.Equal(t, &Schema{Type: "integer", Format: "int64"}, user.Properties["id"], "embedded struct is inlined")
+	assert.Equal(t, &Schema{Type: "string", Description: "Unique login"}, user.Properties["login"])
+	assert.Equal(t, &Schema{Type: "array", Items: &Schema{Type: "string"}}, user.Properties["roles"])
+	assert.Equal(t, &Schema{Ref: "#/components/schemas/testUser"}, user.Properties["manager"], "recursive type")
+	assert.Equal(t, &Schema{Type: "object", AdditionalProperties: &Schema{Type: "string"}}, user.Properties["labels"])
+	assert.Equal(t, &Schema{Type: "string", Format: "uuid"}, user.Properties["session_id"])
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time"}, user.Properties["created_at"])
+	assert.NotContains(t, user.Properties, "Secret")
+	assert.NotContains(t, user.Properties, "internal")
+	assert.NotContains(t, doc.Components.Schemas, "testBase")
+}
+
+func TestSchemaTypeNamer(t *testing.T) {
+	doc := NewDocument(Info{}, func(t reflect.Type) string { return "Custom" + t.Name() })
+	assert.Equal(t, "#/components/schemas/CustomtestBase", doc.Schema(&testBase{}).Ref)
+}
+
+func TestAddOperation(t *testing.T) {
+	doc := NewDocument(Info{Title: "test", Version: "1"}, nil)
+	idParam := Parameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"}}
+
+	op := &Operation{
+		Parameters: []Parameter{idParam},
+		Responses:  map[string]*Response{"200": doc.JSONResponse("OK", testBase{})},
+	}
+	require.NoError(t, doc.AddOperation("GET", "/users/{id}", op))
+	require.NoError(t, doc.AddOperation("DELETE", "/users/{id}", &Operation{Parameters: []Parameter{idParam}}))
+	assert.Len(t, *doc.Paths["/users/{id}"], 2)
+
+	assert.Error(t, doc.AddOperation("GET", "/users/{id}", op), "duplicate")
+	assert.Error(t, doc.AddOperation("CONNECT", "/users", op))
+	assert.Error(t, doc.AddOperation("GET", "/users/{id}/sessions/{sessionID}", op), "undescribed path parameter")
+
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"openapi":"3.1.0"`)
+	assert.Contains(t, string(data), `"$ref":"#/components/schemas/testBase"`)
+}
+
+func TestPathParams(t *testing.T) {
+	assert.Equal(t, []string{"id", "path"}, PathParams("/users/{id}/files/{path...}"))
+	assert.Empty(t, PathParams("/users"))
+}
@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const scopeName = "github.com/agalitsyn/goth/pkg/tracing"
+
+// OTLPExporter sends spans to OpenTelemetry collector with OTLP/HTTP in JSON encoding
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewOTLPExporter returns exporter to endpoint, e.g. http://localhost:4318/v1/traces
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{endpoint: endpoint, client: &http.Client{}}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, res Resource, spans []SpanData) error {
+	body, err := json.Marshal(newExportRequest(res, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FileExporter appends batches of spans to file as lines of OTLP JSON, the same way file exporter of collector does,
+// so file could be inspected with jq or replayed to collector. It is for development.
+type FileExporter struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileExporter(path string) *FileExporter {
+	return &FileExporter{path: path}
+}
+
+func (e *FileExporter) Export(_ context.Context, res Resource, spans []SpanData) error {
+	line, err := json.Marshal(newExportRequest(res, spans))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(e.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(e.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// OTLP JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+// Ids are hex strings and 64 bit integers are decimal strings.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   otlpResource `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *jsonDouble `json:"doubleValue,omitempty"`
+}
+
+// jsonDouble encodes NaN and infinities as strings like protobuf JSON mapping does,
+// encoding/json fails on them and the whole batch would be lost
+type jsonDouble float64
+
+func (d jsonDouble) MarshalJSON() ([]byte, error) {
+	v := float64(d)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return json.Marshal(v)
+}
+
+func newExportRequest(res Resource, spans []SpanData) exportRequest {
+	resAttrs := []Attribute{Attr("service.name", res.ServiceName)}
+	if res.ServiceVersion != "" {
+		resAttrs = append(resAttrs, Attr("service.version", res.ServiceVersion))
+	}
+
+	ss := scopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	ss.Scope.Name = scopeName
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        keyValues(s.Attributes),
+			Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		ss.Spans = append(ss.Spans, span)
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues(resAttrs)},
+		ScopeSpans: []scopeSpans{ss},
+	}}}
+}
+
+func keyValues(attrs []Attribute) []keyValue {
+	kvs := make([]keyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, keyValue{Key: a.Key, Value: newAnyValue(a.Value)})
+	}
+	return kvs
+}
+
+func newAnyValue(v any) anyValue {
+	var av anyValue
+	switch v := v.(type) {
+	case string:
+		av.StringValue = &v
+	case bool:
+		av.BoolValue = &v
+	case int:
+		av.IntValue = ptr(strconv.FormatInt(int64(v), 10))
+	case int64:
+		av.IntValue = ptr(strconv.FormatInt(v, 10))
+	case float64:
+		av.DoubleValue = ptr(jsonDouble(v))
+	default:
+		av.StringValue = ptr(fmt.Sprint(v))
+	}
+	return av
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
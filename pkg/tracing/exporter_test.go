@@ -0,0 +1,289 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests check export request against opentelemetry-proto collector/trace/v1/trace_service.proto
+// in JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+// There is no collector in vendored dependencies, so schema below is written from the proto files.
+
+// field kinds of schema
+const (
+	kindString  = "string"
+	kindBool    = "bool"
+	kindEnum    = "enum"
+	kindUint64  = "uint64" // 64 bit integers are decimal strings
+	kindInt64   = "int64"
+	kindDouble  = "double"
+	kindHex16   = "hex16"
+	kindHex8    = "hex8"
+	kindCount   = "count"
+	kindAnyOf   = "anyvalue"
+	kindArrayOf = "array:"
+)
+
+var otlpSchema = map[string]map[string]string{
+	"ExportTraceServiceRequest": {"resourceSpans": kindArrayOf + "ResourceSpans"},
+	"ResourceSpans": {
+		"resource":   "Resource",
+		"scopeSpans": kindArrayOf + "ScopeSpans",
+		"schemaUrl":  kindString,
+	},
+	"Resource": {
+		"attributes":             kindArrayOf + "KeyValue",
+		"droppedAttributesCount": kindCount,
+	},
+	"ScopeSpans": {
+		"scope":     "InstrumentationScope",
+		"spans":     kindArrayOf + "Span",
+		"schemaUrl": kindString,
+	},
+	"InstrumentationScope": {
+		"name":                   kindString,
+		"version":                kindString,
+		"attributes":             kindArrayOf + "KeyValue",
+		"droppedAttributesCount": kindCount,
+	},
+	"Span": {
+		"traceId":                kindHex16,
+		"spanId":                 kindHex8,
+		"traceState":             kindString,
+		"parentSpanId":           kindHex8,
+		"flags":                  kindCount,
+		"name":                   kindString,
+		"kind":                   kindEnum,
+		"startTimeUnixNano":      kindUint64,
+		"endTimeUnixNano":        kindUint64,
+		"attributes":             kindArrayOf + "KeyValue",
+		"droppedAttributesCount": kindCount,
+		"droppedEventsCount":     kindCount,
+		"droppedLinksCount":      kindCount,
+		"status":                 "Status",
+	},
+	"Status": {
+		"message": kindString,
+		"code":    kindEnum,
+	},
+	"KeyValue": {
+		"key":   kindString,
+		"value": kindAnyOf,
+	},
+}
+
+// enumRanges are values defined by proto enums
+var enumRanges = map[string][2]float64{
+	"kind": {0, 5}, // SPAN_KIND_UNSPECIFIED..SPAN_KIND_CONSUMER
+	"code": {0, 2}, // STATUS_CODE_UNSET..STATUS_CODE_ERROR
+}
+
+var (
+	hex16Re = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	hex8Re  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+func checkMessage(t *testing.T, path, message string, v any) {
+	t.Helper()
+
+	obj, ok := v.(map[string]any)
+	require.True(t, ok, "%s is %s object", path, message)
+	fields := otlpSchema[message]
+	require.NotNil(t, fields, "schema of %s", message)
+	for name, value := range obj {
+		kind, ok := fields[name]
+		require.True(t, ok, "%s.%s is field of %s", path, name, message)
+		checkField(t, path+"."+name, name, kind, value)
+	}
+}
+
+func checkField(t *testing.T, path, name, kind string, v any) {
+	t.Helper()
+
+	switch kind {
+	case kindString:
+		assert.IsType(t, "", v, path)
+	case kindBool:
+		assert.IsType(t, true, v, path)
+	case kindEnum:
+		n, ok := v.(float64)
+		require.True(t, ok, "%s is enum number, not name", path)
+		r := enumRanges[name]
+		assert.True(t, n >= r[0] && n <= r[1] && n == math.Trunc(n), "%s=%v is in enum", path, n)
+	case kindCount:
+		n, ok := v.(float64)
+		require.True(t, ok, "%s is number", path)
+		assert.True(t, n >= 0 && n == math.Trunc(n), path)
+	case kindUint64:
+		s, ok := v.(string)
+		require.True(t, ok, "%s is decimal string", path)
+		_, err := strconv.ParseUint(s, 10, 64)
+		assert.NoError(t, err, path)
+	case kindInt64:
+		s, ok := v.(string)
+		require.True(t, ok, "%s is decimal string", path)
+		_, err := strconv.ParseInt(s, 10, 64)
+		assert.NoError(t, err, path)
+	case kindDouble:
+		switch d := v.(type) {
+		case float64:
+		case string:
+			assert.Contains(t, []string{"NaN", "Infinity", "-Infinity"}, d, path)
+		default:
+			t.Errorf("%s is number", path)
+		}
+	case kindHex16:
+		assert.Regexp(t, hex16Re, v, path)
+	case kindHex8:
+		assert.Regexp(t, hex8Re, v, path)
+	case kindAnyOf:
+		obj, ok := v.(map[string]any)
+		require.True(t, ok, "%s is AnyValue object", path)
+		require.Len(t, obj, 1, "%s has exactly one value", path)
+		for k, value := range obj {
+			valueKinds := map[string]string{
+				"stringValue": kindString,
+				"boolValue":   kindBool,
+				"intValue":    kindInt64,
+				"doubleValue": kindDouble,
+			}
+			vk, ok := valueKinds[k]
+			require.True(t, ok, "%s.%s is supported AnyValue", path, k)
+			checkField(t, path+"."+k, k, vk, value)
+		}
+	default:
+		if elem, ok := cutArray(kind); ok {
+			arr, ok := v.([]any)
+			require.True(t, ok, "%s is array", path)
+			for i, item := range arr {
+				checkMessage(t, path+"["+strconv.Itoa(i)+"]", elem, item)
+			}
+			return
+		}
+		checkMessage(t, path, kind, v)
+	}
+}
+
+func cutArray(kind string) (string, bool) {
+	if len(kind) > len(kindArrayOf) && kind[:len(kindArrayOf)] == kindArrayOf {
+		return kind[len(kindArrayOf):], true
+	}
+	return "", false
+}
+
+func testSpans(t *testing.T) []SpanData {
+	t.Helper()
+
+	var spans []SpanData
+	tracer := NewTracer(Config{Exporter: exporterFunc(func(_ context.Context, _ Resource, batch []SpanData) error {
+		spans = append(spans, batch...)
+		return nil
+	})})
+	ctx, parent := tracer.Start(context.Background(), "GET /users/{id}", WithKind(SpanKindServer))
+	parent.SetAttributes(
+		Attr("http.response.status_code", 500),
+		Attr("big", int64(math.MaxInt64)),
+		Attr("ok", false),
+		Attr("ratio", 0.25),
+		Attr("nan", math.NaN()),
+		Attr("inf", math.Inf(-1)),
+		Attr("duration", time.Second),
+		Attr("name", "юникод \"quoted\"\n\xff"),
+	)
+	parent.RecordError(errors.New("db is down"))
+	_, child := tracer.Start(ctx, "SELECT", WithKind(SpanKindClient))
+	child.End()
+	parent.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	require.Len(t, spans, 2)
+	return spans
+}
+
+type exporterFunc func(ctx context.Context, res Resource, spans []SpanData) error
+
+func (f exporterFunc) Export(ctx context.Context, res Resource, spans []SpanData) error {
+	return f(ctx, res, spans)
+}
+
+func TestOTLPJSONSchema(t *testing.T) {
+	spans := testSpans(t)
+	body, err := json.Marshal(newExportRequest(Resource{ServiceName: "admin", ServiceVersion: "v1"}, spans))
+	require.NoError(t, err, "NaN and infinities do not break encoding")
+
+	var req any
+	require.NoError(t, json.Unmarshal(body, &req))
+	checkMessage(t, "request", "ExportTraceServiceRequest", req)
+
+	// values which schema can't tell
+	var decoded struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					ParentSpanID string `json:"parentSpanId"`
+					Attributes   []struct {
+						Key   string         `json:"key"`
+						Value map[string]any `json:"value"`
+					} `json:"attributes"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(body, &decoded))
+	exported := decoded.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, spans[1].SpanContext.SpanID.String(), exported[0].ParentSpanID)
+	assert.Empty(t, exported[1].ParentSpanID, "root span has no parent field")
+
+	attrs := map[string]map[string]any{}
+	for _, a := range exported[1].Attributes {
+		attrs[a.Key] = a.Value
+	}
+	assert.Equal(t, map[string]any{"intValue": "500"}, attrs["http.response.status_code"])
+	assert.Equal(t, map[string]any{"intValue": "9223372036854775807"}, attrs["big"], "int64 is not rounded")
+	assert.Equal(t, map[string]any{"boolValue": false}, attrs["ok"])
+	assert.Equal(t, map[string]any{"doubleValue": 0.25}, attrs["ratio"])
+	assert.Equal(t, map[string]any{"doubleValue": "NaN"}, attrs["nan"])
+	assert.Equal(t, map[string]any{"doubleValue": "-Infinity"}, attrs["inf"])
+	assert.Equal(t, map[string]any{"stringValue": "1s"}, attrs["duration"])
+	assert.Equal(t, map[string]any{"stringValue": "юникод \"quoted\"\n�"}, attrs["name"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	spans := testSpans(t)
+
+	var status int
+	var body []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var err error
+		body, err = io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer collector.Close()
+	exporter := NewOTLPExporter(collector.URL + "/v1/traces")
+
+	status = http.StatusOK
+	require.NoError(t, exporter.Export(context.Background(), Resource{ServiceName: "admin"}, spans))
+	var req any
+	require.NoError(t, json.Unmarshal(body, &req))
+	checkMessage(t, "request", "ExportTraceServiceRequest", req)
+
+	status = http.StatusServiceUnavailable
+	assert.Error(t, exporter.Export(context.Background(), Resource{ServiceName: "admin"}, spans))
+}
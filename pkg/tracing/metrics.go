@@ -0,0 +1,11 @@
+package tracing
+
+import "github.com/agalitsyn/goth/pkg/metrics"
+
+var spansDroppedTotal = metrics.NewCounterVec("tracing_spans_dropped_total",
+	"Sampled spans which were not exported because export queue was full or export failed.", "reason")
+
+// RegisterMetrics adds span export counters to registry, counters are shared by all tracers
+func RegisterMetrics(reg *metrics.Registry) {
+	reg.MustRegister(spansDroppedTotal)
+}
@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Sampler decides if new trace is recorded. It is asked only for root spans,
+// children follow decision of local or remote parent, as parent-based sampler of OpenTelemetry does.
+type Sampler interface {
+	ShouldSample(traceID TraceID) bool
+}
+
+type samplerFunc func(TraceID) bool
+
+func (f samplerFunc) ShouldSample(traceID TraceID) bool {
+	return f(traceID)
+}
+
+func AlwaysSample() Sampler {
+	return samplerFunc(func(TraceID) bool { return true })
+}
+
+func NeverSample() Sampler {
+	return samplerFunc(func(TraceID) bool { return false })
+}
+
+// TraceIDRatio samples ratio of traces in range [0, 1]. Decision depends only on trace id
+// the same way as in OpenTelemetry SDKs, so services with the same ratio agree on it.
+func TraceIDRatio(ratio float64) Sampler {
+	switch {
+	case ratio >= 1:
+		return AlwaysSample()
+	case ratio <= 0 || math.IsNaN(ratio):
+		return NeverSample()
+	}
+	bound := uint64(ratio * (1 << 63))
+	return samplerFunc(func(traceID TraceID) bool {
+		return binary.BigEndian.Uint64(traceID[8:16])>>1 < bound
+	})
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind values are the same as OTLP ones
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode values are the same as OTLP ones
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute value is string, bool, integer or float, other types are exported as strings
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func Attr(key string, value any) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is snapshot of ended span passed to exporter
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span is operation in trace, it is safe for concurrent use and all methods of nil span do nothing.
+// Changes of ended span are ignored.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type SpanOption func(*SpanData)
+
+func WithKind(kind SpanKind) SpanOption {
+	return func(d *SpanData) {
+		d.Kind = kind
+	}
+}
+
+func WithAttributes(attrs ...Attribute) SpanOption {
+	return func(d *SpanData) {
+		d.Attributes = append(d.Attributes, attrs...)
+	}
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Name = name
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Status = code
+	s.data.StatusMessage = msg
+}
+
+// RecordError marks span failed with error message, nil error is ignored
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes span and queues it for export if trace is sampled, the next calls do nothing.
+// Without exporter spans are only propagated.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled && s.tracer.exporter != nil {
+		s.tracer.enqueue(data)
+	}
+}
+
+// Start starts span which is child of span in ctx or of remote parent, otherwise it starts new trace
+// which is sampled by sampler of config
+func (t *Tracer) Start(ctx context.Context, name string, opts ...SpanOption) (context.Context, *Span) {
+	s := &Span{tracer: t, data: SpanData{Name: name, Kind: SpanKindInternal, Start: t.now()}}
+	if parent, ok := parentFromContext(ctx); ok && parent.IsValid() {
+		s.data.SpanContext = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+		s.data.Parent = parent.SpanID
+	} else {
+		traceID := newTraceID()
+		s.data.SpanContext = SpanContext{TraceID: traceID, SpanID: newSpanID(), Sampled: t.cfg.Sampler.ShouldSample(traceID)}
+	}
+	for _, opt := range opts {
+		opt(&s.data)
+	}
+	return contextWithSpan(ctx, s), s
+}
@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dropWarnInterval limits warnings about dropped spans, every drop is counted by metric
+const dropWarnInterval = time.Minute
+
+// Exporter sends batch of ended spans to storage
+type Exporter interface {
+	Export(ctx context.Context, res Resource, spans []SpanData) error
+}
+
+// Resource describes process which produces spans
+type Resource struct {
+	ServiceName    string
+	ServiceVersion string
+}
+
+type Config struct {
+	Resource Resource
+	// Exporter is nil when spans are only propagated
+	Exporter Exporter
+	// Sampler decides if new traces are recorded, all of them are by default
+	Sampler Sampler
+	// BatchSize is max count of spans in one export
+	BatchSize int
+	// FlushInterval is max delay of span export
+	FlushInterval time.Duration
+	// QueueSize is max count of queued spans, new spans are dropped when queue is full
+	QueueSize int
+}
+
+func (c *Config) setDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 512
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = 5 * time.Second
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 2048
+	}
+	if c.Sampler == nil {
+		c.Sampler = AlwaysSample()
+	}
+}
+
+// Tracer starts spans and exports ended ones in batches in background
+type Tracer struct {
+	cfg      Config
+	exporter Exporter
+	now      func() time.Time
+
+	// mu guards queue from sends after it is closed
+	mu     sync.RWMutex
+	closed bool
+	queue  chan SpanData
+	done   chan struct{}
+
+	// dropped counts spans dropped since last warning
+	dropped      atomic.Int64
+	lastDropWarn atomic.Int64
+}
+
+// NewTracer starts export loop if there is exporter, call Shutdown to flush queued spans
+func NewTracer(cfg Config) *Tracer {
+	cfg.setDefaults()
+	t := &Tracer{
+		cfg:      cfg,
+		exporter: cfg.Exporter,
+		now:      time.Now,
+		queue:    make(chan SpanData, cfg.QueueSize),
+		done:     make(chan struct{}),
+	}
+	if t.exporter != nil {
+		go t.run()
+	} else {
+		close(t.done)
+	}
+	return t
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		t.drop("queue_full", 1)
+	}
+}
+
+// drop counts dropped spans and warns about them at most once per dropWarnInterval,
+// so full queue under load does not flood logs
+func (t *Tracer) drop(reason string, count int) {
+	spansDroppedTotal.Add(float64(count), reason)
+	t.dropped.Add(int64(count))
+
+	now := t.now().UnixNano()
+	last := t.lastDropWarn.Load()
+	if now-last < int64(dropWarnInterval) || !t.lastDropWarn.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Warn("spans dropped", "reason", reason, "count", t.dropped.Swap(0))
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.cfg.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.Export(ctx, t.cfg.Resource, batch); err != nil {
+			slog.Error("could not export spans", "count", len(batch), "error", err)
+			spansDroppedTotal.Add(float64(len(batch)), "export_failed")
+		}
+		batch = make([]SpanData, 0, t.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				close(t.done)
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= t.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports queued spans, spans ended after it are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
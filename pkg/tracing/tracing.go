@@ -0,0 +1,160 @@
+// Package tracing records spans compatible with OpenTelemetry and propagates them with W3C traceparent header.
+//
+// It covers only what the apps need from OpenTelemetry SDK and is written with the standard library,
+// because the module is built from vendored dependencies and go.opentelemetry.io modules with their
+// gRPC and protobuf dependencies are not among them. Spans are exported by OTLP/HTTP in JSON encoding,
+// which collectors accept on the same endpoint as protobuf, so switching to the SDK later changes
+// only this package. Encoding follows opentelemetry-proto trace.proto and its JSON mapping,
+// exporter_test.go checks it.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// TraceparentHeader is W3C Trace Context header, see https://www.w3.org/TR/trace-context/
+const TraceparentHeader = "traceparent"
+
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies span across processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled spans are exported, not sampled are only propagated
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats span context as traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses traceparent header value, fields appended by future versions are ignored
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return sc, ErrInvalidTraceparent
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("%w: version %q", ErrInvalidTraceparent, version)
+	}
+	if err := decodeHex(sc.TraceID[:], traceID); err != nil {
+		return sc, fmt.Errorf("%w: trace id: %w", ErrInvalidTraceparent, err)
+	}
+	if err := decodeHex(sc.SpanID[:], spanID); err != nil {
+		return sc, fmt.Errorf("%w: parent id: %w", ErrInvalidTraceparent, err)
+	}
+	var f [1]byte
+	if err := decodeHex(f[:], flags); err != nil {
+		return sc, fmt.Errorf("%w: flags: %w", ErrInvalidTraceparent, err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("%w: zero id", ErrInvalidTraceparent)
+	}
+	sc.Sampled = f[0]&1 == 1
+	return sc, nil
+}
+
+// decodeHex accepts only lowercase hex of exact length, as spec requires
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("malformed %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+type contextKey string
+
+// ContextWithRemoteSpanContext makes span context received from another process parent of spans started with ctx
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey("remote"), sc)
+}
+
+// SpanFromContext returns current span, it is nil if there is none, methods of nil span do nothing
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey("span")).(*Span)
+	return s
+}
+
+func contextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, contextKey("span"), s)
+}
+
+// parentFromContext returns context of current span or remote parent
+func parentFromContext(ctx context.Context) (SpanContext, bool) {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.SpanContext(), true
+	}
+	sc, ok := ctx.Value(contextKey("remote")).(SpanContext)
+	return sc, ok
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+func init() {
+	defaultTracer.Store(NewTracer(Config{}))
+}
+
+// SetDefault makes t the tracer of Start, default one propagates context but exports nothing
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+func Default() *Tracer {
+	return defaultTracer.Load()
+}
+
+// Start starts span with default tracer, it is child of span in ctx if there is one
+func Start(ctx context.Context, name string, opts ...SpanOption) (context.Context, *Span) {
+	return Default().Start(ctx, name, opts...)
+}
@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// future versions may append fields
+	sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled)
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, s)
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	tracer := NewTracer(Config{})
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	assert.True(t, root.SpanContext().IsValid())
+	assert.True(t, root.SpanContext().Sampled)
+	assert.Same(t, root, SpanFromContext(ctx))
+
+	_, child := tracer.Start(ctx, "child")
+	assert.Equal(t, root.SpanContext().TraceID, child.SpanContext().TraceID)
+	assert.NotEqual(t, root.SpanContext().SpanID, child.SpanContext().SpanID)
+	assert.Equal(t, root.SpanContext().SpanID, child.data.Parent)
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+	_, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), remote), "server")
+	assert.Equal(t, remote.TraceID, span.SpanContext().TraceID)
+	assert.Equal(t, remote.SpanID, span.data.Parent)
+	assert.False(t, span.SpanContext().Sampled, "sampling decision of parent is kept")
+
+	// nil span is no-op
+	var nilSpan *Span
+	nilSpan.SetAttributes(Attr("a", 1))
+	nilSpan.RecordError(errors.New("boom"))
+	nilSpan.End()
+	assert.Nil(t, SpanFromContext(context.Background()))
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces", "spans.jsonl")
+	tracer := NewTracer(Config{
+		Resource: Resource{ServiceName: "test", ServiceVersion: "v1"},
+		Exporter: NewFileExporter(path),
+	})
+
+	ctx, parent := tracer.Start(context.Background(), "GET /users", WithKind(SpanKindServer))
+	parent.SetAttributes(Attr("http.response.status_code", 500), Attr("ok", false))
+	parent.RecordError(errors.New("db is down"))
+	_, child := tracer.Start(ctx, "SELECT", WithKind(SpanKindClient), WithAttributes(Attr("db.system", "postgresql")))
+	child.End()
+	parent.End()
+	parent.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	// spans after shutdown are dropped
+	_, late := tracer.Start(context.Background(), "late")
+	late.End()
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var req exportRequest
+	require.NoError(t, json.Unmarshal(b, &req))
+	require.Len(t, req.ResourceSpans, 1)
+	rs := req.ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "test", *rs.Resource.Attributes[0].Value.StringValue)
+
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "SELECT", spans[0].Name)
+	assert.Equal(t, SpanKindClient, spans[0].Kind)
+	assert.Equal(t, parent.SpanContext().SpanID.String(), spans[0].ParentSpanID)
+	assert.Equal(t, parent.SpanContext().TraceID.String(), spans[0].TraceID)
+
+	assert.Equal(t, "GET /users", spans[1].Name)
+	assert.Empty(t, spans[1].ParentSpanID)
+	assert.Equal(t, otlpStatus{Code: StatusError, Message: "db is down"}, spans[1].Status)
+	assert.Equal(t, "500", *spans[1].Attributes[0].Value.IntValue)
+	assert.False(t, *spans[1].Attributes[1].Value.BoolValue)
+}
+
+func TestTraceIDRatio(t *testing.T) {
+	low := TraceID{8: 0x00, 15: 0x01}
+	high := TraceID{8: 0xff, 9: 0xff, 10: 0xff, 11: 0xff, 12: 0xff, 13: 0xff, 14: 0xff, 15: 0xff}
+
+	assert.True(t, TraceIDRatio(1).ShouldSample(high))
+	assert.False(t, TraceIDRatio(0).ShouldSample(low))
+	assert.True(t, TraceIDRatio(0.5).ShouldSample(low))
+	assert.False(t, TraceIDRatio(0.5).ShouldSample(high))
+
+	var sampled int
+	for range 10000 {
+		if TraceIDRatio(0.25).ShouldSample(newTraceID()) {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 2500, sampled, 300)
+
+	tracer := NewTracer(Config{Sampler: NeverSample()})
+	ctx, root := tracer.Start(context.Background(), "root")
+	assert.False(t, root.SpanContext().Sampled)
+	_, child := tracer.Start(ctx, "child")
+	assert.False(t, child.SpanContext().Sampled, "child follows parent")
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	_, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), remote), "server")
+	assert.True(t, span.SpanContext().Sampled, "span follows remote parent")
+}
+
+func TestTracerDrop(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, nil)))
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracer := NewTracer(Config{QueueSize: 1})
+	tracer.now = func() time.Time { return now }
+	before := droppedSpans(t, "queue_full")
+
+	for range 5 {
+		tracer.enqueue(SpanData{Name: "span"})
+	}
+	assert.Equal(t, before+4, droppedSpans(t, "queue_full"))
+	assert.Equal(t, 1, strings.Count(buf.String(), "spans dropped"), "warning is rate limited")
+	assert.Contains(t, buf.String(), "count=1")
+
+	now = now.Add(dropWarnInterval)
+	tracer.enqueue(SpanData{Name: "span"})
+	assert.Equal(t, 2, strings.Count(buf.String(), "spans dropped"))
+	assert.Contains(t, buf.String(), "count=4", "warning has count of drops since previous one")
+}
+
+func droppedSpans(t *testing.T, reason string) float64 {
+	t.Helper()
+	for _, f := range spansDroppedTotal.Collect() {
+		for _, s := range f.Samples {
+			if len(s.Labels) == 1 && s.Labels[0].Value == reason {
+				return s.Value
+			}
+		}
+	}
+	return 0
+}
@@ -1,9 +1,6 @@
 package version
 
-import (
-	"fmt"
-	"runtime/debug"
-)
+import "runtime/debug"
 
 var (
 	Tag      string
@@ -33,12 +30,21 @@ func init() {
 	}
 }
 
+// String is tag set by ldflags with revision and build time from VCS info, unknown parts are omitted,
+// e.g. VCS info is missing in binaries built by go run or without .git directory
 func String() string {
+	tag := Tag
+	if tag == "" {
+		tag = "dev"
+	}
 	if Revision == "" {
-		return "dev"
+		return tag
 	}
 
-	s := fmt.Sprintf("%s %s at %s", Tag, Revision, BuildAt)
+	s := tag + " " + Revision
+	if BuildAt != "" {
+		s += " at " + BuildAt
+	}
 	if Dirty {
 		s += " dirty"
 	}
@@ -0,0 +1,34 @@
+package version
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	tag, revision, buildAt, dirty := Tag, Revision, BuildAt, Dirty
+	t.Cleanup(func() {
+		Tag, Revision, BuildAt, Dirty = tag, revision, buildAt, dirty
+	})
+
+	tbl := []struct {
+		tag      string
+		revision string
+		buildAt  string
+		dirty    bool
+		expected string
+	}{
+		{expected: "dev"},
+		{tag: "v1.2.0", expected: "v1.2.0"},
+		{revision: "2dfb2f7", buildAt: "2024-11-29T17:16:08Z", expected: "dev 2dfb2f7 at 2024-11-29T17:16:08Z"},
+		{tag: "v1.2.0", revision: "2dfb2f7", buildAt: "2024-11-29T17:16:08Z", dirty: true,
+			expected: "v1.2.0 2dfb2f7 at 2024-11-29T17:16:08Z dirty"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.expected, func(t *testing.T) {
+			Tag, Revision, BuildAt, Dirty = tt.tag, tt.revision, tt.buildAt, tt.dirty
+			assert.Equal(t, tt.expected, String())
+		})
+	}
+}